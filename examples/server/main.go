@@ -70,45 +70,20 @@ func handleHDLCConnection(conn net.Conn) {
 	config.SrcAddr = []byte{0x01}  // Server address
 	config.DestAddr = []byte{0x02} // Client address
 	hdlcConn := hdlc.NewHDLCConnection(config)
+	// APDUs longer than the max PDU size travel in General-Block-Transfer blocks;
+	// the layer writes its acknowledgements and further windows to conn itself.
+	gbtConn := cosem.NewGBTTransport(hdlcConn, conn, nil)
 
-	app, err := setupApplication(hdlcConn)
+	app, err := setupApplication(gbtConn)
 	if err != nil {
 		log.Printf("Failed to set up application: %v", err)
 		return
 	}
 
-	go func() {
-		for {
-			pdu, clientAddr, err := hdlcConn.Read()
-			if err != nil {
-				log.Printf("Error reading PDU: %v", err)
-				return
-			}
-			log.Printf("Server received PDU from %s: %x", clientAddr, pdu)
-
-			responsePDU, err := app.HandleAPDU(pdu, clientAddr)
-			if err != nil {
-				log.Printf("Error handling APDU: %v", err)
-				continue
-			}
-
-			if responsePDU != nil {
-				frames, err := hdlcConn.Send(responsePDU)
-				if err != nil {
-					log.Printf("Error sending response: %v", err)
-					continue
-				}
-				for _, frame := range frames {
-					log.Printf("Server sending response frame: %x", frame)
-					_, err := conn.Write(frame)
-					if err != nil {
-						log.Printf("Error writing to connection: %v", err)
-						return
-					}
-				}
-			}
-		}
-	}()
+	// The associations are released when the link is lost.
+	disconnects := make(chan struct{}, 1)
+	defer close(disconnects)
+	go serveRequests(app, gbtConn, conn, disconnects)
 
 	buf := make([]byte, 1024)
 	for {
@@ -118,7 +93,8 @@ func handleHDLCConnection(conn net.Conn) {
 			return
 		}
 		log.Printf("Server received raw data: %x", buf[:n])
-		responses, err := hdlcConn.Receive(buf[:n])
+		wasConnected := hdlcConn.IsConnected()
+		responses, err := gbtConn.Receive(buf[:n])
 		if err != nil {
 			log.Printf("Error handling HDLC data: %v", err)
 			return
@@ -130,6 +106,15 @@ func handleHDLCConnection(conn net.Conn) {
 				return
 			}
 		}
+		// A DISC or FRMR frame disconnects the HDLC link, which the client
+		// may set up again on the same TCP connection.
+		if wasConnected && !hdlcConn.IsConnected() {
+			log.Printf("HDLC link disconnected")
+			select {
+			case disconnects <- struct{}{}:
+			default:
+			}
+		}
 	}
 }
 
@@ -142,60 +127,130 @@ func handleWrapperConnection(conn net.Conn) {
 	log.Printf("Accepted WRAPPER connection from %s", conn.RemoteAddr())
 
 	wrapperConn := wrapper.NewConnection(conn, nil)
-	app, err := setupApplication(wrapperConn)
+	gbtConn := cosem.NewGBTTransport(wrapperConn, conn, nil)
+	app, err := setupApplication(gbtConn)
 	if err != nil {
 		log.Printf("Failed to set up application: %v", err)
 		return
 	}
 
+	// The associations are released when the link is lost.
+	disconnects := make(chan struct{}, 1)
+	defer close(disconnects)
+	go serveRequests(app, gbtConn, conn, disconnects)
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			log.Printf("Error reading from connection: %v", err)
+			return
+		}
+		log.Printf("Server received raw data: %x", buf[:n])
+		responses, err := gbtConn.Receive(buf[:n])
+		if err != nil {
+			log.Printf("Error handling WRAPPER data: %v", err)
+			return
+		}
+		for _, frame := range responses {
+			log.Printf("Server sending frame: %x", frame)
+			if _, err := conn.Write(frame); err != nil {
+				log.Printf("Error writing WRAPPER response: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// receivedPDU is a request PDU read from a connection.
+type receivedPDU struct {
+	pdu        []byte
+	clientAddr net.Addr
+}
+
+// serveRequests serves the request PDUs read from gbtConn and writes the
+// responses to conn. The requests that arrive while one is served wait in a
+// request queue, which serves the high priority ones first. Each time the
+// supporting layer disconnects, signalled on disconnects, and when it is
+// closed, the associations of the clients served are released. The application
+// is only used from this goroutine.
+func serveRequests(app *cosem.Application, gbtConn *cosem.GBTTransport, conn net.Conn, disconnects <-chan struct{}) {
+	pdus := make(chan receivedPDU, 16)
 	go func() {
+		defer close(pdus)
 		for {
-			pdu, clientAddr, err := wrapperConn.Read()
+			pdu, clientAddr, err := gbtConn.Read()
 			if err != nil {
 				log.Printf("Error reading PDU: %v", err)
 				return
 			}
 			log.Printf("Server received PDU from %s: %x", clientAddr, pdu)
+			pdus <- receivedPDU{pdu: pdu, clientAddr: clientAddr}
+		}
+	}()
 
-			responsePDU, err := app.HandleAPDU(pdu, clientAddr)
-			if err != nil {
-				log.Printf("Error handling APDU: %v", err)
-				continue
+	queue := app.NewRequestQueue()
+	clients := make(map[string]net.Addr)
+	push := func(received receivedPDU) {
+		clients[received.clientAddr.String()] = received.clientAddr
+		queue.Push(received.pdu, received.clientAddr)
+	}
+	release := func() {
+		for key, clientAddr := range clients {
+			if err := app.HandleDisconnect(clientAddr); err != nil {
+				log.Printf("Error releasing association: %v", err)
 			}
+			delete(clients, key)
+		}
+	}
+	defer release()
 
-			if responsePDU != nil {
-				frames, err := wrapperConn.Send(responsePDU)
-				if err != nil {
-					log.Printf("Error sending response: %v", err)
-					continue
+	for {
+		if queue.Len() == 0 {
+			select {
+			case received, ok := <-pdus:
+				if !ok {
+					return
 				}
-				log.Printf("Server sending response frame: %x", frames[0])
-				_, err = conn.Write(frames[0])
-				if err != nil {
-					log.Printf("Error sending wrapper frame: %v", err)
+				push(received)
+			case _, ok := <-disconnects:
+				release()
+				if !ok {
 					return
 				}
+				continue
+			}
+		}
+		// Queue whatever else has arrived so that it is served by priority.
+		for queued := true; queued; {
+			select {
+			case received, ok := <-pdus:
+				if ok {
+					push(received)
+				}
+				queued = ok
+			default:
+				queued = false
 			}
 		}
-	}()
 
-	buf := make([]byte, 1024)
-	for {
-		n, err := conn.Read(buf)
+		responsePDU, clientAddr, _, err := app.ServeNext(queue)
 		if err != nil {
-			log.Printf("Error reading from connection: %v", err)
-			return
+			log.Printf("Error handling APDU: %v", err)
+			continue
 		}
-		log.Printf("Server received raw data: %x", buf[:n])
-		responses, err := wrapperConn.Receive(buf[:n])
+		if responsePDU == nil {
+			continue
+		}
+		frames, err := gbtConn.SendTo(responsePDU, clientAddr)
 		if err != nil {
-			log.Printf("Error handling WRAPPER data: %v", err)
-			return
+			log.Printf("Error sending response: %v", err)
+			continue
 		}
-		for _, frame := range responses {
-			log.Printf("Server sending frame: %x", frame)
+		for _, frame := range frames {
+			log.Printf("Server sending response frame: %x", frame)
 			if _, err := conn.Write(frame); err != nil {
-				log.Printf("Error writing WRAPPER response: %v", err)
+				log.Printf("Error writing to connection: %v", err)
 				return
 			}
 		}
@@ -224,6 +279,9 @@ func setupApplication(tp transport.Transport) (*cosem.Application, error) {
 	addrPub, _ := cosem.NewObisCodeFromString("0.0.40.0.0.255")
 	assocPub, _ := cosem.NewAssociationLN(*addrPub)
 	app.AddAssociation("10", assocPub)
+	if err := app.PreEstablishAssociation("10"); err != nil {
+		return nil, err
+	} // The public client needs no AARQ
 	if err := app.PopulateObjectList(assocPub, []cosem.ObisCode{*obisClock}); err != nil {
 		return nil, err
 	} // Only clock is public
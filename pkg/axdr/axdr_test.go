@@ -70,6 +70,13 @@ func TestPrimitiveTypes(t *testing.T) {
 			expected: []byte{0x1F, 0x00},
 		},
 
+		// Enum
+		{
+			name:     "enum",
+			input:    Enum(2),
+			expected: []byte{0x16, 0x02},
+		},
+
 		// LongUnsigned (uint16)
 		{
 			name:     "long_unsigned_max",
@@ -724,6 +731,44 @@ func TestDateTimeRoundTrip(t *testing.T) {
 	}
 }
 
+// TestDecodeFromAndLengthHelpers tests decoding consecutive values from a shared reader
+// and the exported length helpers used by APDU codecs.
+func TestDecodeFromAndLengthHelpers(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteLength(&buf, 300); err != nil {
+		t.Fatalf("WriteLength failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{0x82, 0x01, 0x2C}) {
+		t.Fatalf("unexpected length encoding: %x", buf.Bytes())
+	}
+	length, err := ReadLength(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadLength failed: %v", err)
+	}
+	if length != 300 {
+		t.Fatalf("ReadLength mismatch: got %d, want 300", length)
+	}
+
+	reader := bytes.NewReader([]byte{0x03, 0x01, 0x09, 0x02, 0xAA, 0xBB, 0xFE})
+	first, err := DecodeFrom(reader)
+	if err != nil {
+		t.Fatalf("DecodeFrom first value failed: %v", err)
+	}
+	if first != true {
+		t.Fatalf("first value mismatch: got %v", first)
+	}
+	second, err := DecodeFrom(reader)
+	if err != nil {
+		t.Fatalf("DecodeFrom second value failed: %v", err)
+	}
+	if !bytes.Equal(second.([]byte), []byte{0xAA, 0xBB}) {
+		t.Fatalf("second value mismatch: got %x", second)
+	}
+	if reader.Len() != 1 {
+		t.Fatalf("reader should be left after the second value, remaining %d bytes", reader.Len())
+	}
+}
+
 // TestErrorCases tests error handling for invalid inputs.
 func TestErrorCases(t *testing.T) {
 	tests := []struct {
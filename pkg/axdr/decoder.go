@@ -15,6 +15,13 @@ func Decode(data []byte) (interface{}, error) {
 	return decodeValue(reader)
 }
 
+// DecodeFrom decodes a single A-XDR value from reader and leaves the reader positioned
+// right after it. It allows callers to decode a Data value embedded in a larger structure
+// (e.g. an xDLMS APDU) without knowing its encoded length in advance.
+func DecodeFrom(reader *bytes.Reader) (interface{}, error) {
+	return decodeValue(reader)
+}
+
 // decodeFunc defines a function signature for type-specific decoding.
 type decodeFunc func(reader *bytes.Reader) (interface{}, error)
 
@@ -43,6 +50,7 @@ func init() {
 		TagDeltaDoubleLongUnsigned: decodeUint32,
 		TagLong64:                  decodeInt64,
 		TagLong64U:                 decodeUint64,
+		TagEnum:                    decodeEnum,
 		TagFloat32:                 decodeFloat32,
 		TagFloat64:                 decodeFloat64,
 		TagOctetString:             decodeOctetString,
@@ -122,6 +130,16 @@ func decodeUint8(reader *bytes.Reader) (interface{}, error) {
 	return uint8(b), nil
 }
 
+// decodeEnum decodes an enumeration (TagEnum) as a single unsigned byte.
+// Returns the Enum or an error if reading fails.
+func decodeEnum(reader *bytes.Reader) (interface{}, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode enum: %v", err)
+	}
+	return Enum(b), nil
+}
+
 // decodeUint16 decodes a 16-bit unsigned integer (TagLongUnsigned, TagDeltaLongUnsigned).
 // Range: 0 to 65,535.
 // Returns the integer or an error if reading fails.
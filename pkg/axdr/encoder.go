@@ -40,6 +40,9 @@ func init() {
 		reflect.TypeOf(uint8(0)): func(buf *bytes.Buffer, v interface{}) error {
 			return encodePrimitive(buf, reflect.ValueOf(v), TagDeltaUnsigned, func() { buf.WriteByte(v.(uint8)) })
 		},
+		reflect.TypeOf(Enum(0)): func(buf *bytes.Buffer, v interface{}) error {
+			return encodePrimitive(buf, reflect.ValueOf(v), TagEnum, func() { buf.WriteByte(byte(v.(Enum))) })
+		},
 		reflect.TypeOf(uint16(0)): func(buf *bytes.Buffer, v interface{}) error {
 			return encodePrimitive(buf, reflect.ValueOf(v), TagDeltaLongUnsigned, func() { _ = binary.Write(buf, binary.BigEndian, v.(uint16)) })
 		},
@@ -73,3 +73,16 @@ func readAXDRLength(reader *bytes.Reader) (int, error) {
 
 	return int(length), nil
 }
+
+// WriteLength appends an A-XDR length field to buf.
+// It is exported for codecs built on top of A-XDR (e.g. xDLMS APDUs) that embed
+// variable-length octet strings or SEQUENCE OF counts outside a tagged Data value.
+func WriteLength(buf *bytes.Buffer, length int) error {
+	return writeAXDRLength(buf, length)
+}
+
+// ReadLength reads an A-XDR length field from reader.
+// It is the counterpart of WriteLength.
+func ReadLength(reader *bytes.Reader) (int, error) {
+	return readAXDRLength(reader)
+}
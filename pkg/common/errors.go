@@ -25,6 +25,7 @@ const (
 	ErrCosemObjectAccessDenied
 	ErrCosemTypeMismatch
 	ErrCosemSecurityPolicyViolation
+	ErrCosemServiceNotSupported
 )
 
 // SpodesError is a custom error type for the application.
@@ -56,3 +57,8 @@ func (e *SpodesError) Error() string {
 func (e *SpodesError) Cause() error {
 	return e.cause
 }
+
+// Unwrap returns the underlying cause so that errors.Is and errors.As see it.
+func (e *SpodesError) Unwrap() error {
+	return e.cause
+}
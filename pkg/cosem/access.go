@@ -0,0 +1,313 @@
+package cosem
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
+)
+
+// APDUType constants for the Access service.
+const (
+	APDU_ACCESS_REQUEST  APDUType = 0xD9
+	APDU_ACCESS_RESPONSE APDUType = 0xDA
+)
+
+// Bits of a Long-Invoke-Id-And-Priority.
+const (
+	// LongInvokeIDMask selects the long-invoke-id bits.
+	LongInvokeIDMask uint32 = 0x00FFFFFF
+	// LongInvokeIDSelfDescriptive asks for the request specifications to be
+	// repeated in the response.
+	LongInvokeIDSelfDescriptive uint32 = 1 << 28
+	// LongInvokeIDBreakOnError stops processing at the first failed request.
+	LongInvokeIDBreakOnError uint32 = 1 << 29
+	// LongInvokeIDConfirmed marks a confirmed service.
+	LongInvokeIDConfirmed uint32 = 1 << 30
+	// LongInvokeIDHighPriority marks a high priority service.
+	LongInvokeIDHighPriority uint32 = 1 << 31
+)
+
+// AccessRequestType is the Access-Request-Specification CHOICE.
+type AccessRequestType byte
+
+const (
+	ACCESS_REQUEST_GET                AccessRequestType = 0x01
+	ACCESS_REQUEST_SET                AccessRequestType = 0x02
+	ACCESS_REQUEST_ACTION             AccessRequestType = 0x03
+	ACCESS_REQUEST_GET_WITH_SELECTION AccessRequestType = 0x04
+	ACCESS_REQUEST_SET_WITH_SELECTION AccessRequestType = 0x05
+)
+
+// AccessResponseType is the Access-Response-Specification CHOICE.
+type AccessResponseType byte
+
+const (
+	ACCESS_RESPONSE_GET    AccessResponseType = 0x01
+	ACCESS_RESPONSE_SET    AccessResponseType = 0x02
+	ACCESS_RESPONSE_ACTION AccessResponseType = 0x03
+)
+
+// AccessRequestSpecification is a single request of an Access-Request.
+// AttributeDescriptor is used by the get and set variants; its AccessSelection is
+// carried only by the with-selection variants, where it is required.
+// MethodDescriptor is used by ACCESS_REQUEST_ACTION.
+type AccessRequestSpecification struct {
+	Type                AccessRequestType
+	AttributeDescriptor CosemAttributeDescriptor
+	MethodDescriptor    CosemMethodDescriptor
+}
+
+// AccessResponseSpecification is the result of a single request of an
+// Access-Request. The Action-Result of ACCESS_RESPONSE_ACTION shares its codes
+// with DataAccessResultEnum.
+type AccessResponseSpecification struct {
+	Type   AccessResponseType
+	Result DataAccessResultEnum
+}
+
+// AccessRequest is the structure for an Access-Request APDU. DataList holds one
+// entry per request: null-data for a get, the value for a set and the method
+// parameters for an action.
+type AccessRequest struct {
+	LongInvokeIDAndPriority uint32
+	// DateTime is the COSEM date-time of the request as a 12-byte octet string. An
+	// empty value means no date-time is sent.
+	DateTime       []byte
+	Specifications []AccessRequestSpecification
+	DataList       []interface{}
+}
+
+// AccessResponse is the structure for an Access-Response APDU. DataList holds
+// one entry per request: the value read by a get, the return parameters of an
+// action and null-data otherwise. RequestSpecifications repeats the requests when
+// the request was self-descriptive and is nil otherwise.
+type AccessResponse struct {
+	LongInvokeIDAndPriority uint32
+	// DateTime is the COSEM date-time of the response as a 12-byte octet string.
+	// An empty value means no date-time is sent.
+	DateTime              []byte
+	RequestSpecifications []AccessRequestSpecification
+	DataList              []interface{}
+	Specifications        []AccessResponseSpecification
+}
+
+// Encode encodes the AccessRequest APDU into a byte slice.
+//
+// The body is long-invoke-id-and-priority (Unsigned32), date-time (OCTET
+// STRING), the list of request specifications and the list of data.
+func (ar *AccessRequest) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_ACCESS_REQUEST))
+	writeUint32(&buf, ar.LongInvokeIDAndPriority)
+	if err := writeOctetString(&buf, ar.DateTime); err != nil {
+		return nil, err
+	}
+	if err := encodeAccessRequestSpecificationList(&buf, ar.Specifications); err != nil {
+		return nil, err
+	}
+	if err := encodeDataList(&buf, ar.DataList); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into an AccessRequest APDU.
+func (ar *AccessRequest) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_ACCESS_REQUEST, "AccessRequest")
+	if err != nil {
+		return err
+	}
+
+	ar.LongInvokeIDAndPriority, err = readUint32(reader, "LongInvokeIDAndPriority")
+	if err != nil {
+		return err
+	}
+	ar.DateTime, err = readAccessDateTime(reader)
+	if err != nil {
+		return err
+	}
+	ar.Specifications, err = decodeAccessRequestSpecificationList(reader)
+	if err != nil {
+		return err
+	}
+	ar.DataList, err = decodeDataList(reader)
+	if err != nil {
+		return err
+	}
+
+	return expectEnd(reader, "AccessRequest")
+}
+
+// Encode encodes the AccessResponse APDU into a byte slice.
+//
+// The body is long-invoke-id-and-priority (Unsigned32), date-time (OCTET
+// STRING), the OPTIONAL list of request specifications, the list of data and the
+// list of response specifications.
+func (ar *AccessResponse) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_ACCESS_RESPONSE))
+	writeUint32(&buf, ar.LongInvokeIDAndPriority)
+	if err := writeOctetString(&buf, ar.DateTime); err != nil {
+		return nil, err
+	}
+	if ar.RequestSpecifications == nil {
+		buf.WriteByte(0x00)
+	} else {
+		buf.WriteByte(0x01)
+		if err := encodeAccessRequestSpecificationList(&buf, ar.RequestSpecifications); err != nil {
+			return nil, err
+		}
+	}
+	if err := encodeDataList(&buf, ar.DataList); err != nil {
+		return nil, err
+	}
+	if err := axdr.WriteLength(&buf, len(ar.Specifications)); err != nil {
+		return nil, err
+	}
+	for _, spec := range ar.Specifications {
+		if spec.Type < ACCESS_RESPONSE_GET || spec.Type > ACCESS_RESPONSE_ACTION {
+			return nil, fmt.Errorf("invalid Access-Response-Specification choice: %d", spec.Type)
+		}
+		buf.WriteByte(byte(spec.Type))
+		buf.WriteByte(byte(spec.Result))
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into an AccessResponse APDU.
+func (ar *AccessResponse) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_ACCESS_RESPONSE, "AccessResponse")
+	if err != nil {
+		return err
+	}
+
+	ar.LongInvokeIDAndPriority, err = readUint32(reader, "LongInvokeIDAndPriority")
+	if err != nil {
+		return err
+	}
+	ar.DateTime, err = readAccessDateTime(reader)
+	if err != nil {
+		return err
+	}
+	present, err := readOptionalFlag(reader, "access-request-specification")
+	if err != nil {
+		return err
+	}
+	ar.RequestSpecifications = nil
+	if present {
+		ar.RequestSpecifications, err = decodeAccessRequestSpecificationList(reader)
+		if err != nil {
+			return err
+		}
+	}
+	ar.DataList, err = decodeDataList(reader)
+	if err != nil {
+		return err
+	}
+
+	count, err := readSequenceLength(reader, "access-response-specification")
+	if err != nil {
+		return err
+	}
+	ar.Specifications = make([]AccessResponseSpecification, count)
+	for i := range ar.Specifications {
+		choice, err := readByte(reader, "Access-Response-Specification")
+		if err != nil {
+			return err
+		}
+		if AccessResponseType(choice) < ACCESS_RESPONSE_GET || AccessResponseType(choice) > ACCESS_RESPONSE_ACTION {
+			return fmt.Errorf("invalid Access-Response-Specification choice: %d", choice)
+		}
+		result, err := readByte(reader, "Result")
+		if err != nil {
+			return err
+		}
+		ar.Specifications[i] = AccessResponseSpecification{Type: AccessResponseType(choice), Result: DataAccessResultEnum(result)}
+	}
+
+	return expectEnd(reader, "AccessResponse")
+}
+
+func readAccessDateTime(reader *bytes.Reader) ([]byte, error) {
+	dateTime, err := readOctetString(reader, "DateTime")
+	if err != nil {
+		return nil, err
+	}
+	if len(dateTime) != 0 && len(dateTime) != 12 {
+		return nil, fmt.Errorf("invalid Access date-time length: %d", len(dateTime))
+	}
+	return dateTime, nil
+}
+
+// encodeAccessRequestSpecificationList writes a List-Of-Access-Request-Specification.
+func encodeAccessRequestSpecificationList(buf *bytes.Buffer, specs []AccessRequestSpecification) error {
+	if err := axdr.WriteLength(buf, len(specs)); err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		buf.WriteByte(byte(spec.Type))
+		switch spec.Type {
+		case ACCESS_REQUEST_GET, ACCESS_REQUEST_SET:
+			encodeAttributeDescriptor(buf, spec.AttributeDescriptor)
+		case ACCESS_REQUEST_ACTION:
+			encodeMethodDescriptor(buf, spec.MethodDescriptor)
+		case ACCESS_REQUEST_GET_WITH_SELECTION, ACCESS_REQUEST_SET_WITH_SELECTION:
+			selection := spec.AttributeDescriptor.AccessSelection
+			if selection == nil {
+				return fmt.Errorf("missing access-selection in Access-Request-Specification %d", spec.Type)
+			}
+			encodeAttributeDescriptor(buf, spec.AttributeDescriptor)
+			buf.WriteByte(selection.AccessSelector)
+			if err := encodeData(buf, selection.AccessParameters); err != nil {
+				return fmt.Errorf("failed to encode access-parameters: %w", err)
+			}
+		default:
+			return fmt.Errorf("invalid Access-Request-Specification choice: %d", spec.Type)
+		}
+	}
+	return nil
+}
+
+func decodeAccessRequestSpecificationList(reader *bytes.Reader) ([]AccessRequestSpecification, error) {
+	count, err := readSequenceLength(reader, "access-request-specification")
+	if err != nil {
+		return nil, err
+	}
+	specs := make([]AccessRequestSpecification, count)
+	for i := range specs {
+		choice, err := readByte(reader, "Access-Request-Specification")
+		if err != nil {
+			return nil, err
+		}
+		spec := AccessRequestSpecification{Type: AccessRequestType(choice)}
+		switch spec.Type {
+		case ACCESS_REQUEST_GET, ACCESS_REQUEST_SET:
+			spec.AttributeDescriptor, err = decodeAttributeDescriptor(reader)
+		case ACCESS_REQUEST_ACTION:
+			spec.MethodDescriptor, err = decodeMethodDescriptor(reader)
+		case ACCESS_REQUEST_GET_WITH_SELECTION, ACCESS_REQUEST_SET_WITH_SELECTION:
+			spec.AttributeDescriptor, err = decodeAttributeDescriptor(reader)
+			if err != nil {
+				return nil, err
+			}
+			selection := &SelectiveAccessDescriptor{}
+			selection.AccessSelector, err = readByte(reader, "access-selector")
+			if err != nil {
+				return nil, err
+			}
+			selection.AccessParameters, err = axdr.DecodeFrom(reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode access-parameters: %w", err)
+			}
+			spec.AttributeDescriptor.AccessSelection = selection
+		default:
+			return nil, fmt.Errorf("invalid Access-Request-Specification choice: %d", choice)
+		}
+		if err != nil {
+			return nil, err
+		}
+		specs[i] = spec
+	}
+	return specs, nil
+}
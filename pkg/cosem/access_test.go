@@ -0,0 +1,159 @@
+package cosem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessRequest_WireFormat(t *testing.T) {
+	obis := obisOf(t, "1.0.1.8.0.255")
+	req := &AccessRequest{
+		LongInvokeIDAndPriority: LongInvokeIDConfirmed | 0x000102,
+		DateTime:                []byte{},
+		Specifications: []AccessRequestSpecification{
+			{Type: ACCESS_REQUEST_GET, AttributeDescriptor: CosemAttributeDescriptor{ClassID: RegisterClassID, InstanceID: obis, AttributeID: 2}},
+			{Type: ACCESS_REQUEST_SET, AttributeDescriptor: CosemAttributeDescriptor{ClassID: RegisterClassID, InstanceID: obis, AttributeID: 2}},
+			{Type: ACCESS_REQUEST_ACTION, MethodDescriptor: CosemMethodDescriptor{ClassID: RegisterClassID, InstanceID: obis, MethodID: 1}},
+			{Type: ACCESS_REQUEST_GET_WITH_SELECTION, AttributeDescriptor: CosemAttributeDescriptor{
+				ClassID: RegisterClassID, InstanceID: obis, AttributeID: 2,
+				AccessSelection: &SelectiveAccessDescriptor{AccessSelector: 2, AccessParameters: uint8(1)},
+			}},
+		},
+		DataList: []interface{}{nil, uint32(7), int8(0), nil},
+	}
+
+	encoded, err := req.Encode()
+	require.NoError(t, err)
+	ln := []byte{0x01, 0x00, 0x01, 0x08, 0x00, 0xFF}
+	want := []byte{0xD9, 0x40, 0x00, 0x01, 0x02, 0x00, 0x04}
+	want = append(append(append(want, 0x01, 0x00, 0x03), ln...), 0x02)
+	want = append(append(append(want, 0x02, 0x00, 0x03), ln...), 0x02)
+	want = append(append(append(want, 0x03, 0x00, 0x03), ln...), 0x01)
+	want = append(append(append(want, 0x04, 0x00, 0x03), ln...), 0x02, 0x02, 0x1F, 0x01)
+	want = append(want, 0x04, 0x00, 0x21, 0x00, 0x00, 0x00, 0x07, 0x1C, 0x00, 0x00)
+	assert.Equal(t, want, encoded)
+
+	decoded := &AccessRequest{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, req, decoded)
+
+	req.Specifications = append(req.Specifications, AccessRequestSpecification{Type: ACCESS_REQUEST_SET_WITH_SELECTION})
+	_, err = req.Encode()
+	assert.Error(t, err)
+	assert.Error(t, decoded.Decode(encoded[:len(encoded)-1]))
+	assert.Error(t, decoded.Decode(append(append([]byte{}, encoded...), 0x00)))
+}
+
+func TestAccessResponse_WireFormat(t *testing.T) {
+	resp := &AccessResponse{
+		LongInvokeIDAndPriority: 0x000102,
+		DateTime:                []byte{},
+		DataList:                []interface{}{uint32(7), nil},
+		Specifications: []AccessResponseSpecification{
+			{Type: ACCESS_RESPONSE_GET, Result: SUCCESS},
+			{Type: ACCESS_RESPONSE_SET, Result: READ_WRITE_DENIED},
+		},
+	}
+
+	encoded, err := resp.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0xDA, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00,
+		0x02, 0x21, 0x00, 0x00, 0x00, 0x07, 0x00,
+		0x02, 0x01, 0x00, 0x02, 0x03,
+	}, encoded)
+
+	decoded := &AccessResponse{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, resp, decoded)
+
+	resp.RequestSpecifications = []AccessRequestSpecification{
+		{Type: ACCESS_REQUEST_GET, AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: obisOf(t, "0.0.1.0.0.255"), AttributeID: 2}},
+		{Type: ACCESS_REQUEST_SET, AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: obisOf(t, "0.0.1.0.0.255"), AttributeID: 2}},
+	}
+	encoded, err = resp.Encode()
+	require.NoError(t, err)
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, resp, decoded)
+
+	assert.Error(t, decoded.Decode([]byte{0xDA, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00, 0x00, 0x01, 0x04, 0x00}))
+}
+
+func TestApplication_AccessRequest(t *testing.T) {
+	app, assoc, clientAddr, dataObj := setupTestApp(t)
+
+	register, err := NewRegister(obisOf(t, "1.0.1.8.0.255"), uint32(500), ScalerUnit{Scaler: 0, Unit: 30})
+	require.NoError(t, err)
+	app.RegisterObject(register)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{register.InstanceID}))
+
+	dataValue := CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2}
+	registerValue := CosemAttributeDescriptor{ClassID: RegisterClassID, InstanceID: register.InstanceID, AttributeID: 2}
+	missing := CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: obisOf(t, "0.0.96.1.0.255"), AttributeID: 2}
+	reset := CosemMethodDescriptor{ClassID: RegisterClassID, InstanceID: register.InstanceID, MethodID: 1}
+
+	access := func(t *testing.T, invokeID uint32, specs []AccessRequestSpecification, data []interface{}) *AccessResponse {
+		src, err := (&AccessRequest{LongInvokeIDAndPriority: invokeID, Specifications: specs, DataList: data}).Encode()
+		require.NoError(t, err)
+		encodedResp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		resp := &AccessResponse{}
+		require.NoError(t, resp.Decode(encodedResp))
+		assert.Equal(t, invokeID, resp.LongInvokeIDAndPriority)
+		return resp
+	}
+
+	t.Run("MixedRequests", func(t *testing.T) {
+		resp := access(t, LongInvokeIDConfirmed|1, []AccessRequestSpecification{
+			{Type: ACCESS_REQUEST_GET, AttributeDescriptor: registerValue},
+			{Type: ACCESS_REQUEST_SET, AttributeDescriptor: dataValue},
+			{Type: ACCESS_REQUEST_GET, AttributeDescriptor: missing},
+			{Type: ACCESS_REQUEST_ACTION, MethodDescriptor: reset},
+			{Type: ACCESS_REQUEST_GET, AttributeDescriptor: registerValue},
+			{Type: ACCESS_REQUEST_GET, AttributeDescriptor: dataValue},
+		}, []interface{}{nil, uint32(777), nil, nil, nil, nil})
+
+		assert.Nil(t, resp.RequestSpecifications)
+		assert.Equal(t, []interface{}{uint32(500), nil, nil, nil, uint32(0), uint32(777)}, resp.DataList)
+		assert.Equal(t, []AccessResponseSpecification{
+			{Type: ACCESS_RESPONSE_GET, Result: SUCCESS},
+			{Type: ACCESS_RESPONSE_SET, Result: SUCCESS},
+			{Type: ACCESS_RESPONSE_GET, Result: READ_WRITE_DENIED},
+			{Type: ACCESS_RESPONSE_ACTION, Result: SUCCESS},
+			{Type: ACCESS_RESPONSE_GET, Result: SUCCESS},
+			{Type: ACCESS_RESPONSE_GET, Result: SUCCESS},
+		}, resp.Specifications)
+	})
+
+	t.Run("BreakOnErrorAndSelfDescriptive", func(t *testing.T) {
+		specs := []AccessRequestSpecification{
+			{Type: ACCESS_REQUEST_SET, AttributeDescriptor: dataValue},
+			{Type: ACCESS_REQUEST_SET, AttributeDescriptor: missing},
+			{Type: ACCESS_REQUEST_SET, AttributeDescriptor: dataValue},
+		}
+		resp := access(t, LongInvokeIDConfirmed|LongInvokeIDBreakOnError|LongInvokeIDSelfDescriptive|2, specs,
+			[]interface{}{uint32(1), uint32(2), uint32(3)})
+
+		assert.Equal(t, specs[:2], resp.RequestSpecifications)
+		assert.Equal(t, []AccessResponseSpecification{
+			{Type: ACCESS_RESPONSE_SET, Result: SUCCESS},
+			{Type: ACCESS_RESPONSE_SET, Result: READ_WRITE_DENIED},
+		}, resp.Specifications)
+		value, err := dataObj.GetAttribute(2)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1), value)
+	})
+
+	t.Run("DataListMismatch", func(t *testing.T) {
+		src, err := (&AccessRequest{
+			LongInvokeIDAndPriority: LongInvokeIDConfirmed | 3,
+			Specifications:          []AccessRequestSpecification{{Type: ACCESS_REQUEST_GET, AttributeDescriptor: dataValue}},
+		}).Encode()
+		require.NoError(t, err)
+		encodedResp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, byte(APDU_EXCEPTION_RESPONSE), encodedResp[0])
+	})
+}
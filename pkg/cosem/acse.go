@@ -1,9 +1,14 @@
 package cosem
 
 import (
+	"bytes"
 	"crypto/ecdsa"
-	"crypto/sha256"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/asn1"
+	"fmt"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
 )
 
 // OIDs for COSEM application contexts and authentication mechanisms.
@@ -14,103 +19,328 @@ var (
 	OidApplicationContextSN = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 1, 2}
 	// OidMechanismLLS specifies the object identifier for Low-Level Security (LLS) authentication.
 	OidMechanismLLS = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 2, 1}
+	// OidMechanismHLSMD5 specifies the object identifier for HLS authentication with MD5.
+	OidMechanismHLSMD5 = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 2, 3}
+	// OidMechanismHLSSHA1 specifies the object identifier for HLS authentication with SHA-1.
+	OidMechanismHLSSHA1 = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 2, 4}
 	// OidMechanismHLS specifies the object identifier for High-Level Security (HLS) GMAC authentication.
 	OidMechanismHLS = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 2, 5}
+	// OidMechanismHLSSHA256 specifies the object identifier for HLS authentication with SHA-256.
+	OidMechanismHLSSHA256 = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 2, 6}
+	// OidMechanismHLSECDSA specifies the object identifier for HLS authentication with ECDSA.
+	OidMechanismHLSECDSA = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 2, 7}
+)
+
+// Authentication mechanism ids, the last arc of the mechanism names as held in
+// the authentication_mechanism_name of the association objects.
+const (
+	MechanismLowest    byte = 0
+	MechanismLLS       byte = 1
+	MechanismHLS       byte = 2
+	MechanismHLSMD5    byte = 3
+	MechanismHLSSHA1   byte = 4
+	MechanismHLSGMAC   byte = 5
+	MechanismHLSSHA256 byte = 6
+	MechanismHLSECDSA  byte = 7
+)
+
+// APDUType constants for the ACSE APDUs, whose tags are the BER
+// [APPLICATION 0..3] identifiers.
+const (
+	APDU_AARQ APDUType = 0x60
+	APDU_AARE APDUType = 0x61
+	APDU_RLRQ APDUType = 0x62
+	APDU_RLRE APDUType = 0x63
+)
+
+// APDUType constants for the xDLMS APDUs carried in the user-information of
+// AARQ and AARE.
+const (
+	APDU_INITIATE_REQUEST      APDUType = 0x01
+	APDU_INITIATE_RESPONSE     APDUType = 0x08
+	APDU_GLO_INITIATE_REQUEST  APDUType = 0x21
+	APDU_GLO_INITIATE_RESPONSE APDUType = 0x28
 )
 
+// conformanceTag is the BER [APPLICATION 31] tag and length of the 24-bit
+// Conformance BIT STRING embedded in the A-XDR initiate APDUs.
+var conformanceTag = []byte{0x5F, 0x1F, 0x04}
+
+// Bits of the Conformance block, numbered as in the Conformance BIT STRING of
+// IEC 62056-5-3: bit 0 is the most significant bit of the first byte.
+const (
+	ConformanceGeneralProtection           = 1
+	ConformanceGeneralBlockTransfer        = 2
+	ConformanceRead                        = 3
+	ConformanceWrite                       = 4
+	ConformanceUnconfirmedWrite            = 5
+	ConformanceDeltaValueEncoding          = 6
+	ConformanceAttribute0WithSet           = 8
+	ConformancePriorityManagement          = 9
+	ConformanceAttribute0WithGet           = 10
+	ConformanceBlockTransferWithGetOrRead  = 11
+	ConformanceBlockTransferWithSetOrWrite = 12
+	ConformanceBlockTransferWithAction     = 13
+	ConformanceMultipleReferences          = 14
+	ConformanceInformationReport           = 15
+	ConformanceDataNotification            = 16
+	ConformanceAccess                      = 17
+	ConformanceParameterizedAccess         = 18
+	ConformanceGet                         = 19
+	ConformanceSet                         = 20
+	ConformanceSelectiveAccess             = 21
+	ConformanceEventNotification           = 22
+	ConformanceAction                      = 23
+)
+
+// defaultConformanceBits are the services served by Application on any
+// transport.
+var defaultConformanceBits = []int{
+	ConformanceGeneralProtection,
+	ConformanceRead,
+	ConformanceWrite,
+	ConformanceUnconfirmedWrite,
+	ConformancePriorityManagement,
+	ConformanceBlockTransferWithGetOrRead,
+	ConformanceBlockTransferWithSetOrWrite,
+	ConformanceBlockTransferWithAction,
+	ConformanceMultipleReferences,
+	ConformanceDataNotification,
+	ConformanceAccess,
+	ConformanceParameterizedAccess,
+	ConformanceGet,
+	ConformanceSet,
+	ConformanceSelectiveAccess,
+	ConformanceEventNotification,
+	ConformanceAction,
+}
+
+// DefaultConformance returns the Conformance block of the services served by
+// Application on any transport, advertised to clients in the negotiated
+// conformance. General-Block-Transfer is only served behind a GBTTransport, so
+// it is left out.
+func DefaultConformance() asn1.BitString {
+	return NewConformance(defaultConformanceBits...)
+}
+
+// Conformance bits of the services that exist only with LN or only with SN
+// referencing. They are removed from the conformance negotiated for the other
+// application context.
+var (
+	lnOnlyConformance = NewConformance(
+		ConformanceAttribute0WithSet,
+		ConformanceAttribute0WithGet,
+		ConformanceBlockTransferWithAction,
+		ConformanceAccess,
+		ConformanceGet,
+		ConformanceSet,
+		ConformanceSelectiveAccess,
+		ConformanceEventNotification,
+		ConformanceAction,
+	)
+	snOnlyConformance = NewConformance(
+		ConformanceRead,
+		ConformanceWrite,
+		ConformanceUnconfirmedWrite,
+		ConformanceInformationReport,
+		ConformanceParameterizedAccess,
+	)
+)
+
+// DLMSVersion is the DLMS version number implemented by the server. Clients
+// proposing an older version are refused.
+const DLMSVersion = 6
+
+// minPDUSize is the smallest max receive PDU size a client may propose.
+const minPDUSize = 12
+
+// Values of the vaa-name of an InitiateResponse: the short name of the current
+// association object, which is 0x0007 under LN referencing.
+const (
+	VAANameLN = 0x0007
+	VAANameSN = 0xFA00
+)
+
+// NewConformance returns a 24-bit Conformance block with the given bits set.
+func NewConformance(bits ...int) asn1.BitString {
+	conformance := asn1.BitString{Bytes: make([]byte, 3), BitLength: 24}
+	for _, bit := range bits {
+		conformance.Bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+	return conformance
+}
+
 // AssociationState represents the state of the COSEM association.
 type AssociationState int
 
 const (
 	StateUnassociated AssociationState = iota
 	StateAssociated
+	StateAssociationPending
+)
+
+// Lengths of the HLS challenges. The client challenge CtoS must be 8 to 64 bytes
+// long; the server sends a StoC of challengeSize bytes.
+const (
+	minChallengeSize = 8
+	maxChallengeSize = 64
+	challengeSize    = 16
 )
 
 // ACSE manages the state of a COSEM association.
 type ACSE struct {
 	state             AssociationState
+	mechanism         byte
 	password          string
 	privateKey        *ecdsa.PrivateKey
 	serverSystemTitle []byte
+	clientSystemTitle []byte
+	dedicatedKey      []byte
+	maxReceivePDUSize uint16
+	conformance       asn1.BitString
+	lastFrameCounter  uint32
+	context           XDLMSContextInfo
+	ctos, stoc        []byte
 }
 
-// NewACSE creates a new ACSE manager.
+// NewACSE creates a new ACSE manager. password is the secret of the association:
+// the LLS password, or the HLS secret of mechanisms 3, 4 and 6. privateKey signs
+// f(CtoS) under mechanism 7.
 func NewACSE(password string, privateKey *ecdsa.PrivateKey, serverSystemTitle []byte) *ACSE {
 	return &ACSE{
 		state:             StateUnassociated,
 		password:          password,
 		privateKey:        privateKey,
 		serverSystemTitle: serverSystemTitle,
+		maxReceivePDUSize: DefaultMaxPDUSize,
+		conformance:       DefaultConformance(),
 	}
 }
 
+// SetMaxReceivePDUSize sets the largest APDU the server accepts, returned to
+// clients as the server-max-receive-pdu-size of the InitiateResponse.
+func (a *ACSE) SetMaxReceivePDUSize(size uint16) {
+	a.maxReceivePDUSize = size
+}
+
+// SetConformance sets the Conformance block of the services the server supports,
+// intersected with the one proposed by clients to negotiate the conformance.
+func (a *ACSE) SetConformance(conformance asn1.BitString) {
+	a.conformance = conformance
+}
+
+// SetLastFrameCounter sets the frame counter of the last ciphered APDU received
+// from the client. A glo-initiate-request must carry a greater one.
+func (a *ACSE) SetLastFrameCounter(frameCounter uint32) {
+	a.lastFrameCounter = frameCounter
+}
+
+// LastFrameCounter returns the frame counter of the last ciphered APDU received
+// from the client, which the glo-initiate-request of an AARQ advances.
+func (a *ACSE) LastFrameCounter() uint32 {
+	return a.lastFrameCounter
+}
+
 // AARQ (Association Request) APDU structure, used to initiate a COSEM association.
-// It is encoded using ASN.1 BER rules.
+// It is encoded using the BER rules of IEC 62056-5-3 as an [APPLICATION 0] SEQUENCE;
+// protocol-version is left at its default and the called-* and invocation
+// identifier fields are not used. Empty fields are not sent.
 type AARQ struct {
-	ProtocolVersion            asn1.BitString        `asn1:"tag:0,optional,default:0"`
-	ApplicationContextName     asn1.ObjectIdentifier `asn1:"tag:1"`
-	CallingAPtitle             asn1.RawValue         `asn1:"tag:2,optional"`
-	RespondingAPtitle          asn1.RawValue         `asn1:"tag:3,optional"`
-	MechanismName              asn1.ObjectIdentifier `asn1:"tag:11,optional"`
-	CallingAuthenticationValue asn1.RawValue         `asn1:"tag:12,explicit"` // CHOICE of Authentication-value
-	UserInformation            asn1.RawValue         `asn1:"tag:30,optional"`
+	ApplicationContextName asn1.ObjectIdentifier
+	// CallingAPTitle is the system title of the client.
+	CallingAPTitle []byte
+	// CallingAEQualifier carries the public key certificate of the client when
+	// the association uses ECDSA authentication.
+	CallingAEQualifier []byte
+	// SenderACSERequirements selects the authentication functional unit; it is
+	// needed for MechanismName and CallingAuthenticationValue to be sent.
+	SenderACSERequirements bool
+	MechanismName          asn1.ObjectIdentifier
+	// CallingAuthenticationValue is the password for LLS or the challenge CtoS
+	// for HLS; nil means it is not sent.
+	CallingAuthenticationValue *AuthenticationValue
+	// UserInformation is the A-XDR encoded xDLMS APDU, normally an
+	// InitiateRequest, carried as an OCTET STRING.
+	UserInformation []byte
 }
 
 // AARE (Association Response) APDU structure, sent in response to an AARQ.
-// It is encoded using ASN.1 BER rules.
+// It is encoded using the BER rules of IEC 62056-5-3 as an [APPLICATION 1]
+// SEQUENCE. Empty optional fields are not sent.
 type AARE struct {
-	ProtocolVersion               asn1.BitString         `asn1:"tag:0,optional,default:0"`
-	ApplicationContextName        asn1.ObjectIdentifier  `asn1:"tag:1"`
-	Result                        asn1.Enumerated        `asn1:"tag:2"`
-	ResultSourceDiagnostic        ResultSourceDiagnostic `asn1:"tag:3,explicit"`
-	RespondingAPtitle             asn1.RawValue          `asn1:"tag:5,optional"`
-	MechanismName                 asn1.ObjectIdentifier  `asn1:"tag:9,optional"`
-	RespondingAuthenticationValue asn1.RawValue          `asn1:"tag:10,explicit,optional"` // CHOICE of Authentication-value
-	UserInformation               asn1.RawValue          `asn1:"tag:30,optional"`
+	ApplicationContextName asn1.ObjectIdentifier
+	Result                 asn1.Enumerated
+	ResultSourceDiagnostic ResultSourceDiagnostic
+	// RespondingAPTitle is the system title of the server.
+	RespondingAPTitle []byte
+	// RespondingAEQualifier carries the public key certificate of the server when
+	// the association uses ECDSA authentication.
+	RespondingAEQualifier     []byte
+	ResponderACSERequirements bool
+	MechanismName             asn1.ObjectIdentifier
+	// RespondingAuthenticationValue is the challenge StoC for HLS; nil means it
+	// is not sent.
+	RespondingAuthenticationValue *AuthenticationValue
+	// UserInformation is the A-XDR encoded InitiateResponse or
+	// Confirmed-Service-Error, carried as an OCTET STRING.
+	UserInformation []byte
 }
 
 // RLRQ (Release Request) APDU structure, used to terminate a COSEM association.
+// It is encoded as an [APPLICATION 2] SEQUENCE; the reason is always sent.
 type RLRQ struct {
-	Reason          asn1.Enumerated `asn1:"tag:0,optional"`
-	UserInformation asn1.RawValue   `asn1:"tag:30,optional"`
+	Reason asn1.Enumerated
+	// UserInformation is the A-XDR encoded xDLMS APDU, a ciphered
+	// InitiateRequest when the association uses ciphering.
+	UserInformation []byte
 }
 
 // RLRE (Release Response) APDU structure, sent in response to an RLRQ.
+// It is encoded as an [APPLICATION 3] SEQUENCE; the reason is always sent.
 type RLRE struct {
-	Reason          asn1.Enumerated `asn1:"tag:0,optional"`
-	UserInformation asn1.RawValue   `asn1:"tag:30,optional"`
+	Reason          asn1.Enumerated
+	UserInformation []byte
 }
 
-// AuthenticationValue represents the CHOICE for authentication data.
-// For LLS, the GraphicString variant is used.
+// AuthenticationValue represents the Authentication-value CHOICE. DLMS uses the
+// charstring alternative; the bitstring alternative is sent only when
+// Charstring is nil and Bitstring is not empty.
 type AuthenticationValue struct {
-	GraphicString string `asn1:"tag:0"`
+	Charstring []byte
+	Bitstring  asn1.BitString
 }
 
-// HLSAuthentication represents the authentication value for HLS.
-type HLSAuthentication struct {
-	EphemeralPublicKey []byte `asn1:"tag:0"`
-}
-
-// ResultSourceDiagnostic represents the CHOICE for association result diagnostics.
+// ResultSourceDiagnostic represents the Associate-source-diagnostic CHOICE. The
+// acse-service-provider alternative is sent when ACSEServiceProvider is not
+// null; otherwise the acse-service-user alternative is sent.
 type ResultSourceDiagnostic struct {
-	ACSEServiceUser     asn1.Enumerated `asn1:"tag:1,optional"`
-	ACSEServiceProvider asn1.Enumerated `asn1:"tag:2,optional"`
+	ACSEServiceUser     asn1.Enumerated
+	ACSEServiceProvider asn1.Enumerated
 }
 
-// InitiateRequest represents the user-information field of an AARQ APDU.
+// InitiateRequest represents the xDLMS InitiateRequest carried in the
+// user-information field of an AARQ APDU. It is encoded in A-XDR; the
+// response-allowed and proposed-quality-of-service components are always sent
+// with their default values and ignored when received.
 type InitiateRequest struct {
-	ProposedConformance       asn1.BitString `asn1:"optional"`
-	ProposedMaxPduSize        int            `asn1:"optional"`
-	ProposedDlmsVersionNumber int            `asn1:"optional"`
+	// DedicatedKey is the key the client proposes for dedicated ciphering during
+	// the association. It may only be sent in a ciphered InitiateRequest; nil
+	// means no dedicated key.
+	DedicatedKey              []byte
+	ProposedConformance       asn1.BitString
+	ProposedMaxPduSize        int
+	ProposedDlmsVersionNumber int
 }
 
-// InitiateResponse represents the user-information field of an AARE APDU.
+// InitiateResponse represents the xDLMS InitiateResponse carried in the
+// user-information field of an AARE APDU. It is encoded in A-XDR; the
+// negotiated-quality-of-service component is never sent and ignored when
+// received. NegotiatedMaxPduSize is the server-max-receive-pdu-size.
 type InitiateResponse struct {
-	NegotiatedConformance       asn1.BitString  `asn1:"optional"`
-	NegotiatedMaxPduSize        int             `asn1:"optional"`
-	NegotiatedDlmsVersionNumber int             `asn1:"optional"`
-	VAAname                     asn1.Enumerated `asn1:"optional"`
+	NegotiatedConformance       asn1.BitString
+	NegotiatedMaxPduSize        int
+	NegotiatedDlmsVersionNumber int
+	VAAname                     int
 }
 
 // Enumerated values for AARE.Result field.
@@ -125,8 +355,11 @@ const (
 	ACSEUserNull                                asn1.Enumerated = 0
 	ACSEUserNoReasonGiven                       asn1.Enumerated = 1
 	ACSEUserAppContextNotSupported              asn1.Enumerated = 2
-	ACSEUserAuthenticationFailed                asn1.Enumerated = 14
-	ACSEUserAuthenticationMechanismNotSupported asn1.Enumerated = 15
+	ACSEUserCallingAPTitleNotRecognized         asn1.Enumerated = 3
+	ACSEUserAuthenticationMechanismNotSupported asn1.Enumerated = 11
+	ACSEUserAuthenticationMechanismRequired     asn1.Enumerated = 12
+	ACSEUserAuthenticationFailed                asn1.Enumerated = 13
+	ACSEUserAuthenticationRequired              asn1.Enumerated = 14
 )
 
 // Enumerated values for ResultSourceDiagnostic.ACSEServiceProvider.
@@ -143,11 +376,19 @@ const (
 	ReasonUserDefined asn1.Enumerated = 30
 )
 
-// HandleAARQ processes an AARQ and returns an AARE.
+// HandleAARQ processes an AARQ and returns an AARE. Under the HLS mechanisms 3
+// to 7 the AARE carries the server challenge StoC and the association is left
+// pending until the client answers it (see ReplyToHLSAuthentication). Mechanism 7
+// needs the server signing key.
 func (a *ACSE) HandleAARQ(req *AARQ, securitySetup *SecuritySetup) (*AARE, error) {
+	a.ctos, a.stoc = nil, nil
 	resp := &AARE{
-		ApplicationContextName: req.ApplicationContextName,
-		MechanismName:          req.MechanismName,
+		ApplicationContextName:    req.ApplicationContextName,
+		ResponderACSERequirements: req.SenderACSERequirements,
+		MechanismName:             req.MechanismName,
+	}
+	if len(a.serverSystemTitle) > 0 {
+		resp.RespondingAPTitle = a.serverSystemTitle
 	}
 
 	if !req.ApplicationContextName.Equal(OidApplicationContextLN) && !req.ApplicationContextName.Equal(OidApplicationContextSN) {
@@ -158,137 +399,1061 @@ func (a *ACSE) HandleAARQ(req *AARQ, securitySetup *SecuritySetup) (*AARE, error
 		return resp, nil
 	}
 
-	if req.MechanismName.Equal(OidMechanismLLS) {
-		var authVal AuthenticationValue
-		_, err := asn1.Unmarshal(req.CallingAuthenticationValue.Bytes, &authVal)
-		if err != nil {
+	clientSystemTitle, ok := callingSystemTitle(req, securitySetup)
+	if !ok {
+		resp.Result = ResultRejectedPermanent
+		resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
+			ACSEServiceUser: ACSEUserCallingAPTitleNotRecognized,
+		}
+		return resp, nil
+	}
+	a.clientSystemTitle = clientSystemTitle
+
+	mechanism, ok := mechanismID(req.MechanismName)
+	if !ok {
+		resp.Result = ResultRejectedPermanent
+		resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
+			ACSEServiceUser: ACSEUserAuthenticationMechanismNotSupported,
+		}
+		return resp, nil
+	}
+	a.mechanism = mechanism
+
+	switch mechanism {
+	case MechanismLowest:
+		// Lowest level security: the client is not authenticated.
+	case MechanismLLS:
+		authVal := req.CallingAuthenticationValue
+		if authVal == nil {
 			resp.Result = ResultRejectedPermanent
 			resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
-				ACSEServiceUser: ACSEUserAuthenticationFailed,
+				ACSEServiceUser: ACSEUserAuthenticationRequired,
 			}
 			return resp, nil
 		}
 
-		if authVal.GraphicString != a.password {
+		if string(authVal.Charstring) != a.password {
 			resp.Result = ResultRejectedPermanent
 			resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
 				ACSEServiceUser: ACSEUserAuthenticationFailed,
 			}
 			return resp, nil
 		}
-	} else if req.MechanismName.Equal(OidMechanismHLS) {
-		if a.privateKey == nil {
+	case MechanismHLSMD5, MechanismHLSSHA1, MechanismHLSGMAC, MechanismHLSSHA256, MechanismHLSECDSA:
+		if mechanism == MechanismHLSECDSA && a.privateKey == nil {
 			resp.Result = ResultRejectedPermanent
 			resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
 				ACSEServiceUser: ACSEUserAuthenticationMechanismNotSupported,
 			}
 			return resp, nil
 		}
-
-		var authVal HLSAuthentication
-		_, err := asn1.Unmarshal(req.CallingAuthenticationValue.Bytes, &authVal)
-		if err != nil {
+		if req.CallingAuthenticationValue == nil {
+			resp.Result = ResultRejectedPermanent
+			resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
+				ACSEServiceUser: ACSEUserAuthenticationRequired,
+			}
+			return resp, nil
+		}
+		ctos := req.CallingAuthenticationValue.Charstring
+		if len(ctos) < minChallengeSize || len(ctos) > maxChallengeSize {
 			resp.Result = ResultRejectedPermanent
 			resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
 				ACSEServiceUser: ACSEUserAuthenticationFailed,
 			}
 			return resp, nil
 		}
-		// In a real implementation, the public key would be parsed and used for ECDH
-		// For now, we'll just accept any public key.
-
-		// Key agreement
-		clientEphemeralPublicKey, err := UnmarshalPublicKey(authVal.EphemeralPublicKey)
-		if err != nil {
+		stoc := make([]byte, challengeSize)
+		if _, err := rand.Read(stoc); err != nil {
 			return nil, err
 		}
-		sharedSecret, err := ECDH(a.privateKey, clientEphemeralPublicKey)
+		a.ctos = append([]byte(nil), ctos...)
+		a.stoc = stoc
+		resp.RespondingAuthenticationValue = &AuthenticationValue{Charstring: stoc}
+
+	default:
+		resp.Result = ResultRejectedPermanent
+		resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
+			ACSEServiceUser: ACSEUserAuthenticationMechanismNotSupported,
+		}
+		return resp, nil
+	}
+
+	a.context = XDLMSContextInfo{}
+	if req.UserInformation != nil {
+		initiate, err := a.decodeInitiateRequest(req.UserInformation, securitySetup)
 		if err != nil {
-			return nil, err
+			resp.Result = ResultRejectedPermanent
+			resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
+				ACSEServiceUser: ACSEUserNoReasonGiven,
+			}
+			return resp, nil
 		}
 
-		// Key derivation
-		guek, gak, err := deriveKeys(sharedSecret)
+		initiateResponse, initiateError := a.negotiate(initiate, req.ApplicationContextName)
+		if initiateError != nil {
+			resp.Result = ResultRejectedPermanent
+			resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
+				ACSEServiceUser: ACSEUserNoReasonGiven,
+			}
+			resp.UserInformation, err = initiateError.Encode()
+			return resp, err
+		}
+		resp.UserInformation, err = initiateResponse.Encode()
 		if err != nil {
 			return nil, err
 		}
-		securitySetup.GlobalUnicastKey = guek
-		securitySetup.GlobalAuthenticationKey = gak
+		a.dedicatedKey = initiate.DedicatedKey
+	}
 
-	} else {
-		resp.Result = ResultRejectedPermanent
+	resp.Result = ResultAccepted
+	if a.stoc != nil {
+		// The client is authenticated by reply_to_HLS_authentication.
+		a.state = StateAssociationPending
 		resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
-			ACSEServiceUser: ACSEUserAuthenticationMechanismNotSupported,
+			ACSEServiceUser: ACSEUserAuthenticationRequired,
 		}
 		return resp, nil
 	}
-
 	a.state = StateAssociated
-	resp.Result = ResultAccepted
 	resp.ResultSourceDiagnostic = ResultSourceDiagnostic{
 		ACSEServiceUser: ACSEUserNull,
 	}
 	return resp, nil
 }
 
+// ReplyToHLSAuthentication completes the HLS authentication of a pending
+// association with f(StoC), the value the client passed to the
+// reply_to_HLS_authentication method, and returns f(CtoS) for the client to
+// authenticate the server in turn. The client system title is the one of the
+// AARQ and the server system title attribute 5 of securitySetup. Under mechanism 5
+// f(StoC) is computed by HLSGMAC under the global keys of securitySetup with a
+// frame counter that must follow the last one received from the client, and is
+// then recorded as it; f(CtoS) is computed with the frame counter
+// nextServerFrameCounter returns, only taken once f(StoC) is verified.
+// Mechanisms 3, 4 and 6 hash the challenges with the secret of the ACSE (see
+// HLSDigest), and under mechanism 7 the client signature is checked with the
+// ClientSigningKey of securitySetup (see HLSSign). The association stays pending
+// if f(StoC) is wrong.
+func (a *ACSE) ReplyToHLSAuthentication(fStoC []byte, securitySetup *SecuritySetup, nextServerFrameCounter func() uint32) ([]byte, error) {
+	if a.state != StateAssociationPending {
+		return nil, fmt.Errorf("no HLS authentication pending")
+	}
+	serverSystemTitle, err := securitySetup.GetAttribute(5)
+	if err != nil {
+		return nil, err
+	}
+	clientTitle, serverTitle := a.clientSystemTitle, serverSystemTitle.([]byte)
+
+	var fCtoS []byte
+	switch a.mechanism {
+	case MechanismHLSGMAC:
+		if err := VerifyHLSGMAC(fStoC, securitySetup.GlobalUnicastKey, securitySetup.GlobalAuthenticationKey, a.stoc, clientTitle); err != nil {
+			return nil, err
+		}
+		header := &SecurityHeader{}
+		if err := header.Decode(fStoC); err != nil {
+			return nil, err
+		}
+		if header.FrameCounter <= a.lastFrameCounter {
+			return nil, ErrReplayAttack
+		}
+		a.lastFrameCounter = header.FrameCounter
+		fCtoS, err = HLSGMAC(securitySetup.GlobalUnicastKey, securitySetup.GlobalAuthenticationKey, a.ctos, serverTitle, nextServerFrameCounter())
+	case MechanismHLSMD5, MechanismHLSSHA1, MechanismHLSSHA256:
+		secret := []byte(a.password)
+		var expected []byte
+		if expected, err = HLSDigest(a.mechanism, secret, a.stoc, a.ctos, clientTitle, serverTitle); err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare(fStoC, expected) != 1 {
+			return nil, ErrAuthenticationFailed
+		}
+		fCtoS, err = HLSDigest(a.mechanism, secret, a.ctos, a.stoc, serverTitle, clientTitle)
+	case MechanismHLSECDSA:
+		clientKey, _ := securitySetup.ClientSigningKey.(*ecdsa.PublicKey)
+		if err := VerifyHLSSignature(fStoC, clientKey, a.stoc, a.ctos, clientTitle, serverTitle); err != nil {
+			return nil, err
+		}
+		fCtoS, err = HLSSign(a.privateKey, a.ctos, a.stoc, serverTitle, clientTitle)
+	default:
+		return nil, fmt.Errorf("unsupported HLS mechanism: %d", a.mechanism)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.state = StateAssociated
+	a.ctos, a.stoc = nil, nil
+	return fCtoS, nil
+}
+
+// ClientSystemTitle returns the system title of the client, sent in the
+// calling-AP-title of the AARQ or else the client_system_title of the security
+// setup.
+func (a *ACSE) ClientSystemTitle() []byte {
+	return a.clientSystemTitle
+}
+
+// callingSystemTitle returns the system title of the client proposing req: its
+// calling-AP-title, which must match the client_system_title of securitySetup
+// when one is set, or else that client_system_title. ok is false for a client
+// the server does not recognise.
+func callingSystemTitle(req *AARQ, securitySetup *SecuritySetup) (title []byte, ok bool) {
+	var expected []byte
+	if securitySetup != nil {
+		expected, _ = securitySetup.Attributes[4].Value.([]byte)
+	}
+	if req.CallingAPTitle == nil {
+		return expected, true
+	}
+	if len(expected) > 0 && !bytes.Equal(req.CallingAPTitle, expected) {
+		return nil, false
+	}
+	return req.CallingAPTitle, true
+}
+
+// mechanismID returns the mechanism_id of the authentication mechanism name, the
+// last arc of 2.16.756.5.8.2.x, as held in the authentication_mechanism_name of
+// the association objects. The lowest level security has no mechanism name and
+// mechanism_id 0. ok is false for a name outside the COSEM mechanisms.
+func mechanismID(name asn1.ObjectIdentifier) (id byte, ok bool) {
+	if len(name) == 0 {
+		return 0, true
+	}
+	if len(name) != len(OidMechanismLLS) || !name[:len(name)-1].Equal(OidMechanismLLS[:len(OidMechanismLLS)-1]) {
+		return 0, false
+	}
+	last := name[len(name)-1]
+	if last < 0 || last > 0xFF {
+		return 0, false
+	}
+	return byte(last), true
+}
+
+// negotiate agrees on the xDLMS context proposed by initiate: the conformance
+// block is the intersection of the proposed one with the supported one, and
+// the server sends APDUs of at most the client-max-receive-pdu-size, 0 meaning
+// no limit. A proposal the server cannot accept is answered with the
+// Confirmed-Service-Error returned in place of the InitiateResponse.
+func (a *ACSE) negotiate(initiate *InitiateRequest, applicationContextName asn1.ObjectIdentifier) (*InitiateResponse, *ConfirmedServiceError) {
+	initiateError := func(value byte) *ConfirmedServiceError {
+		return &ConfirmedServiceError{Service: CONFIRMED_SERVICE_ERROR_INITIATE, ErrorType: SERVICE_ERROR_TYPE_INITIATE, Value: value}
+	}
+
+	if initiate.ProposedDlmsVersionNumber < DLMSVersion {
+		return nil, initiateError(INITIATE_DLMS_VERSION_TOO_LOW)
+	}
+	maxSendPDUSize := initiate.ProposedMaxPduSize
+	if maxSendPDUSize == 0 {
+		maxSendPDUSize = 0xFFFF
+	} else if maxSendPDUSize < minPDUSize {
+		return nil, initiateError(INITIATE_PDU_SIZE_TOO_SHORT)
+	}
+
+	contextOnly, vaaName := snOnlyConformance, VAANameLN
+	if applicationContextName.Equal(OidApplicationContextSN) {
+		contextOnly, vaaName = lnOnlyConformance, VAANameSN
+	}
+	conformance := NewConformance()
+	var proposed, supported [3]byte
+	copy(proposed[:], initiate.ProposedConformance.Bytes)
+	copy(supported[:], a.conformance.Bytes)
+	for i := range conformance.Bytes {
+		conformance.Bytes[i] = proposed[i] & supported[i] &^ contextOnly.Bytes[i]
+	}
+	if conformanceValue(conformance) == 0 {
+		return nil, initiateError(INITIATE_INCOMPATIBLE_CONFORMANCE)
+	}
+
+	a.context = XDLMSContextInfo{
+		Conformance:       conformanceValue(conformance),
+		MaxReceivePDUSize: a.maxReceivePDUSize,
+		MaxSendPDUSize:    uint16(maxSendPDUSize),
+		DLMSVersionNumber: DLMSVersion,
+		CypheringInfo:     []byte{},
+	}
+	return &InitiateResponse{
+		NegotiatedConformance:       conformance,
+		NegotiatedMaxPduSize:        int(a.maxReceivePDUSize),
+		NegotiatedDlmsVersionNumber: DLMSVersion,
+		VAAname:                     vaaName,
+	}, nil
+}
+
+// conformanceValue returns the 24-bit Conformance block as an integer, bit 0
+// being its most significant bit.
+func conformanceValue(conformance asn1.BitString) uint32 {
+	var bits [3]byte
+	copy(bits[:], conformance.Bytes)
+	return uint32(bits[0])<<16 | uint32(bits[1])<<8 | uint32(bits[2])
+}
+
 // HandleRLRQ processes an RLRQ and returns an RLRE.
 func (a *ACSE) HandleRLRQ(req *RLRQ) *RLRE {
 	a.state = StateUnassociated
+	a.ctos, a.stoc = nil, nil
+	a.clientSystemTitle = nil
+	a.dedicatedKey = nil
+	a.context = XDLMSContextInfo{}
 	return &RLRE{
 		Reason: req.Reason,
 	}
 }
 
-// deriveKeys derives the GUEK and GAK from the shared secret.
-func deriveKeys(sharedSecret []byte) ([]byte, []byte, error) {
-	// For simplicity, we'll use a simple key derivation function.
-	// In a real implementation, a proper KDF like HKDF should be used.
-	hash := sha256.Sum256(sharedSecret)
-	guek := hash[:16]
-	gak := hash[16:]
-	return guek, gak, nil
+// DedicatedKey returns the dedicated key the client sent in the InitiateRequest
+// of the current association, or nil if it did not send one.
+func (a *ACSE) DedicatedKey() []byte {
+	return a.dedicatedKey
+}
+
+// XDLMSContext returns the xDLMS context negotiated by the InitiateRequest of
+// the current association, to be recorded in the xDLMS_context_info of its
+// association object (see Application.SetXDLMSContext). It is the zero value
+// if no InitiateRequest was negotiated.
+func (a *ACSE) XDLMSContext() XDLMSContextInfo {
+	return a.context
+}
+
+// decodeInitiateRequest decodes the InitiateRequest carried in the
+// user-information of an AARQ. A glo-initiate-request is deciphered with the
+// global unicast key of securitySetup under the system title of the client, and
+// its frame counter recorded as the last one received from the client.
+func (a *ACSE) decodeInitiateRequest(apdu []byte, securitySetup *SecuritySetup) (*InitiateRequest, error) {
+	if len(apdu) == 0 {
+		return nil, fmt.Errorf("empty user-information")
+	}
+
+	initiate := &InitiateRequest{}
+	if APDUType(apdu[0]) != APDU_GLO_INITIATE_REQUEST {
+		if err := initiate.Decode(apdu); err != nil {
+			return nil, err
+		}
+		if initiate.DedicatedKey != nil {
+			return nil, fmt.Errorf("dedicated key sent in an unciphered InitiateRequest")
+		}
+		return initiate, nil
+	}
+
+	if securitySetup == nil {
+		return nil, fmt.Errorf("no security setup to decipher the InitiateRequest")
+	}
+	suite, err := securitySetup.GetAttribute(3)
+	if err != nil {
+		return nil, err
+	}
+	ciphered, err := initiate.DecodeGloCiphered(apdu, securitySetup.GlobalUnicastKey, a.clientSystemTitle, suite.(SecuritySuite), a.lastFrameCounter)
+	if err != nil {
+		return nil, err
+	}
+	a.lastFrameCounter = ciphered.SecurityHeader.FrameCounter
+	return initiate, nil
 }
 
-// Encode encodes the AARQ APDU into a byte slice.
+// Encode encodes the AARQ APDU in BER. The sender-acse-requirements field is
+// sent only when SenderACSERequirements is set.
 func (a *AARQ) Encode() ([]byte, error) {
-	return asn1.Marshal(*a)
+	var body bytes.Buffer
+	if err := writeBERObjectIdentifier(&body, berTagApplicationContextName, a.ApplicationContextName, true); err != nil {
+		return nil, fmt.Errorf("invalid application-context-name: %w", err)
+	}
+	if a.CallingAPTitle != nil {
+		writeBERExplicit(&body, berTagCallingAPTitle, berTagOctetString, a.CallingAPTitle)
+	}
+	if a.CallingAEQualifier != nil {
+		writeBERExplicit(&body, berTagCallingAEQualifier, berTagOctetString, a.CallingAEQualifier)
+	}
+	if a.SenderACSERequirements {
+		writeBER(&body, berTagSenderACSERequirements, acseRequirementsAuthentication)
+	}
+	if a.MechanismName != nil {
+		if err := writeBERObjectIdentifier(&body, berTagAARQMechanismName, a.MechanismName, false); err != nil {
+			return nil, fmt.Errorf("invalid mechanism-name: %w", err)
+		}
+	}
+	if a.CallingAuthenticationValue != nil {
+		if err := writeAuthenticationValue(&body, berTagCallingAuthenticationValue, a.CallingAuthenticationValue); err != nil {
+			return nil, err
+		}
+	}
+	if a.UserInformation != nil {
+		writeBERExplicit(&body, berTagUserInformation, berTagOctetString, a.UserInformation)
+	}
+
+	var buf bytes.Buffer
+	writeBER(&buf, byte(APDU_AARQ), body.Bytes())
+	return buf.Bytes(), nil
 }
 
-// Decode decodes a byte slice into an AARQ APDU.
+// Decode decodes a BER encoded AARQ APDU. The called-* and invocation
+// identifier fields and the implementation-information are skipped.
 func (a *AARQ) Decode(src []byte) error {
-	_, err := asn1.Unmarshal(src, a)
-	return err
+	reader, err := newBERReader(src, APDU_AARQ, "AARQ")
+	if err != nil {
+		return err
+	}
+
+	*a = AARQ{}
+	for reader.Len() > 0 {
+		tag, content, err := readBER(reader, "AARQ component")
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case berTagApplicationContextName:
+			a.ApplicationContextName, err = readBERObjectIdentifier(content, true)
+		case berTagCallingAPTitle:
+			a.CallingAPTitle, err = readBERExplicit(content, berTagOctetString, "calling-AP-title")
+		case berTagCallingAEQualifier:
+			a.CallingAEQualifier, err = readBERExplicit(content, berTagOctetString, "calling-AE-qualifier")
+		case berTagSenderACSERequirements:
+			a.SenderACSERequirements = readACSERequirements(content)
+		case berTagAARQMechanismName:
+			a.MechanismName, err = readBERObjectIdentifier(content, false)
+		case berTagCallingAuthenticationValue:
+			a.CallingAuthenticationValue, err = readAuthenticationValue(content)
+		case berTagUserInformation:
+			a.UserInformation, err = readBERExplicit(content, berTagOctetString, "user-information")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.ApplicationContextName == nil {
+		return fmt.Errorf("missing application-context-name in AARQ")
+	}
+	return nil
 }
 
-// Encode encodes the AARE APDU into a byte slice.
+// Encode encodes the AARE APDU in BER. The responder-acse-requirements field is
+// sent only when ResponderACSERequirements is set.
 func (a *AARE) Encode() ([]byte, error) {
-	return asn1.Marshal(*a)
+	var body bytes.Buffer
+	if err := writeBERObjectIdentifier(&body, berTagApplicationContextName, a.ApplicationContextName, true); err != nil {
+		return nil, fmt.Errorf("invalid application-context-name: %w", err)
+	}
+	writeBERExplicit(&body, berTagResult, berTagInteger, marshalBERInteger(int(a.Result)))
+
+	var diagnostic bytes.Buffer
+	if a.ResultSourceDiagnostic.ACSEServiceProvider != ACSEServiceProviderNull {
+		writeBERExplicit(&diagnostic, berTagACSEServiceProvider, berTagInteger, marshalBERInteger(int(a.ResultSourceDiagnostic.ACSEServiceProvider)))
+	} else {
+		writeBERExplicit(&diagnostic, berTagACSEServiceUser, berTagInteger, marshalBERInteger(int(a.ResultSourceDiagnostic.ACSEServiceUser)))
+	}
+	writeBER(&body, berTagResultSourceDiagnostic, diagnostic.Bytes())
+
+	if a.RespondingAPTitle != nil {
+		writeBERExplicit(&body, berTagRespondingAPTitle, berTagOctetString, a.RespondingAPTitle)
+	}
+	if a.RespondingAEQualifier != nil {
+		writeBERExplicit(&body, berTagRespondingAEQualifier, berTagOctetString, a.RespondingAEQualifier)
+	}
+	if a.ResponderACSERequirements {
+		writeBER(&body, berTagResponderACSERequirements, acseRequirementsAuthentication)
+	}
+	if a.MechanismName != nil {
+		if err := writeBERObjectIdentifier(&body, berTagAAREMechanismName, a.MechanismName, false); err != nil {
+			return nil, fmt.Errorf("invalid mechanism-name: %w", err)
+		}
+	}
+	if a.RespondingAuthenticationValue != nil {
+		if err := writeAuthenticationValue(&body, berTagRespondingAuthenticationValue, a.RespondingAuthenticationValue); err != nil {
+			return nil, err
+		}
+	}
+	if a.UserInformation != nil {
+		writeBERExplicit(&body, berTagUserInformation, berTagOctetString, a.UserInformation)
+	}
+
+	var buf bytes.Buffer
+	writeBER(&buf, byte(APDU_AARE), body.Bytes())
+	return buf.Bytes(), nil
 }
 
-// Decode decodes a byte slice into an AARE APDU.
+// Decode decodes a BER encoded AARE APDU. The invocation identifier fields and
+// the implementation-information are skipped.
 func (a *AARE) Decode(src []byte) error {
-	_, err := asn1.Unmarshal(src, a)
-	return err
+	reader, err := newBERReader(src, APDU_AARE, "AARE")
+	if err != nil {
+		return err
+	}
+
+	*a = AARE{}
+	var hasResult, hasDiagnostic bool
+	for reader.Len() > 0 {
+		tag, content, err := readBER(reader, "AARE component")
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case berTagApplicationContextName:
+			a.ApplicationContextName, err = readBERObjectIdentifier(content, true)
+		case berTagResult:
+			var result int
+			result, err = readBERExplicitInteger(content, "result")
+			a.Result, hasResult = asn1.Enumerated(result), true
+		case berTagResultSourceDiagnostic:
+			a.ResultSourceDiagnostic, err = readResultSourceDiagnostic(content)
+			hasDiagnostic = true
+		case berTagRespondingAPTitle:
+			a.RespondingAPTitle, err = readBERExplicit(content, berTagOctetString, "responding-AP-title")
+		case berTagRespondingAEQualifier:
+			a.RespondingAEQualifier, err = readBERExplicit(content, berTagOctetString, "responding-AE-qualifier")
+		case berTagResponderACSERequirements:
+			a.ResponderACSERequirements = readACSERequirements(content)
+		case berTagAAREMechanismName:
+			a.MechanismName, err = readBERObjectIdentifier(content, false)
+		case berTagRespondingAuthenticationValue:
+			a.RespondingAuthenticationValue, err = readAuthenticationValue(content)
+		case berTagUserInformation:
+			a.UserInformation, err = readBERExplicit(content, berTagOctetString, "user-information")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case a.ApplicationContextName == nil:
+		return fmt.Errorf("missing application-context-name in AARE")
+	case !hasResult:
+		return fmt.Errorf("missing result in AARE")
+	case !hasDiagnostic:
+		return fmt.Errorf("missing result-source-diagnostic in AARE")
+	}
+	return nil
 }
 
-// Encode encodes the RLRQ APDU into a byte slice.
+// Encode encodes the RLRQ APDU in BER.
 func (r *RLRQ) Encode() ([]byte, error) {
-	return asn1.Marshal(*r)
+	return encodeRelease(APDU_RLRQ, r.Reason, r.UserInformation), nil
 }
 
-// Decode decodes a byte slice into an RLRQ APDU.
+// Decode decodes a BER encoded RLRQ APDU. A missing reason decodes as
+// ReasonNormal.
 func (r *RLRQ) Decode(src []byte) error {
-	_, err := asn1.Unmarshal(src, r)
+	var err error
+	r.Reason, r.UserInformation, err = decodeRelease(src, APDU_RLRQ, "RLRQ")
 	return err
 }
 
-// Encode encodes the RLRE APDU into a byte slice.
+// Encode encodes the RLRE APDU in BER.
 func (r *RLRE) Encode() ([]byte, error) {
-	return asn1.Marshal(*r)
+	return encodeRelease(APDU_RLRE, r.Reason, r.UserInformation), nil
 }
 
-// Decode decodes a byte slice into an RLRE APDU.
+// Decode decodes a BER encoded RLRE APDU. A missing reason decodes as
+// ReasonNormal.
 func (r *RLRE) Decode(src []byte) error {
-	_, err := asn1.Unmarshal(src, r)
+	var err error
+	r.Reason, r.UserInformation, err = decodeRelease(src, APDU_RLRE, "RLRE")
 	return err
 }
+
+// BER tags of the universal types and of the ACSE APDU components used by
+// IEC 62056-5-3. Components are tagged EXPLICIT unless noted otherwise.
+const (
+	berTagInteger          byte = 0x02
+	berTagBitString        byte = 0x03
+	berTagOctetString      byte = 0x04
+	berTagObjectIdentifier byte = 0x06
+
+	berTagApplicationContextName byte = 0xA1
+	berTagUserInformation        byte = 0xBE
+
+	berTagCallingAPTitle             byte = 0xA6
+	berTagCallingAEQualifier         byte = 0xA7
+	berTagSenderACSERequirements     byte = 0x8A // IMPLICIT
+	berTagAARQMechanismName          byte = 0x8B // IMPLICIT
+	berTagCallingAuthenticationValue byte = 0xAC
+
+	berTagResult                        byte = 0xA2
+	berTagResultSourceDiagnostic        byte = 0xA3
+	berTagRespondingAPTitle             byte = 0xA4
+	berTagRespondingAEQualifier         byte = 0xA5
+	berTagResponderACSERequirements     byte = 0x88 // IMPLICIT
+	berTagAAREMechanismName             byte = 0x89 // IMPLICIT
+	berTagRespondingAuthenticationValue byte = 0xAA
+
+	berTagACSEServiceUser     byte = 0xA1
+	berTagACSEServiceProvider byte = 0xA2
+
+	berTagCharstring byte = 0x80 // IMPLICIT GraphicString
+	berTagBitstring  byte = 0x81 // IMPLICIT BIT STRING
+
+	berTagReason byte = 0x80 // IMPLICIT INTEGER
+)
+
+// acseRequirementsAuthentication is the ACSE-requirements BIT STRING with only
+// the authentication functional unit selected.
+var acseRequirementsAuthentication = []byte{0x07, 0x80}
+
+// writeBER writes a BER element with a definite length, which is encoded like an
+// A-XDR length.
+func writeBER(buf *bytes.Buffer, tag byte, content []byte) {
+	buf.WriteByte(tag)
+	_ = axdr.WriteLength(buf, len(content)) // fails only for a negative length
+	buf.Write(content)
+}
+
+// writeBERExplicit writes an element of the universal type innerTag under the
+// explicit tag.
+func writeBERExplicit(buf *bytes.Buffer, tag, innerTag byte, content []byte) {
+	var inner bytes.Buffer
+	writeBER(&inner, innerTag, content)
+	writeBER(buf, tag, inner.Bytes())
+}
+
+// readBER reads a BER element with a single-byte tag and a definite length.
+func readBER(reader *bytes.Reader, field string) (byte, []byte, error) {
+	tag, err := readByte(reader, field+" tag")
+	if err != nil {
+		return 0, nil, err
+	}
+	if tag&0x1F == 0x1F {
+		return 0, nil, fmt.Errorf("unsupported multi-byte tag in %s", field)
+	}
+	content, err := readOctetString(reader, field)
+	if err != nil {
+		return 0, nil, err
+	}
+	return tag, content, nil
+}
+
+// readBERExplicit returns the contents of the element of the universal type
+// innerTag that makes up the contents of an explicitly tagged component.
+func readBERExplicit(content []byte, innerTag byte, field string) ([]byte, error) {
+	reader := bytes.NewReader(content)
+	tag, inner, err := readBER(reader, field)
+	if err != nil {
+		return nil, err
+	}
+	if tag != innerTag {
+		return nil, fmt.Errorf("invalid %s tag: got %X, expected %X", field, tag, innerTag)
+	}
+	if err := expectEnd(reader, field); err != nil {
+		return nil, err
+	}
+	return inner, nil
+}
+
+// newBERReader validates the [APPLICATION n] tag and length of an ACSE APDU and
+// returns a reader over its components.
+func newBERReader(src []byte, expected APDUType, name string) (*bytes.Reader, error) {
+	if len(src) == 0 {
+		return nil, fmt.Errorf("empty source byte slice")
+	}
+	reader := bytes.NewReader(src)
+	tag, content, err := readBER(reader, name)
+	if err != nil {
+		return nil, err
+	}
+	if APDUType(tag) != expected {
+		return nil, fmt.Errorf("invalid APDU tag for %s: got %X, expected %X", name, tag, expected)
+	}
+	if err := expectEnd(reader, name); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+// marshalBERInteger returns the contents octets of an INTEGER.
+func marshalBERInteger(v int) []byte {
+	der, _ := asn1.Marshal(v) // an int always marshals
+	return der[2:]
+}
+
+// readBERExplicitInteger reads the INTEGER making up an explicitly tagged
+// component.
+func readBERExplicitInteger(content []byte, field string) (int, error) {
+	inner, err := readBERExplicit(content, berTagInteger, field)
+	if err != nil {
+		return 0, err
+	}
+	return unmarshalBERInteger(inner, field)
+}
+
+// unmarshalBERInteger parses the contents octets of an INTEGER.
+func unmarshalBERInteger(content []byte, field string) (int, error) {
+	var v int
+	if err := unmarshalBERContent(berTagInteger, content, &v); err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", field, err)
+	}
+	return v, nil
+}
+
+// unmarshalBERContent parses contents octets as the universal type tag into v.
+func unmarshalBERContent(tag byte, content []byte, v interface{}) error {
+	var buf bytes.Buffer
+	writeBER(&buf, tag, content)
+	rest, err := asn1.Unmarshal(buf.Bytes(), v)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("unexpected %d trailing bytes", len(rest))
+	}
+	return nil
+}
+
+// writeBERObjectIdentifier writes an OBJECT IDENTIFIER under tag, either
+// explicitly or implicitly tagged.
+func writeBERObjectIdentifier(buf *bytes.Buffer, tag byte, oid asn1.ObjectIdentifier, explicit bool) error {
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		return err
+	}
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &raw); err != nil {
+		return err
+	}
+	if explicit {
+		writeBERExplicit(buf, tag, berTagObjectIdentifier, raw.Bytes)
+	} else {
+		writeBER(buf, tag, raw.Bytes)
+	}
+	return nil
+}
+
+// readBERObjectIdentifier reads an explicitly or implicitly tagged OBJECT
+// IDENTIFIER.
+func readBERObjectIdentifier(content []byte, explicit bool) (asn1.ObjectIdentifier, error) {
+	if explicit {
+		var err error
+		content, err = readBERExplicit(content, berTagObjectIdentifier, "object identifier")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var oid asn1.ObjectIdentifier
+	if err := unmarshalBERContent(berTagObjectIdentifier, content, &oid); err != nil {
+		return nil, fmt.Errorf("invalid object identifier: %w", err)
+	}
+	return oid, nil
+}
+
+// readACSERequirements reports whether an ACSE-requirements BIT STRING selects
+// the authentication functional unit.
+func readACSERequirements(content []byte) bool {
+	return len(content) > 1 && content[1]&0x80 != 0
+}
+
+// writeAuthenticationValue writes an Authentication-value under the explicit tag.
+func writeAuthenticationValue(buf *bytes.Buffer, tag byte, value *AuthenticationValue) error {
+	var choice bytes.Buffer
+	if value.Charstring == nil && value.Bitstring.BitLength > 0 {
+		der, err := asn1.Marshal(value.Bitstring)
+		if err != nil {
+			return fmt.Errorf("invalid authentication-value: %w", err)
+		}
+		writeBER(&choice, berTagBitstring, der[2:])
+	} else {
+		writeBER(&choice, berTagCharstring, value.Charstring)
+	}
+	writeBER(buf, tag, choice.Bytes())
+	return nil
+}
+
+// readAuthenticationValue reads the Authentication-value CHOICE making up an
+// explicitly tagged component.
+func readAuthenticationValue(content []byte) (*AuthenticationValue, error) {
+	reader := bytes.NewReader(content)
+	tag, choice, err := readBER(reader, "authentication-value")
+	if err != nil {
+		return nil, err
+	}
+	if err := expectEnd(reader, "authentication-value"); err != nil {
+		return nil, err
+	}
+	switch tag {
+	case berTagCharstring:
+		return &AuthenticationValue{Charstring: choice}, nil
+	case berTagBitstring:
+		value := &AuthenticationValue{}
+		if err := unmarshalBERContent(berTagBitString, choice, &value.Bitstring); err != nil {
+			return nil, fmt.Errorf("invalid authentication-value: %w", err)
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unsupported authentication-value choice: %X", tag)
+	}
+}
+
+// readResultSourceDiagnostic reads the Associate-source-diagnostic CHOICE making
+// up the result-source-diagnostic component.
+func readResultSourceDiagnostic(content []byte) (ResultSourceDiagnostic, error) {
+	reader := bytes.NewReader(content)
+	tag, choice, err := readBER(reader, "result-source-diagnostic")
+	if err != nil {
+		return ResultSourceDiagnostic{}, err
+	}
+	if err := expectEnd(reader, "result-source-diagnostic"); err != nil {
+		return ResultSourceDiagnostic{}, err
+	}
+	switch tag {
+	case berTagACSEServiceUser:
+		v, err := readBERExplicitInteger(choice, "acse-service-user")
+		return ResultSourceDiagnostic{ACSEServiceUser: asn1.Enumerated(v)}, err
+	case berTagACSEServiceProvider:
+		v, err := readBERExplicitInteger(choice, "acse-service-provider")
+		return ResultSourceDiagnostic{ACSEServiceProvider: asn1.Enumerated(v)}, err
+	default:
+		return ResultSourceDiagnostic{}, fmt.Errorf("invalid result-source-diagnostic choice: %X", tag)
+	}
+}
+
+// encodeRelease encodes the body shared by RLRQ and RLRE.
+func encodeRelease(apduType APDUType, reason asn1.Enumerated, userInformation []byte) []byte {
+	var body bytes.Buffer
+	writeBER(&body, berTagReason, marshalBERInteger(int(reason)))
+	if userInformation != nil {
+		writeBERExplicit(&body, berTagUserInformation, berTagOctetString, userInformation)
+	}
+	var buf bytes.Buffer
+	writeBER(&buf, byte(apduType), body.Bytes())
+	return buf.Bytes()
+}
+
+// decodeRelease decodes the body shared by RLRQ and RLRE.
+func decodeRelease(src []byte, apduType APDUType, name string) (asn1.Enumerated, []byte, error) {
+	reader, err := newBERReader(src, apduType, name)
+	if err != nil {
+		return 0, nil, err
+	}
+	reason := ReasonNormal
+	var userInformation []byte
+	for reader.Len() > 0 {
+		tag, content, err := readBER(reader, name+" component")
+		if err != nil {
+			return 0, nil, err
+		}
+		switch tag {
+		case berTagReason:
+			var v int
+			v, err = unmarshalBERInteger(content, "reason")
+			reason = asn1.Enumerated(v)
+		case berTagUserInformation:
+			userInformation, err = readBERExplicit(content, berTagOctetString, "user-information")
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return reason, userInformation, nil
+}
+
+// Encode encodes the InitiateRequest into its A-XDR form.
+func (ir *InitiateRequest) Encode() ([]byte, error) {
+	if ir.ProposedMaxPduSize < 0 || ir.ProposedMaxPduSize > 0xFFFF {
+		return nil, fmt.Errorf("invalid proposed max PDU size: %d", ir.ProposedMaxPduSize)
+	}
+	if ir.ProposedDlmsVersionNumber < 0 || ir.ProposedDlmsVersionNumber > 0xFF {
+		return nil, fmt.Errorf("invalid proposed DLMS version number: %d", ir.ProposedDlmsVersionNumber)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_INITIATE_REQUEST))
+	if ir.DedicatedKey == nil {
+		buf.WriteByte(0x00)
+	} else {
+		buf.WriteByte(0x01)
+		if err := writeOctetString(&buf, ir.DedicatedKey); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte(0x00) // response-allowed: DEFAULT TRUE
+	buf.WriteByte(0x00) // proposed-quality-of-service: absent
+	buf.WriteByte(byte(ir.ProposedDlmsVersionNumber))
+	writeConformance(&buf, ir.ProposedConformance)
+	writeUint16(&buf, uint16(ir.ProposedMaxPduSize))
+	return buf.Bytes(), nil
+}
+
+// Decode decodes an A-XDR encoded InitiateRequest.
+func (ir *InitiateRequest) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_INITIATE_REQUEST, "InitiateRequest")
+	if err != nil {
+		return err
+	}
+
+	present, err := readOptionalFlag(reader, "DedicatedKey")
+	if err != nil {
+		return err
+	}
+	ir.DedicatedKey = nil
+	if present {
+		ir.DedicatedKey, err = readOctetString(reader, "DedicatedKey")
+		if err != nil {
+			return err
+		}
+	}
+
+	present, err = readOptionalFlag(reader, "ResponseAllowed")
+	if err != nil {
+		return err
+	}
+	if present {
+		if _, err := readBoolean(reader, "ResponseAllowed"); err != nil {
+			return err
+		}
+	}
+	present, err = readOptionalFlag(reader, "ProposedQualityOfService")
+	if err != nil {
+		return err
+	}
+	if present {
+		if _, err := readByte(reader, "ProposedQualityOfService"); err != nil {
+			return err
+		}
+	}
+
+	version, err := readByte(reader, "ProposedDlmsVersionNumber")
+	if err != nil {
+		return err
+	}
+	ir.ProposedDlmsVersionNumber = int(version)
+	ir.ProposedConformance, err = readConformance(reader)
+	if err != nil {
+		return err
+	}
+	maxPduSize, err := readUint16(reader, "ClientMaxReceivePduSize")
+	if err != nil {
+		return err
+	}
+	ir.ProposedMaxPduSize = int(maxPduSize)
+
+	return expectEnd(reader, "InitiateRequest")
+}
+
+// EncodeGloCiphered encodes the InitiateRequest and protects it in a
+// glo-initiate-request with the global unicast key.
+func (ir *InitiateRequest) EncodeGloCiphered(key, systemTitle []byte, header *SecurityHeader, suite SecuritySuite) ([]byte, error) {
+	plaintext, err := ir.Encode()
+	if err != nil {
+		return nil, err
+	}
+	ciphered, err := NewCipheredAPDU(APDU_GLO_INITIATE_REQUEST, key, plaintext, systemTitle, header, suite)
+	if err != nil {
+		return nil, err
+	}
+	return ciphered.Encode()
+}
+
+// DecodeGloCiphered unprotects a glo-initiate-request sent by the client with
+// systemTitle and decodes the InitiateRequest it carries. Its frame counter must
+// follow lastFrameCounter, the last one received from the client. It returns the
+// decoded ciphered APDU so the caller can record the frame counter.
+func (ir *InitiateRequest) DecodeGloCiphered(src, key, systemTitle []byte, suite SecuritySuite, lastFrameCounter uint32) (*CipheredAPDU, error) {
+	if len(src) == 0 || APDUType(src[0]) != APDU_GLO_INITIATE_REQUEST {
+		return nil, fmt.Errorf("invalid APDU tag for glo-initiate-request")
+	}
+	ciphered := &CipheredAPDU{}
+	if err := ciphered.Decode(src); err != nil {
+		return nil, err
+	}
+	plaintext, err := ciphered.Open(key, systemTitle, suite, lastFrameCounter)
+	if err != nil {
+		return nil, err
+	}
+	return ciphered, ir.Decode(plaintext)
+}
+
+// Encode encodes the InitiateResponse into its A-XDR form.
+func (ir *InitiateResponse) Encode() ([]byte, error) {
+	if ir.NegotiatedMaxPduSize < 0 || ir.NegotiatedMaxPduSize > 0xFFFF {
+		return nil, fmt.Errorf("invalid negotiated max PDU size: %d", ir.NegotiatedMaxPduSize)
+	}
+	if ir.NegotiatedDlmsVersionNumber < 0 || ir.NegotiatedDlmsVersionNumber > 0xFF {
+		return nil, fmt.Errorf("invalid negotiated DLMS version number: %d", ir.NegotiatedDlmsVersionNumber)
+	}
+	if ir.VAAname < 0 || ir.VAAname > 0xFFFF {
+		return nil, fmt.Errorf("invalid vaa-name: %d", ir.VAAname)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_INITIATE_RESPONSE))
+	buf.WriteByte(0x00) // negotiated-quality-of-service: absent
+	buf.WriteByte(byte(ir.NegotiatedDlmsVersionNumber))
+	writeConformance(&buf, ir.NegotiatedConformance)
+	writeUint16(&buf, uint16(ir.NegotiatedMaxPduSize))
+	writeUint16(&buf, uint16(ir.VAAname))
+	return buf.Bytes(), nil
+}
+
+// Decode decodes an A-XDR encoded InitiateResponse.
+func (ir *InitiateResponse) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_INITIATE_RESPONSE, "InitiateResponse")
+	if err != nil {
+		return err
+	}
+
+	present, err := readOptionalFlag(reader, "NegotiatedQualityOfService")
+	if err != nil {
+		return err
+	}
+	if present {
+		if _, err := readByte(reader, "NegotiatedQualityOfService"); err != nil {
+			return err
+		}
+	}
+
+	version, err := readByte(reader, "NegotiatedDlmsVersionNumber")
+	if err != nil {
+		return err
+	}
+	ir.NegotiatedDlmsVersionNumber = int(version)
+	ir.NegotiatedConformance, err = readConformance(reader)
+	if err != nil {
+		return err
+	}
+	maxPduSize, err := readUint16(reader, "ServerMaxReceivePduSize")
+	if err != nil {
+		return err
+	}
+	ir.NegotiatedMaxPduSize = int(maxPduSize)
+	vaaName, err := readUint16(reader, "VAAName")
+	if err != nil {
+		return err
+	}
+	ir.VAAname = int(vaaName)
+
+	return expectEnd(reader, "InitiateResponse")
+}
+
+// writeConformance writes a Conformance BIT STRING in its BER form.
+func writeConformance(buf *bytes.Buffer, conformance asn1.BitString) {
+	buf.Write(conformanceTag)
+	buf.WriteByte(0x00) // no unused bits
+	var bits [3]byte
+	copy(bits[:], conformance.Bytes)
+	buf.Write(bits[:])
+}
+
+// readConformance reads a Conformance BIT STRING in its BER form.
+func readConformance(reader *bytes.Reader) (asn1.BitString, error) {
+	var tag [3]byte
+	for i := range tag {
+		b, err := readByte(reader, "Conformance tag")
+		if err != nil {
+			return asn1.BitString{}, err
+		}
+		tag[i] = b
+	}
+	if !bytes.Equal(tag[:], conformanceTag) {
+		return asn1.BitString{}, fmt.Errorf("invalid Conformance tag: %X", tag)
+	}
+	unused, err := readByte(reader, "Conformance unused bits")
+	if err != nil {
+		return asn1.BitString{}, err
+	}
+	if unused > 7 {
+		return asn1.BitString{}, fmt.Errorf("invalid Conformance unused bits: %d", unused)
+	}
+	bits := make([]byte, 3)
+	for i := range bits {
+		bits[i], err = readByte(reader, "Conformance")
+		if err != nil {
+			return asn1.BitString{}, err
+		}
+	}
+	return asn1.BitString{Bytes: bits, BitLength: 24 - int(unused)}, nil
+}
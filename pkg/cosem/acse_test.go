@@ -5,147 +5,248 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestAARQ_EncodeDecode(t *testing.T) {
-	authValue, _ := asn1.Marshal(AuthenticationValue{GraphicString: "password"})
+func TestAARQ_WireFormat(t *testing.T) {
+	// The LLS AARQ example of the Green Book.
 	aarq := &AARQ{
-		ApplicationContextName: OidApplicationContextLN,
-		MechanismName:          OidMechanismLLS,
-		CallingAuthenticationValue: asn1.RawValue{
-			Class:      asn1.ClassContextSpecific,
-			Tag:        12,
-			IsCompound: true,
-			Bytes:      authValue,
-		},
+		ApplicationContextName:     OidApplicationContextLN,
+		SenderACSERequirements:     true,
+		MechanismName:              OidMechanismLLS,
+		CallingAuthenticationValue: &AuthenticationValue{Charstring: []byte("12345678")},
+		UserInformation:            []byte{0x01, 0x00, 0x00, 0x00, 0x06, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x7E, 0x1F, 0x04, 0xB0},
+	}
+	want := []byte{
+		0x60, 0x36,
+		0xA1, 0x09, 0x06, 0x07, 0x60, 0x85, 0x74, 0x05, 0x08, 0x01, 0x01,
+		0x8A, 0x02, 0x07, 0x80,
+		0x8B, 0x07, 0x60, 0x85, 0x74, 0x05, 0x08, 0x02, 0x01,
+		0xAC, 0x0A, 0x80, 0x08, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38,
+		0xBE, 0x10, 0x04, 0x0E, 0x01, 0x00, 0x00, 0x00, 0x06, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x7E, 0x1F, 0x04, 0xB0,
 	}
 
 	encoded, err := aarq.Encode()
-	assert.NoError(t, err)
+	require.NoError(t, err)
+	assert.Equal(t, want, encoded)
 
 	decoded := &AARQ{}
-	err = decoded.Decode(encoded)
-	assert.NoError(t, err)
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, aarq, decoded)
+
+	aarq = &AARQ{
+		ApplicationContextName: OidApplicationContextLN,
+		CallingAPTitle:         []byte("MMM00001"),
+		CallingAEQualifier:     []byte{0x30, 0x00},
+	}
+	encoded, err = aarq.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xA6, 0x0A, 0x04, 0x08, 'M', 'M', 'M', '0', '0', '0', '0', '1', 0xA7, 0x04, 0x04, 0x02, 0x30, 0x00}, encoded[13:])
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, aarq, decoded)
+
+	// protocol-version and unused called-* fields are skipped.
+	require.NoError(t, decoded.Decode([]byte{
+		0x60, 0x14,
+		0x80, 0x02, 0x07, 0x80,
+		0xA1, 0x09, 0x06, 0x07, 0x60, 0x85, 0x74, 0x05, 0x08, 0x01, 0x02,
+		0xA2, 0x03, 0x04, 0x01, 0x00,
+	}))
+	assert.Equal(t, OidApplicationContextSN, decoded.ApplicationContextName)
 
-	assert.True(t, aarq.ApplicationContextName.Equal(decoded.ApplicationContextName))
-	assert.True(t, aarq.MechanismName.Equal(decoded.MechanismName))
-	assert.Equal(t, aarq.CallingAuthenticationValue.Bytes, decoded.CallingAuthenticationValue.Bytes)
+	assert.Error(t, decoded.Decode(want[:len(want)-1]))
+	assert.Error(t, decoded.Decode(append(append([]byte{}, want...), 0x00)))
+	assert.Error(t, decoded.Decode([]byte{0x60, 0x04, 0x8A, 0x02, 0x07, 0x80}))
+	assert.Error(t, decoded.Decode([]byte{0x61, 0x00}))
 }
 
-func TestAARE_EncodeDecode(t *testing.T) {
+func TestAARE_WireFormat(t *testing.T) {
+	// The AARE example of the Green Book, accepting the LN association.
 	aare := &AARE{
 		ApplicationContextName: OidApplicationContextLN,
 		Result:                 ResultAccepted,
-		ResultSourceDiagnostic: ResultSourceDiagnostic{
-			ACSEServiceUser: ACSEUserNull,
-		},
+		ResultSourceDiagnostic: ResultSourceDiagnostic{ACSEServiceUser: ACSEUserNull},
+		UserInformation:        []byte{0x08, 0x00, 0x06, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x50, 0x1F, 0x01, 0xF4, 0x00, 0x07},
+	}
+	want := []byte{
+		0x61, 0x29,
+		0xA1, 0x09, 0x06, 0x07, 0x60, 0x85, 0x74, 0x05, 0x08, 0x01, 0x01,
+		0xA2, 0x03, 0x02, 0x01, 0x00,
+		0xA3, 0x05, 0xA1, 0x03, 0x02, 0x01, 0x00,
+		0xBE, 0x10, 0x04, 0x0E, 0x08, 0x00, 0x06, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x50, 0x1F, 0x01, 0xF4, 0x00, 0x07,
 	}
 
 	encoded, err := aare.Encode()
-	assert.NoError(t, err)
+	require.NoError(t, err)
+	assert.Equal(t, want, encoded)
 
 	decoded := &AARE{}
-	err = decoded.Decode(encoded)
-	assert.NoError(t, err)
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, aare, decoded)
 
-	assert.True(t, aare.ApplicationContextName.Equal(decoded.ApplicationContextName))
-	assert.Equal(t, aare.Result, decoded.Result)
-	assert.Equal(t, aare.ResultSourceDiagnostic.ACSEServiceUser, decoded.ResultSourceDiagnostic.ACSEServiceUser)
-}
-
-func TestRLRQ_EncodeDecode(t *testing.T) {
-	rlrq := &RLRQ{
-		Reason: ReasonNormal,
+	aare = &AARE{
+		ApplicationContextName:        OidApplicationContextLN,
+		Result:                        ResultRejectedPermanent,
+		ResultSourceDiagnostic:        ResultSourceDiagnostic{ACSEServiceUser: ACSEUserAuthenticationFailed},
+		RespondingAPTitle:             []byte("SERVER01"),
+		ResponderACSERequirements:     true,
+		MechanismName:                 OidMechanismHLS,
+		RespondingAuthenticationValue: &AuthenticationValue{Charstring: []byte("P6wRJ21F")},
 	}
+	encoded, err = aare.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0xA2, 0x03, 0x02, 0x01, 0x01,
+		0xA3, 0x05, 0xA1, 0x03, 0x02, 0x01, 0x0D,
+		0xA4, 0x0A, 0x04, 0x08, 'S', 'E', 'R', 'V', 'E', 'R', '0', '1',
+		0x88, 0x02, 0x07, 0x80,
+		0x89, 0x07, 0x60, 0x85, 0x74, 0x05, 0x08, 0x02, 0x05,
+		0xAA, 0x0A, 0x80, 0x08, 'P', '6', 'w', 'R', 'J', '2', '1', 'F',
+	}, encoded[13:])
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, aare, decoded)
 
-	encoded, err := rlrq.Encode()
-	assert.NoError(t, err)
-
-	decoded := &RLRQ{}
-	err = decoded.Decode(encoded)
-	assert.NoError(t, err)
+	aare = &AARE{
+		ApplicationContextName: OidApplicationContextLN,
+		Result:                 ResultRejectedPermanent,
+		ResultSourceDiagnostic: ResultSourceDiagnostic{ACSEServiceProvider: ACSEServiceProviderNoCommonACSEVersion},
+	}
+	encoded, err = aare.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xA3, 0x05, 0xA2, 0x03, 0x02, 0x01, 0x02}, encoded[18:])
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, aare, decoded)
 
-	assert.Equal(t, rlrq.Reason, decoded.Reason)
+	// result-source-diagnostic is mandatory.
+	assert.Error(t, decoded.Decode(want[:18]))
 }
 
-func TestRLRE_EncodeDecode(t *testing.T) {
-	rlre := &RLRE{
-		Reason: ReasonNormal,
-	}
+func TestRelease_WireFormat(t *testing.T) {
+	encoded, err := (&RLRQ{Reason: ReasonNormal}).Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x62, 0x03, 0x80, 0x01, 0x00}, encoded)
 
-	encoded, err := rlre.Encode()
-	assert.NoError(t, err)
+	encoded, err = (&RLRE{Reason: ReasonNormal}).Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x63, 0x03, 0x80, 0x01, 0x00}, encoded)
 
-	decoded := &RLRE{}
-	err = decoded.Decode(encoded)
-	assert.NoError(t, err)
+	rlrq := &RLRQ{Reason: ReasonUserDefined, UserInformation: []byte{0x21, 0x00}}
+	encoded, err = rlrq.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x62, 0x09, 0x80, 0x01, 0x1E, 0xBE, 0x04, 0x04, 0x02, 0x21, 0x00}, encoded)
+	decodedRLRQ := &RLRQ{}
+	require.NoError(t, decodedRLRQ.Decode(encoded))
+	assert.Equal(t, rlrq, decodedRLRQ)
 
-	assert.Equal(t, rlre.Reason, decoded.Reason)
+	// The reason is OPTIONAL.
+	decodedRLRE := &RLRE{Reason: ReasonUrgent}
+	require.NoError(t, decodedRLRE.Decode([]byte{0x63, 0x00}))
+	assert.Equal(t, &RLRE{Reason: ReasonNormal}, decodedRLRE)
+	assert.Error(t, decodedRLRE.Decode([]byte{0x62, 0x00}))
 }
 
 func TestACSE_HandleAARQ_LLS(t *testing.T) {
 	acse := NewACSE("password", nil, nil)
 
 	t.Run("Successful Association", func(t *testing.T) {
-		authValue, _ := asn1.Marshal(AuthenticationValue{GraphicString: "password"})
 		aarq := &AARQ{
-			ApplicationContextName: OidApplicationContextLN,
-			MechanismName:          OidMechanismLLS,
-			CallingAuthenticationValue: asn1.RawValue{
-				Bytes: authValue,
-			},
+			ApplicationContextName:     OidApplicationContextLN,
+			SenderACSERequirements:     true,
+			MechanismName:              OidMechanismLLS,
+			CallingAuthenticationValue: &AuthenticationValue{Charstring: []byte("password")},
 		}
 
 		aare, err := acse.HandleAARQ(aarq, nil)
 		assert.NoError(t, err)
 		assert.Equal(t, ResultAccepted, aare.Result)
+		assert.True(t, aare.ResponderACSERequirements)
 		assert.Equal(t, StateAssociated, acse.state)
 	})
 
 	t.Run("Failed Authentication", func(t *testing.T) {
-		authValue, _ := asn1.Marshal(AuthenticationValue{GraphicString: "wrong_password"})
+		aarq := &AARQ{
+			ApplicationContextName:     OidApplicationContextLN,
+			SenderACSERequirements:     true,
+			MechanismName:              OidMechanismLLS,
+			CallingAuthenticationValue: &AuthenticationValue{Charstring: []byte("wrong_password")},
+		}
+
+		aare, err := acse.HandleAARQ(aarq, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, ResultRejectedPermanent, aare.Result)
+		assert.Equal(t, ACSEUserAuthenticationFailed, aare.ResultSourceDiagnostic.ACSEServiceUser)
+	})
+
+	t.Run("Missing Password", func(t *testing.T) {
 		aarq := &AARQ{
 			ApplicationContextName: OidApplicationContextLN,
+			SenderACSERequirements: true,
 			MechanismName:          OidMechanismLLS,
-			CallingAuthenticationValue: asn1.RawValue{
-				Bytes: authValue,
-			},
 		}
 
 		aare, err := acse.HandleAARQ(aarq, nil)
 		assert.NoError(t, err)
 		assert.Equal(t, ResultRejectedPermanent, aare.Result)
-		assert.Equal(t, ACSEUserAuthenticationFailed, aare.ResultSourceDiagnostic.ACSEServiceUser)
+		assert.Equal(t, ACSEUserAuthenticationRequired, aare.ResultSourceDiagnostic.ACSEServiceUser)
 	})
 }
 
 func TestACSE_HandleAARQ_HLS(t *testing.T) {
-	priv, pub, err := GenerateECDHKeys()
-	assert.NoError(t, err)
-	acse := NewACSE("", priv, []byte("SERVER01"))
-
-	clientPriv, clientPub, err := GenerateECDHKeys()
-	assert.NoError(t, err)
-	marshaledClientPub, _ := MarshalPublicKey(clientPub)
-	authValue, _ := asn1.Marshal(HLSAuthentication{EphemeralPublicKey: marshaledClientPub})
-	aarq := &AARQ{
-		ApplicationContextName: OidApplicationContextLN,
-		MechanismName:          OidMechanismHLS,
-		CallingAuthenticationValue: asn1.RawValue{
-			Bytes: authValue,
-		},
-	}
+	acse := NewACSE("", nil, []byte("SERVER01"))
 	obis, _ := NewObisCodeFromString("0.0.43.0.0.255")
-	securitySetup, _ := NewSecuritySetup(*obis, []byte("CLIENT"), []byte("SERVER01"), nil, nil, nil)
+	guek := []byte("0123456789ABCDEF")
+	gak := []byte("FEDCBA9876543210")
+	securitySetup, err := NewSecuritySetup(*obis, []byte("CLIENT01"), []byte("SERVER01"), nil, guek, gak)
+	require.NoError(t, err)
 
+	ctos := []byte("CtoS0123")
+	aarq := &AARQ{
+		ApplicationContextName:     OidApplicationContextLN,
+		SenderACSERequirements:     true,
+		MechanismName:              OidMechanismHLS,
+		CallingAuthenticationValue: &AuthenticationValue{Charstring: ctos},
+	}
 	aare, err := acse.HandleAARQ(aarq, securitySetup)
-	assert.NoError(t, err)
+	require.NoError(t, err)
 	assert.Equal(t, ResultAccepted, aare.Result)
+	assert.Equal(t, ACSEUserAuthenticationRequired, aare.ResultSourceDiagnostic.ACSEServiceUser)
+	require.NotNil(t, aare.RespondingAuthenticationValue)
+	stoc := aare.RespondingAuthenticationValue.Charstring
+	assert.Len(t, stoc, challengeSize)
+	assert.Equal(t, StateAssociationPending, acse.state)
+
+	// f(StoC) computed under the server system title is refused.
+	fStoC, err := HLSGMAC(guek, gak, stoc, []byte("SERVER01"), 1)
+	require.NoError(t, err)
+	_, err = acse.ReplyToHLSAuthentication(fStoC, securitySetup, func() uint32 { return 1 })
+	assert.ErrorIs(t, err, ErrAuthenticationFailed)
+	assert.Equal(t, StateAssociationPending, acse.state)
+
+	// f(StoC) must carry a frame counter following the last one of the client.
+	acse.SetLastFrameCounter(1)
+	fStoC, err = HLSGMAC(guek, gak, stoc, []byte("CLIENT01"), 1)
+	require.NoError(t, err)
+	_, err = acse.ReplyToHLSAuthentication(fStoC, securitySetup, func() uint32 { return 7 })
+	assert.ErrorIs(t, err, ErrReplayAttack)
+	assert.Equal(t, StateAssociationPending, acse.state)
+
+	fStoC, err = HLSGMAC(guek, gak, stoc, []byte("CLIENT01"), 2)
+	require.NoError(t, err)
+	fCtoS, err := acse.ReplyToHLSAuthentication(fStoC, securitySetup, func() uint32 { return 7 })
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), acse.LastFrameCounter())
+	assert.Equal(t, StateAssociated, acse.state)
+	assert.Equal(t, []byte{0x10, 0x00, 0x00, 0x00, 0x07}, fCtoS[:5])
+	assert.NoError(t, VerifyHLSGMAC(fCtoS, guek, gak, ctos, []byte("SERVER01")))
 
-	sharedSecret, _ := ECDH(clientPriv, pub)
-	guek, gak, _ := deriveKeys(sharedSecret)
-	assert.Equal(t, guek, securitySetup.GlobalUnicastKey)
-	assert.Equal(t, gak, securitySetup.GlobalAuthenticationKey)
+	_, err = acse.ReplyToHLSAuthentication(fStoC, securitySetup, func() uint32 { return 8 })
+	assert.Error(t, err)
+
+	aarq.CallingAuthenticationValue = &AuthenticationValue{Charstring: []byte("short")}
+	aare, err = acse.HandleAARQ(aarq, securitySetup)
+	require.NoError(t, err)
+	assert.Equal(t, ResultRejectedPermanent, aare.Result)
+	assert.Equal(t, ACSEUserAuthenticationFailed, aare.ResultSourceDiagnostic.ACSEServiceUser)
 }
 
 func TestACSE_HandleRLRQ(t *testing.T) {
@@ -160,3 +261,316 @@ func TestACSE_HandleRLRQ(t *testing.T) {
 	assert.Equal(t, ReasonNormal, rlre.Reason)
 	assert.Equal(t, StateUnassociated, acse.state)
 }
+
+func TestACSE_HandleAARQ_CallingAPTitle(t *testing.T) {
+	serverSystemTitle := []byte("SERVER01")
+	aarq := func(callingAPTitle []byte) *AARQ {
+		return &AARQ{ApplicationContextName: OidApplicationContextLN, CallingAPTitle: callingAPTitle}
+	}
+
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), []byte("CLIENT01"), serverSystemTitle, nil, nil, nil)
+	require.NoError(t, err)
+
+	acse := NewACSE("", nil, serverSystemTitle)
+	aare, err := acse.HandleAARQ(aarq([]byte("CLIENT01")), securitySetup)
+	require.NoError(t, err)
+	assert.Equal(t, ResultAccepted, aare.Result)
+	assert.Equal(t, serverSystemTitle, aare.RespondingAPTitle)
+	assert.Equal(t, []byte("CLIENT01"), acse.ClientSystemTitle())
+	acse.HandleRLRQ(&RLRQ{Reason: ReasonNormal})
+	assert.Nil(t, acse.ClientSystemTitle())
+
+	// Without a calling-AP-title the client is taken to be the known client.
+	aare, err = acse.HandleAARQ(aarq(nil), securitySetup)
+	require.NoError(t, err)
+	assert.Equal(t, ResultAccepted, aare.Result)
+	assert.Equal(t, []byte("CLIENT01"), acse.ClientSystemTitle())
+
+	aare, err = NewACSE("", nil, serverSystemTitle).HandleAARQ(aarq([]byte("CLIENT02")), securitySetup)
+	require.NoError(t, err)
+	assert.Equal(t, ResultRejectedPermanent, aare.Result)
+	assert.Equal(t, ACSEUserCallingAPTitleNotRecognized, aare.ResultSourceDiagnostic.ACSEServiceUser)
+
+	// Any client is recognised when the security setup names none.
+	open, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, serverSystemTitle, nil, nil, nil)
+	require.NoError(t, err)
+	acse = NewACSE("", nil, nil)
+	aare, err = acse.HandleAARQ(aarq([]byte("CLIENT02")), open)
+	require.NoError(t, err)
+	assert.Equal(t, ResultAccepted, aare.Result)
+	assert.Nil(t, aare.RespondingAPTitle)
+	assert.Equal(t, []byte("CLIENT02"), acse.ClientSystemTitle())
+}
+
+func TestInitiateRequest_WireFormat(t *testing.T) {
+	ir := &InitiateRequest{
+		ProposedConformance:       asn1.BitString{Bytes: []byte{0x00, 0x18, 0x1F}, BitLength: 24},
+		ProposedMaxPduSize:        0xFFFF,
+		ProposedDlmsVersionNumber: 6,
+	}
+	encoded, err := ir.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01, 0x00, 0x00, 0x00, 0x06, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x18, 0x1F, 0xFF, 0xFF}, encoded)
+
+	decoded := &InitiateRequest{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, ir, decoded)
+
+	ir.DedicatedKey = []byte("DEDICATEDKEY0123")
+	encoded, err = ir.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01, 0x01, 0x10}, encoded[:3])
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, ir, decoded)
+
+	// response-allowed and proposed-quality-of-service sent by the client are accepted.
+	require.NoError(t, decoded.Decode([]byte{0x01, 0x00, 0x01, 0x00, 0x01, 0x02, 0x06, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x18, 0x1F, 0x04, 0x00}))
+	assert.Equal(t, 0x0400, decoded.ProposedMaxPduSize)
+	assert.Nil(t, decoded.DedicatedKey)
+
+	assert.Error(t, decoded.Decode([]byte{0x01, 0x00, 0x00, 0x00, 0x06, 0x5F, 0x1E, 0x04, 0x00, 0x00, 0x18, 0x1F, 0xFF, 0xFF}))
+	assert.Error(t, decoded.Decode(encoded[:len(encoded)-1]))
+	_, err = (&InitiateRequest{ProposedMaxPduSize: 0x10000}).Encode()
+	assert.Error(t, err)
+}
+
+func TestInitiateResponse_WireFormat(t *testing.T) {
+	// The InitiateResponse of the Green Book AARE example.
+	ir := &InitiateResponse{
+		NegotiatedConformance:       asn1.BitString{Bytes: []byte{0x00, 0x50, 0x1F}, BitLength: 24},
+		NegotiatedMaxPduSize:        0x01F4,
+		NegotiatedDlmsVersionNumber: 6,
+		VAAname:                     VAANameLN,
+	}
+	want := []byte{0x08, 0x00, 0x06, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x50, 0x1F, 0x01, 0xF4, 0x00, 0x07}
+	encoded, err := ir.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, want, encoded)
+
+	decoded := &InitiateResponse{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, ir, decoded)
+
+	// A negotiated-quality-of-service sent by the server is accepted.
+	require.NoError(t, decoded.Decode([]byte{0x08, 0x01, 0x00, 0x06, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x50, 0x1F, 0x01, 0xF4, 0xFA, 0x00}))
+	assert.Equal(t, VAANameSN, decoded.VAAname)
+
+	assert.Error(t, decoded.Decode(want[:len(want)-1]))
+	_, err = (&InitiateResponse{VAAname: 0x10000}).Encode()
+	assert.Error(t, err)
+}
+
+func TestACSE_HandleAARQ_Negotiation(t *testing.T) {
+	associate := func(t *testing.T, acse *ACSE, context asn1.ObjectIdentifier, initiate *InitiateRequest) *AARE {
+		userInformation, err := initiate.Encode()
+		require.NoError(t, err)
+		aare, err := acse.HandleAARQ(&AARQ{
+			ApplicationContextName:     context,
+			SenderACSERequirements:     true,
+			MechanismName:              OidMechanismLLS,
+			CallingAuthenticationValue: &AuthenticationValue{Charstring: []byte("password")},
+			UserInformation:            userInformation,
+		}, nil)
+		require.NoError(t, err)
+		return aare
+	}
+	proposal := func(conformance []byte, maxPduSize, version int) *InitiateRequest {
+		return &InitiateRequest{
+			ProposedConformance:       asn1.BitString{Bytes: conformance, BitLength: 24},
+			ProposedMaxPduSize:        maxPduSize,
+			ProposedDlmsVersionNumber: version,
+		}
+	}
+
+	t.Run("Accepted", func(t *testing.T) {
+		acse := NewACSE("password", nil, nil)
+		acse.SetMaxReceivePDUSize(0x0200)
+		aare := associate(t, acse, OidApplicationContextLN, proposal([]byte{0x00, 0x7E, 0x1F}, 0x04B0, 6))
+		require.Equal(t, ResultAccepted, aare.Result)
+
+		// attribute0-supported-with-get is not supported by the server.
+		assert.Equal(t, []byte{0x08, 0x00, 0x06, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x5E, 0x1F, 0x02, 0x00, 0x00, 0x07}, aare.UserInformation)
+		assert.Equal(t, XDLMSContextInfo{
+			Conformance:       0x005E1F,
+			MaxReceivePDUSize: 0x0200,
+			MaxSendPDUSize:    0x04B0,
+			DLMSVersionNumber: 6,
+			CypheringInfo:     []byte{},
+		}, acse.XDLMSContext())
+
+		acse.HandleRLRQ(&RLRQ{Reason: ReasonNormal})
+		assert.Equal(t, XDLMSContextInfo{}, acse.XDLMSContext())
+	})
+
+	t.Run("ShortNameContext", func(t *testing.T) {
+		acse := NewACSE("password", nil, nil)
+		aare := associate(t, acse, OidApplicationContextSN, proposal([]byte{0x1C, 0x03, 0x20}, 0, 6))
+		require.Equal(t, ResultAccepted, aare.Result)
+
+		response := &InitiateResponse{}
+		require.NoError(t, response.Decode(aare.UserInformation))
+		// information-report is not supported by the server.
+		assert.Equal(t, []byte{0x1C, 0x02, 0x20}, response.NegotiatedConformance.Bytes)
+		assert.Equal(t, VAANameSN, response.VAAname)
+		assert.Equal(t, uint16(0xFFFF), acse.XDLMSContext().MaxSendPDUSize)
+	})
+
+	rejected := []struct {
+		name     string
+		initiate *InitiateRequest
+		value    byte
+	}{
+		{"VersionTooLow", proposal([]byte{0x00, 0x00, 0x1F}, 0x0400, 5), INITIATE_DLMS_VERSION_TOO_LOW},
+		{"PDUSizeTooShort", proposal([]byte{0x00, 0x00, 0x1F}, 11, 6), INITIATE_PDU_SIZE_TOO_SHORT},
+		{"IncompatibleConformance", proposal([]byte{0x18, 0x00, 0x00}, 0x0400, 6), INITIATE_INCOMPATIBLE_CONFORMANCE},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			acse := NewACSE("password", nil, nil)
+			aare := associate(t, acse, OidApplicationContextLN, tt.initiate)
+			assert.Equal(t, ResultRejectedPermanent, aare.Result)
+			assert.Equal(t, ACSEUserNoReasonGiven, aare.ResultSourceDiagnostic.ACSEServiceUser)
+			assert.Equal(t, []byte{0x0E, 0x01, 0x06, tt.value}, aare.UserInformation)
+			assert.Equal(t, StateUnassociated, acse.state)
+		})
+	}
+}
+
+func TestACSE_HandleAARQ_DedicatedKey(t *testing.T) {
+	guek := []byte("0123456789ABCDEF")
+	serverSystemTitle := []byte("SERVER01")
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), []byte("CLIENT01"), serverSystemTitle, nil, guek, nil)
+	require.NoError(t, err)
+
+	newAARQ := func(initiate []byte) *AARQ {
+		aarq := &AARQ{
+			ApplicationContextName:     OidApplicationContextLN,
+			SenderACSERequirements:     true,
+			MechanismName:              OidMechanismLLS,
+			CallingAuthenticationValue: &AuthenticationValue{Charstring: []byte("password")},
+			UserInformation:            initiate,
+		}
+		// The request is passed through its BER encoding as a server would receive it.
+		encoded, err := aarq.Encode()
+		require.NoError(t, err)
+		decoded := &AARQ{}
+		require.NoError(t, decoded.Decode(encoded))
+		return decoded
+	}
+
+	dedicatedKey := []byte("DEDICATEDKEY0123")
+	initiate := &InitiateRequest{
+		DedicatedKey:              dedicatedKey,
+		ProposedConformance:       DefaultConformance(),
+		ProposedDlmsVersionNumber: 6,
+		ProposedMaxPduSize:        1024,
+	}
+
+	t.Run("CipheredInitiateRequest", func(t *testing.T) {
+		acse := NewACSE("password", nil, serverSystemTitle)
+		header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: 1}
+		ciphered, err := initiate.EncodeGloCiphered(guek, []byte("CLIENT01"), header, SecuritySuite0)
+		require.NoError(t, err)
+		assert.Equal(t, byte(APDU_GLO_INITIATE_REQUEST), ciphered[0])
+
+		aare, err := acse.HandleAARQ(newAARQ(ciphered), securitySetup)
+		require.NoError(t, err)
+		assert.Equal(t, ResultAccepted, aare.Result)
+		assert.Equal(t, dedicatedKey, acse.DedicatedKey())
+		assert.Equal(t, uint32(1), acse.LastFrameCounter())
+
+		acse.HandleRLRQ(&RLRQ{Reason: ReasonNormal})
+		assert.Nil(t, acse.DedicatedKey())
+	})
+
+	t.Run("UncipheredDedicatedKeyRejected", func(t *testing.T) {
+		acse := NewACSE("password", nil, serverSystemTitle)
+		plain, err := initiate.Encode()
+		require.NoError(t, err)
+
+		aare, err := acse.HandleAARQ(newAARQ(plain), securitySetup)
+		require.NoError(t, err)
+		assert.Equal(t, ResultRejectedPermanent, aare.Result)
+		assert.Nil(t, acse.DedicatedKey())
+	})
+
+	t.Run("WrongGlobalKeyRejected", func(t *testing.T) {
+		acse := NewACSE("password", nil, serverSystemTitle)
+		header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: 1}
+		ciphered, err := initiate.EncodeGloCiphered([]byte("FEDCBA9876543210"), []byte("CLIENT01"), header, SecuritySuite0)
+		require.NoError(t, err)
+
+		aare, err := acse.HandleAARQ(newAARQ(ciphered), securitySetup)
+		require.NoError(t, err)
+		assert.Equal(t, ResultRejectedPermanent, aare.Result)
+	})
+
+	t.Run("ReplayedInitiateRequestRejected", func(t *testing.T) {
+		acse := NewACSE("password", nil, serverSystemTitle)
+		acse.SetLastFrameCounter(1)
+		header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: 1}
+		ciphered, err := initiate.EncodeGloCiphered(guek, []byte("CLIENT01"), header, SecuritySuite0)
+		require.NoError(t, err)
+
+		aare, err := acse.HandleAARQ(newAARQ(ciphered), securitySetup)
+		require.NoError(t, err)
+		assert.Equal(t, ResultRejectedPermanent, aare.Result)
+		assert.Nil(t, acse.DedicatedKey())
+		assert.Equal(t, uint32(1), acse.LastFrameCounter())
+	})
+}
+
+func TestDefaultConformance(t *testing.T) {
+	assert.Equal(t, []byte{0x80, 0x00, 0x00}, NewConformance(0).Bytes)
+	assert.Equal(t, []byte{0x00, 0x00, 0x01}, NewConformance(ConformanceAction).Bytes)
+	assert.Equal(t, 1, DefaultConformance().At(ConformanceAccess))
+	assert.Equal(t, 1, DefaultConformance().At(ConformanceGet))
+	assert.Equal(t, 0, DefaultConformance().At(ConformanceInformationReport))
+	assert.Equal(t, 0, DefaultConformance().At(ConformanceGeneralBlockTransfer))
+}
+
+func TestApplication_Conformance(t *testing.T) {
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	negotiated := func(t *testing.T, app *Application) asn1.BitString {
+		assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+		require.NoError(t, err)
+		clientAddr := mockAddr("client")
+		app.AddAssociation(clientAddr.String(), assoc)
+
+		initiate, err := (&InitiateRequest{
+			ProposedConformance:       asn1.BitString{Bytes: []byte{0xFF, 0xFF, 0xFF}, BitLength: 24},
+			ProposedDlmsVersionNumber: DLMSVersion,
+		}).Encode()
+		require.NoError(t, err)
+		src, err := (&AARQ{ApplicationContextName: OidApplicationContextLN, UserInformation: initiate}).Encode()
+		require.NoError(t, err)
+		encodedAARE, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		aare := &AARE{}
+		require.NoError(t, aare.Decode(encodedAARE))
+		require.Equal(t, ResultAccepted, aare.Result)
+		resp := &InitiateResponse{}
+		require.NoError(t, resp.Decode(aare.UserInformation))
+		return resp.NegotiatedConformance
+	}
+
+	t.Run("WithoutGeneralBlockTransfer", func(t *testing.T) {
+		app := NewApplication(nil, securitySetup)
+		assert.Equal(t, DefaultConformance(), app.Conformance())
+		assert.Equal(t, 0, negotiated(t, app).At(ConformanceGeneralBlockTransfer))
+	})
+
+	t.Run("BehindGBTTransport", func(t *testing.T) {
+		app := NewApplication(NewGBTTransport(nil, nil, nil), securitySetup)
+		conformance := negotiated(t, app)
+		assert.Equal(t, 1, conformance.At(ConformanceGeneralBlockTransfer))
+		assert.Equal(t, 1, conformance.At(ConformanceGet))
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		app := NewApplication(nil, securitySetup)
+		app.SetConformance(NewConformance(ConformanceGet))
+		assert.Equal(t, NewConformance(ConformanceGet).Bytes, negotiated(t, app).Bytes)
+	})
+}
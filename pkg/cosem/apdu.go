@@ -1,7 +1,11 @@
 package cosem
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
+
 	"github.com/gvtret/spodes-go/pkg/axdr"
 )
 
@@ -15,55 +19,88 @@ const (
 	APDU_GET_RESPONSE    APDUType = 0xC4
 	APDU_SET_RESPONSE    APDUType = 0xC5
 	APDU_ACTION_RESPONSE APDUType = 0xC7
+
+	APDU_DATA_NOTIFICATION          APDUType = 0x0F
+	APDU_EVENT_NOTIFICATION_REQUEST APDUType = 0xC2
 )
 
 // GetRequestType represents the type of a Get-Request APDU.
 type GetRequestType byte
 
 const (
-	GET_REQUEST_NORMAL GetRequestType = 0x01
+	GET_REQUEST_NORMAL    GetRequestType = 0x01
+	GET_REQUEST_NEXT      GetRequestType = 0x02
+	GET_REQUEST_WITH_LIST GetRequestType = 0x03
 )
 
 // SetRequestType represents the type of a Set-Request APDU.
 type SetRequestType byte
 
 const (
-	SET_REQUEST_NORMAL SetRequestType = 0x01
+	SET_REQUEST_NORMAL                        SetRequestType = 0x01
+	SET_REQUEST_WITH_FIRST_DATABLOCK          SetRequestType = 0x02
+	SET_REQUEST_WITH_DATABLOCK                SetRequestType = 0x03
+	SET_REQUEST_WITH_LIST                     SetRequestType = 0x04
+	SET_REQUEST_WITH_LIST_AND_FIRST_DATABLOCK SetRequestType = 0x05
 )
 
 // ActionRequestType represents the type of an Action-Request APDU.
 type ActionRequestType byte
 
 const (
-	ACTION_REQUEST_NORMAL ActionRequestType = 0x01
+	ACTION_REQUEST_NORMAL                     ActionRequestType = 0x01
+	ACTION_REQUEST_NEXT_PBLOCK                ActionRequestType = 0x02
+	ACTION_REQUEST_WITH_LIST                  ActionRequestType = 0x03
+	ACTION_REQUEST_WITH_FIRST_PBLOCK          ActionRequestType = 0x04
+	ACTION_REQUEST_WITH_LIST_AND_FIRST_PBLOCK ActionRequestType = 0x05
+	ACTION_REQUEST_WITH_PBLOCK                ActionRequestType = 0x06
 )
 
 // GetResponseType represents the type of a Get-Response APDU.
 type GetResponseType byte
 
 const (
-	GET_RESPONSE_NORMAL GetResponseType = 0x01
+	GET_RESPONSE_NORMAL         GetResponseType = 0x01
+	GET_RESPONSE_WITH_DATABLOCK GetResponseType = 0x02
+	GET_RESPONSE_WITH_LIST      GetResponseType = 0x03
 )
 
 // SetResponseType represents the type of a Set-Response APDU.
 type SetResponseType byte
 
 const (
-	SET_RESPONSE_NORMAL SetResponseType = 0x01
+	SET_RESPONSE_NORMAL                   SetResponseType = 0x01
+	SET_RESPONSE_DATABLOCK                SetResponseType = 0x02
+	SET_RESPONSE_LAST_DATABLOCK           SetResponseType = 0x03
+	SET_RESPONSE_LAST_DATABLOCK_WITH_LIST SetResponseType = 0x04
+	SET_RESPONSE_WITH_LIST                SetResponseType = 0x05
 )
 
 // ActionResponseType represents the type of an Action-Response APDU.
 type ActionResponseType byte
 
 const (
-	ACTION_RESPONSE_NORMAL ActionResponseType = 0x01
+	ACTION_RESPONSE_NORMAL      ActionResponseType = 0x01
+	ACTION_RESPONSE_WITH_PBLOCK ActionResponseType = 0x02
+	ACTION_RESPONSE_WITH_LIST   ActionResponseType = 0x03
+	ACTION_RESPONSE_NEXT_PBLOCK ActionResponseType = 0x04
 )
 
 // CosemAttributeDescriptor is the structure for a COSEM attribute descriptor.
+// AccessSelection is only carried where the APDU allows selective access and is nil
+// when the whole attribute is addressed.
 type CosemAttributeDescriptor struct {
-	ClassID     uint16
-	InstanceID  ObisCode
-	AttributeID int8
+	ClassID         uint16
+	InstanceID      ObisCode
+	AttributeID     int8
+	AccessSelection *SelectiveAccessDescriptor
+}
+
+// SelectiveAccessDescriptor is the Selective-Access-Descriptor of an attribute reference:
+// a class-specific selector and its parameters.
+type SelectiveAccessDescriptor struct {
+	AccessSelector   uint8
+	AccessParameters interface{}
 }
 
 // CosemMethodDescriptor is the structure for a COSEM method descriptor.
@@ -78,22 +115,35 @@ type GetRequest struct {
 	Type                GetRequestType
 	InvokeIDAndPriority uint8
 	AttributeDescriptor CosemAttributeDescriptor
+	BlockNumber         uint32                     // GET_REQUEST_NEXT: number of the last block received
+	AttributeList       []CosemAttributeDescriptor // GET_REQUEST_WITH_LIST
 }
 
 // SetRequest is the structure for a Set-Request APDU.
+// AttributeList is used by the with-list variants, ValueList by SET_REQUEST_WITH_LIST
+// and DataBlock by the datablock variants.
 type SetRequest struct {
 	Type                SetRequestType
 	InvokeIDAndPriority uint8
 	AttributeDescriptor CosemAttributeDescriptor
 	Value               interface{}
+	AttributeList       []CosemAttributeDescriptor
+	ValueList           []interface{}
+	DataBlock           DataBlockSA
 }
 
 // ActionRequest is the structure for an Action-Request APDU.
+// MethodList and ParameterList are used by the with-list variants, DataBlock by the
+// pblock variants and BlockNumber by ACTION_REQUEST_NEXT_PBLOCK.
 type ActionRequest struct {
 	Type                ActionRequestType
 	InvokeIDAndPriority uint8
 	MethodDescriptor    CosemMethodDescriptor
 	Parameters          interface{}
+	MethodList          []CosemMethodDescriptor
+	ParameterList       []interface{}
+	DataBlock           DataBlockSA
+	BlockNumber         uint32
 }
 
 // GetResponse is the structure for a Get-Response APDU.
@@ -101,20 +151,30 @@ type GetResponse struct {
 	Type                GetResponseType
 	InvokeIDAndPriority uint8
 	Result              GetDataResult
+	DataBlock           DataBlockG      // GET_RESPONSE_WITH_DATABLOCK
+	ResultList          []GetDataResult // GET_RESPONSE_WITH_LIST
 }
 
 // SetResponse is the structure for a Set-Response APDU.
+// BlockNumber is used by the datablock variants and ResultList by the with-list variants.
 type SetResponse struct {
 	Type                SetResponseType
 	InvokeIDAndPriority uint8
 	Result              DataAccessResultEnum
+	BlockNumber         uint32
+	ResultList          []DataAccessResultEnum
 }
 
 // ActionResponse is the structure for an Action-Response APDU.
+// ResultList is used by ACTION_RESPONSE_WITH_LIST, DataBlock by ACTION_RESPONSE_WITH_PBLOCK
+// and BlockNumber by ACTION_RESPONSE_NEXT_PBLOCK.
 type ActionResponse struct {
 	Type                ActionResponseType
 	InvokeIDAndPriority uint8
 	Result              ActionResult
+	ResultList          []ActionResult
+	DataBlock           DataBlockSA
+	BlockNumber         uint32
 }
 
 // GetDataResult represents the Get-Data-Result CHOICE.
@@ -125,7 +185,28 @@ type GetDataResult struct {
 	Value              interface{}
 }
 
-// ActionResult represents the Action-Result CHOICE.
+// DataBlockG represents the DataBlock-G structure of a Get-Response-With-Datablock.
+// If IsDataAccessResult is true, DataAccessResult holds the reason the long get was aborted.
+// Otherwise RawData holds the next part of the encoded attribute value.
+type DataBlockG struct {
+	LastBlock          bool
+	BlockNumber        uint32
+	IsDataAccessResult bool
+	RawData            []byte
+	DataAccessResult   DataAccessResultEnum
+}
+
+// DataBlockSA represents the DataBlock-SA structure used to carry a value in several
+// Set-Request, Action-Request and Action-Response APDUs.
+type DataBlockSA struct {
+	LastBlock   bool
+	BlockNumber uint32
+	RawData     []byte
+}
+
+// ActionResult represents the outcome of an action (Action-Response-With-Optional-Data).
+// If IsDataAccessResult is true, Value holds the DataAccessResultEnum result code.
+// If IsDataAccessResult is false, the action succeeded and Value holds the optional return data.
 type ActionResult struct {
 	IsDataAccessResult bool
 	Value              interface{}
@@ -156,582 +237,1135 @@ const (
 	LONG_SET_ABORTED          DataAccessResultEnum = 17
 	NO_LONG_SET_IN_PROGRESS   DataAccessResultEnum = 18
 	DATA_BLOCK_NUMBER_INVALID DataAccessResultEnum = 19
-	OTHER_REASON              DataAccessResultEnum = 250
+
+	// Action-Result codes that share their values with the long-get results.
+	LONG_ACTION_ABORTED        DataAccessResultEnum = 15
+	NO_LONG_ACTION_IN_PROGRESS DataAccessResultEnum = 16
+	OTHER_REASON               DataAccessResultEnum = 250
 )
 
 // Encode encodes the GetRequest APDU into a byte slice.
+//
+// The body is encoded in the untagged A-XDR form of Get-Request defined in
+// IEC 62056-5-3: request type, invoke-id-and-priority and the request fields.
 func (gr *GetRequest) Encode() ([]byte, error) {
-	obisBytes := gr.AttributeDescriptor.InstanceID.Bytes()
-	attrDescStruct := axdr.Structure{
-		gr.AttributeDescriptor.ClassID,
-		obisBytes[:],
-		gr.AttributeDescriptor.AttributeID,
-	}
-
-	reqStruct := axdr.Structure{
-		byte(gr.Type),
-		gr.InvokeIDAndPriority,
-		attrDescStruct,
-	}
-
-	reqBody, err := axdr.Encode(reqStruct)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode GetRequest body: %w", err)
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_GET_REQUEST))
+	buf.WriteByte(byte(gr.Type))
+	buf.WriteByte(gr.InvokeIDAndPriority)
+
+	switch gr.Type {
+	case GET_REQUEST_NORMAL:
+		if err := encodeAttributeDescriptorWithSelection(&buf, gr.AttributeDescriptor); err != nil {
+			return nil, err
+		}
+	case GET_REQUEST_NEXT:
+		writeUint32(&buf, gr.BlockNumber)
+	case GET_REQUEST_WITH_LIST:
+		if err := encodeAttributeDescriptorList(&buf, gr.AttributeList); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported GetRequest type: %d", gr.Type)
 	}
 
-	res := append([]byte{byte(APDU_GET_REQUEST)}, reqBody...)
-	return res, nil
+	return buf.Bytes(), nil
 }
 
 // Decode decodes a byte slice into a GetRequest APDU.
 func (gr *GetRequest) Decode(src []byte) error {
-	if len(src) == 0 {
-		return fmt.Errorf("empty source byte slice")
+	reader, err := newAPDUReader(src, APDU_GET_REQUEST, "GetRequest")
+	if err != nil {
+		return err
 	}
 
-	if APDUType(src[0]) != APDU_GET_REQUEST {
-		return fmt.Errorf("invalid APDU tag for GetRequest: got %X, expected %X", src[0], APDU_GET_REQUEST)
+	reqType, err := readByte(reader, "GetRequestType")
+	if err != nil {
+		return err
 	}
+	gr.Type = GetRequestType(reqType)
 
-	decoded, err := axdr.Decode(src[1:])
+	gr.InvokeIDAndPriority, err = readByte(reader, "InvokeIDAndPriority")
 	if err != nil {
 		return err
 	}
-	decodedStruct, ok := decoded.(axdr.Structure)
-	if !ok {
-		return fmt.Errorf("expected a structure")
-	}
 
-	if len(decodedStruct) != 3 {
-		return fmt.Errorf("expected 3 elements in GetRequest, got %d", len(decodedStruct))
+	switch gr.Type {
+	case GET_REQUEST_NORMAL:
+		gr.AttributeDescriptor, err = decodeAttributeDescriptorWithSelection(reader)
+		if err != nil {
+			return err
+		}
+	case GET_REQUEST_NEXT:
+		gr.BlockNumber, err = readUint32(reader, "BlockNumber")
+		if err != nil {
+			return err
+		}
+	case GET_REQUEST_WITH_LIST:
+		gr.AttributeList, err = decodeAttributeDescriptorList(reader)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported GetRequest type: %d", gr.Type)
 	}
 
-	reqType, ok := decodedStruct[0].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for GetRequestType")
-	}
-	gr.Type = GetRequestType(reqType)
+	return expectEnd(reader, "GetRequest")
+}
 
-	gr.InvokeIDAndPriority, ok = decodedStruct[1].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for InvokeIDAndPriority")
+// Encode encodes the SetRequest APDU into a byte slice.
+func (sr *SetRequest) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_SET_REQUEST))
+	buf.WriteByte(byte(sr.Type))
+	buf.WriteByte(sr.InvokeIDAndPriority)
+
+	switch sr.Type {
+	case SET_REQUEST_NORMAL:
+		if err := encodeAttributeDescriptorWithSelection(&buf, sr.AttributeDescriptor); err != nil {
+			return nil, err
+		}
+		if err := encodeData(&buf, sr.Value); err != nil {
+			return nil, fmt.Errorf("failed to encode value: %w", err)
+		}
+	case SET_REQUEST_WITH_FIRST_DATABLOCK:
+		if err := encodeAttributeDescriptorWithSelection(&buf, sr.AttributeDescriptor); err != nil {
+			return nil, err
+		}
+		if err := encodeDataBlockSA(&buf, sr.DataBlock); err != nil {
+			return nil, err
+		}
+	case SET_REQUEST_WITH_DATABLOCK:
+		if err := encodeDataBlockSA(&buf, sr.DataBlock); err != nil {
+			return nil, err
+		}
+	case SET_REQUEST_WITH_LIST:
+		if len(sr.ValueList) != len(sr.AttributeList) {
+			return nil, fmt.Errorf("value-list length %d does not match attribute-descriptor-list length %d", len(sr.ValueList), len(sr.AttributeList))
+		}
+		if err := encodeAttributeDescriptorList(&buf, sr.AttributeList); err != nil {
+			return nil, err
+		}
+		if err := encodeDataList(&buf, sr.ValueList); err != nil {
+			return nil, fmt.Errorf("failed to encode value-list: %w", err)
+		}
+	case SET_REQUEST_WITH_LIST_AND_FIRST_DATABLOCK:
+		if err := encodeAttributeDescriptorList(&buf, sr.AttributeList); err != nil {
+			return nil, err
+		}
+		if err := encodeDataBlockSA(&buf, sr.DataBlock); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SetRequest type: %d", sr.Type)
 	}
 
-	attrDescStruct, ok := decodedStruct[2].(axdr.Structure)
-	if !ok {
-		return fmt.Errorf("invalid type for AttributeDescriptor")
-	}
+	return buf.Bytes(), nil
+}
 
-	if len(attrDescStruct) != 3 {
-		return fmt.Errorf("expected 3 elements in AttributeDescriptor, got %d", len(attrDescStruct))
+// Decode decodes a byte slice into a SetRequest APDU.
+func (sr *SetRequest) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_SET_REQUEST, "SetRequest")
+	if err != nil {
+		return err
 	}
 
-	classID, ok := attrDescStruct[0].(uint16)
-	if !ok {
-		return fmt.Errorf("invalid type for ClassID")
+	reqType, err := readByte(reader, "SetRequestType")
+	if err != nil {
+		return err
 	}
+	sr.Type = SetRequestType(reqType)
 
-	instanceID, ok := attrDescStruct[1].([]byte)
-	if !ok {
-		return fmt.Errorf("invalid type for InstanceID")
-	}
-	if len(instanceID) != 6 {
-		return fmt.Errorf("invalid length for InstanceID: expected 6, got %d", len(instanceID))
+	sr.InvokeIDAndPriority, err = readByte(reader, "InvokeIDAndPriority")
+	if err != nil {
+		return err
 	}
 
-	attributeID, ok := attrDescStruct[2].(int8)
-	if !ok {
-		return fmt.Errorf("invalid type for AttributeID")
+	switch sr.Type {
+	case SET_REQUEST_NORMAL:
+		sr.AttributeDescriptor, err = decodeAttributeDescriptorWithSelection(reader)
+		if err != nil {
+			return err
+		}
+		sr.Value, err = axdr.DecodeFrom(reader)
+		if err != nil {
+			return fmt.Errorf("failed to decode value: %w", err)
+		}
+	case SET_REQUEST_WITH_FIRST_DATABLOCK:
+		sr.AttributeDescriptor, err = decodeAttributeDescriptorWithSelection(reader)
+		if err != nil {
+			return err
+		}
+		sr.DataBlock, err = decodeDataBlockSA(reader)
+		if err != nil {
+			return err
+		}
+	case SET_REQUEST_WITH_DATABLOCK:
+		sr.DataBlock, err = decodeDataBlockSA(reader)
+		if err != nil {
+			return err
+		}
+	case SET_REQUEST_WITH_LIST:
+		sr.AttributeList, err = decodeAttributeDescriptorList(reader)
+		if err != nil {
+			return err
+		}
+		sr.ValueList, err = decodeDataList(reader)
+		if err != nil {
+			return fmt.Errorf("failed to decode value-list: %w", err)
+		}
+		if len(sr.ValueList) != len(sr.AttributeList) {
+			return fmt.Errorf("value-list length %d does not match attribute-descriptor-list length %d", len(sr.ValueList), len(sr.AttributeList))
+		}
+	case SET_REQUEST_WITH_LIST_AND_FIRST_DATABLOCK:
+		sr.AttributeList, err = decodeAttributeDescriptorList(reader)
+		if err != nil {
+			return err
+		}
+		sr.DataBlock, err = decodeDataBlockSA(reader)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported SetRequest type: %d", sr.Type)
 	}
 
-	gr.AttributeDescriptor.ClassID = classID
-	var instanceIDBytes [6]byte
-	copy(instanceIDBytes[:], instanceID)
-	gr.AttributeDescriptor.InstanceID.SetFromBytes(instanceIDBytes)
-	gr.AttributeDescriptor.AttributeID = attributeID
-
-	return nil
+	return expectEnd(reader, "SetRequest")
 }
 
-// Encode encodes the SetRequest APDU into a byte slice.
-func (sr *SetRequest) Encode() ([]byte, error) {
-	obisBytes := sr.AttributeDescriptor.InstanceID.Bytes()
-	attrDescStruct := axdr.Structure{
-		sr.AttributeDescriptor.ClassID,
-		obisBytes[:],
-		sr.AttributeDescriptor.AttributeID,
+// Encode encodes the ActionRequest APDU into a byte slice.
+// A nil Parameters value is encoded as absent method-invocation-parameters.
+func (ar *ActionRequest) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_ACTION_REQUEST))
+	buf.WriteByte(byte(ar.Type))
+	buf.WriteByte(ar.InvokeIDAndPriority)
+
+	switch ar.Type {
+	case ACTION_REQUEST_NORMAL:
+		encodeMethodDescriptor(&buf, ar.MethodDescriptor)
+		if err := encodeOptionalData(&buf, ar.Parameters); err != nil {
+			return nil, fmt.Errorf("failed to encode parameters: %w", err)
+		}
+	case ACTION_REQUEST_NEXT_PBLOCK:
+		writeUint32(&buf, ar.BlockNumber)
+	case ACTION_REQUEST_WITH_LIST:
+		if len(ar.ParameterList) != len(ar.MethodList) {
+			return nil, fmt.Errorf("parameter list length %d does not match method-descriptor-list length %d", len(ar.ParameterList), len(ar.MethodList))
+		}
+		if err := encodeMethodDescriptorList(&buf, ar.MethodList); err != nil {
+			return nil, err
+		}
+		if err := encodeDataList(&buf, ar.ParameterList); err != nil {
+			return nil, fmt.Errorf("failed to encode parameters: %w", err)
+		}
+	case ACTION_REQUEST_WITH_FIRST_PBLOCK:
+		encodeMethodDescriptor(&buf, ar.MethodDescriptor)
+		if err := encodeDataBlockSA(&buf, ar.DataBlock); err != nil {
+			return nil, err
+		}
+	case ACTION_REQUEST_WITH_LIST_AND_FIRST_PBLOCK:
+		if err := encodeMethodDescriptorList(&buf, ar.MethodList); err != nil {
+			return nil, err
+		}
+		if err := encodeDataBlockSA(&buf, ar.DataBlock); err != nil {
+			return nil, err
+		}
+	case ACTION_REQUEST_WITH_PBLOCK:
+		if err := encodeDataBlockSA(&buf, ar.DataBlock); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ActionRequest type: %d", ar.Type)
 	}
 
-	// The value is encoded with a data tag
-	encodedValue, err := axdr.Encode(sr.Value)
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into an ActionRequest APDU.
+func (ar *ActionRequest) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_ACTION_REQUEST, "ActionRequest")
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode value: %w", err)
+		return err
 	}
 
-	reqStruct := axdr.Structure{
-		byte(sr.Type),
-		sr.InvokeIDAndPriority,
-		attrDescStruct,
-		encodedValue,
+	reqType, err := readByte(reader, "ActionRequestType")
+	if err != nil {
+		return err
 	}
+	ar.Type = ActionRequestType(reqType)
 
-	reqBody, err := axdr.Encode(reqStruct)
+	ar.InvokeIDAndPriority, err = readByte(reader, "InvokeIDAndPriority")
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode SetRequest body: %w", err)
+		return err
+	}
+
+	switch ar.Type {
+	case ACTION_REQUEST_NORMAL:
+		ar.MethodDescriptor, err = decodeMethodDescriptor(reader)
+		if err != nil {
+			return err
+		}
+		ar.Parameters, err = decodeOptionalData(reader, "method-invocation-parameters")
+		if err != nil {
+			return fmt.Errorf("failed to decode parameters: %w", err)
+		}
+	case ACTION_REQUEST_NEXT_PBLOCK:
+		ar.BlockNumber, err = readUint32(reader, "BlockNumber")
+		if err != nil {
+			return err
+		}
+	case ACTION_REQUEST_WITH_LIST:
+		ar.MethodList, err = decodeMethodDescriptorList(reader)
+		if err != nil {
+			return err
+		}
+		ar.ParameterList, err = decodeDataList(reader)
+		if err != nil {
+			return fmt.Errorf("failed to decode parameters: %w", err)
+		}
+		if len(ar.ParameterList) != len(ar.MethodList) {
+			return fmt.Errorf("parameter list length %d does not match method-descriptor-list length %d", len(ar.ParameterList), len(ar.MethodList))
+		}
+	case ACTION_REQUEST_WITH_FIRST_PBLOCK:
+		ar.MethodDescriptor, err = decodeMethodDescriptor(reader)
+		if err != nil {
+			return err
+		}
+		ar.DataBlock, err = decodeDataBlockSA(reader)
+		if err != nil {
+			return err
+		}
+	case ACTION_REQUEST_WITH_LIST_AND_FIRST_PBLOCK:
+		ar.MethodList, err = decodeMethodDescriptorList(reader)
+		if err != nil {
+			return err
+		}
+		ar.DataBlock, err = decodeDataBlockSA(reader)
+		if err != nil {
+			return err
+		}
+	case ACTION_REQUEST_WITH_PBLOCK:
+		ar.DataBlock, err = decodeDataBlockSA(reader)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported ActionRequest type: %d", ar.Type)
 	}
 
-	res := append([]byte{byte(APDU_SET_REQUEST)}, reqBody...)
-	return res, nil
+	return expectEnd(reader, "ActionRequest")
 }
 
-// Decode decodes a byte slice into a SetRequest APDU.
-func (sr *SetRequest) Decode(src []byte) error {
-	if len(src) == 0 {
-		return fmt.Errorf("empty source byte slice")
+// Encode encodes the GetResponse APDU into a byte slice.
+func (gr *GetResponse) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_GET_RESPONSE))
+	buf.WriteByte(byte(gr.Type))
+	buf.WriteByte(gr.InvokeIDAndPriority)
+
+	switch gr.Type {
+	case GET_RESPONSE_NORMAL:
+		if err := encodeGetDataResult(&buf, gr.Result); err != nil {
+			return nil, err
+		}
+	case GET_RESPONSE_WITH_DATABLOCK:
+		if err := encodeDataBlockG(&buf, gr.DataBlock); err != nil {
+			return nil, err
+		}
+	case GET_RESPONSE_WITH_LIST:
+		if err := encodeGetDataResultList(&buf, gr.ResultList); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported GetResponse type: %d", gr.Type)
 	}
 
-	if APDUType(src[0]) != APDU_SET_REQUEST {
-		return fmt.Errorf("invalid APDU tag for SetRequest: got %X, expected %X", src[0], APDU_SET_REQUEST)
-	}
+	return buf.Bytes(), nil
+}
 
-	decoded, err := axdr.Decode(src[1:])
+// Decode decodes a byte slice into a GetResponse APDU.
+func (gr *GetResponse) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_GET_RESPONSE, "GetResponse")
 	if err != nil {
 		return err
 	}
-	decodedStruct, ok := decoded.(axdr.Structure)
-	if !ok {
-		return fmt.Errorf("expected a structure")
-	}
 
-	if len(decodedStruct) != 4 {
-		return fmt.Errorf("expected 4 elements in SetRequest, got %d", len(decodedStruct))
+	respType, err := readByte(reader, "GetResponseType")
+	if err != nil {
+		return err
 	}
+	gr.Type = GetResponseType(respType)
 
-	reqType, ok := decodedStruct[0].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for SetRequestType")
+	gr.InvokeIDAndPriority, err = readByte(reader, "InvokeIDAndPriority")
+	if err != nil {
+		return err
 	}
-	sr.Type = SetRequestType(reqType)
 
-	sr.InvokeIDAndPriority, ok = decodedStruct[1].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for InvokeIDAndPriority")
+	switch gr.Type {
+	case GET_RESPONSE_NORMAL:
+		gr.Result, err = decodeGetDataResult(reader)
+		if err != nil {
+			return err
+		}
+	case GET_RESPONSE_WITH_DATABLOCK:
+		gr.DataBlock, err = decodeDataBlockG(reader)
+		if err != nil {
+			return err
+		}
+	case GET_RESPONSE_WITH_LIST:
+		gr.ResultList, err = decodeGetDataResultList(reader)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported GetResponse type: %d", gr.Type)
 	}
 
-	attrDescStruct, ok := decodedStruct[2].(axdr.Structure)
-	if !ok {
-		return fmt.Errorf("invalid type for AttributeDescriptor")
-	}
+	return expectEnd(reader, "GetResponse")
+}
 
-	if len(attrDescStruct) != 3 {
-		return fmt.Errorf("expected 3 elements in AttributeDescriptor, got %d", len(attrDescStruct))
+// Encode encodes the SetResponse APDU into a byte slice.
+func (sr *SetResponse) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_SET_RESPONSE))
+	buf.WriteByte(byte(sr.Type))
+	buf.WriteByte(sr.InvokeIDAndPriority)
+
+	switch sr.Type {
+	case SET_RESPONSE_NORMAL:
+		buf.WriteByte(byte(sr.Result))
+	case SET_RESPONSE_DATABLOCK:
+		writeUint32(&buf, sr.BlockNumber)
+	case SET_RESPONSE_LAST_DATABLOCK:
+		buf.WriteByte(byte(sr.Result))
+		writeUint32(&buf, sr.BlockNumber)
+	case SET_RESPONSE_LAST_DATABLOCK_WITH_LIST:
+		if err := encodeDataAccessResultList(&buf, sr.ResultList); err != nil {
+			return nil, err
+		}
+		writeUint32(&buf, sr.BlockNumber)
+	case SET_RESPONSE_WITH_LIST:
+		if err := encodeDataAccessResultList(&buf, sr.ResultList); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SetResponse type: %d", sr.Type)
 	}
 
-	classID, ok := attrDescStruct[0].(uint16)
-	if !ok {
-		return fmt.Errorf("invalid type for ClassID")
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into a SetResponse APDU.
+func (sr *SetResponse) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_SET_RESPONSE, "SetResponse")
+	if err != nil {
+		return err
 	}
 
-	instanceID, ok := attrDescStruct[1].([]byte)
-	if !ok {
-		return fmt.Errorf("invalid type for InstanceID")
+	respType, err := readByte(reader, "SetResponseType")
+	if err != nil {
+		return err
 	}
-	if len(instanceID) != 6 {
-		return fmt.Errorf("invalid length for InstanceID: expected 6, got %d", len(instanceID))
+	sr.Type = SetResponseType(respType)
+
+	sr.InvokeIDAndPriority, err = readByte(reader, "InvokeIDAndPriority")
+	if err != nil {
+		return err
 	}
 
-	attributeID, ok := attrDescStruct[2].(int8)
-	if !ok {
-		return fmt.Errorf("invalid type for AttributeID")
+	switch sr.Type {
+	case SET_RESPONSE_NORMAL:
+		result, err := readByte(reader, "Result")
+		if err != nil {
+			return err
+		}
+		sr.Result = DataAccessResultEnum(result)
+	case SET_RESPONSE_DATABLOCK:
+		sr.BlockNumber, err = readUint32(reader, "BlockNumber")
+		if err != nil {
+			return err
+		}
+	case SET_RESPONSE_LAST_DATABLOCK:
+		result, err := readByte(reader, "Result")
+		if err != nil {
+			return err
+		}
+		sr.Result = DataAccessResultEnum(result)
+		sr.BlockNumber, err = readUint32(reader, "BlockNumber")
+		if err != nil {
+			return err
+		}
+	case SET_RESPONSE_LAST_DATABLOCK_WITH_LIST:
+		sr.ResultList, err = decodeDataAccessResultList(reader)
+		if err != nil {
+			return err
+		}
+		sr.BlockNumber, err = readUint32(reader, "BlockNumber")
+		if err != nil {
+			return err
+		}
+	case SET_RESPONSE_WITH_LIST:
+		sr.ResultList, err = decodeDataAccessResultList(reader)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported SetResponse type: %d", sr.Type)
 	}
 
-	sr.AttributeDescriptor.ClassID = classID
-	var instanceIDBytes [6]byte
-	copy(instanceIDBytes[:], instanceID)
-	sr.AttributeDescriptor.InstanceID.SetFromBytes(instanceIDBytes)
-	sr.AttributeDescriptor.AttributeID = attributeID
+	return expectEnd(reader, "SetResponse")
+}
 
-	encodedValue, ok := decodedStruct[3].([]byte)
-	if !ok {
-		return fmt.Errorf("invalid type for Value")
-	}
-	value, err := axdr.Decode(encodedValue)
-	if err != nil {
-		return fmt.Errorf("failed to decode value: %w", err)
+// Encode encodes the ActionResponse APDU into a byte slice.
+//
+// The ActionResult is mapped onto Action-Response-With-Optional-Data: a data access
+// result becomes the action result enum, while data (if non-nil) is sent as
+// return-parameters alongside a success result.
+func (ar *ActionResponse) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_ACTION_RESPONSE))
+	buf.WriteByte(byte(ar.Type))
+	buf.WriteByte(ar.InvokeIDAndPriority)
+
+	switch ar.Type {
+	case ACTION_RESPONSE_NORMAL:
+		if err := encodeActionResult(&buf, ar.Result); err != nil {
+			return nil, err
+		}
+	case ACTION_RESPONSE_WITH_PBLOCK:
+		if err := encodeDataBlockSA(&buf, ar.DataBlock); err != nil {
+			return nil, err
+		}
+	case ACTION_RESPONSE_WITH_LIST:
+		if err := encodeActionResultList(&buf, ar.ResultList); err != nil {
+			return nil, err
+		}
+	case ACTION_RESPONSE_NEXT_PBLOCK:
+		writeUint32(&buf, ar.BlockNumber)
+	default:
+		return nil, fmt.Errorf("unsupported ActionResponse type: %d", ar.Type)
 	}
-	sr.Value = value
 
-	return nil
+	return buf.Bytes(), nil
 }
 
-// Encode encodes the ActionRequest APDU into a byte slice.
-func (ar *ActionRequest) Encode() ([]byte, error) {
-	obisBytes := ar.MethodDescriptor.InstanceID.Bytes()
-	methodDescStruct := axdr.Structure{
-		ar.MethodDescriptor.ClassID,
-		obisBytes[:],
-		ar.MethodDescriptor.MethodID,
+// Decode decodes a byte slice into an ActionResponse APDU.
+func (ar *ActionResponse) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_ACTION_RESPONSE, "ActionResponse")
+	if err != nil {
+		return err
 	}
 
-	encodedParams, err := axdr.Encode(ar.Parameters)
+	respType, err := readByte(reader, "ActionResponseType")
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode parameters: %w", err)
+		return err
 	}
+	ar.Type = ActionResponseType(respType)
 
-	reqStruct := axdr.Structure{
-		byte(ar.Type),
-		ar.InvokeIDAndPriority,
-		methodDescStruct,
-		encodedParams,
+	ar.InvokeIDAndPriority, err = readByte(reader, "InvokeIDAndPriority")
+	if err != nil {
+		return err
 	}
 
-	reqBody, err := axdr.Encode(reqStruct)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode ActionRequest body: %w", err)
+	switch ar.Type {
+	case ACTION_RESPONSE_NORMAL:
+		ar.Result, err = decodeActionResult(reader)
+		if err != nil {
+			return err
+		}
+	case ACTION_RESPONSE_WITH_PBLOCK:
+		ar.DataBlock, err = decodeDataBlockSA(reader)
+		if err != nil {
+			return err
+		}
+	case ACTION_RESPONSE_WITH_LIST:
+		ar.ResultList, err = decodeActionResultList(reader)
+		if err != nil {
+			return err
+		}
+	case ACTION_RESPONSE_NEXT_PBLOCK:
+		ar.BlockNumber, err = readUint32(reader, "BlockNumber")
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported ActionResponse type: %d", ar.Type)
 	}
 
-	res := append([]byte{byte(APDU_ACTION_REQUEST)}, reqBody...)
-	return res, nil
+	return expectEnd(reader, "ActionResponse")
 }
 
-// Decode decodes a byte slice into an ActionRequest APDU.
-func (ar *ActionRequest) Decode(src []byte) error {
+// newAPDUReader validates the APDU tag and returns a reader positioned after it.
+func newAPDUReader(src []byte, expected APDUType, name string) (*bytes.Reader, error) {
 	if len(src) == 0 {
-		return fmt.Errorf("empty source byte slice")
+		return nil, fmt.Errorf("empty source byte slice")
+	}
+	if APDUType(src[0]) != expected {
+		return nil, fmt.Errorf("invalid APDU tag for %s: got %X, expected %X", name, src[0], expected)
 	}
+	return bytes.NewReader(src[1:]), nil
+}
 
-	if APDUType(src[0]) != APDU_ACTION_REQUEST {
-		return fmt.Errorf("invalid APDU tag for ActionRequest: got %X, expected %X", src[0], APDU_ACTION_REQUEST)
+// expectEnd reports an error if the reader still holds undecoded bytes.
+func expectEnd(reader *bytes.Reader, name string) error {
+	if reader.Len() != 0 {
+		return fmt.Errorf("unexpected %d trailing bytes in %s", reader.Len(), name)
 	}
+	return nil
+}
 
-	decoded, err := axdr.Decode(src[1:])
+func readByte(reader *bytes.Reader, field string) (byte, error) {
+	b, err := reader.ReadByte()
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("failed to read %s: %w", field, err)
 	}
-	decodedStruct, ok := decoded.(axdr.Structure)
-	if !ok {
-		return fmt.Errorf("expected a structure")
+	return b, nil
+}
+
+func readUint16(reader *bytes.Reader, field string) (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(reader, b[:]); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", field, err)
 	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
 
-	if len(decodedStruct) != 4 {
-		return fmt.Errorf("expected 4 elements in ActionRequest, got %d", len(decodedStruct))
+func writeUint16(buf *bytes.Buffer, v uint16) {
+	buf.WriteByte(byte(v >> 8))
+	buf.WriteByte(byte(v))
+}
+
+func readUint32(reader *bytes.Reader, field string) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(reader, b[:]); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", field, err)
 	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
 
-	reqType, ok := decodedStruct[0].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for ActionRequestType")
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func readBoolean(reader *bytes.Reader, field string) (bool, error) {
+	b, err := readByte(reader, field)
+	if err != nil {
+		return false, err
 	}
-	ar.Type = ActionRequestType(reqType)
+	return b != 0, nil
+}
 
-	ar.InvokeIDAndPriority, ok = decodedStruct[1].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for InvokeIDAndPriority")
+func writeBoolean(buf *bytes.Buffer, v bool) {
+	if v {
+		buf.WriteByte(0x01)
+		return
 	}
+	buf.WriteByte(0x00)
+}
 
-	methodDescStruct, ok := decodedStruct[2].(axdr.Structure)
-	if !ok {
-		return fmt.Errorf("invalid type for MethodDescriptor")
+// readSequenceLength reads the element count of a SEQUENCE OF. Every element takes at
+// least one byte, so counts above the remaining input are rejected before allocating.
+func readSequenceLength(reader *bytes.Reader, field string) (int, error) {
+	count, err := axdr.ReadLength(reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s length: %w", field, err)
+	}
+	if count > reader.Len() {
+		return 0, fmt.Errorf("%s length %d exceeds remaining %d bytes", field, count, reader.Len())
 	}
+	return count, nil
+}
 
-	if len(methodDescStruct) != 3 {
-		return fmt.Errorf("expected 3 elements in MethodDescriptor, got %d", len(methodDescStruct))
+// writeOctetString writes a variable-length OCTET STRING: A-XDR length followed by the bytes.
+func writeOctetString(buf *bytes.Buffer, data []byte) error {
+	if err := axdr.WriteLength(buf, len(data)); err != nil {
+		return err
 	}
+	buf.Write(data)
+	return nil
+}
 
-	classID, ok := methodDescStruct[0].(uint16)
-	if !ok {
-		return fmt.Errorf("invalid type for ClassID")
+func readOctetString(reader *bytes.Reader, field string) ([]byte, error) {
+	length, err := axdr.ReadLength(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s length: %w", field, err)
+	}
+	if length > reader.Len() {
+		return nil, fmt.Errorf("%s length %d exceeds remaining %d bytes", field, length, reader.Len())
 	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", field, err)
+	}
+	return data, nil
+}
 
-	instanceID, ok := methodDescStruct[1].([]byte)
-	if !ok {
-		return fmt.Errorf("invalid type for InstanceID")
+// readOptionalFlag reads the A-XDR presence flag of an OPTIONAL component.
+func readOptionalFlag(reader *bytes.Reader, field string) (bool, error) {
+	flag, err := readByte(reader, field+" presence flag")
+	if err != nil {
+		return false, err
 	}
-	if len(instanceID) != 6 {
-		return fmt.Errorf("invalid length for InstanceID: expected 6, got %d", len(instanceID))
+	switch flag {
+	case 0x00:
+		return false, nil
+	case 0x01:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid presence flag for %s: %X", field, flag)
 	}
+}
 
-	methodID, ok := methodDescStruct[2].(int8)
-	if !ok {
-		return fmt.Errorf("invalid type for MethodID")
+func readObisCode(reader *bytes.Reader) (ObisCode, error) {
+	var raw [6]byte
+	if _, err := io.ReadFull(reader, raw[:]); err != nil {
+		return ObisCode{}, fmt.Errorf("failed to read InstanceID: %w", err)
 	}
+	var obis ObisCode
+	obis.SetFromBytes(raw)
+	return obis, nil
+}
 
-	ar.MethodDescriptor.ClassID = classID
-	var instanceIDBytes [6]byte
-	copy(instanceIDBytes[:], instanceID)
-	ar.MethodDescriptor.InstanceID.SetFromBytes(instanceIDBytes)
-	ar.MethodDescriptor.MethodID = methodID
+// encodeAttributeDescriptor writes a Cosem-Attribute-Descriptor:
+// class-id (Unsigned16), instance-id (OCTET STRING SIZE(6)), attribute-id (Integer8).
+func encodeAttributeDescriptor(buf *bytes.Buffer, desc CosemAttributeDescriptor) {
+	writeUint16(buf, desc.ClassID)
+	obis := desc.InstanceID.Bytes()
+	buf.Write(obis[:])
+	buf.WriteByte(byte(desc.AttributeID))
+}
 
-	encodedParams, ok := decodedStruct[3].([]byte)
-	if !ok {
-		return fmt.Errorf("invalid type for Parameters")
+func decodeAttributeDescriptor(reader *bytes.Reader) (CosemAttributeDescriptor, error) {
+	var desc CosemAttributeDescriptor
+	var err error
+	if desc.ClassID, err = readUint16(reader, "ClassID"); err != nil {
+		return desc, err
+	}
+	if desc.InstanceID, err = readObisCode(reader); err != nil {
+		return desc, err
 	}
-	params, err := axdr.Decode(encodedParams)
+	attributeID, err := readByte(reader, "AttributeID")
 	if err != nil {
-		return fmt.Errorf("failed to decode parameters: %w", err)
+		return desc, err
 	}
-	ar.Parameters = params
-
-	return nil
+	desc.AttributeID = int8(attributeID)
+	return desc, nil
 }
 
-// Encode encodes the GetResponse APDU into a byte slice.
-func (gr *GetResponse) Encode() ([]byte, error) {
-	var resultChoice Choice
-	if gr.Result.IsDataAccessResult {
-		resultChoice = Choice{
-			Tag:   1, // tag for data-access-result
-			Value: byte(gr.Result.Value.(DataAccessResultEnum)),
-		}
-	} else {
-		resultChoice = Choice{
-			Tag:   0, // tag for data
-			Value: gr.Result.Value,
-		}
+// encodeAttributeDescriptorWithSelection writes a Cosem-Attribute-Descriptor followed by
+// the OPTIONAL Selective-Access-Descriptor taken from desc.AccessSelection.
+func encodeAttributeDescriptorWithSelection(buf *bytes.Buffer, desc CosemAttributeDescriptor) error {
+	encodeAttributeDescriptor(buf, desc)
+	if desc.AccessSelection == nil {
+		buf.WriteByte(0x00) // access-selection absent
+		return nil
 	}
+	buf.WriteByte(0x01)
+	buf.WriteByte(desc.AccessSelection.AccessSelector)
+	if err := encodeData(buf, desc.AccessSelection.AccessParameters); err != nil {
+		return fmt.Errorf("failed to encode access-parameters: %w", err)
+	}
+	return nil
+}
 
-	encodedChoice, err := axdr.Encode(resultChoice.Value)
+func decodeAttributeDescriptorWithSelection(reader *bytes.Reader) (CosemAttributeDescriptor, error) {
+	desc, err := decodeAttributeDescriptor(reader)
 	if err != nil {
-		return nil, err
+		return desc, err
 	}
-	encodedChoice = append([]byte{resultChoice.Tag}, encodedChoice...)
-
-	respStruct := axdr.Structure{
-		byte(gr.Type),
-		gr.InvokeIDAndPriority,
-		encodedChoice,
+	hasSelection, err := readOptionalFlag(reader, "access-selection")
+	if err != nil || !hasSelection {
+		return desc, err
 	}
-
-	respBody, err := axdr.Encode(respStruct)
+	selector, err := readByte(reader, "access-selector")
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode GetResponse body: %w", err)
+		return desc, err
 	}
-
-	res := append([]byte{byte(APDU_GET_RESPONSE)}, respBody...)
-	return res, nil
-}
-
-// Decode decodes a byte slice into a GetResponse APDU.
-func (gr *GetResponse) Decode(src []byte) error {
-	if len(src) == 0 {
-		return fmt.Errorf("empty source byte slice")
+	parameters, err := axdr.DecodeFrom(reader)
+	if err != nil {
+		return desc, fmt.Errorf("failed to decode access-parameters: %w", err)
 	}
-
-	if APDUType(src[0]) != APDU_GET_RESPONSE {
-		return fmt.Errorf("invalid APDU tag for GetResponse: got %X, expected %X", src[0], APDU_GET_RESPONSE)
+	desc.AccessSelection = &SelectiveAccessDescriptor{
+		AccessSelector:   selector,
+		AccessParameters: parameters,
 	}
+	return desc, nil
+}
 
-	decoded, err := axdr.Decode(src[1:])
-	if err != nil {
+// encodeAttributeDescriptorList writes a SEQUENCE OF Cosem-Attribute-Descriptor-With-Selection.
+func encodeAttributeDescriptorList(buf *bytes.Buffer, list []CosemAttributeDescriptor) error {
+	if err := axdr.WriteLength(buf, len(list)); err != nil {
 		return err
 	}
-	decodedStruct, ok := decoded.(axdr.Structure)
-	if !ok {
-		return fmt.Errorf("expected a structure")
+	for _, desc := range list {
+		if err := encodeAttributeDescriptorWithSelection(buf, desc); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if len(decodedStruct) != 3 {
-		return fmt.Errorf("expected 3 elements in GetResponse, got %d", len(decodedStruct))
+func decodeAttributeDescriptorList(reader *bytes.Reader) ([]CosemAttributeDescriptor, error) {
+	count, err := readSequenceLength(reader, "attribute-descriptor-list")
+	if err != nil {
+		return nil, err
 	}
-
-	respType, ok := decodedStruct[0].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for GetResponseType")
+	list := make([]CosemAttributeDescriptor, count)
+	for i := range list {
+		list[i], err = decodeAttributeDescriptorWithSelection(reader)
+		if err != nil {
+			return nil, err
+		}
 	}
-	gr.Type = GetResponseType(respType)
+	return list, nil
+}
 
-	gr.InvokeIDAndPriority, ok = decodedStruct[1].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for InvokeIDAndPriority")
-	}
+// encodeMethodDescriptor writes a Cosem-Method-Descriptor:
+// class-id (Unsigned16), instance-id (OCTET STRING SIZE(6)), method-id (Integer8).
+func encodeMethodDescriptor(buf *bytes.Buffer, desc CosemMethodDescriptor) {
+	writeUint16(buf, desc.ClassID)
+	obis := desc.InstanceID.Bytes()
+	buf.Write(obis[:])
+	buf.WriteByte(byte(desc.MethodID))
+}
 
-	encodedChoice, ok := decodedStruct[2].([]byte)
-	if !ok {
-		return fmt.Errorf("invalid type for GetDataResult")
+func decodeMethodDescriptor(reader *bytes.Reader) (CosemMethodDescriptor, error) {
+	var desc CosemMethodDescriptor
+	var err error
+	if desc.ClassID, err = readUint16(reader, "ClassID"); err != nil {
+		return desc, err
 	}
-	if len(encodedChoice) < 1 {
-		return fmt.Errorf("invalid length for GetDataResult")
+	if desc.InstanceID, err = readObisCode(reader); err != nil {
+		return desc, err
 	}
-	resultTag := encodedChoice[0]
-	resultValue, err := axdr.Decode(encodedChoice[1:])
+	methodID, err := readByte(reader, "MethodID")
 	if err != nil {
-		return fmt.Errorf("failed to decode GetDataResult value: %w", err)
+		return desc, err
 	}
+	desc.MethodID = int8(methodID)
+	return desc, nil
+}
 
-	switch resultTag {
-	case 0: // data
-		gr.Result.IsDataAccessResult = false
-		gr.Result.Value = resultValue
-	case 1: // data-access-result
-		gr.Result.IsDataAccessResult = true
-		val, ok := resultValue.(uint8)
-		if !ok {
-			return fmt.Errorf("invalid type for DataAccessResult")
-		}
-		gr.Result.Value = DataAccessResultEnum(val)
-	default:
-		return fmt.Errorf("invalid tag for GetDataResult CHOICE: %d", resultTag)
+// encodeMethodDescriptorList writes a SEQUENCE OF Cosem-Method-Descriptor.
+func encodeMethodDescriptorList(buf *bytes.Buffer, list []CosemMethodDescriptor) error {
+	if err := axdr.WriteLength(buf, len(list)); err != nil {
+		return err
+	}
+	for _, desc := range list {
+		encodeMethodDescriptor(buf, desc)
 	}
-
 	return nil
 }
 
-// Encode encodes the SetResponse APDU into a byte slice.
-func (sr *SetResponse) Encode() ([]byte, error) {
-	respStruct := axdr.Structure{
-		byte(sr.Type),
-		sr.InvokeIDAndPriority,
-		byte(sr.Result),
+func decodeMethodDescriptorList(reader *bytes.Reader) ([]CosemMethodDescriptor, error) {
+	count, err := readSequenceLength(reader, "method-descriptor-list")
+	if err != nil {
+		return nil, err
+	}
+	list := make([]CosemMethodDescriptor, count)
+	for i := range list {
+		list[i], err = decodeMethodDescriptor(reader)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return list, nil
+}
 
-	respBody, err := axdr.Encode(respStruct)
+func encodeData(buf *bytes.Buffer, value interface{}) error {
+	encoded, err := axdr.Encode(value)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode SetResponse body: %w", err)
+		return err
 	}
-
-	res := append([]byte{byte(APDU_SET_RESPONSE)}, respBody...)
-	return res, nil
+	buf.Write(encoded)
+	return nil
 }
 
-// Decode decodes a byte slice into a SetResponse APDU.
-func (sr *SetResponse) Decode(src []byte) error {
-	if len(src) == 0 {
-		return fmt.Errorf("empty source byte slice")
+// encodeDataList writes a SEQUENCE OF Data.
+func encodeDataList(buf *bytes.Buffer, values []interface{}) error {
+	if err := axdr.WriteLength(buf, len(values)); err != nil {
+		return err
 	}
-
-	if APDUType(src[0]) != APDU_SET_RESPONSE {
-		return fmt.Errorf("invalid APDU tag for SetResponse: got %X, expected %X", src[0], APDU_SET_RESPONSE)
+	for _, value := range values {
+		if err := encodeData(buf, value); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	decoded, err := axdr.Decode(src[1:])
+func decodeDataList(reader *bytes.Reader) ([]interface{}, error) {
+	count, err := readSequenceLength(reader, "value-list")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	decodedStruct, ok := decoded.(axdr.Structure)
-	if !ok {
-		return fmt.Errorf("expected a structure")
+	values := make([]interface{}, count)
+	for i := range values {
+		values[i], err = axdr.DecodeFrom(reader)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return values, nil
+}
 
-	if len(decodedStruct) != 3 {
-		return fmt.Errorf("expected 3 elements in SetResponse, got %d", len(decodedStruct))
+// encodeOptionalData writes an OPTIONAL Data component; nil is encoded as absent.
+func encodeOptionalData(buf *bytes.Buffer, value interface{}) error {
+	if value == nil {
+		buf.WriteByte(0x00)
+		return nil
 	}
+	buf.WriteByte(0x01)
+	return encodeData(buf, value)
+}
 
-	respType, ok := decodedStruct[0].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for SetResponseType")
+func decodeOptionalData(reader *bytes.Reader, field string) (interface{}, error) {
+	present, err := readOptionalFlag(reader, field)
+	if err != nil || !present {
+		return nil, err
 	}
-	sr.Type = SetResponseType(respType)
+	return axdr.DecodeFrom(reader)
+}
 
-	sr.InvokeIDAndPriority, ok = decodedStruct[1].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for InvokeIDAndPriority")
+// encodeGetDataResult writes a Get-Data-Result CHOICE.
+func encodeGetDataResult(buf *bytes.Buffer, result GetDataResult) error {
+	if result.IsDataAccessResult {
+		dar, ok := result.Value.(DataAccessResultEnum)
+		if !ok {
+			return fmt.Errorf("invalid type for DataAccessResult: %T", result.Value)
+		}
+		buf.WriteByte(1) // data-access-result
+		buf.WriteByte(byte(dar))
+		return nil
 	}
-
-	result, ok := decodedStruct[2].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for Result")
+	buf.WriteByte(0) // data
+	if err := encodeData(buf, result.Value); err != nil {
+		return fmt.Errorf("failed to encode GetDataResult value: %w", err)
 	}
-	sr.Result = DataAccessResultEnum(result)
-
 	return nil
 }
 
-// Encode encodes the ActionResponse APDU into a byte slice.
-func (ar *ActionResponse) Encode() ([]byte, error) {
-	var resultChoice Choice
-	if ar.Result.IsDataAccessResult {
-		resultChoice = Choice{
-			Tag:   1, // tag for data-access-result
-			Value: byte(ar.Result.Value.(DataAccessResultEnum)),
+func decodeGetDataResult(reader *bytes.Reader) (GetDataResult, error) {
+	tag, err := readByte(reader, "GetDataResult CHOICE tag")
+	if err != nil {
+		return GetDataResult{}, err
+	}
+	switch tag {
+	case 0: // data
+		value, err := axdr.DecodeFrom(reader)
+		if err != nil {
+			return GetDataResult{}, fmt.Errorf("failed to decode GetDataResult value: %w", err)
 		}
-	} else {
-		resultChoice = Choice{
-			Tag:   0, // tag for data
-			Value: ar.Result.Value,
+		return GetDataResult{IsDataAccessResult: false, Value: value}, nil
+	case 1: // data-access-result
+		dar, err := readByte(reader, "DataAccessResult")
+		if err != nil {
+			return GetDataResult{}, err
+		}
+		return GetDataResult{IsDataAccessResult: true, Value: DataAccessResultEnum(dar)}, nil
+	default:
+		return GetDataResult{}, fmt.Errorf("invalid tag for GetDataResult CHOICE: %d", tag)
+	}
+}
+
+// encodeGetDataResultList writes a SEQUENCE OF Get-Data-Result.
+func encodeGetDataResultList(buf *bytes.Buffer, results []GetDataResult) error {
+	if err := axdr.WriteLength(buf, len(results)); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if err := encodeGetDataResult(buf, result); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	encodedChoice, err := axdr.Encode(resultChoice.Value)
+func decodeGetDataResultList(reader *bytes.Reader) ([]GetDataResult, error) {
+	count, err := readSequenceLength(reader, "result-list")
 	if err != nil {
 		return nil, err
 	}
-	encodedChoice = append([]byte{resultChoice.Tag}, encodedChoice...)
+	results := make([]GetDataResult, count)
+	for i := range results {
+		results[i], err = decodeGetDataResult(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
 
-	respStruct := axdr.Structure{
-		byte(ar.Type),
-		ar.InvokeIDAndPriority,
-		encodedChoice,
+// encodeDataAccessResultList writes a SEQUENCE OF Data-Access-Result.
+func encodeDataAccessResultList(buf *bytes.Buffer, results []DataAccessResultEnum) error {
+	if err := axdr.WriteLength(buf, len(results)); err != nil {
+		return err
 	}
+	for _, result := range results {
+		buf.WriteByte(byte(result))
+	}
+	return nil
+}
 
-	respBody, err := axdr.Encode(respStruct)
+func decodeDataAccessResultList(reader *bytes.Reader) ([]DataAccessResultEnum, error) {
+	count, err := readSequenceLength(reader, "result-list")
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode ActionResponse body: %w", err)
+		return nil, err
 	}
-
-	res := append([]byte{byte(APDU_ACTION_RESPONSE)}, respBody...)
-	return res, nil
+	results := make([]DataAccessResultEnum, count)
+	for i := range results {
+		result, err := readByte(reader, "DataAccessResult")
+		if err != nil {
+			return nil, err
+		}
+		results[i] = DataAccessResultEnum(result)
+	}
+	return results, nil
 }
 
-// Decode decodes a byte slice into an ActionResponse APDU.
-func (ar *ActionResponse) Decode(src []byte) error {
-	if len(src) == 0 {
-		return fmt.Errorf("empty source byte slice")
+// encodeActionResult writes an Action-Response-With-Optional-Data.
+func encodeActionResult(buf *bytes.Buffer, result ActionResult) error {
+	if result.IsDataAccessResult {
+		dar, ok := result.Value.(DataAccessResultEnum)
+		if !ok {
+			return fmt.Errorf("invalid type for DataAccessResult: %T", result.Value)
+		}
+		buf.WriteByte(byte(dar))
+		buf.WriteByte(0x00) // return-parameters absent
+		return nil
 	}
 
-	if APDUType(src[0]) != APDU_ACTION_RESPONSE {
-		return fmt.Errorf("invalid APDU tag for ActionResponse: got %X, expected %X", src[0], APDU_ACTION_RESPONSE)
+	buf.WriteByte(byte(SUCCESS))
+	if result.Value == nil {
+		buf.WriteByte(0x00) // return-parameters absent
+		return nil
 	}
+	buf.WriteByte(0x01)
+	return encodeGetDataResult(buf, GetDataResult{IsDataAccessResult: false, Value: result.Value})
+}
 
-	decoded, err := axdr.Decode(src[1:])
+func decodeActionResult(reader *bytes.Reader) (ActionResult, error) {
+	code, err := readByte(reader, "ActionResult")
 	if err != nil {
-		return err
+		return ActionResult{}, err
 	}
-	decodedStruct, ok := decoded.(axdr.Structure)
-	if !ok {
-		return fmt.Errorf("expected a structure")
+	hasReturn, err := readOptionalFlag(reader, "return-parameters")
+	if err != nil {
+		return ActionResult{}, err
 	}
 
-	if len(decodedStruct) != 3 {
-		return fmt.Errorf("expected 3 elements in ActionResponse, got %d", len(decodedStruct))
+	var returnParams GetDataResult
+	if hasReturn {
+		returnParams, err = decodeGetDataResult(reader)
+		if err != nil {
+			return ActionResult{}, err
+		}
 	}
 
-	respType, ok := decodedStruct[0].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for ActionResponseType")
+	if DataAccessResultEnum(code) != SUCCESS {
+		return ActionResult{IsDataAccessResult: true, Value: DataAccessResultEnum(code)}, nil
 	}
-	ar.Type = ActionResponseType(respType)
-
-	ar.InvokeIDAndPriority, ok = decodedStruct[1].(uint8)
-	if !ok {
-		return fmt.Errorf("invalid type for InvokeIDAndPriority")
+	if !hasReturn {
+		return ActionResult{IsDataAccessResult: false, Value: nil}, nil
 	}
+	return ActionResult{IsDataAccessResult: returnParams.IsDataAccessResult, Value: returnParams.Value}, nil
+}
 
-	encodedChoice, ok := decodedStruct[2].([]byte)
-	if !ok {
-		return fmt.Errorf("invalid type for ActionResult")
+// encodeDataBlockG writes a DataBlock-G: last-block, block-number and the raw-data /
+// data-access-result CHOICE.
+func encodeDataBlockG(buf *bytes.Buffer, block DataBlockG) error {
+	writeBoolean(buf, block.LastBlock)
+	writeUint32(buf, block.BlockNumber)
+	if block.IsDataAccessResult {
+		buf.WriteByte(1) // data-access-result
+		buf.WriteByte(byte(block.DataAccessResult))
+		return nil
+	}
+	buf.WriteByte(0) // raw-data
+	return writeOctetString(buf, block.RawData)
+}
+
+func decodeDataBlockG(reader *bytes.Reader) (DataBlockG, error) {
+	var block DataBlockG
+	var err error
+	if block.LastBlock, err = readBoolean(reader, "LastBlock"); err != nil {
+		return block, err
 	}
-	if len(encodedChoice) < 1 {
-		return fmt.Errorf("invalid length for ActionResult")
+	if block.BlockNumber, err = readUint32(reader, "BlockNumber"); err != nil {
+		return block, err
 	}
-	resultTag := encodedChoice[0]
-	resultValue, err := axdr.Decode(encodedChoice[1:])
+	tag, err := readByte(reader, "DataBlock-G CHOICE tag")
 	if err != nil {
-		return fmt.Errorf("failed to decode ActionResult value: %w", err)
+		return block, err
 	}
-
-	switch resultTag {
-	case 0: // data
-		ar.Result.IsDataAccessResult = false
-		ar.Result.Value = resultValue
+	switch tag {
+	case 0: // raw-data
+		block.RawData, err = readOctetString(reader, "raw-data")
+		if err != nil {
+			return block, err
+		}
 	case 1: // data-access-result
-		ar.Result.IsDataAccessResult = true
-		val, ok := resultValue.(uint8)
-		if !ok {
-			return fmt.Errorf("invalid type for DataAccessResult")
+		dar, err := readByte(reader, "DataAccessResult")
+		if err != nil {
+			return block, err
 		}
-		ar.Result.Value = DataAccessResultEnum(val)
+		block.IsDataAccessResult = true
+		block.DataAccessResult = DataAccessResultEnum(dar)
 	default:
-		return fmt.Errorf("invalid tag for ActionResult CHOICE: %d", resultTag)
+		return block, fmt.Errorf("invalid tag for DataBlock-G CHOICE: %d", tag)
 	}
+	return block, nil
+}
 
+// encodeActionResultList writes a SEQUENCE OF Action-Response-With-Optional-Data.
+func encodeActionResultList(buf *bytes.Buffer, results []ActionResult) error {
+	if err := axdr.WriteLength(buf, len(results)); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if err := encodeActionResult(buf, result); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func decodeActionResultList(reader *bytes.Reader) ([]ActionResult, error) {
+	count, err := readSequenceLength(reader, "list-of-responses")
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ActionResult, count)
+	for i := range results {
+		results[i], err = decodeActionResult(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// encodeDataBlockSA writes a DataBlock-SA: last-block, block-number and raw-data.
+func encodeDataBlockSA(buf *bytes.Buffer, block DataBlockSA) error {
+	writeBoolean(buf, block.LastBlock)
+	writeUint32(buf, block.BlockNumber)
+	return writeOctetString(buf, block.RawData)
+}
+
+func decodeDataBlockSA(reader *bytes.Reader) (DataBlockSA, error) {
+	var block DataBlockSA
+	var err error
+	if block.LastBlock, err = readBoolean(reader, "LastBlock"); err != nil {
+		return block, err
+	}
+	if block.BlockNumber, err = readUint32(reader, "BlockNumber"); err != nil {
+		return block, err
+	}
+	if block.RawData, err = readOctetString(reader, "raw-data"); err != nil {
+		return block, err
+	}
+	return block, nil
+}
@@ -1,6 +1,8 @@
 package cosem
 
 import (
+	"bytes"
+	"reflect"
 	"testing"
 )
 
@@ -286,3 +288,401 @@ func TestActionResponse(t *testing.T) {
 		t.Errorf("Value mismatch: got %v, want %v", decoded.Result.Value, resp.Result.Value)
 	}
 }
+
+func TestAPDU_WireFormat(t *testing.T) {
+	clockObis, _ := NewObisCodeFromString("0.0.1.0.0.255")
+	registerObis, _ := NewObisCodeFromString("1.0.0.4.0.255")
+
+	tests := []struct {
+		name    string
+		apdu    APDU
+		decoded interface{ Decode([]byte) error }
+		want    []byte
+	}{
+		{
+			name: "GetRequestNormal",
+			apdu: &GetRequest{
+				Type:                GET_REQUEST_NORMAL,
+				InvokeIDAndPriority: 0x81,
+				AttributeDescriptor: CosemAttributeDescriptor{ClassID: ClockClassID, InstanceID: *clockObis, AttributeID: 2},
+			},
+			decoded: &GetRequest{},
+			want:    []byte{0xC0, 0x01, 0x81, 0x00, 0x08, 0x00, 0x00, 0x01, 0x00, 0x00, 0xFF, 0x02, 0x00},
+		},
+		{
+			name: "GetResponseNormalData",
+			apdu: &GetResponse{
+				Type:                GET_RESPONSE_NORMAL,
+				InvokeIDAndPriority: 0x81,
+				Result:              GetDataResult{Value: []byte{0x12, 0x34}},
+			},
+			decoded: &GetResponse{},
+			want:    []byte{0xC4, 0x01, 0x81, 0x00, 0x09, 0x02, 0x12, 0x34},
+		},
+		{
+			name: "GetResponseNormalDataAccessResult",
+			apdu: &GetResponse{
+				Type:                GET_RESPONSE_NORMAL,
+				InvokeIDAndPriority: 0x81,
+				Result:              GetDataResult{IsDataAccessResult: true, Value: OBJECT_UNAVAILABLE},
+			},
+			decoded: &GetResponse{},
+			want:    []byte{0xC4, 0x01, 0x81, 0x01, 0x0B},
+		},
+		{
+			name: "GetRequestNormalWithSelectiveAccess",
+			apdu: &GetRequest{
+				Type:                GET_REQUEST_NORMAL,
+				InvokeIDAndPriority: 0x81,
+				AttributeDescriptor: CosemAttributeDescriptor{
+					ClassID:     ProfileGenericClassID,
+					InstanceID:  *registerObis,
+					AttributeID: 2,
+					AccessSelection: &SelectiveAccessDescriptor{
+						AccessSelector:   ProfileGenericEntryDescriptor,
+						AccessParameters: EntryDescriptor{FromEntry: 1, ToEntry: 2}.AccessParameters(),
+					},
+				},
+			},
+			decoded: &GetRequest{},
+			want: []byte{
+				0xC0, 0x01, 0x81, 0x00, 0x07, 0x01, 0x00, 0x00, 0x04, 0x00, 0xFF, 0x02,
+				0x01, 0x02, // access-selection present, entry_descriptor
+				0x02, 0x04, 0x21, 0x00, 0x00, 0x00, 0x01, 0x21, 0x00, 0x00, 0x00, 0x02, 0x20, 0x00, 0x00, 0x20, 0x00, 0x00,
+			},
+		},
+		{
+			name: "GetRequestNext",
+			apdu: &GetRequest{
+				Type:                GET_REQUEST_NEXT,
+				InvokeIDAndPriority: 0x81,
+				BlockNumber:         1,
+			},
+			decoded: &GetRequest{},
+			want:    []byte{0xC0, 0x02, 0x81, 0x00, 0x00, 0x00, 0x01},
+		},
+		{
+			name: "GetRequestWithList",
+			apdu: &GetRequest{
+				Type:                GET_REQUEST_WITH_LIST,
+				InvokeIDAndPriority: 0x81,
+				AttributeList: []CosemAttributeDescriptor{
+					{ClassID: ClockClassID, InstanceID: *clockObis, AttributeID: 2},
+					{ClassID: RegisterClassID, InstanceID: *registerObis, AttributeID: 3},
+				},
+			},
+			decoded: &GetRequest{},
+			want: []byte{
+				0xC0, 0x03, 0x81, 0x02,
+				0x00, 0x08, 0x00, 0x00, 0x01, 0x00, 0x00, 0xFF, 0x02, 0x00,
+				0x00, 0x03, 0x01, 0x00, 0x00, 0x04, 0x00, 0xFF, 0x03, 0x00,
+			},
+		},
+		{
+			name: "GetResponseWithList",
+			apdu: &GetResponse{
+				Type:                GET_RESPONSE_WITH_LIST,
+				InvokeIDAndPriority: 0x81,
+				ResultList: []GetDataResult{
+					{Value: uint32(5)},
+					{IsDataAccessResult: true, Value: READ_WRITE_DENIED},
+				},
+			},
+			decoded: &GetResponse{},
+			want:    []byte{0xC4, 0x03, 0x81, 0x02, 0x00, 0x21, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03},
+		},
+		{
+			name: "GetResponseWithDatablockRawData",
+			apdu: &GetResponse{
+				Type:                GET_RESPONSE_WITH_DATABLOCK,
+				InvokeIDAndPriority: 0x81,
+				DataBlock:           DataBlockG{LastBlock: true, BlockNumber: 2, RawData: []byte{0x12, 0x34}},
+			},
+			decoded: &GetResponse{},
+			want:    []byte{0xC4, 0x02, 0x81, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x02, 0x12, 0x34},
+		},
+		{
+			name: "GetResponseWithDatablockDataAccessResult",
+			apdu: &GetResponse{
+				Type:                GET_RESPONSE_WITH_DATABLOCK,
+				InvokeIDAndPriority: 0x81,
+				DataBlock:           DataBlockG{LastBlock: true, BlockNumber: 3, IsDataAccessResult: true, DataAccessResult: LONG_GET_ABORTED},
+			},
+			decoded: &GetResponse{},
+			want:    []byte{0xC4, 0x02, 0x81, 0x01, 0x00, 0x00, 0x00, 0x03, 0x01, 0x0F},
+		},
+		{
+			name: "SetRequestNormal",
+			apdu: &SetRequest{
+				Type:                SET_REQUEST_NORMAL,
+				InvokeIDAndPriority: 0xC1,
+				AttributeDescriptor: CosemAttributeDescriptor{ClassID: ClockClassID, InstanceID: *clockObis, AttributeID: 2},
+				Value:               true,
+			},
+			decoded: &SetRequest{},
+			want:    []byte{0xC1, 0x01, 0xC1, 0x00, 0x08, 0x00, 0x00, 0x01, 0x00, 0x00, 0xFF, 0x02, 0x00, 0x03, 0x01},
+		},
+		{
+			name:    "SetResponseNormal",
+			apdu:    &SetResponse{Type: SET_RESPONSE_NORMAL, InvokeIDAndPriority: 0xC1, Result: SUCCESS},
+			decoded: &SetResponse{},
+			want:    []byte{0xC5, 0x01, 0xC1, 0x00},
+		},
+		{
+			name: "SetRequestWithFirstDatablock",
+			apdu: &SetRequest{
+				Type:                SET_REQUEST_WITH_FIRST_DATABLOCK,
+				InvokeIDAndPriority: 0xC1,
+				AttributeDescriptor: CosemAttributeDescriptor{ClassID: ClockClassID, InstanceID: *clockObis, AttributeID: 2},
+				DataBlock:           DataBlockSA{BlockNumber: 1, RawData: []byte{0x03}},
+			},
+			decoded: &SetRequest{},
+			want: []byte{
+				0xC1, 0x02, 0xC1, 0x00, 0x08, 0x00, 0x00, 0x01, 0x00, 0x00, 0xFF, 0x02, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x03,
+			},
+		},
+		{
+			name: "SetRequestWithDatablock",
+			apdu: &SetRequest{
+				Type:                SET_REQUEST_WITH_DATABLOCK,
+				InvokeIDAndPriority: 0xC1,
+				DataBlock:           DataBlockSA{LastBlock: true, BlockNumber: 2, RawData: []byte{0x01}},
+			},
+			decoded: &SetRequest{},
+			want:    []byte{0xC1, 0x03, 0xC1, 0x01, 0x00, 0x00, 0x00, 0x02, 0x01, 0x01},
+		},
+		{
+			name: "SetRequestWithList",
+			apdu: &SetRequest{
+				Type:                SET_REQUEST_WITH_LIST,
+				InvokeIDAndPriority: 0xC1,
+				AttributeList: []CosemAttributeDescriptor{
+					{ClassID: ClockClassID, InstanceID: *clockObis, AttributeID: 2},
+				},
+				ValueList: []interface{}{true},
+			},
+			decoded: &SetRequest{},
+			want: []byte{
+				0xC1, 0x04, 0xC1, 0x01, 0x00, 0x08, 0x00, 0x00, 0x01, 0x00, 0x00, 0xFF, 0x02, 0x00,
+				0x01, 0x03, 0x01,
+			},
+		},
+		{
+			name: "SetRequestWithListAndFirstDatablock",
+			apdu: &SetRequest{
+				Type:                SET_REQUEST_WITH_LIST_AND_FIRST_DATABLOCK,
+				InvokeIDAndPriority: 0xC1,
+				AttributeList: []CosemAttributeDescriptor{
+					{ClassID: ClockClassID, InstanceID: *clockObis, AttributeID: 2},
+				},
+				DataBlock: DataBlockSA{LastBlock: true, BlockNumber: 1, RawData: []byte{0x01, 0x03, 0x01}},
+			},
+			decoded: &SetRequest{},
+			want: []byte{
+				0xC1, 0x05, 0xC1, 0x01, 0x00, 0x08, 0x00, 0x00, 0x01, 0x00, 0x00, 0xFF, 0x02, 0x00,
+				0x01, 0x00, 0x00, 0x00, 0x01, 0x03, 0x01, 0x03, 0x01,
+			},
+		},
+		{
+			name:    "SetResponseDatablock",
+			apdu:    &SetResponse{Type: SET_RESPONSE_DATABLOCK, InvokeIDAndPriority: 0xC1, BlockNumber: 1},
+			decoded: &SetResponse{},
+			want:    []byte{0xC5, 0x02, 0xC1, 0x00, 0x00, 0x00, 0x01},
+		},
+		{
+			name:    "SetResponseLastDatablock",
+			apdu:    &SetResponse{Type: SET_RESPONSE_LAST_DATABLOCK, InvokeIDAndPriority: 0xC1, Result: SUCCESS, BlockNumber: 2},
+			decoded: &SetResponse{},
+			want:    []byte{0xC5, 0x03, 0xC1, 0x00, 0x00, 0x00, 0x00, 0x02},
+		},
+		{
+			name: "SetResponseLastDatablockWithList",
+			apdu: &SetResponse{
+				Type:                SET_RESPONSE_LAST_DATABLOCK_WITH_LIST,
+				InvokeIDAndPriority: 0xC1,
+				ResultList:          []DataAccessResultEnum{SUCCESS, READ_WRITE_DENIED},
+				BlockNumber:         2,
+			},
+			decoded: &SetResponse{},
+			want:    []byte{0xC5, 0x04, 0xC1, 0x02, 0x00, 0x03, 0x00, 0x00, 0x00, 0x02},
+		},
+		{
+			name: "SetResponseWithList",
+			apdu: &SetResponse{
+				Type:                SET_RESPONSE_WITH_LIST,
+				InvokeIDAndPriority: 0xC1,
+				ResultList:          []DataAccessResultEnum{SUCCESS, TYPE_UNMATCHED},
+			},
+			decoded: &SetResponse{},
+			want:    []byte{0xC5, 0x05, 0xC1, 0x02, 0x00, 0x0C},
+		},
+		{
+			name:    "ActionRequestNextPblock",
+			apdu:    &ActionRequest{Type: ACTION_REQUEST_NEXT_PBLOCK, InvokeIDAndPriority: 0xC1, BlockNumber: 1},
+			decoded: &ActionRequest{},
+			want:    []byte{0xC3, 0x02, 0xC1, 0x00, 0x00, 0x00, 0x01},
+		},
+		{
+			name: "ActionRequestWithList",
+			apdu: &ActionRequest{
+				Type:                ACTION_REQUEST_WITH_LIST,
+				InvokeIDAndPriority: 0xC1,
+				MethodList:          []CosemMethodDescriptor{{ClassID: RegisterClassID, InstanceID: *registerObis, MethodID: 1}},
+				ParameterList:       []interface{}{true},
+			},
+			decoded: &ActionRequest{},
+			want:    []byte{0xC3, 0x03, 0xC1, 0x01, 0x00, 0x03, 0x01, 0x00, 0x00, 0x04, 0x00, 0xFF, 0x01, 0x01, 0x03, 0x01},
+		},
+		{
+			name: "ActionRequestWithFirstPblock",
+			apdu: &ActionRequest{
+				Type:                ACTION_REQUEST_WITH_FIRST_PBLOCK,
+				InvokeIDAndPriority: 0xC1,
+				MethodDescriptor:    CosemMethodDescriptor{ClassID: RegisterClassID, InstanceID: *registerObis, MethodID: 1},
+				DataBlock:           DataBlockSA{BlockNumber: 1, RawData: []byte{0x03}},
+			},
+			decoded: &ActionRequest{},
+			want:    []byte{0xC3, 0x04, 0xC1, 0x00, 0x03, 0x01, 0x00, 0x00, 0x04, 0x00, 0xFF, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x03},
+		},
+		{
+			name: "ActionRequestWithListAndFirstPblock",
+			apdu: &ActionRequest{
+				Type:                ACTION_REQUEST_WITH_LIST_AND_FIRST_PBLOCK,
+				InvokeIDAndPriority: 0xC1,
+				MethodList:          []CosemMethodDescriptor{{ClassID: RegisterClassID, InstanceID: *registerObis, MethodID: 1}},
+				DataBlock:           DataBlockSA{LastBlock: true, BlockNumber: 1, RawData: []byte{0x01, 0x00}},
+			},
+			decoded: &ActionRequest{},
+			want:    []byte{0xC3, 0x05, 0xC1, 0x01, 0x00, 0x03, 0x01, 0x00, 0x00, 0x04, 0x00, 0xFF, 0x01, 0x01, 0x00, 0x00, 0x00, 0x01, 0x02, 0x01, 0x00},
+		},
+		{
+			name: "ActionRequestWithPblock",
+			apdu: &ActionRequest{
+				Type:                ACTION_REQUEST_WITH_PBLOCK,
+				InvokeIDAndPriority: 0xC1,
+				DataBlock:           DataBlockSA{LastBlock: true, BlockNumber: 2, RawData: []byte{0x01}},
+			},
+			decoded: &ActionRequest{},
+			want:    []byte{0xC3, 0x06, 0xC1, 0x01, 0x00, 0x00, 0x00, 0x02, 0x01, 0x01},
+		},
+		{
+			name: "ActionResponseWithPblock",
+			apdu: &ActionResponse{
+				Type:                ACTION_RESPONSE_WITH_PBLOCK,
+				InvokeIDAndPriority: 0xC1,
+				DataBlock:           DataBlockSA{BlockNumber: 1, RawData: []byte{0x00, 0x00}},
+			},
+			decoded: &ActionResponse{},
+			want:    []byte{0xC7, 0x02, 0xC1, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00},
+		},
+		{
+			name: "ActionResponseWithList",
+			apdu: &ActionResponse{
+				Type:                ACTION_RESPONSE_WITH_LIST,
+				InvokeIDAndPriority: 0xC1,
+				ResultList: []ActionResult{
+					{Value: nil},
+					{IsDataAccessResult: true, Value: OBJECT_UNAVAILABLE},
+				},
+			},
+			decoded: &ActionResponse{},
+			want:    []byte{0xC7, 0x03, 0xC1, 0x02, 0x00, 0x00, 0x0B, 0x00},
+		},
+		{
+			name:    "ActionResponseNextPblock",
+			apdu:    &ActionResponse{Type: ACTION_RESPONSE_NEXT_PBLOCK, InvokeIDAndPriority: 0xC1, BlockNumber: 3},
+			decoded: &ActionResponse{},
+			want:    []byte{0xC7, 0x04, 0xC1, 0x00, 0x00, 0x00, 0x03},
+		},
+		{
+			name: "ActionRequestNormalWithoutParameters",
+			apdu: &ActionRequest{
+				Type:                ACTION_REQUEST_NORMAL,
+				InvokeIDAndPriority: 0xC1,
+				MethodDescriptor:    CosemMethodDescriptor{ClassID: RegisterClassID, InstanceID: *registerObis, MethodID: 1},
+			},
+			decoded: &ActionRequest{},
+			want:    []byte{0xC3, 0x01, 0xC1, 0x00, 0x03, 0x01, 0x00, 0x00, 0x04, 0x00, 0xFF, 0x01, 0x00},
+		},
+		{
+			name: "ActionRequestNormalWithParameters",
+			apdu: &ActionRequest{
+				Type:                ACTION_REQUEST_NORMAL,
+				InvokeIDAndPriority: 0xC1,
+				MethodDescriptor:    CosemMethodDescriptor{ClassID: RegisterClassID, InstanceID: *registerObis, MethodID: 1},
+				Parameters:          []byte{0x00},
+			},
+			decoded: &ActionRequest{},
+			want:    []byte{0xC3, 0x01, 0xC1, 0x00, 0x03, 0x01, 0x00, 0x00, 0x04, 0x00, 0xFF, 0x01, 0x01, 0x09, 0x01, 0x00},
+		},
+		{
+			name:    "ActionResponseNormalSuccess",
+			apdu:    &ActionResponse{Type: ACTION_RESPONSE_NORMAL, InvokeIDAndPriority: 0xC1},
+			decoded: &ActionResponse{},
+			want:    []byte{0xC7, 0x01, 0xC1, 0x00, 0x00},
+		},
+		{
+			name: "ActionResponseNormalWithReturnData",
+			apdu: &ActionResponse{
+				Type:                ACTION_RESPONSE_NORMAL,
+				InvokeIDAndPriority: 0xC1,
+				Result:              ActionResult{Value: true},
+			},
+			decoded: &ActionResponse{},
+			want:    []byte{0xC7, 0x01, 0xC1, 0x00, 0x01, 0x00, 0x03, 0x01},
+		},
+		{
+			name: "ActionResponseNormalFailure",
+			apdu: &ActionResponse{
+				Type:                ACTION_RESPONSE_NORMAL,
+				InvokeIDAndPriority: 0xC1,
+				Result:              ActionResult{IsDataAccessResult: true, Value: TYPE_UNMATCHED},
+			},
+			decoded: &ActionResponse{},
+			want:    []byte{0xC7, 0x01, 0xC1, 0x0C, 0x00},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.apdu.Encode()
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if !bytes.Equal(encoded, tt.want) {
+				t.Fatalf("Encode mismatch:\ngot  %X\nwant %X", encoded, tt.want)
+			}
+			if err := tt.decoded.Decode(tt.want); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(tt.decoded, tt.apdu) {
+				t.Errorf("Decode mismatch: got %+v, want %+v", tt.decoded, tt.apdu)
+			}
+		})
+	}
+}
+
+func TestAPDU_DecodeRejectsMalformedInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		decoded interface{ Decode([]byte) error }
+		src     []byte
+	}{
+		{"Empty", &GetRequest{}, nil},
+		{"WrongTag", &GetRequest{}, []byte{0xC4, 0x01, 0x81}},
+		{"Truncated", &GetRequest{}, []byte{0xC0, 0x01, 0x81, 0x00, 0x08, 0x00}},
+		{"TrailingBytes", &SetResponse{}, []byte{0xC5, 0x01, 0xC1, 0x00, 0x00}},
+		{"UnknownType", &GetResponse{}, []byte{0xC4, 0x09, 0x81}},
+		{"InvalidChoiceTag", &GetResponse{}, []byte{0xC4, 0x01, 0x81, 0x05, 0x00}},
+		{"InvalidPresenceFlag", &ActionRequest{}, []byte{0xC3, 0x01, 0xC1, 0x00, 0x03, 0x01, 0x00, 0x00, 0x04, 0x00, 0xFF, 0x01, 0x07}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.decoded.Decode(tt.src); err == nil {
+				t.Errorf("expected error decoding %X", tt.src)
+			}
+		})
+	}
+}
@@ -1,10 +1,17 @@
 package cosem
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"time"
 
 	"github.com/gvtret/spodes-go/pkg/axdr"
+	"github.com/gvtret/spodes-go/pkg/common"
 	"github.com/gvtret/spodes-go/pkg/transport"
 )
 
@@ -17,9 +24,28 @@ type Application struct {
 	transport           transport.Transport
 	lastFrameCounters   map[*AssociationLN]uint32
 	serverFrameCounters map[*AssociationLN]uint32
+	lastTransactionIDs  map[*AssociationLN][]byte
+	longGets            map[*AssociationLN]*blockSender
+	longSets            map[*AssociationLN]*longSetState
+	longActions         map[*AssociationLN]*longActionState
+	longActionResponses map[*AssociationLN]*blockSender
+	dedicatedKeys       map[*AssociationLN][]byte
+	longReads           map[*AssociationLN]*blockSender
+	acses               map[*AssociationLN]*ACSE
+	shortNames          []shortNameEntry
+	maxPDUSize          uint16
+	conformance         asn1.BitString
+	gbt                 *GBTTransport
 }
 
-// NewApplication creates a new COSEM application instance.
+// DefaultMaxPDUSize is the APDU size limit used for associations that have not
+// negotiated a max PDU size of their own.
+const DefaultMaxPDUSize uint16 = 1024
+
+// NewApplication creates a new COSEM application instance supporting the
+// services of DefaultConformance. When transport is a GBTTransport, the
+// application serves General-Block-Transfer APDUs through it (see HandleAPDU)
+// and supports General-Block-Transfer as well.
 func NewApplication(transport transport.Transport, securitySetup *SecuritySetup) *Application {
 	app := &Application{
 		objects:             make(map[string]BaseInterface),
@@ -28,6 +54,20 @@ func NewApplication(transport transport.Transport, securitySetup *SecuritySetup)
 		securitySetup:       securitySetup,
 		lastFrameCounters:   make(map[*AssociationLN]uint32),
 		serverFrameCounters: make(map[*AssociationLN]uint32),
+		lastTransactionIDs:  make(map[*AssociationLN][]byte),
+		longGets:            make(map[*AssociationLN]*blockSender),
+		longSets:            make(map[*AssociationLN]*longSetState),
+		longActions:         make(map[*AssociationLN]*longActionState),
+		longActionResponses: make(map[*AssociationLN]*blockSender),
+		dedicatedKeys:       make(map[*AssociationLN][]byte),
+		longReads:           make(map[*AssociationLN]*blockSender),
+		acses:               make(map[*AssociationLN]*ACSE),
+		maxPDUSize:          DefaultMaxPDUSize,
+		conformance:         DefaultConformance(),
+	}
+	if gbt, ok := transport.(*GBTTransport); ok {
+		app.gbt = gbt
+		app.conformance = NewConformance(append([]int{ConformanceGeneralBlockTransfer}, defaultConformanceBits...)...)
 	}
 	// Register the SecuritySetup object
 	app.RegisterObject(securitySetup)
@@ -35,6 +75,8 @@ func NewApplication(transport transport.Transport, securitySetup *SecuritySetup)
 }
 
 // AddAssociation maps a client address string to a specific AssociationLN instance.
+// The client must open the association with an AARQ before using any data service,
+// unless it is pre-established (see PreEstablishAssociation).
 func (app *Application) AddAssociation(address string, assoc *AssociationLN) {
 	app.associations[address] = assoc
 	app.lastFrameCounters[assoc] = 0
@@ -43,6 +85,172 @@ func (app *Application) AddAssociation(address string, assoc *AssociationLN) {
 	app.RegisterObject(assoc)
 }
 
+// SetDedicatedKey sets the dedicated key of the association of the client at
+// address, as sent in the InitiateRequest of its AARQ (see ACSE.DedicatedKey).
+// The key is used for ded- and general-ded-ciphering until it is replaced, or
+// cleared with a nil key when the association is released.
+func (app *Application) SetDedicatedKey(address string, key []byte) error {
+	assoc, ok := app.associations[address]
+	if !ok {
+		return fmt.Errorf("no association found for client address: %s", address)
+	}
+	if key == nil {
+		delete(app.dedicatedKeys, assoc)
+		return nil
+	}
+	app.dedicatedKeys[assoc] = append([]byte(nil), key...)
+	return nil
+}
+
+// SetXDLMSContext records the xDLMS context negotiated by the AARQ of the client
+// at address (see ACSE.XDLMSContext) in the xDLMS_context_info of its
+// association. The services the client may use are then limited to the
+// negotiated conformance block, requests above the max receive PDU size are
+// refused and responses above the max send PDU size are sent in blocks.
+func (app *Application) SetXDLMSContext(address string, info XDLMSContextInfo) error {
+	assoc, ok := app.associations[address]
+	if !ok {
+		return fmt.Errorf("no association found for client address: %s", address)
+	}
+	assoc.SetXDLMSContextInfo(info)
+	return nil
+}
+
+// SetMaxPDUSize sets the APDU size limit used for associations whose xDLMS context
+// does not specify a max send PDU size. Responses above the limit are sent in blocks.
+func (app *Application) SetMaxPDUSize(size uint16) {
+	app.maxPDUSize = size
+}
+
+// SetConformance sets the Conformance block of the services the application
+// supports, negotiated with clients when they open an association. Services
+// left out of it are still served to pre-established associations.
+func (app *Application) SetConformance(conformance asn1.BitString) {
+	app.conformance = conformance
+}
+
+// Conformance returns the Conformance block of the services the application
+// supports.
+func (app *Application) Conformance() asn1.BitString {
+	return app.conformance
+}
+
+// maxSendPDUSize returns the largest APDU the server may send to the client of assoc.
+func (app *Application) maxSendPDUSize(assoc *AssociationLN) int {
+	if ctx := xdlmsContext(assoc); ctx.MaxSendPDUSize != 0 {
+		return int(ctx.MaxSendPDUSize)
+	}
+	return int(app.maxPDUSize)
+}
+
+// xdlmsContext returns the xDLMS context recorded for assoc.
+func xdlmsContext(assoc *AssociationLN) XDLMSContextInfo {
+	if info, err := assoc.GetAttribute(5); err == nil {
+		if ctx, ok := info.(XDLMSContextInfo); ok {
+			return ctx
+		}
+	}
+	return XDLMSContextInfo{}
+}
+
+// checkConformance verifies that the services with the given conformance bits
+// were negotiated for assoc. An association without a negotiated conformance
+// block is not restricted.
+func checkConformance(assoc *AssociationLN, bits ...int) error {
+	conformance := xdlmsContext(assoc).Conformance
+	if conformance == 0 {
+		return nil
+	}
+	for _, bit := range bits {
+		if conformance&(1<<(23-bit)) == 0 {
+			return common.NewError(common.ErrCosemServiceNotSupported, fmt.Sprintf("service of conformance bit %d not negotiated", bit))
+		}
+	}
+	return nil
+}
+
+// requiredConformance returns the conformance bits of the services an
+// unprotected request uses.
+func requiredConformance(req interface{}) []int {
+	var bits []int
+	selective := func(descs ...CosemAttributeDescriptor) {
+		for _, desc := range descs {
+			if desc.AccessSelection != nil {
+				bits = append(bits, ConformanceSelectiveAccess)
+				return
+			}
+		}
+	}
+
+	switch req := req.(type) {
+	case *GetRequest:
+		bits = append(bits, ConformanceGet)
+		switch req.Type {
+		case GET_REQUEST_NEXT:
+			bits = append(bits, ConformanceBlockTransferWithGetOrRead)
+		case GET_REQUEST_WITH_LIST:
+			bits = append(bits, ConformanceMultipleReferences)
+			selective(req.AttributeList...)
+		default:
+			selective(req.AttributeDescriptor)
+		}
+	case *SetRequest:
+		bits = append(bits, ConformanceSet)
+		switch req.Type {
+		case SET_REQUEST_WITH_FIRST_DATABLOCK, SET_REQUEST_WITH_DATABLOCK:
+			bits = append(bits, ConformanceBlockTransferWithSetOrWrite)
+		case SET_REQUEST_WITH_LIST:
+			bits = append(bits, ConformanceMultipleReferences)
+		case SET_REQUEST_WITH_LIST_AND_FIRST_DATABLOCK:
+			bits = append(bits, ConformanceMultipleReferences, ConformanceBlockTransferWithSetOrWrite)
+		}
+		selective(req.AttributeDescriptor)
+		selective(req.AttributeList...)
+	case *ActionRequest:
+		bits = append(bits, ConformanceAction)
+		switch req.Type {
+		case ACTION_REQUEST_NEXT_PBLOCK, ACTION_REQUEST_WITH_FIRST_PBLOCK, ACTION_REQUEST_WITH_PBLOCK:
+			bits = append(bits, ConformanceBlockTransferWithAction)
+		case ACTION_REQUEST_WITH_LIST:
+			bits = append(bits, ConformanceMultipleReferences)
+		case ACTION_REQUEST_WITH_LIST_AND_FIRST_PBLOCK:
+			bits = append(bits, ConformanceMultipleReferences, ConformanceBlockTransferWithAction)
+		}
+	case *AccessRequest:
+		bits = append(bits, ConformanceAccess)
+	case *ReadRequest:
+		bits = append(bits, ConformanceRead)
+		bits = append(bits, variableAccessConformance(req.Variables)...)
+	case *WriteRequest:
+		bits = append(bits, ConformanceWrite)
+		bits = append(bits, variableAccessConformance(req.Variables)...)
+	case *UnconfirmedWriteRequest:
+		bits = append(bits, ConformanceUnconfirmedWrite)
+		bits = append(bits, variableAccessConformance(req.Variables)...)
+	}
+	return bits
+}
+
+// variableAccessConformance returns the conformance bits used by the
+// variable-access-specifications of a short name request.
+func variableAccessConformance(variables []VariableAccessSpecification) []int {
+	var bits []int
+	if len(variables) > 1 {
+		bits = append(bits, ConformanceMultipleReferences)
+	}
+	for _, variable := range variables {
+		switch variable.Type {
+		case VARIABLE_ACCESS_PARAMETERIZED:
+			bits = append(bits, ConformanceParameterizedAccess)
+		case VARIABLE_ACCESS_BLOCK_NUMBER, VARIABLE_ACCESS_READ_DATA_BLOCK:
+			bits = append(bits, ConformanceBlockTransferWithGetOrRead)
+		case VARIABLE_ACCESS_WRITE_DATA_BLOCK:
+			bits = append(bits, ConformanceBlockTransferWithSetOrWrite)
+		}
+	}
+	return bits
+}
+
 // RegisterObject adds a COSEM object to the application's master object list.
 // If an object with the same instance ID already exists, it will be overwritten.
 func (app *Application) RegisterObject(obj BaseInterface) {
@@ -70,332 +278,1734 @@ func (app *Application) FindObject(instanceID ObisCode) (BaseInterface, bool) {
 	return obj, found
 }
 
-// HandleAPDU processes an incoming APDU from a specific client address.
-func (app *Application) HandleAPDU(src []byte, clientAddr net.Addr) ([]byte, error) {
-	if len(src) == 0 {
-		return nil, fmt.Errorf("empty APDU")
+// RegisterShortName registers obj like RegisterObject and assigns it baseName for
+// short name referencing. Attribute n of the object is then addressed by the short
+// name baseName + 8*(n-1), and its methods from a class-specific offset on (see
+// shortNameMethodOffsets). baseName must be a multiple of 8 and the short names of
+// the object must not overlap those of another one.
+func (app *Application) RegisterShortName(obj BaseInterface, baseName uint16) error {
+	if baseName%8 != 0 {
+		return fmt.Errorf("base_name %04X is not a multiple of 8", baseName)
 	}
-
-	assoc, ok := app.associations[clientAddr.String()]
-	if !ok {
-		return nil, fmt.Errorf("no association found for client address: %s", clientAddr.String())
+	entry := shortNameEntry{baseName: baseName, obj: obj, span: shortNameSpan(obj)}
+	if int(baseName)+8*entry.span > 0x10000 {
+		return fmt.Errorf("short names of object %s exceed the short name range", obj.GetInstanceID().String())
 	}
 
-	apduType := APDUType(src[0])
-
-	switch apduType {
-	case APDU_GLO_GET_REQUEST, APDU_GLO_SET_REQUEST, APDU_GLO_ACTION_REQUEST:
-		return app.handleSecuredAPDU(apduType, src, assoc)
-	case APDU_GET_REQUEST, APDU_SET_REQUEST, APDU_ACTION_REQUEST:
-		return app.handleUnsecuredAPDU(apduType, src, assoc)
-	default:
-		return nil, fmt.Errorf("unsupported APDU type: %X", apduType)
+	i := sort.Search(len(app.shortNames), func(i int) bool { return app.shortNames[i].baseName >= baseName })
+	if i > 0 && app.shortNames[i-1].end() > int(baseName) {
+		return fmt.Errorf("base_name %04X overlaps the short names of object %s", baseName, app.shortNames[i-1].obj.GetInstanceID().String())
 	}
+	if i < len(app.shortNames) && int(app.shortNames[i].baseName) < entry.end() {
+		return fmt.Errorf("base_name %04X overlaps the short names of object %s", baseName, app.shortNames[i].obj.GetInstanceID().String())
+	}
+
+	app.shortNames = append(app.shortNames, shortNameEntry{})
+	copy(app.shortNames[i+1:], app.shortNames[i:])
+	app.shortNames[i] = entry
+	app.RegisterObject(obj)
+	return nil
 }
 
-func (app *Application) handleSecuredAPDU(apduType APDUType, src []byte, assoc *AssociationLN) ([]byte, error) {
-	policy, err := app.securitySetup.GetAttribute(2)
-	if err != nil {
-		return nil, err
+// PopulateObjectListSN adds a curated list of objects to the object list of an
+// AssociationSN. Every object must have been registered with RegisterShortName.
+// The access rights listed are those of the association the AssociationSN
+// presents, so its object list should be populated first.
+func (app *Application) PopulateObjectListSN(assoc *AssociationSN, objectOBISs []ObisCode) error {
+	for _, obis := range objectOBISs {
+		entry, found := app.findShortNameEntry(obis)
+		if !found {
+			return fmt.Errorf("object with OBIS code %s has no base_name", obis.String())
+		}
+		assoc.AddObject(entry.obj, entry.baseName)
 	}
-	securityPolicy := policy.(SecurityPolicy)
+	return nil
+}
 
-	header := &SecurityHeader{}
-	err = header.Decode(src[1:])
-	if err != nil {
-		return nil, err
+func (app *Application) findShortNameEntry(obis ObisCode) (shortNameEntry, bool) {
+	for _, entry := range app.shortNames {
+		if entry.obj.GetInstanceID().String() == obis.String() {
+			return entry, true
+		}
 	}
+	return shortNameEntry{}, false
+}
 
-	// Check security policy
-	sc := header.SecurityControl
-	if (securityPolicy&PolicyAuthenticatedRequest != 0) && (sc != SecurityControlAuthenticationOnly && sc != SecurityControlAuthenticatedAndEncrypted) {
-		return nil, fmt.Errorf("security policy violation: authenticated request required")
-	}
-	if (securityPolicy&PolicyEncryptedRequest != 0) && (sc != SecurityControlEncryptionOnly && sc != SecurityControlAuthenticatedAndEncrypted) {
-		return nil, fmt.Errorf("security policy violation: encrypted request required")
+// resolveShortName finds the attribute or method addressed by a short name.
+func (app *Application) resolveShortName(name uint16) (shortNameReference, bool) {
+	i := sort.Search(len(app.shortNames), func(i int) bool { return app.shortNames[i].baseName > name })
+	if i == 0 {
+		return shortNameReference{}, false
 	}
-
-	var key []byte
-	suite, err := app.securitySetup.GetAttribute(3)
-	if err != nil {
-		return nil, err
+	entry := app.shortNames[i-1]
+	offset := int(name - entry.baseName)
+	if offset%8 != 0 || int(name) >= entry.end() {
+		return shortNameReference{}, false
 	}
 
-	if sc == SecurityControlAuthenticatedAndEncrypted || sc == SecurityControlEncryptionOnly {
-		key = app.securitySetup.GlobalUnicastKey
+	ref := shortNameReference{obj: entry.obj}
+	if methodOffset, ok := shortNameMethodOffsets[entry.obj.GetClassID()]; ok && offset >= methodOffset {
+		ref.methodID = byte((offset-methodOffset)/8 + 1)
 	} else {
-		key = app.securitySetup.GlobalAuthenticationKey
+		ref.attributeID = byte(offset/8 + 1)
 	}
-
-	serverSystemTitle, err := app.securitySetup.GetAttribute(5)
-	if err != nil {
-		return nil, err
+	if ref.methodID != 0 && entry.obj.GetMethodAccess(ref.methodID) == MethodNoAccess ||
+		ref.attributeID != 0 && entry.obj.GetAttributeAccess(ref.attributeID) == AttributeNoAccess {
+		return shortNameReference{}, false
 	}
+	return ref, true
+}
 
-	lastFrameCounter := app.lastFrameCounters[assoc]
-
-	plaintext, err := DecryptAndVerify(key, src[6:], serverSystemTitle.([]byte), header, suite.(SecuritySuite), lastFrameCounter)
-	if err != nil {
-		return nil, err
+// SendEventNotification reports the current value of the attribute described by
+// desc to the client at clientAddr with an Event-Notification-Request. eventTime
+// is the optional COSEM date-time of the event. The notification is protected as
+// the security policy requires of responses (see protectUnsolicited). The APDU is
+// passed to the application's transport and the frames it produces are returned
+// for the caller to write to the link.
+func (app *Application) SendEventNotification(clientAddr net.Addr, desc CosemAttributeDescriptor, eventTime []byte) ([][]byte, error) {
+	if app.transport == nil {
+		return nil, fmt.Errorf("no transport configured for event notifications")
 	}
-	app.lastFrameCounters[assoc] = header.FrameCounter
-
-	respAPDU, err := app.dispatchAPDU(plaintext, assoc)
-	if err != nil {
-		return nil, err
+	assoc, ok := app.associations[clientAddr.String()]
+	if !ok {
+		return nil, fmt.Errorf("no association found for client address: %s", clientAddr.String())
 	}
 
-	// Wrap the response in a secured APDU
-	encodedResp, err := respAPDU.Encode()
+	obj, found := app.FindObject(desc.InstanceID)
+	if !found {
+		return nil, fmt.Errorf("object with OBIS code %s not found in master list", desc.InstanceID.String())
+	}
+	if obj.GetClassID() != desc.ClassID {
+		return nil, fmt.Errorf("object %s has class %d, not %d", desc.InstanceID.String(), obj.GetClassID(), desc.ClassID)
+	}
+	value, err := obj.GetAttribute(byte(desc.AttributeID))
 	if err != nil {
 		return nil, err
 	}
 
-	nextFrameCounter := app.serverFrameCounters[assoc] + 1
-	app.serverFrameCounters[assoc] = nextFrameCounter
-	assoc.SetServerInvocationCounter(nextFrameCounter)
-
-	respHeader := &SecurityHeader{
-		SecurityControl: header.SecurityControl,
-		FrameCounter:    nextFrameCounter,
+	desc.AccessSelection = nil
+	notification := &EventNotificationRequest{
+		Time:                eventTime,
+		AttributeDescriptor: desc,
+		AttributeValue:      value,
 	}
-
-	ciphertext, err := EncryptAndTag(key, encodedResp, serverSystemTitle.([]byte), respHeader, suite.(SecuritySuite))
+	encoded, err := notification.Encode()
 	if err != nil {
 		return nil, err
 	}
-
-	encodedRespHeader, err := respHeader.Encode()
+	encoded, err = app.protectUnsolicited(encoded, assoc)
 	if err != nil {
 		return nil, err
 	}
-
-	// Determine the response APDU type
-	var respAPDUType APDUType
-	switch apduType {
-	case APDU_GLO_GET_REQUEST:
-		respAPDUType = APDU_GLO_GET_RESPONSE
-	case APDU_GLO_SET_REQUEST:
-		respAPDUType = APDU_GLO_SET_RESPONSE
-	case APDU_GLO_ACTION_REQUEST:
-		respAPDUType = APDU_GLO_ACTION_RESPONSE
-	}
-
-	return append([]byte{byte(respAPDUType)}, append(encodedRespHeader, ciphertext...)...), nil
+	return app.transport.Send(encoded)
 }
 
-func (app *Application) handleUnsecuredAPDU(apduType APDUType, src []byte, assoc *AssociationLN) ([]byte, error) {
-	policy, err := app.securitySetup.GetAttribute(2)
+// protectUnsolicited protects an APDU the server sends to the client of assoc on
+// its own as the security policy requires of responses. Under
+// PolicyAuthenticatedResponse or PolicyEncryptedResponse the APDU is sent in a
+// general-glo-ciphering APDU under the server system title and the next server
+// frame counter of assoc; under PolicyDigitallySignedResponse it is signed.
+func (app *Application) protectUnsolicited(apdu []byte, assoc *AssociationLN) ([]byte, error) {
+	securityPolicy, err := app.securityPolicy()
 	if err != nil {
 		return nil, err
 	}
-	securityPolicy := policy.(SecurityPolicy)
 
-	if securityPolicy != PolicyNone {
-		return nil, fmt.Errorf("security policy violation: unsecured request not allowed")
+	var sc SecurityControl
+	if securityPolicy&PolicyAuthenticatedResponse != 0 {
+		sc |= SecurityControlAuthenticationOnly
 	}
-
-	respAPDU, err := app.dispatchAPDU(src, assoc)
-	if err != nil {
-		return nil, err
+	if securityPolicy&PolicyEncryptedResponse != 0 {
+		sc |= SecurityControlEncryptionOnly
 	}
-
-	return respAPDU.Encode()
-}
-
-func (app *Application) dispatchAPDU(src []byte, assoc *AssociationLN) (APDU, error) {
-	apduType := APDUType(src[0])
-	switch apduType {
-	case APDU_GET_REQUEST:
-		req := &GetRequest{}
-		err := req.Decode(src)
+	if sc != 0 {
+		suite, err := app.securitySuite()
 		if err != nil {
 			return nil, err
 		}
-		return app.HandleGetRequest(req, assoc), nil
-	case APDU_SET_REQUEST:
-		req := &SetRequest{}
-		err := req.Decode(src)
+		serverSystemTitle, err := app.serverSystemTitle()
 		if err != nil {
 			return nil, err
 		}
-		return app.HandleSetRequest(req, assoc), nil
-	case APDU_ACTION_REQUEST:
-		req := &ActionRequest{}
-		err := req.Decode(src)
+		header := &SecurityHeader{SecurityControl: sc, FrameCounter: app.nextServerFrameCounter(assoc)}
+		glo, err := NewGeneralGloCiphering(app.globalKey(sc), apdu, serverSystemTitle, header, suite)
 		if err != nil {
 			return nil, err
 		}
-		return app.HandleActionRequest(req, assoc), nil
-	default:
-		return nil, fmt.Errorf("unsupported APDU type: %X", apduType)
+		if apdu, err = glo.Encode(); err != nil {
+			return nil, err
+		}
 	}
-}
 
-// APDU is an interface for all APDU types.
-type APDU interface {
-	Encode() ([]byte, error)
+	if securityPolicy&PolicyDigitallySignedResponse != 0 {
+		return app.signResponse(apdu, nil)
+	}
+	return apdu, nil
 }
 
-// HandleGetRequest processes a Get-Request APDU and returns a Get-Response APDU.
-func (app *Application) HandleGetRequest(req *GetRequest, assoc *AssociationLN) *GetResponse {
-	resp := &GetResponse{
-		Type:                GET_RESPONSE_NORMAL,
-		InvokeIDAndPriority: req.InvokeIDAndPriority,
+// HandleAPDU processes an incoming APDU from a specific client address.
+//
+// An AARQ opens the application association of the client and an RLRQ releases
+// it; both are answered with their ACSE response. Any other request is served
+// only once the association is established.
+//
+// A request that cannot be served at all, because it cannot be decoded, comes
+// from a client that is not associated, exceeds the negotiated max receive PDU
+// size, is not supported or not negotiated in the conformance block, violates the
+// security policy or fails deciphering, is answered with an Exception-Response
+// rather than an error, so the client is not left waiting for a reply; a failed
+// ReadRequest or WriteRequest is answered with a Confirmed-Service-Error. A nil
+// response means none is to be sent, as for an UnconfirmedWriteRequest or a
+// Set-Request, Action-Request or Access-Request of the unconfirmed service class.
+//
+// A General-Block-Transfer APDU is passed to the GBT layer of the application,
+// which acknowledges it or sends the next window of a long response on its own;
+// the APDU its last block completes is then served like any other. The response
+// is returned whole, for the caller to send through the GBT layer, which splits
+// it into blocks when it exceeds the max PDU size of the layer.
+//
+// A client address with no association added is refused: its AARQ is rejected
+// and its other requests are answered as those of a client that is not
+// associated.
+func (app *Application) HandleAPDU(src []byte, clientAddr net.Addr) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, fmt.Errorf("empty APDU")
 	}
-
-	if !assoc.CheckAttributeAccess(req.AttributeDescriptor.InstanceID, byte(req.AttributeDescriptor.AttributeID), Read) {
-		resp.Result = GetDataResult{
-			IsDataAccessResult: true,
-			Value:              READ_WRITE_DENIED,
-		}
-		return resp
+	if APDUType(src[0]) == APDU_GENERAL_BLOCK_TRANSFER {
+		return app.handleGeneralBlockTransfer(src, clientAddr)
 	}
 
-	obj, found := app.FindObject(req.AttributeDescriptor.InstanceID)
-	if !found {
-		resp.Result = GetDataResult{
-			IsDataAccessResult: true,
-			Value:              OBJECT_UNDEFINED,
-		}
-		return resp
+	assoc, ok := app.associations[clientAddr.String()]
+	if !ok {
+		return app.refuseUnknownClient(src)
 	}
 
-	val, err := obj.GetAttribute(byte(req.AttributeDescriptor.AttributeID))
-	if err != nil {
-		// The original error from GetAttribute might be too generic.
-		// We can provide a more specific access control error if the association check failed.
-		switch err {
-		case ErrAttributeNotSupported:
-			resp.Result = GetDataResult{
-				IsDataAccessResult: true,
-				Value:              OBJECT_UNAVAILABLE,
-			}
-		case ErrAccessDenied:
-			resp.Result = GetDataResult{
-				IsDataAccessResult: true,
-				Value:              READ_WRITE_DENIED,
-			}
-		default:
-			resp.Result = GetDataResult{
-				IsDataAccessResult: true,
-				Value:              OTHER_REASON,
-			}
+	switch APDUType(src[0]) {
+	case APDU_AARQ:
+		return app.handleAARQ(src, assoc)
+	case APDU_RLRQ:
+		resp, err := app.handleRLRQ(src, assoc)
+		if err != nil {
+			return app.errorResponse(src, err, assoc)
 		}
-		return resp
+		return resp, nil
 	}
-
-	resp.Result = GetDataResult{
-		IsDataAccessResult: false,
-		Value:              val,
+	if assoc.Status() == AssociationStatusNonAssociated {
+		return app.errorResponse(src, ErrNotAssociated, assoc)
 	}
-	return resp
-}
 
-// HandleSetRequest processes a Set-Request APDU and returns a Set-Response APDU.
-func (app *Application) HandleSetRequest(req *SetRequest, assoc *AssociationLN) *SetResponse {
-	resp := &SetResponse{
-		Type:                SET_RESPONSE_NORMAL,
-		InvokeIDAndPriority: req.InvokeIDAndPriority,
+	if maxReceive := xdlmsContext(assoc).MaxReceivePDUSize; maxReceive != 0 && len(src) > int(maxReceive) {
+		return app.errorResponse(src, ErrPDUTooLong, assoc)
 	}
+	resp, err := app.handleRequest(src, assoc, nil)
+	if err != nil {
+		return app.errorResponse(src, err, assoc)
+	}
+	return resp, nil
+}
 
-	if !assoc.CheckAttributeAccess(req.AttributeDescriptor.InstanceID, byte(req.AttributeDescriptor.AttributeID), Write) {
-		resp.Result = READ_WRITE_DENIED
-		return resp
+// refuseUnknownClient answers an APDU from a client address that has no
+// association: an AARQ is rejected and any other request answered as for a
+// client that is not associated.
+func (app *Application) refuseUnknownClient(src []byte) ([]byte, error) {
+	if APDUType(src[0]) == APDU_AARQ {
+		applicationContextName := OidApplicationContextLN
+		req := &AARQ{}
+		if err := req.Decode(src); err == nil {
+			applicationContextName = req.ApplicationContextName
+		}
+		return rejectAARQ(applicationContextName, ResultSourceDiagnostic{ACSEServiceUser: ACSEUserNoReasonGiven})
 	}
+	return app.errorResponse(src, ErrNotAssociated, nil)
+}
 
-	obj, found := app.FindObject(req.AttributeDescriptor.InstanceID)
-	if !found {
-		resp.Result = OBJECT_UNDEFINED
-		return resp
+// handleRequest serves a request APDU in any of its protected forms. signed is the
+// general-signing APDU src was carried in, or nil if it was not signed. Under
+// PolicyDigitallySignedResponse the response is signed in turn.
+func (app *Application) handleRequest(src []byte, assoc *AssociationLN, signed *GeneralSigning) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, common.NewError(common.ErrCosemAPDUUngarsable, "empty APDU")
 	}
+	apduType := APDUType(src[0])
 
-	err := obj.SetAttribute(byte(req.AttributeDescriptor.AttributeID), req.Value)
+	securityPolicy, err := app.securityPolicy()
 	if err != nil {
-		switch err {
-		case ErrAttributeNotSupported:
-			resp.Result = OBJECT_UNAVAILABLE
-		case ErrAccessDenied:
-			resp.Result = READ_WRITE_DENIED
-		case ErrInvalidValueType:
-			resp.Result = TYPE_UNMATCHED
-		default:
-			resp.Result = OTHER_REASON
+		return nil, err
+	}
+	if apduType == APDU_GENERAL_SIGNING {
+		if signed != nil {
+			return nil, common.NewError(common.ErrCosemAPDUUngarsable, "nested general-signing APDU")
 		}
-		return resp
+		return app.handleSignedAPDU(src, assoc)
 	}
-
-	resp.Result = SUCCESS
-	return resp
-}
-
-// HandleActionRequest processes an Action-Request APDU and returns an Action-Response APDU.
-func (app *Application) HandleActionRequest(req *ActionRequest, assoc *AssociationLN) *ActionResponse {
-	resp := &ActionResponse{
-		Type:                ACTION_RESPONSE_NORMAL,
-		InvokeIDAndPriority: req.InvokeIDAndPriority,
+	if signed == nil && securityPolicy&PolicyDigitallySignedRequest != 0 {
+		return nil, common.NewError(common.ErrCosemSecurityPolicyViolation, "security policy violation: signed request required")
 	}
 
-	if !assoc.CheckMethodAccess(req.MethodDescriptor.InstanceID, byte(req.MethodDescriptor.MethodID)) {
-		resp.Result = ActionResult{
-			IsDataAccessResult: true,
-			Value:              READ_WRITE_DENIED,
-		}
-		return resp
+	var resp []byte
+	switch apduType {
+	case APDU_GLO_GET_REQUEST, APDU_GLO_SET_REQUEST, APDU_GLO_ACTION_REQUEST,
+		APDU_DED_GET_REQUEST, APDU_DED_SET_REQUEST, APDU_DED_ACTION_REQUEST,
+		APDU_GLO_READ_REQUEST, APDU_GLO_WRITE_REQUEST, APDU_GLO_UNCONFIRMED_WRITE_REQUEST,
+		APDU_DED_READ_REQUEST, APDU_DED_WRITE_REQUEST, APDU_DED_UNCONFIRMED_WRITE_REQUEST:
+		resp, err = app.handleSecuredAPDU(apduType, src, assoc)
+	case APDU_GENERAL_GLO_CIPHERING, APDU_GENERAL_DED_CIPHERING, APDU_GENERAL_CIPHERING:
+		resp, err = app.handleGeneralCipheredAPDU(apduType, src, assoc)
+	case APDU_GET_REQUEST, APDU_SET_REQUEST, APDU_ACTION_REQUEST, APDU_ACCESS_REQUEST,
+		APDU_READ_REQUEST, APDU_WRITE_REQUEST, APDU_UNCONFIRMED_WRITE_REQUEST:
+		resp, err = app.handleUnsecuredAPDU(apduType, src, assoc, signed != nil)
+	default:
+		err = errUnsupportedAPDU(apduType)
+	}
+	if err != nil || resp == nil {
+		return nil, err
 	}
 
-	obj, found := app.FindObject(req.MethodDescriptor.InstanceID)
-	if !found {
-		resp.Result = ActionResult{
-			IsDataAccessResult: true,
-			Value:              OBJECT_UNDEFINED,
-		}
-		return resp
+	if securityPolicy&PolicyDigitallySignedResponse != 0 {
+		return app.signResponse(resp, signed)
 	}
+	return resp, nil
+}
 
-	params, ok := req.Parameters.(axdr.Array)
+// handleGeneralBlockTransfer passes a General-Block-Transfer APDU to the GBT layer
+// and serves the APDU it completes. An application without a GBT layer does not
+// support the service. Only associated clients may transfer blocks, each in a
+// transfer of its own that may not exceed its max receive PDU size.
+func (app *Application) handleGeneralBlockTransfer(src []byte, clientAddr net.Addr) ([]byte, error) {
+	if app.gbt == nil {
+		return app.errorResponse(src, errUnsupportedAPDU(APDU_GENERAL_BLOCK_TRANSFER), nil)
+	}
+	assoc, ok := app.associations[clientAddr.String()]
 	if !ok {
-		if req.Parameters == nil {
-			params = axdr.Array{}
-		} else {
-			resp.Result = ActionResult{
-				IsDataAccessResult: true,
-				Value:              TYPE_UNMATCHED,
-			}
-			return resp
-		}
+		return app.refuseUnknownClient(src)
 	}
-
-	val, err := obj.Invoke(byte(req.MethodDescriptor.MethodID), params)
+	if assoc.Status() == AssociationStatusNonAssociated {
+		return app.errorResponse(src, ErrNotAssociated, assoc)
+	}
+	block := &GeneralBlockTransfer{}
+	if err := block.Decode(src); err != nil {
+		return app.errorResponse(src, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode General-Block-Transfer", err), assoc)
+	}
+	maxSize := int(xdlmsContext(assoc).MaxReceivePDUSize)
+	if maxSize == 0 {
+		maxSize = int(app.maxPDUSize)
+	}
+	apdu, err := app.gbt.handleBlock(block, clientAddr, maxSize)
 	if err != nil {
-		switch err {
-		case ErrMethodNotSupported:
-			resp.Result = ActionResult{
-				IsDataAccessResult: true,
-				Value:              OBJECT_UNAVAILABLE,
-			}
-		case ErrAccessDenied:
-			resp.Result = ActionResult{
+		return app.errorResponse(src, err, assoc)
+	}
+	if apdu == nil {
+		return nil, nil
+	}
+	return app.HandleAPDU(apdu, clientAddr)
+}
+
+// SignedAPDUMaxClockSkew is how far the date-time of a general-signing request
+// may be from the server clock for the request to be accepted.
+const SignedAPDUMaxClockSkew = 5 * time.Minute
+
+// handleSignedAPDU verifies a general-signing request with the client signing key
+// of the security setup, checks that it is addressed to this server and fresh
+// (see checkSignedRequest) and serves the APDU it carries.
+func (app *Application) handleSignedAPDU(src []byte, assoc *AssociationLN) ([]byte, error) {
+	req := &GeneralSigning{}
+	if err := req.Decode(src); err != nil {
+		return nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode general-signing", err)
+	}
+	if app.securitySetup.ClientSigningKey == nil {
+		return nil, fmt.Errorf("no client signing key configured: %w", ErrInvalidSignature)
+	}
+	if err := req.Verify(app.securitySetup.ClientSigningKey); err != nil {
+		return nil, err
+	}
+	if err := app.checkSignedRequest(req, assoc); err != nil {
+		return nil, err
+	}
+	app.lastTransactionIDs[assoc] = req.TransactionID
+	return app.handleRequest(req.Content, assoc, req)
+}
+
+// checkSignedRequest checks that a verified general-signing request goes from
+// the client of assoc, when its system title is known, to this server and is
+// fresh: its transaction-id, read as
+// an unsigned integer, must be greater than that of the last signed request of
+// the client, and its date-time, when present, within SignedAPDUMaxClockSkew of
+// the server clock.
+func (app *Application) checkSignedRequest(req *GeneralSigning, assoc *AssociationLN) error {
+	serverSystemTitle, err := app.serverSystemTitle()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(req.RecipientSystemTitle, serverSystemTitle) {
+		return fmt.Errorf("unexpected recipient system title %x: %w", req.RecipientSystemTitle, ErrAuthenticationFailed)
+	}
+	clientSystemTitle, err := app.clientSystemTitle(assoc)
+	if err != nil {
+		return err
+	}
+	if len(clientSystemTitle) > 0 && !bytes.Equal(req.OriginatorSystemTitle, clientSystemTitle) {
+		return fmt.Errorf("unexpected client system title %x: %w", req.OriginatorSystemTitle, ErrAuthenticationFailed)
+	}
+	if !transactionIDAfter(req.TransactionID, app.lastTransactionIDs[assoc]) {
+		return fmt.Errorf("replayed transaction-id %x: %w", req.TransactionID, ErrAuthenticationFailed)
+	}
+	if len(req.DateTime) == 0 {
+		return nil
+	}
+	value, err := axdr.Decode(append([]byte{byte(axdr.TagDateTime)}, req.DateTime...))
+	if err != nil {
+		return common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode general-signing date-time", err)
+	}
+	dateTime, err := value.(axdr.DateTime).ToTime()
+	if err != nil {
+		return common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode general-signing date-time", err)
+	}
+	if skew := time.Since(dateTime); skew > SignedAPDUMaxClockSkew || skew < -SignedAPDUMaxClockSkew {
+		return fmt.Errorf("stale general-signing date-time %s: %w", dateTime, ErrAuthenticationFailed)
+	}
+	return nil
+}
+
+// transactionIDAfter reports whether the transaction-id id, read as a big-endian
+// unsigned integer, is greater than last.
+func transactionIDAfter(id, last []byte) bool {
+	id, last = bytes.TrimLeft(id, "\x00"), bytes.TrimLeft(last, "\x00")
+	if len(id) != len(last) {
+		return len(id) > len(last)
+	}
+	return bytes.Compare(id, last) > 0
+}
+
+// signResponse wraps resp in a general-signing APDU signed with the server signing
+// key of the security setup. A response to a signed request repeats its
+// transaction-id and is addressed to its originator.
+func (app *Application) signResponse(resp []byte, req *GeneralSigning) ([]byte, error) {
+	if app.securitySetup.ServerSigningKey == nil {
+		return nil, common.NewError(common.ErrCosemSecurityPolicyViolation, "security policy violation: no server signing key for signed response")
+	}
+	serverSystemTitle, err := app.serverSystemTitle()
+	if err != nil {
+		return nil, err
+	}
+
+	signedResp := &GeneralSigning{
+		TransactionID:         []byte{},
+		OriginatorSystemTitle: serverSystemTitle,
+		RecipientSystemTitle:  []byte{},
+		DateTime:              []byte{},
+		OtherInformation:      []byte{},
+		Content:               resp,
+	}
+	if req != nil {
+		signedResp.TransactionID = req.TransactionID
+		signedResp.RecipientSystemTitle = req.OriginatorSystemTitle
+	}
+	if err := signedResp.Sign(app.securitySetup.ServerSigningKey); err != nil {
+		return nil, err
+	}
+	return signedResp.Encode()
+}
+
+// errorResponse encodes the APDU answering the request src that failed with err.
+// The short name services are answered with a Confirmed-Service-Error, an
+// unconfirmed service with nothing and every other request with an
+// Exception-Response.
+func (app *Application) errorResponse(src []byte, err error, assoc *AssociationLN) ([]byte, error) {
+	if requestUnconfirmed(src) {
+		return nil, nil
+	}
+	switch APDUType(src[0]) {
+	case APDU_READ_REQUEST, APDU_GLO_READ_REQUEST, APDU_DED_READ_REQUEST:
+		return NewConfirmedServiceError(CONFIRMED_SERVICE_ERROR_READ, err).Encode()
+	case APDU_WRITE_REQUEST, APDU_GLO_WRITE_REQUEST, APDU_DED_WRITE_REQUEST:
+		return NewConfirmedServiceError(CONFIRMED_SERVICE_ERROR_WRITE, err).Encode()
+	case APDU_GLO_UNCONFIRMED_WRITE_REQUEST, APDU_DED_UNCONFIRMED_WRITE_REQUEST:
+		return nil, nil
+	}
+
+	exception := NewExceptionResponse(err)
+	if exception.ServiceError == SERVICE_ERROR_INVOCATION_COUNTER_ERROR {
+		exception.InvocationCounter = app.lastFrameCounters[assoc] + 1
+	}
+	return exception.Encode()
+}
+
+// openSecuredAPDU decodes a glo- or ded- ciphered request of the client of assoc,
+// checks it against the security policy and deciphers it with the key of its
+// type. Its frame counter must follow the last one received from the client, but
+// is not recorded.
+func (app *Application) openSecuredAPDU(apduType APDUType, src []byte, assoc *AssociationLN) (req *CipheredAPDU, key, plaintext []byte, err error) {
+	req = &CipheredAPDU{}
+	if err := req.Decode(src); err != nil {
+		return nil, nil, nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode ciphered APDU", err)
+	}
+	if err := app.checkRequestSecurity(req.SecurityHeader.SecurityControl); err != nil {
+		return nil, nil, nil, err
+	}
+	switch apduType {
+	case APDU_DED_GET_REQUEST, APDU_DED_SET_REQUEST, APDU_DED_ACTION_REQUEST,
+		APDU_DED_READ_REQUEST, APDU_DED_WRITE_REQUEST, APDU_DED_UNCONFIRMED_WRITE_REQUEST:
+		key, err = app.dedicatedKey(assoc)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	default:
+		key = app.globalKey(req.SecurityHeader.SecurityControl)
+	}
+
+	suite, err := app.securitySuite()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clientSystemTitle, err := app.clientSystemTitle(assoc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	plaintext, err = req.Open(key, clientSystemTitle, suite, app.lastFrameCounters[assoc])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return req, key, plaintext, nil
+}
+
+func (app *Application) handleSecuredAPDU(apduType APDUType, src []byte, assoc *AssociationLN) ([]byte, error) {
+	req, key, plaintext, err := app.openSecuredAPDU(apduType, src, assoc)
+	if err != nil {
+		return nil, err
+	}
+	header := &req.SecurityHeader
+	app.lastFrameCounters[assoc] = header.FrameCounter
+
+	suite, err := app.securitySuite()
+	if err != nil {
+		return nil, err
+	}
+	serverSystemTitle, err := app.serverSystemTitle()
+	if err != nil {
+		return nil, err
+	}
+
+	encodedResp, respHeader, err := app.serveDeciphered(plaintext, header.SecurityControl, assoc)
+	if err != nil || encodedResp == nil {
+		return nil, err
+	}
+
+	// Determine the response APDU type
+	var respAPDUType APDUType
+	switch apduType {
+	case APDU_GLO_GET_REQUEST:
+		respAPDUType = APDU_GLO_GET_RESPONSE
+	case APDU_GLO_SET_REQUEST:
+		respAPDUType = APDU_GLO_SET_RESPONSE
+	case APDU_GLO_ACTION_REQUEST:
+		respAPDUType = APDU_GLO_ACTION_RESPONSE
+	case APDU_DED_GET_REQUEST:
+		respAPDUType = APDU_DED_GET_RESPONSE
+	case APDU_DED_SET_REQUEST:
+		respAPDUType = APDU_DED_SET_RESPONSE
+	case APDU_DED_ACTION_REQUEST:
+		respAPDUType = APDU_DED_ACTION_RESPONSE
+	case APDU_GLO_READ_REQUEST:
+		respAPDUType = APDU_GLO_READ_RESPONSE
+	case APDU_GLO_WRITE_REQUEST:
+		respAPDUType = APDU_GLO_WRITE_RESPONSE
+	case APDU_DED_READ_REQUEST:
+		respAPDUType = APDU_DED_READ_RESPONSE
+	case APDU_DED_WRITE_REQUEST:
+		respAPDUType = APDU_DED_WRITE_RESPONSE
+	}
+
+	resp, err := NewCipheredAPDU(respAPDUType, key, encodedResp, serverSystemTitle, respHeader, suite)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Encode()
+}
+
+// openGeneralCipheredAPDU decodes a general-glo-ciphering, general-ded-ciphering
+// or general-ciphering request of the client of assoc, checks it against the
+// security policy and deciphers it. The nonce is built from the system title the
+// client sent, which must be the one of its association. The frame counter must
+// follow the last one received from the client, but is not recorded. request is
+// the general-ciphering APDU, nil for the other forms.
+func (app *Application) openGeneralCipheredAPDU(apduType APDUType, src []byte, assoc *AssociationLN) (header SecurityHeader, request *GeneralCiphering, key, plaintext []byte, err error) {
+	var systemTitle, ciphertext []byte
+	switch apduType {
+	case APDU_GENERAL_GLO_CIPHERING:
+		glo := &GeneralGloCiphering{}
+		if err := glo.Decode(src); err != nil {
+			return header, nil, nil, nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode general-glo-ciphering", err)
+		}
+		systemTitle, header, ciphertext = glo.SystemTitle, glo.SecurityHeader, glo.Ciphertext
+	case APDU_GENERAL_DED_CIPHERING:
+		ded := &GeneralDedCiphering{}
+		if err := ded.Decode(src); err != nil {
+			return header, nil, nil, nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode general-ded-ciphering", err)
+		}
+		systemTitle, header, ciphertext = ded.SystemTitle, ded.SecurityHeader, ded.Ciphertext
+	default:
+		request = &GeneralCiphering{}
+		if err := request.Decode(src); err != nil {
+			return header, nil, nil, nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode general-ciphering", err)
+		}
+		systemTitle, header, ciphertext = request.OriginatorSystemTitle, request.SecurityHeader, request.Ciphertext
+	}
+
+	clientSystemTitle, err := app.clientSystemTitle(assoc)
+	if err != nil {
+		return header, nil, nil, nil, err
+	}
+	if len(clientSystemTitle) > 0 && !bytes.Equal(systemTitle, clientSystemTitle) {
+		return header, nil, nil, nil, fmt.Errorf("unexpected client system title %x: %w", systemTitle, ErrAuthenticationFailed)
+	}
+	if err := app.checkRequestSecurity(header.SecurityControl); err != nil {
+		return header, nil, nil, nil, err
+	}
+
+	switch apduType {
+	case APDU_GENERAL_GLO_CIPHERING:
+		key = app.globalKey(header.SecurityControl)
+	case APDU_GENERAL_DED_CIPHERING:
+		key, err = app.dedicatedKey(assoc)
+	default:
+		key, err = app.generalCipheringKey(request, header.SecurityControl)
+	}
+	if err != nil {
+		return header, nil, nil, nil, err
+	}
+
+	suite, err := app.securitySuite()
+	if err != nil {
+		return header, nil, nil, nil, err
+	}
+	plaintext, err = DecryptAndVerify(key, ciphertext, systemTitle, &header, suite, app.lastFrameCounters[assoc])
+	if err != nil {
+		return header, nil, nil, nil, err
+	}
+	return header, request, key, plaintext, nil
+}
+
+// handleGeneralCipheredAPDU serves a request protected with general-glo-ciphering,
+// general-ded-ciphering or general-ciphering (see openGeneralCipheredAPDU). The
+// response is protected in the same form under the server system title.
+func (app *Application) handleGeneralCipheredAPDU(apduType APDUType, src []byte, assoc *AssociationLN) ([]byte, error) {
+	header, request, key, plaintext, err := app.openGeneralCipheredAPDU(apduType, src, assoc)
+	if err != nil {
+		return nil, err
+	}
+	app.lastFrameCounters[assoc] = header.FrameCounter
+
+	suite, err := app.securitySuite()
+	if err != nil {
+		return nil, err
+	}
+
+	encodedResp, respHeader, err := app.serveDeciphered(plaintext, header.SecurityControl, assoc)
+	if err != nil || encodedResp == nil {
+		return nil, err
+	}
+
+	serverSystemTitle, err := app.serverSystemTitle()
+	if err != nil {
+		return nil, err
+	}
+
+	var resp APDU
+	switch apduType {
+	case APDU_GENERAL_GLO_CIPHERING:
+		resp, err = NewGeneralGloCiphering(key, encodedResp, serverSystemTitle, respHeader, suite)
+	case APDU_GENERAL_DED_CIPHERING:
+		resp, err = NewGeneralDedCiphering(key, encodedResp, serverSystemTitle, respHeader, suite)
+	default:
+		general := &GeneralCiphering{
+			TransactionID:         request.TransactionID,
+			OriginatorSystemTitle: serverSystemTitle,
+			RecipientSystemTitle:  request.OriginatorSystemTitle,
+			DateTime:              []byte{},
+			OtherInformation:      []byte{},
+			KeyInfo:               request.KeyInfo,
+		}
+		err = general.Seal(key, encodedResp, respHeader, suite)
+		resp = general
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Encode()
+}
+
+// checkRequestSecurity verifies that a request protected with sc satisfies the
+// security policy.
+func (app *Application) checkRequestSecurity(sc SecurityControl) error {
+	securityPolicy, err := app.securityPolicy()
+	if err != nil {
+		return err
+	}
+
+	if (securityPolicy&PolicyAuthenticatedRequest != 0) && (sc != SecurityControlAuthenticationOnly && sc != SecurityControlAuthenticatedAndEncrypted) {
+		return common.NewError(common.ErrCosemSecurityPolicyViolation, "security policy violation: authenticated request required")
+	}
+	if (securityPolicy&PolicyEncryptedRequest != 0) && (sc != SecurityControlEncryptionOnly && sc != SecurityControlAuthenticatedAndEncrypted) {
+		return common.NewError(common.ErrCosemSecurityPolicyViolation, "security policy violation: encrypted request required")
+	}
+	return nil
+}
+
+// globalKey returns the global key protecting an APDU with security control sc.
+func (app *Application) globalKey(sc SecurityControl) []byte {
+	if sc == SecurityControlAuthenticatedAndEncrypted || sc == SecurityControlEncryptionOnly {
+		return app.securitySetup.GlobalUnicastKey
+	}
+	return app.securitySetup.GlobalAuthenticationKey
+}
+
+// dedicatedKey returns the dedicated key established for assoc. A request
+// ciphered with a dedicated key the server does not know cannot be deciphered.
+func (app *Application) dedicatedKey(assoc *AssociationLN) ([]byte, error) {
+	key := app.dedicatedKeys[assoc]
+	if key == nil {
+		return nil, fmt.Errorf("no dedicated key established: %w", ErrAuthenticationFailed)
+	}
+	return key, nil
+}
+
+// generalCipheringKey returns the key named by the Key-Info of a general-ciphering
+// request. Without Key-Info the global key is used.
+func (app *Application) generalCipheringKey(request *GeneralCiphering, sc SecurityControl) ([]byte, error) {
+	keyInfo := request.KeyInfo
+	if keyInfo == nil {
+		return app.globalKey(sc), nil
+	}
+	switch keyInfo.Type {
+	case KeyInfoIdentifiedKey:
+		if keyInfo.KeyID != KeyIDGlobalUnicastEncryption {
+			return nil, common.NewError(common.ErrCosemServiceNotSupported, fmt.Sprintf("unsupported key id: %d", keyInfo.KeyID))
+		}
+		return app.globalKey(sc), nil
+	case KeyInfoAgreedKey:
+		return app.agreedKey(request)
+	default:
+		return nil, common.NewError(common.ErrCosemServiceNotSupported, fmt.Sprintf("unsupported Key-Info choice: %d", keyInfo.Type))
+	}
+}
+
+// agreedKey returns the key of a general-ciphering request agreed with One-Pass
+// Diffie-Hellman C(1e, 1s): the key-ciphered-data is the ephemeral public key of
+// the originator as x || y, and the static key of the server is its
+// ServerKeyAgreementKey. The originator is party U and the recipient party V.
+func (app *Application) agreedKey(request *GeneralCiphering) ([]byte, error) {
+	keyInfo := request.KeyInfo
+	if !bytes.Equal(keyInfo.KeyParameters, []byte{KeyAgreementOnePassDiffieHellman}) {
+		return nil, common.NewError(common.ErrCosemServiceNotSupported, fmt.Sprintf("unsupported key agreement scheme: %x", keyInfo.KeyParameters))
+	}
+	suite, err := app.securitySuite()
+	if err != nil {
+		return nil, err
+	}
+	params, err := keyAgreementSuiteOf(suite)
+	if err != nil {
+		return nil, common.WrapError(common.ErrCosemServiceNotSupported, "no key agreement", err)
+	}
+	staticKey, ok := app.securitySetup.ServerKeyAgreementKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, common.NewError(common.ErrCosemServiceNotSupported, "no key agreement key")
+	}
+	ephemeralKey, err := unmarshalRawPublicKey(params.curve, keyInfo.KeyCipheredData)
+	if err != nil {
+		return nil, err
+	}
+	return AgreeKey(suite, staticKey, ephemeralKey, params.algorithmID, request.OriginatorSystemTitle, request.RecipientSystemTitle)
+}
+
+// securityPolicy returns the security policy of the security setup.
+func (app *Application) securityPolicy() (SecurityPolicy, error) {
+	policy, err := app.securitySetup.GetAttribute(2)
+	if err != nil {
+		return 0, err
+	}
+	return policy.(SecurityPolicy), nil
+}
+
+// securitySuite returns the security suite of the security setup.
+func (app *Application) securitySuite() (SecuritySuite, error) {
+	suite, err := app.securitySetup.GetAttribute(3)
+	if err != nil {
+		return 0, err
+	}
+	return suite.(SecuritySuite), nil
+}
+
+// serverSystemTitle returns the server system title of the security setup.
+func (app *Application) serverSystemTitle() ([]byte, error) {
+	title, err := app.securitySetup.GetAttribute(5)
+	if err != nil {
+		return nil, err
+	}
+	return title.([]byte), nil
+}
+
+// clientSystemTitle returns the system title of the client of assoc, which
+// starts the nonce of its ciphered requests: the title it sent when opening the
+// association, or else the client_system_title of the security setup, as for a
+// pre-established association.
+func (app *Application) clientSystemTitle(assoc *AssociationLN) ([]byte, error) {
+	if acse, ok := app.acses[assoc]; ok && len(acse.ClientSystemTitle()) > 0 {
+		return acse.ClientSystemTitle(), nil
+	}
+	title, err := app.securitySetup.GetAttribute(4)
+	if err != nil {
+		return nil, err
+	}
+	return title.([]byte), nil
+}
+
+// nextServerFrameCounter returns the frame counter of the next value the server
+// protects for the client of assoc and records it as used.
+func (app *Application) nextServerFrameCounter(assoc *AssociationLN) uint32 {
+	nextFrameCounter := app.serverFrameCounters[assoc] + 1
+	app.serverFrameCounters[assoc] = nextFrameCounter
+	assoc.SetServerInvocationCounter(nextFrameCounter)
+	return nextFrameCounter
+}
+
+// serveDeciphered dispatches a deciphered request and returns the encoded response
+// together with the security header to protect it with, which carries the next
+// server frame counter. A request that takes no response gives a nil response.
+func (app *Application) serveDeciphered(plaintext []byte, sc SecurityControl, assoc *AssociationLN) ([]byte, *SecurityHeader, error) {
+	respAPDU, err := app.dispatchAPDU(plaintext, assoc)
+	if err != nil || respAPDU == nil {
+		return nil, nil, err
+	}
+	encodedResp, err := respAPDU.Encode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nextFrameCounter := app.nextServerFrameCounter(assoc)
+
+	return encodedResp, &SecurityHeader{SecurityControl: sc, FrameCounter: nextFrameCounter}, nil
+}
+
+func (app *Application) handleUnsecuredAPDU(apduType APDUType, src []byte, assoc *AssociationLN, signed bool) ([]byte, error) {
+	securityPolicy, err := app.securityPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	// Signing the response needs nothing from the request, and a signature is the
+	// only protection a signed request without ciphering has.
+	securityPolicy &^= PolicyDigitallySignedResponse
+	if signed {
+		securityPolicy &^= PolicyDigitallySignedRequest
+	}
+	if securityPolicy != PolicyNone {
+		return nil, common.NewError(common.ErrCosemSecurityPolicyViolation, "security policy violation: unsecured request not allowed")
+	}
+
+	respAPDU, err := app.dispatchAPDU(src, assoc)
+	if err != nil || respAPDU == nil {
+		return nil, err
+	}
+
+	return respAPDU.Encode()
+}
+
+// dispatchAPDU serves an unprotected request and returns its response, or nil for a
+// request that takes no response. An unconfirmed request is served like a
+// confirmed one, but its response is dropped.
+func (app *Application) dispatchAPDU(src []byte, assoc *AssociationLN) (APDU, error) {
+	if len(src) == 0 {
+		return nil, common.NewError(common.ErrCosemAPDUUngarsable, "empty APDU")
+	}
+	resp, err := app.serveAPDU(src, assoc)
+	if err != nil || requestUnconfirmed(src) {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// serveAPDU decodes an unprotected request and passes it to its handler. While the
+// association is pending only reply_to_HLS_authentication is served.
+func (app *Application) serveAPDU(src []byte, assoc *AssociationLN) (APDU, error) {
+	apduType := APDUType(src[0])
+	pending := assoc.Status() == AssociationStatusAssociationPending
+	if pending && apduType != APDU_ACTION_REQUEST && apduType != APDU_READ_REQUEST && apduType != APDU_WRITE_REQUEST {
+		return nil, ErrNotAssociated
+	}
+	switch apduType {
+	case APDU_GET_REQUEST:
+		req := &GetRequest{}
+		err := req.Decode(src)
+		if err != nil {
+			return nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode Get-Request", err)
+		}
+		if err := checkConformance(assoc, requiredConformance(req)...); err != nil {
+			return nil, err
+		}
+		return app.HandleGetRequest(req, assoc), nil
+	case APDU_SET_REQUEST:
+		req := &SetRequest{}
+		err := req.Decode(src)
+		if err != nil {
+			return nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode Set-Request", err)
+		}
+		if err := checkConformance(assoc, requiredConformance(req)...); err != nil {
+			return nil, err
+		}
+		return app.HandleSetRequest(req, assoc), nil
+	case APDU_ACTION_REQUEST:
+		req := &ActionRequest{}
+		err := req.Decode(src)
+		if err != nil {
+			return nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode Action-Request", err)
+		}
+		if pending && !isReplyToHLS(req) {
+			return nil, ErrNotAssociated
+		}
+		if err := checkConformance(assoc, requiredConformance(req)...); err != nil {
+			return nil, err
+		}
+		return app.HandleActionRequest(req, assoc), nil
+	case APDU_ACCESS_REQUEST:
+		req := &AccessRequest{}
+		err := req.Decode(src)
+		if err != nil {
+			return nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode Access-Request", err)
+		}
+		if err := checkConformance(assoc, requiredConformance(req)...); err != nil {
+			return nil, err
+		}
+		resp, err := app.HandleAccessRequest(req, assoc)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	case APDU_READ_REQUEST:
+		req := &ReadRequest{}
+		err := req.Decode(src)
+		if err != nil {
+			return nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode ReadRequest", err)
+		}
+		if pending && !app.namesReplyToHLS(req.Variables, assoc) {
+			return nil, ErrNotAssociated
+		}
+		if err := checkConformance(assoc, requiredConformance(req)...); err != nil {
+			return nil, err
+		}
+		resp, err := app.HandleReadRequest(req, assoc)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	case APDU_WRITE_REQUEST:
+		req := &WriteRequest{}
+		err := req.Decode(src)
+		if err != nil {
+			return nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode WriteRequest", err)
+		}
+		if pending && !app.namesReplyToHLS(req.Variables, assoc) {
+			return nil, ErrNotAssociated
+		}
+		if err := checkConformance(assoc, requiredConformance(req)...); err != nil {
+			return nil, err
+		}
+		resp, err := app.HandleWriteRequest(req, assoc)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	case APDU_UNCONFIRMED_WRITE_REQUEST:
+		req := &UnconfirmedWriteRequest{}
+		err := req.Decode(src)
+		if err != nil {
+			return nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode UnconfirmedWriteRequest", err)
+		}
+		if err := checkConformance(assoc, requiredConformance(req)...); err != nil {
+			return nil, err
+		}
+		return nil, app.HandleUnconfirmedWriteRequest(req, assoc)
+	default:
+		return nil, errUnsupportedAPDU(apduType)
+	}
+}
+
+// APDU is an interface for all APDU types.
+type APDU interface {
+	Encode() ([]byte, error)
+}
+
+// HandleGetRequest processes a Get-Request APDU and returns a Get-Response APDU.
+// Each attribute of a Get-Request-With-List is read independently, so one failing
+// attribute does not affect the others. Responses too large for a single APDU are
+// returned with Get-Response-With-Datablock; the client fetches the remaining blocks
+// with Get-Request-Next.
+func (app *Application) HandleGetRequest(req *GetRequest, assoc *AssociationLN) *GetResponse {
+	if req.Type == GET_REQUEST_NEXT {
+		return app.handleGetRequestNext(req, assoc)
+	}
+
+	// A new request abandons any long get still in progress on this association.
+	delete(app.longGets, assoc)
+
+	resp := &GetResponse{InvokeIDAndPriority: req.InvokeIDAndPriority}
+	var encoded bytes.Buffer
+	if req.Type == GET_REQUEST_WITH_LIST {
+		resp.Type = GET_RESPONSE_WITH_LIST
+		resp.ResultList = make([]GetDataResult, len(req.AttributeList))
+		for i, desc := range req.AttributeList {
+			resp.ResultList[i] = encodableGetDataResult(app.getAttribute(desc, assoc))
+		}
+		if err := encodeGetDataResultList(&encoded, resp.ResultList); err != nil {
+			return resp
+		}
+	} else {
+		resp.Type = GET_RESPONSE_NORMAL
+		resp.Result = encodableGetDataResult(app.getAttribute(req.AttributeDescriptor, assoc))
+		if resp.Result.IsDataAccessResult {
+			return resp
+		}
+		if err := encodeData(&encoded, resp.Result.Value); err != nil {
+			resp.Result = GetDataResult{IsDataAccessResult: true, Value: OTHER_REASON}
+			return resp
+		}
+	}
+
+	maxPDU := app.maxSendPDUSize(assoc)
+	if getResponseHeaderSize+encoded.Len()+apduCipheringOverhead <= maxPDU {
+		return resp
+	}
+
+	state := newBlockSender(req.InvokeIDAndPriority, encoded.Bytes(), maxPDU, getResponseBlockHeaderSize)
+	app.longGets[assoc] = state
+	return app.nextGetBlock(state, assoc)
+}
+
+// encodableGetDataResult replaces a value that cannot be A-XDR encoded with OTHER_REASON.
+func encodableGetDataResult(result GetDataResult) GetDataResult {
+	if result.IsDataAccessResult {
+		return result
+	}
+	if _, err := axdr.Encode(result.Value); err != nil {
+		return GetDataResult{IsDataAccessResult: true, Value: OTHER_REASON}
+	}
+	return result
+}
+
+// getAttribute reads a single attribute on behalf of assoc and returns it as a Get-Data-Result.
+func (app *Application) getAttribute(desc CosemAttributeDescriptor, assoc *AssociationLN) GetDataResult {
+	if !assoc.CheckAttributeAccess(desc.InstanceID, byte(desc.AttributeID), Read) {
+		return GetDataResult{
+			IsDataAccessResult: true,
+			Value:              READ_WRITE_DENIED,
+		}
+	}
+
+	obj, found := app.FindObject(desc.InstanceID)
+	if !found {
+		return GetDataResult{
+			IsDataAccessResult: true,
+			Value:              OBJECT_UNDEFINED,
+		}
+	}
+
+	if desc.AccessSelection != nil {
+		return app.getAttributeWithSelector(obj, desc)
+	}
+
+	val, err := obj.GetAttribute(byte(desc.AttributeID))
+	if err != nil {
+		// The original error from GetAttribute might be too generic.
+		// We can provide a more specific access control error if the association check failed.
+		switch err {
+		case ErrAttributeNotSupported:
+			return GetDataResult{
+				IsDataAccessResult: true,
+				Value:              OBJECT_UNAVAILABLE,
+			}
+		case ErrAccessDenied:
+			return GetDataResult{
+				IsDataAccessResult: true,
+				Value:              READ_WRITE_DENIED,
+			}
+		default:
+			return GetDataResult{
+				IsDataAccessResult: true,
+				Value:              OTHER_REASON,
+			}
+		}
+	}
+
+	return GetDataResult{
+		IsDataAccessResult: false,
+		Value:              val,
+	}
+}
+
+// getAttributeWithSelector serves a selective access read through the object's
+// SelectiveAccessor implementation.
+func (app *Application) getAttributeWithSelector(obj BaseInterface, desc CosemAttributeDescriptor) GetDataResult {
+	accessor, ok := obj.(SelectiveAccessor)
+	if !ok {
+		return GetDataResult{IsDataAccessResult: true, Value: OBJECT_UNAVAILABLE}
+	}
+
+	val, err := accessor.GetAttributeWithSelector(byte(desc.AttributeID), desc.AccessSelection.AccessSelector, desc.AccessSelection.AccessParameters)
+	if err != nil {
+		return GetDataResult{IsDataAccessResult: true, Value: selectiveAccessResult(err)}
+	}
+	return GetDataResult{IsDataAccessResult: false, Value: val}
+}
+
+// selectiveAccessResult maps an error from a selective read to a Data-Access-Result.
+func selectiveAccessResult(err error) DataAccessResultEnum {
+	switch {
+	case errors.Is(err, ErrAttributeNotSupported), errors.Is(err, ErrAccessSelectorNotSupported):
+		return OBJECT_UNAVAILABLE
+	case errors.Is(err, ErrAccessDenied):
+		return READ_WRITE_DENIED
+	case errors.Is(err, ErrInvalidParameter), errors.Is(err, ErrInvalidValueType):
+		return TYPE_UNMATCHED
+	default:
+		return OTHER_REASON
+	}
+}
+
+// handleGetRequestNext serves a Get-Request-Next for the long get in progress on assoc.
+func (app *Application) handleGetRequestNext(req *GetRequest, assoc *AssociationLN) *GetResponse {
+	state, ok := app.longGets[assoc]
+	switch {
+	case !ok:
+		return getBlockError(req.InvokeIDAndPriority, req.BlockNumber, NO_LONG_GET_IN_PROGRESS)
+	case req.InvokeIDAndPriority&invokeIDMask != state.invokeIDAndPriority&invokeIDMask:
+		delete(app.longGets, assoc)
+		return getBlockError(req.InvokeIDAndPriority, req.BlockNumber, LONG_GET_ABORTED)
+	case req.BlockNumber != state.blockNumber:
+		delete(app.longGets, assoc)
+		return getBlockError(req.InvokeIDAndPriority, req.BlockNumber, DATA_BLOCK_NUMBER_INVALID)
+	}
+	return app.nextGetBlock(state, assoc)
+}
+
+// nextGetBlock sends the next block of state and ends the long get after the last one.
+func (app *Application) nextGetBlock(state *blockSender, assoc *AssociationLN) *GetResponse {
+	block := state.nextBlock()
+	if block.LastBlock {
+		delete(app.longGets, assoc)
+	}
+	return &GetResponse{
+		Type:                GET_RESPONSE_WITH_DATABLOCK,
+		InvokeIDAndPriority: state.invokeIDAndPriority,
+		DataBlock: DataBlockG{
+			LastBlock:   block.LastBlock,
+			BlockNumber: block.BlockNumber,
+			RawData:     block.RawData,
+		},
+	}
+}
+
+// HandleSetRequest processes a Set-Request APDU and returns a Set-Response APDU.
+// Values sent in data blocks are collected per association and written once the
+// last block has arrived. Each attribute of a with-list request is written independently.
+func (app *Application) HandleSetRequest(req *SetRequest, assoc *AssociationLN) *SetResponse {
+	if req.Type == SET_REQUEST_WITH_DATABLOCK {
+		return app.handleSetRequestWithDatablock(req, assoc)
+	}
+
+	// A new request abandons any long set still in progress on this association.
+	delete(app.longSets, assoc)
+
+	switch req.Type {
+	case SET_REQUEST_WITH_FIRST_DATABLOCK:
+		state := &longSetState{
+			blockReceiver: blockReceiver{invokeIDAndPriority: req.InvokeIDAndPriority},
+			attributes:    []CosemAttributeDescriptor{req.AttributeDescriptor},
+		}
+		return app.acceptSetBlock(state, req, assoc)
+	case SET_REQUEST_WITH_LIST_AND_FIRST_DATABLOCK:
+		state := &longSetState{
+			blockReceiver: blockReceiver{invokeIDAndPriority: req.InvokeIDAndPriority, withList: true},
+			attributes:    req.AttributeList,
+		}
+		return app.acceptSetBlock(state, req, assoc)
+	case SET_REQUEST_WITH_LIST:
+		resp := &SetResponse{
+			Type:                SET_RESPONSE_WITH_LIST,
+			InvokeIDAndPriority: req.InvokeIDAndPriority,
+			ResultList:          make([]DataAccessResultEnum, len(req.AttributeList)),
+		}
+		for i, desc := range req.AttributeList {
+			if i >= len(req.ValueList) {
+				resp.ResultList[i] = TYPE_UNMATCHED
+				continue
+			}
+			resp.ResultList[i] = app.setAttribute(desc, req.ValueList[i], assoc)
+		}
+		return resp
+	default:
+		return &SetResponse{
+			Type:                SET_RESPONSE_NORMAL,
+			InvokeIDAndPriority: req.InvokeIDAndPriority,
+			Result:              app.setAttribute(req.AttributeDescriptor, req.Value, assoc),
+		}
+	}
+}
+
+// setAttribute writes a single attribute on behalf of assoc and returns the result code.
+func (app *Application) setAttribute(desc CosemAttributeDescriptor, value interface{}, assoc *AssociationLN) DataAccessResultEnum {
+	if !assoc.CheckAttributeAccess(desc.InstanceID, byte(desc.AttributeID), Write) {
+		return READ_WRITE_DENIED
+	}
+
+	obj, found := app.FindObject(desc.InstanceID)
+	if !found {
+		return OBJECT_UNDEFINED
+	}
+	if desc.AccessSelection != nil {
+		// Selective access is defined for reads only.
+		return OBJECT_UNAVAILABLE
+	}
+
+	err := obj.SetAttribute(byte(desc.AttributeID), value)
+	if err != nil {
+		switch err {
+		case ErrAttributeNotSupported:
+			return OBJECT_UNAVAILABLE
+		case ErrAccessDenied:
+			return READ_WRITE_DENIED
+		case ErrInvalidValueType:
+			return TYPE_UNMATCHED
+		default:
+			return OTHER_REASON
+		}
+	}
+
+	return SUCCESS
+}
+
+// handleSetRequestWithDatablock continues the long set in progress on assoc.
+func (app *Application) handleSetRequestWithDatablock(req *SetRequest, assoc *AssociationLN) *SetResponse {
+	state, ok := app.longSets[assoc]
+	if !ok {
+		return setBlockError(req.InvokeIDAndPriority, req.DataBlock.BlockNumber, NO_LONG_SET_IN_PROGRESS)
+	}
+	if req.InvokeIDAndPriority&invokeIDMask != state.invokeIDAndPriority&invokeIDMask {
+		delete(app.longSets, assoc)
+		return setBlockError(req.InvokeIDAndPriority, req.DataBlock.BlockNumber, LONG_SET_ABORTED)
+	}
+	return app.acceptSetBlock(state, req, assoc)
+}
+
+// acceptSetBlock appends the data block of req to state. It acknowledges intermediate
+// blocks and writes the reassembled value(s) once the last block has arrived.
+func (app *Application) acceptSetBlock(state *longSetState, req *SetRequest, assoc *AssociationLN) *SetResponse {
+	block := req.DataBlock
+	if err := state.accept(block); err != nil {
+		delete(app.longSets, assoc)
+		return setBlockError(req.InvokeIDAndPriority, block.BlockNumber, DATA_BLOCK_NUMBER_INVALID)
+	}
+
+	if !block.LastBlock {
+		app.longSets[assoc] = state
+		return &SetResponse{
+			Type:                SET_RESPONSE_DATABLOCK,
+			InvokeIDAndPriority: req.InvokeIDAndPriority,
+			BlockNumber:         block.BlockNumber,
+		}
+	}
+	delete(app.longSets, assoc)
+
+	values, err := state.values(len(state.attributes))
+	if err != nil {
+		return setBlockError(req.InvokeIDAndPriority, block.BlockNumber, LONG_SET_ABORTED)
+	}
+
+	if !state.withList {
+		return &SetResponse{
+			Type:                SET_RESPONSE_LAST_DATABLOCK,
+			InvokeIDAndPriority: req.InvokeIDAndPriority,
+			Result:              app.setAttribute(state.attributes[0], values[0], assoc),
+			BlockNumber:         block.BlockNumber,
+		}
+	}
+
+	resp := &SetResponse{
+		Type:                SET_RESPONSE_LAST_DATABLOCK_WITH_LIST,
+		InvokeIDAndPriority: req.InvokeIDAndPriority,
+		ResultList:          make([]DataAccessResultEnum, len(state.attributes)),
+		BlockNumber:         block.BlockNumber,
+	}
+	for i, desc := range state.attributes {
+		resp.ResultList[i] = app.setAttribute(desc, values[i], assoc)
+	}
+	return resp
+}
+
+// HandleActionRequest processes an Action-Request APDU and returns an Action-Response APDU.
+// Parameters sent in pblocks are collected per association and the method is invoked once
+// the last block has arrived. Responses too large for a single APDU are returned with
+// Action-Response-With-Pblock; the client fetches the remaining blocks with
+// Action-Request-Next-Pblock.
+func (app *Application) HandleActionRequest(req *ActionRequest, assoc *AssociationLN) *ActionResponse {
+	switch req.Type {
+	case ACTION_REQUEST_WITH_PBLOCK:
+		return app.handleActionRequestWithPblock(req, assoc)
+	case ACTION_REQUEST_NEXT_PBLOCK:
+		return app.handleActionRequestNextPblock(req, assoc)
+	}
+
+	// A new request abandons any long action still in progress on this association.
+	delete(app.longActions, assoc)
+	delete(app.longActionResponses, assoc)
+
+	switch req.Type {
+	case ACTION_REQUEST_WITH_FIRST_PBLOCK:
+		state := &longActionState{
+			blockReceiver: blockReceiver{invokeIDAndPriority: req.InvokeIDAndPriority},
+			methods:       []CosemMethodDescriptor{req.MethodDescriptor},
+		}
+		return app.acceptActionBlock(state, req, assoc)
+	case ACTION_REQUEST_WITH_LIST_AND_FIRST_PBLOCK:
+		state := &longActionState{
+			blockReceiver: blockReceiver{invokeIDAndPriority: req.InvokeIDAndPriority, withList: true},
+			methods:       req.MethodList,
+		}
+		return app.acceptActionBlock(state, req, assoc)
+	case ACTION_REQUEST_WITH_LIST:
+		results := make([]ActionResult, len(req.MethodList))
+		for i, desc := range req.MethodList {
+			if i >= len(req.ParameterList) {
+				results[i] = ActionResult{IsDataAccessResult: true, Value: TYPE_UNMATCHED}
+				continue
+			}
+			results[i] = app.invokeMethod(desc, req.ParameterList[i], assoc)
+		}
+		return app.newActionResponse(req.InvokeIDAndPriority, results, true, assoc)
+	default:
+		result := app.invokeMethod(req.MethodDescriptor, req.Parameters, assoc)
+		return app.newActionResponse(req.InvokeIDAndPriority, []ActionResult{result}, false, assoc)
+	}
+}
+
+// methodParameters maps method-invocation-parameters onto the parameter list of Invoke.
+// Absent parameters give an empty list and an array is taken as the list itself; any
+// other value is passed as the single parameter.
+func methodParameters(parameters interface{}) []interface{} {
+	switch p := parameters.(type) {
+	case nil:
+		return axdr.Array{}
+	case axdr.Array:
+		return p
+	default:
+		return []interface{}{p}
+	}
+}
+
+// invokeMethod invokes a single method on behalf of assoc and returns its result.
+// A client can always authenticate through reply_to_HLS_authentication of its own
+// association, listed in its object list or not.
+func (app *Application) invokeMethod(desc CosemMethodDescriptor, parameters interface{}, assoc *AssociationLN) ActionResult {
+	obj, replyToHLS := app.replyToHLSObject(desc, assoc)
+	if !replyToHLS {
+		if !assoc.CheckMethodAccess(desc.InstanceID, byte(desc.MethodID)) {
+			return ActionResult{
+				IsDataAccessResult: true,
+				Value:              READ_WRITE_DENIED,
+			}
+		}
+
+		var found bool
+		obj, found = app.FindObject(desc.InstanceID)
+		if !found {
+			return ActionResult{
+				IsDataAccessResult: true,
+				Value:              OBJECT_UNDEFINED,
+			}
+		}
+	}
+
+	val, err := app.invoke(obj, byte(desc.MethodID), methodParameters(parameters), assoc)
+	if err != nil {
+		switch err {
+		case ErrMethodNotSupported:
+			return ActionResult{
+				IsDataAccessResult: true,
+				Value:              OBJECT_UNAVAILABLE,
+			}
+		case ErrAccessDenied:
+			return ActionResult{
 				IsDataAccessResult: true,
 				Value:              READ_WRITE_DENIED,
 			}
 		case ErrInvalidParameter:
-			resp.Result = ActionResult{
+			return ActionResult{
 				IsDataAccessResult: true,
 				Value:              TYPE_UNMATCHED,
 			}
 		default:
-			resp.Result = ActionResult{
+			return ActionResult{
 				IsDataAccessResult: true,
 				Value:              OTHER_REASON,
 			}
 		}
-		return resp
 	}
 
-	resp.Result = ActionResult{
+	return ActionResult{
 		IsDataAccessResult: false,
 		Value:              val,
 	}
-	return resp
+}
+
+// invoke invokes the method methodID of obj for the client of assoc. A Security
+// setup is told the system title of the client, under which key_agreement
+// derives the keys. When a method replaces a global key of the security setup
+// of the application, the frame counters of every client restart, as clients
+// count their invocations afresh under a new key.
+func (app *Application) invoke(obj BaseInterface, methodID byte, parameters []interface{}, assoc *AssociationLN) (interface{}, error) {
+	if setup, ok := obj.(*SecuritySetup); ok {
+		title, err := app.clientSystemTitle(assoc)
+		if err != nil {
+			return nil, err
+		}
+		setup.invokingSystemTitle = title
+		guek, gak := setup.GlobalUnicastKey, setup.GlobalAuthenticationKey
+		defer func() {
+			setup.invokingSystemTitle = nil
+			if setup == app.securitySetup && (!bytes.Equal(guek, setup.GlobalUnicastKey) || !bytes.Equal(gak, setup.GlobalAuthenticationKey)) {
+				clear(app.lastFrameCounters)
+			}
+		}()
+	}
+	return obj.Invoke(methodID, parameters)
+}
+
+// newActionResponse builds an Action-Response-Normal or Action-Response-With-List for
+// results and switches to Action-Response-With-Pblock when it does not fit into one APDU.
+func (app *Application) newActionResponse(invokeIDAndPriority uint8, results []ActionResult, withList bool, assoc *AssociationLN) *ActionResponse {
+	resp := &ActionResponse{InvokeIDAndPriority: invokeIDAndPriority}
+	var encoded bytes.Buffer
+	var err error
+	if withList {
+		resp.Type = ACTION_RESPONSE_WITH_LIST
+		resp.ResultList = results
+		err = encodeActionResultList(&encoded, results)
+	} else {
+		resp.Type = ACTION_RESPONSE_NORMAL
+		resp.Result = results[0]
+		err = encodeActionResult(&encoded, results[0])
+	}
+	if err != nil {
+		return actionBlockError(invokeIDAndPriority, OTHER_REASON)
+	}
+
+	maxPDU := app.maxSendPDUSize(assoc)
+	if encoded.Len()+actionResponseHeaderSize+apduCipheringOverhead <= maxPDU {
+		return resp
+	}
+
+	state := newBlockSender(invokeIDAndPriority, encoded.Bytes(), maxPDU, actionResponseBlockHeaderSize)
+	app.longActionResponses[assoc] = state
+	return app.nextActionBlock(state, assoc)
+}
+
+// handleActionRequestWithPblock continues the long action request in progress on assoc.
+func (app *Application) handleActionRequestWithPblock(req *ActionRequest, assoc *AssociationLN) *ActionResponse {
+	state, ok := app.longActions[assoc]
+	if !ok {
+		return actionBlockError(req.InvokeIDAndPriority, NO_LONG_ACTION_IN_PROGRESS)
+	}
+	if req.InvokeIDAndPriority&invokeIDMask != state.invokeIDAndPriority&invokeIDMask {
+		delete(app.longActions, assoc)
+		return actionBlockError(req.InvokeIDAndPriority, LONG_ACTION_ABORTED)
+	}
+	return app.acceptActionBlock(state, req, assoc)
+}
+
+// acceptActionBlock appends the pblock of req to state. It acknowledges intermediate
+// blocks and invokes the method(s) once the last block has arrived.
+func (app *Application) acceptActionBlock(state *longActionState, req *ActionRequest, assoc *AssociationLN) *ActionResponse {
+	block := req.DataBlock
+	if err := state.accept(block); err != nil {
+		delete(app.longActions, assoc)
+		return actionBlockError(req.InvokeIDAndPriority, LONG_ACTION_ABORTED)
+	}
+
+	if !block.LastBlock {
+		app.longActions[assoc] = state
+		return &ActionResponse{
+			Type:                ACTION_RESPONSE_NEXT_PBLOCK,
+			InvokeIDAndPriority: req.InvokeIDAndPriority,
+			BlockNumber:         block.BlockNumber,
+		}
+	}
+	delete(app.longActions, assoc)
+
+	parameters, err := state.values(len(state.methods))
+	if err != nil {
+		return actionBlockError(req.InvokeIDAndPriority, LONG_ACTION_ABORTED)
+	}
+
+	results := make([]ActionResult, len(state.methods))
+	for i, desc := range state.methods {
+		results[i] = app.invokeMethod(desc, parameters[i], assoc)
+	}
+	return app.newActionResponse(req.InvokeIDAndPriority, results, state.withList, assoc)
+}
+
+// handleActionRequestNextPblock serves the next block of the long action response on assoc.
+func (app *Application) handleActionRequestNextPblock(req *ActionRequest, assoc *AssociationLN) *ActionResponse {
+	state, ok := app.longActionResponses[assoc]
+	if !ok {
+		return actionBlockError(req.InvokeIDAndPriority, NO_LONG_ACTION_IN_PROGRESS)
+	}
+	if req.InvokeIDAndPriority&invokeIDMask != state.invokeIDAndPriority&invokeIDMask || req.BlockNumber != state.blockNumber {
+		delete(app.longActionResponses, assoc)
+		return actionBlockError(req.InvokeIDAndPriority, LONG_ACTION_ABORTED)
+	}
+	return app.nextActionBlock(state, assoc)
+}
+
+// nextActionBlock sends the next block of state and ends the long action after the last one.
+func (app *Application) nextActionBlock(state *blockSender, assoc *AssociationLN) *ActionResponse {
+	block := state.nextBlock()
+	if block.LastBlock {
+		delete(app.longActionResponses, assoc)
+	}
+	return &ActionResponse{
+		Type:                ACTION_RESPONSE_WITH_PBLOCK,
+		InvokeIDAndPriority: state.invokeIDAndPriority,
+		DataBlock:           block,
+	}
+}
+
+// HandleReadRequest processes a ReadRequest APDU and returns a ReadResponse APDU.
+// Each variable is resolved through the short names of the registered objects and
+// read like the attribute of a Get-Request on behalf of assoc. A parameterized
+// access reads the attribute selectively; on a method it invokes the method with
+// the parameter, as does a plain variable name. Responses too large for a single
+// APDU are sent in data blocks; the client fetches the next one with a
+// block-number-access carrying the number of the last block received.
+func (app *Application) HandleReadRequest(req *ReadRequest, assoc *AssociationLN) (*ReadResponse, error) {
+	if len(req.Variables) == 1 && req.Variables[0].Type == VARIABLE_ACCESS_BLOCK_NUMBER {
+		return app.handleReadRequestNext(req.Variables[0].BlockNumber, assoc), nil
+	}
+
+	// A new request abandons any long read still in progress on this association.
+	delete(app.longReads, assoc)
+
+	resp := &ReadResponse{Results: make([]ReadResult, len(req.Variables))}
+	for i, variable := range req.Variables {
+		result, err := app.readVariable(variable, assoc)
+		if err != nil {
+			return nil, err
+		}
+		resp.Results[i] = result
+	}
+
+	var encoded bytes.Buffer
+	if err := encodeReadResultList(&encoded, resp.Results); err != nil {
+		return nil, err
+	}
+	maxPDU := app.maxSendPDUSize(assoc)
+	if readResponseHeaderSize+encoded.Len()+apduCipheringOverhead <= maxPDU {
+		return resp, nil
+	}
+
+	state := newBlockSender(0, encoded.Bytes(), maxPDU, readResponseBlockHeaderSize)
+	app.longReads[assoc] = state
+	return app.nextReadBlock(state, assoc), nil
+}
+
+// readVariable serves a single variable of a ReadRequest.
+func (app *Application) readVariable(variable VariableAccessSpecification, assoc *AssociationLN) (ReadResult, error) {
+	if variable.Type != VARIABLE_ACCESS_NAME && variable.Type != VARIABLE_ACCESS_PARAMETERIZED {
+		return ReadResult{}, common.NewError(common.ErrCosemServiceNotSupported, fmt.Sprintf("unsupported variable access in ReadRequest: %d", variable.Type))
+	}
+
+	ref, found := app.resolveShortName(variable.VariableName)
+	if !found {
+		return readAccessError(OBJECT_UNDEFINED), nil
+	}
+
+	if ref.methodID != 0 {
+		desc := CosemMethodDescriptor{ClassID: ref.obj.GetClassID(), InstanceID: ref.obj.GetInstanceID(), MethodID: int8(ref.methodID)}
+		result := app.invokeMethod(desc, variable.Parameter, assoc)
+		if result.IsDataAccessResult {
+			return readAccessError(result.Value.(DataAccessResultEnum)), nil
+		}
+		if _, err := axdr.Encode(result.Value); err != nil {
+			return readAccessError(OTHER_REASON), nil
+		}
+		return ReadResult{Type: READ_RESULT_DATA, Value: result.Value}, nil
+	}
+
+	desc := CosemAttributeDescriptor{ClassID: ref.obj.GetClassID(), InstanceID: ref.obj.GetInstanceID(), AttributeID: int8(ref.attributeID)}
+	if variable.Type == VARIABLE_ACCESS_PARAMETERIZED {
+		desc.AccessSelection = &SelectiveAccessDescriptor{AccessSelector: variable.Selector, AccessParameters: variable.Parameter}
+	}
+	result := encodableGetDataResult(app.getAttribute(desc, assoc))
+	if result.IsDataAccessResult {
+		return readAccessError(result.Value.(DataAccessResultEnum)), nil
+	}
+	return ReadResult{Type: READ_RESULT_DATA, Value: result.Value}, nil
+}
+
+func readAccessError(result DataAccessResultEnum) ReadResult {
+	return ReadResult{Type: READ_RESULT_DATA_ACCESS_ERROR, Value: result}
+}
+
+// handleReadRequestNext serves the block following blockNumber of the long read in
+// progress on assoc.
+func (app *Application) handleReadRequestNext(blockNumber uint16, assoc *AssociationLN) *ReadResponse {
+	state, ok := app.longReads[assoc]
+	switch {
+	case !ok:
+		return &ReadResponse{Results: []ReadResult{readAccessError(NO_LONG_GET_IN_PROGRESS)}}
+	case uint32(blockNumber) != state.blockNumber:
+		delete(app.longReads, assoc)
+		return &ReadResponse{Results: []ReadResult{readAccessError(DATA_BLOCK_NUMBER_INVALID)}}
+	}
+	return app.nextReadBlock(state, assoc)
+}
+
+// nextReadBlock sends the next block of state and ends the long read after the last one.
+func (app *Application) nextReadBlock(state *blockSender, assoc *AssociationLN) *ReadResponse {
+	block := state.nextBlock()
+	if block.LastBlock {
+		delete(app.longReads, assoc)
+	}
+	return &ReadResponse{Results: []ReadResult{{
+		Type: READ_RESULT_DATA_BLOCK,
+		DataBlock: DataBlockResult{
+			LastBlock:   block.LastBlock,
+			BlockNumber: uint16(block.BlockNumber),
+			RawData:     block.RawData,
+		},
+	}}}
+}
+
+// HandleWriteRequest processes a WriteRequest APDU and returns a WriteResponse APDU.
+// Each variable is resolved through the short names of the registered objects and
+// written like the attribute of a Set-Request on behalf of assoc; a variable naming
+// a method invokes it with the value as its parameter. Writing in blocks is not
+// supported.
+func (app *Application) HandleWriteRequest(req *WriteRequest, assoc *AssociationLN) (*WriteResponse, error) {
+	results, err := app.writeVariables(req.Variables, req.Values, assoc)
+	if err != nil {
+		return nil, err
+	}
+	return &WriteResponse{Results: results}, nil
+}
+
+// HandleUnconfirmedWriteRequest serves an UnconfirmedWriteRequest APDU like a
+// WriteRequest. The results are not reported to the client.
+func (app *Application) HandleUnconfirmedWriteRequest(req *UnconfirmedWriteRequest, assoc *AssociationLN) error {
+	_, err := app.writeVariables(req.Variables, req.Values, assoc)
+	return err
+}
+
+// writeVariables writes values to variables and returns the result of each write.
+func (app *Application) writeVariables(variables []VariableAccessSpecification, values []interface{}, assoc *AssociationLN) ([]WriteResult, error) {
+	results := make([]WriteResult, len(variables))
+	for i, variable := range variables {
+		if variable.Type != VARIABLE_ACCESS_NAME && variable.Type != VARIABLE_ACCESS_PARAMETERIZED {
+			return nil, common.NewError(common.ErrCosemServiceNotSupported, fmt.Sprintf("unsupported variable access in WriteRequest: %d", variable.Type))
+		}
+		if i >= len(values) {
+			results[i] = WriteResult{Result: TYPE_UNMATCHED}
+			continue
+		}
+
+		ref, found := app.resolveShortName(variable.VariableName)
+		if !found {
+			results[i] = WriteResult{Result: OBJECT_UNDEFINED}
+			continue
+		}
+
+		if ref.methodID != 0 {
+			desc := CosemMethodDescriptor{ClassID: ref.obj.GetClassID(), InstanceID: ref.obj.GetInstanceID(), MethodID: int8(ref.methodID)}
+			result := app.invokeMethod(desc, values[i], assoc)
+			if result.IsDataAccessResult {
+				results[i] = WriteResult{Result: result.Value.(DataAccessResultEnum)}
+			} else {
+				results[i] = WriteResult{Result: SUCCESS}
+			}
+			continue
+		}
+
+		desc := CosemAttributeDescriptor{ClassID: ref.obj.GetClassID(), InstanceID: ref.obj.GetInstanceID(), AttributeID: int8(ref.attributeID)}
+		if variable.Type == VARIABLE_ACCESS_PARAMETERIZED {
+			desc.AccessSelection = &SelectiveAccessDescriptor{AccessSelector: variable.Selector, AccessParameters: variable.Parameter}
+		}
+		results[i] = WriteResult{Result: app.setAttribute(desc, values[i], assoc)}
+	}
+	return results, nil
+}
+
+// HandleAccessRequest processes an Access-Request APDU and returns an
+// Access-Response APDU. The requests are served in order, each like the attribute
+// or method of a Get-, Set- or Action-Request on behalf of assoc, so one failing
+// request does not affect the others. When the request asks to break on error,
+// processing stops at the first failed request and only the requests served so
+// far are answered. A response too large for a single APDU is left to the general
+// block transfer.
+func (app *Application) HandleAccessRequest(req *AccessRequest, assoc *AssociationLN) (*AccessResponse, error) {
+	if len(req.DataList) != len(req.Specifications) {
+		return nil, common.NewError(common.ErrCosemAPDUUngarsable, fmt.Sprintf("Access-Request carries %d data for %d requests", len(req.DataList), len(req.Specifications)))
+	}
+
+	resp := &AccessResponse{
+		LongInvokeIDAndPriority: req.LongInvokeIDAndPriority,
+		DateTime:                []byte{},
+		DataList:                make([]interface{}, 0, len(req.Specifications)),
+		Specifications:          make([]AccessResponseSpecification, 0, len(req.Specifications)),
+	}
+	for i, spec := range req.Specifications {
+		data, result, err := app.accessRequest(spec, req.DataList[i], assoc)
+		if err != nil {
+			return nil, err
+		}
+		resp.DataList = append(resp.DataList, data)
+		resp.Specifications = append(resp.Specifications, result)
+		if result.Result != SUCCESS && req.LongInvokeIDAndPriority&LongInvokeIDBreakOnError != 0 {
+			break
+		}
+	}
+	if req.LongInvokeIDAndPriority&LongInvokeIDSelfDescriptive != 0 {
+		resp.RequestSpecifications = req.Specifications[:len(resp.Specifications)]
+	}
+	return resp, nil
+}
+
+// accessRequest serves a single request of an Access-Request and returns the data
+// and the result to answer it with.
+func (app *Application) accessRequest(spec AccessRequestSpecification, data interface{}, assoc *AssociationLN) (interface{}, AccessResponseSpecification, error) {
+	switch spec.Type {
+	case ACCESS_REQUEST_GET, ACCESS_REQUEST_GET_WITH_SELECTION:
+		desc := spec.AttributeDescriptor
+		if spec.Type == ACCESS_REQUEST_GET {
+			desc.AccessSelection = nil
+		}
+		result := encodableGetDataResult(app.getAttribute(desc, assoc))
+		if result.IsDataAccessResult {
+			return nil, AccessResponseSpecification{Type: ACCESS_RESPONSE_GET, Result: result.Value.(DataAccessResultEnum)}, nil
+		}
+		return result.Value, AccessResponseSpecification{Type: ACCESS_RESPONSE_GET, Result: SUCCESS}, nil
+	case ACCESS_REQUEST_SET, ACCESS_REQUEST_SET_WITH_SELECTION:
+		desc := spec.AttributeDescriptor
+		if spec.Type == ACCESS_REQUEST_SET {
+			desc.AccessSelection = nil
+		}
+		return nil, AccessResponseSpecification{Type: ACCESS_RESPONSE_SET, Result: app.setAttribute(desc, data, assoc)}, nil
+	case ACCESS_REQUEST_ACTION:
+		result := app.invokeMethod(spec.MethodDescriptor, data, assoc)
+		if result.IsDataAccessResult {
+			return nil, AccessResponseSpecification{Type: ACCESS_RESPONSE_ACTION, Result: result.Value.(DataAccessResultEnum)}, nil
+		}
+		if _, err := axdr.Encode(result.Value); err != nil {
+			return nil, AccessResponseSpecification{Type: ACCESS_RESPONSE_ACTION, Result: OTHER_REASON}, nil
+		}
+		return result.Value, AccessResponseSpecification{Type: ACCESS_RESPONSE_ACTION, Result: SUCCESS}, nil
+	default:
+		return nil, AccessResponseSpecification{}, common.NewError(common.ErrCosemServiceNotSupported, fmt.Sprintf("unsupported Access-Request-Specification: %d", spec.Type))
+	}
 }
@@ -1,9 +1,15 @@
 package cosem
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"net"
+	"reflect"
 	"testing"
 
+	"github.com/gvtret/spodes-go/pkg/axdr"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -30,6 +36,7 @@ func setupTestApp(t *testing.T) (*Application, *AssociationLN, net.Addr, *Data)
 
 	clientAddr := mockAddr("client1")
 	app.AddAssociation(clientAddr.String(), associationLN)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
 
 	obis, err := NewObisCodeFromString("1.0.0.3.0.255")
 	require.NoError(t, err)
@@ -55,10 +62,11 @@ func TestApplication_SecuredAPDUMaintainsIndependentCounters(t *testing.T) {
 	obisSecurity, err := NewObisCodeFromString("0.0.43.0.0.255")
 	require.NoError(t, err)
 
+	clientSystemTitle := []byte("CLIENT01")
 	serverSystemTitle := []byte("SERVER01")
 	guek := []byte("0123456789ABCDEF")
 
-	securitySetup, err := NewSecuritySetup(*obisSecurity, nil, serverSystemTitle, nil, guek, nil)
+	securitySetup, err := NewSecuritySetup(*obisSecurity, clientSystemTitle, serverSystemTitle, nil, guek, nil)
 	require.NoError(t, err)
 	err = securitySetup.SetAttribute(2, SecurityPolicy(PolicyAuthenticatedRequest|PolicyEncryptedRequest))
 	require.NoError(t, err)
@@ -71,7 +79,9 @@ func TestApplication_SecuredAPDUMaintainsIndependentCounters(t *testing.T) {
 	clientAddr2 := mockAddr("secured-client2")
 
 	app.AddAssociation(clientAddr1.String(), assocClient1)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr1.String()))
 	app.AddAssociation(clientAddr2.String(), assocClient2)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr2.String()))
 
 	obis, err := NewObisCodeFromString("1.0.0.3.0.255")
 	require.NoError(t, err)
@@ -102,11 +112,7 @@ func TestApplication_SecuredAPDUMaintainsIndependentCounters(t *testing.T) {
 			SecurityControl: SecurityControlAuthenticatedAndEncrypted,
 			FrameCounter:    frameCounter,
 		}
-		ciphertext, err := EncryptAndTag(guek, encodedReq, serverSystemTitle, header, SecuritySuite0)
-		require.NoError(t, err)
-		encodedHeader, err := header.Encode()
-		require.NoError(t, err)
-		return append([]byte{byte(APDU_GLO_GET_REQUEST)}, append(encodedHeader, ciphertext...)...)
+		return cipherAPDU(t, APDU_GLO_GET_REQUEST, guek, encodedReq, clientSystemTitle, header, SecuritySuite0)
 	}
 
 	// First request from client 1 should succeed with frame counter 1
@@ -121,9 +127,12 @@ func TestApplication_SecuredAPDUMaintainsIndependentCounters(t *testing.T) {
 
 	// Replaying the same frame counter for client 1 should now be rejected
 	securedReq = buildSecured(1)
-	_, err = app.HandleAPDU(securedReq, clientAddr1)
-	require.Error(t, err)
-	require.ErrorIs(t, err, ErrReplayAttack)
+	encodedResp, err := app.HandleAPDU(securedReq, clientAddr1)
+	require.NoError(t, err)
+	exception := &ExceptionResponse{}
+	require.NoError(t, exception.Decode(encodedResp))
+	assert.Equal(t, SERVICE_ERROR_INVOCATION_COUNTER_ERROR, exception.ServiceError)
+	assert.Equal(t, uint32(2), exception.InvocationCounter)
 }
 
 func TestApplication_SecuredResponsesUseIncrementingCounters(t *testing.T) {
@@ -137,10 +146,11 @@ func TestApplication_SecuredResponsesUseIncrementingCounters(t *testing.T) {
 	obisSecurity, err := NewObisCodeFromString("0.0.43.0.0.255")
 	require.NoError(t, err)
 
+	clientSystemTitle := []byte("CLIENT01")
 	serverSystemTitle := []byte("SERVER01")
 	guek := []byte("0123456789ABCDEF")
 
-	securitySetup, err := NewSecuritySetup(*obisSecurity, nil, serverSystemTitle, nil, guek, nil)
+	securitySetup, err := NewSecuritySetup(*obisSecurity, clientSystemTitle, serverSystemTitle, nil, guek, nil)
 	require.NoError(t, err)
 	err = securitySetup.SetAttribute(2, SecurityPolicy(PolicyAuthenticatedRequest|PolicyEncryptedRequest))
 	require.NoError(t, err)
@@ -150,6 +160,7 @@ func TestApplication_SecuredResponsesUseIncrementingCounters(t *testing.T) {
 	app := NewApplication(nil, securitySetup)
 	clientAddr := mockAddr("secured-client")
 	app.AddAssociation(clientAddr.String(), assoc)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
 
 	obis, err := NewObisCodeFromString("1.0.0.3.0.255")
 	require.NoError(t, err)
@@ -178,11 +189,7 @@ func TestApplication_SecuredResponsesUseIncrementingCounters(t *testing.T) {
 			SecurityControl: SecurityControlAuthenticatedAndEncrypted,
 			FrameCounter:    frameCounter,
 		}
-		ciphertext, err := EncryptAndTag(guek, encodedReq, serverSystemTitle, header, SecuritySuite0)
-		require.NoError(t, err)
-		encodedHeader, err := header.Encode()
-		require.NoError(t, err)
-		return append([]byte{byte(APDU_GLO_GET_REQUEST)}, append(encodedHeader, ciphertext...)...)
+		return cipherAPDU(t, APDU_GLO_GET_REQUEST, guek, encodedReq, clientSystemTitle, header, SecuritySuite0)
 	}
 
 	expectedCounter := assoc.ServerInvocationCounter()
@@ -196,16 +203,11 @@ func TestApplication_SecuredResponsesUseIncrementingCounters(t *testing.T) {
 
 		require.Equal(t, byte(APDU_GLO_GET_RESPONSE), encodedResp[0])
 
-		respHeader := &SecurityHeader{}
-		err = respHeader.Decode(encodedResp[1:])
-		require.NoError(t, err)
+		respHeader, plaintext := decipherAPDU(t, encodedResp, guek, serverSystemTitle, SecuritySuite0, lastServerCounter)
 
 		expectedCounter++
 		require.Equal(t, expectedCounter, respHeader.FrameCounter)
 
-		plaintext, err := DecryptAndVerify(guek, encodedResp[6:], serverSystemTitle, respHeader, SecuritySuite0, lastServerCounter)
-		require.NoError(t, err)
-
 		lastServerCounter = respHeader.FrameCounter
 
 		resp := &GetResponse{}
@@ -297,13 +299,173 @@ func TestApplication_HandleGetRequest(t *testing.T) {
 	})
 }
 
+func TestApplication_GetRequestWithList(t *testing.T) {
+	app, assoc, clientAddr, dataObj := setupTestApp(t)
+
+	deniedObis := obisOf(t, "1.1.1.1.1.1")
+	deniedObj, err := NewData(deniedObis, uint32(999))
+	require.NoError(t, err)
+	app.RegisterObject(deniedObj)
+
+	req := &GetRequest{
+		Type:                GET_REQUEST_WITH_LIST,
+		InvokeIDAndPriority: 0x81,
+		AttributeList: []CosemAttributeDescriptor{
+			{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+			{ClassID: DataClassID, InstanceID: deniedObis, AttributeID: 2},
+			{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 9},
+		},
+	}
+
+	encodedReq, err := req.Encode()
+	require.NoError(t, err)
+	encodedResp, err := app.HandleAPDU(encodedReq, clientAddr)
+	require.NoError(t, err)
+
+	resp := &GetResponse{}
+	require.NoError(t, resp.Decode(encodedResp))
+	assert.Equal(t, GET_RESPONSE_WITH_LIST, resp.Type)
+	require.Len(t, resp.ResultList, 3)
+
+	assert.False(t, resp.ResultList[0].IsDataAccessResult)
+	assert.Equal(t, uint32(12345), resp.ResultList[0].Value)
+	assert.True(t, resp.ResultList[1].IsDataAccessResult)
+	assert.Equal(t, READ_WRITE_DENIED, resp.ResultList[1].Value)
+	assert.True(t, resp.ResultList[2].IsDataAccessResult)
+
+	t.Run("Long List Uses Blocks", func(t *testing.T) {
+		app.SetMaxPDUSize(48)
+		defer app.SetMaxPDUSize(DefaultMaxPDUSize)
+
+		list := make([]CosemAttributeDescriptor, 10)
+		for i := range list {
+			list[i] = CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2}
+		}
+
+		reassembler := &GetBlockReassembler{}
+		next := &GetRequest{Type: GET_REQUEST_WITH_LIST, InvokeIDAndPriority: 0x81, AttributeList: list}
+		for next != nil {
+			resp := app.HandleGetRequest(next, assoc)
+			assert.Equal(t, GET_RESPONSE_WITH_DATABLOCK, resp.Type)
+			next, err = reassembler.Push(resp)
+			require.NoError(t, err)
+		}
+
+		results, err := reassembler.ResultList()
+		require.NoError(t, err)
+		require.Len(t, results, 10)
+		for _, result := range results {
+			assert.Equal(t, uint32(12345), result.Value)
+		}
+	})
+}
+
+func TestApplication_LongGet(t *testing.T) {
+	app, assoc, clientAddr, _ := setupTestApp(t)
+	app.SetMaxPDUSize(64)
+
+	payload := make([]byte, 300)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	obis, err := NewObisCodeFromString("0.0.96.1.0.255")
+	require.NoError(t, err)
+	bigObj, err := NewData(*obis, payload)
+	require.NoError(t, err)
+	app.RegisterObject(bigObj)
+	assoc.AddObject(bigObj)
+
+	getBig := &GetRequest{
+		Type:                GET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0x81,
+		AttributeDescriptor: CosemAttributeDescriptor{
+			ClassID:     DataClassID,
+			InstanceID:  *obis,
+			AttributeID: 2,
+		},
+	}
+
+	exchange := func(t *testing.T, req *GetRequest) *GetResponse {
+		t.Helper()
+		encodedReq, err := req.Encode()
+		require.NoError(t, err)
+		encodedResp, err := app.HandleAPDU(encodedReq, clientAddr)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(encodedResp), 64)
+		resp := &GetResponse{}
+		require.NoError(t, resp.Decode(encodedResp))
+		return resp
+	}
+
+	t.Run("Reassembles All Blocks", func(t *testing.T) {
+		reassembler := &GetBlockReassembler{}
+		req := getBig
+		blocks := 0
+		for req != nil {
+			resp := exchange(t, req)
+			assert.Equal(t, GET_RESPONSE_WITH_DATABLOCK, resp.Type)
+			blocks++
+			req, err = reassembler.Push(resp)
+			require.NoError(t, err)
+		}
+		assert.Greater(t, blocks, 1)
+
+		result, err := reassembler.Result()
+		require.NoError(t, err)
+		assert.False(t, result.IsDataAccessResult)
+		assert.Equal(t, payload, result.Value)
+	})
+
+	t.Run("No Long Get In Progress", func(t *testing.T) {
+		resp := exchange(t, &GetRequest{Type: GET_REQUEST_NEXT, InvokeIDAndPriority: 0x81, BlockNumber: 1})
+		assert.True(t, resp.DataBlock.IsDataAccessResult)
+		assert.Equal(t, NO_LONG_GET_IN_PROGRESS, resp.DataBlock.DataAccessResult)
+	})
+
+	t.Run("Invalid Block Number Aborts", func(t *testing.T) {
+		resp := exchange(t, getBig)
+		assert.Equal(t, uint32(1), resp.DataBlock.BlockNumber)
+
+		resp = exchange(t, &GetRequest{Type: GET_REQUEST_NEXT, InvokeIDAndPriority: 0x81, BlockNumber: 5})
+		assert.True(t, resp.DataBlock.IsDataAccessResult)
+		assert.Equal(t, DATA_BLOCK_NUMBER_INVALID, resp.DataBlock.DataAccessResult)
+
+		resp = exchange(t, &GetRequest{Type: GET_REQUEST_NEXT, InvokeIDAndPriority: 0x81, BlockNumber: 1})
+		assert.Equal(t, NO_LONG_GET_IN_PROGRESS, resp.DataBlock.DataAccessResult)
+	})
+
+	t.Run("Different Invoke ID Aborts", func(t *testing.T) {
+		exchange(t, getBig)
+		resp := exchange(t, &GetRequest{Type: GET_REQUEST_NEXT, InvokeIDAndPriority: 0x82, BlockNumber: 1})
+		assert.True(t, resp.DataBlock.IsDataAccessResult)
+		assert.Equal(t, LONG_GET_ABORTED, resp.DataBlock.DataAccessResult)
+	})
+
+	t.Run("Small Values Stay Normal", func(t *testing.T) {
+		resp := exchange(t, &GetRequest{
+			Type:                GET_REQUEST_NORMAL,
+			InvokeIDAndPriority: 0x81,
+			AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: obisOf(t, "1.0.0.3.0.255"), AttributeID: 2},
+		})
+		assert.Equal(t, GET_RESPONSE_NORMAL, resp.Type)
+		assert.Equal(t, uint32(12345), resp.Result.Value)
+	})
+}
+
+func obisOf(t *testing.T, s string) ObisCode {
+	t.Helper()
+	obis, err := NewObisCodeFromString(s)
+	require.NoError(t, err)
+	return *obis
+}
+
 func TestApplication_HandleSetRequest(t *testing.T) {
 	app, _, clientAddr, dataObj := setupTestApp(t)
 
 	t.Run("Successful Set", func(t *testing.T) {
 		req := &SetRequest{
 			Type:                SET_REQUEST_NORMAL,
-			InvokeIDAndPriority: 0x81,
+			InvokeIDAndPriority: 0xC1,
 			AttributeDescriptor: CosemAttributeDescriptor{
 				ClassID:     DataClassID,
 				InstanceID:  dataObj.InstanceID,
@@ -327,6 +489,143 @@ func TestApplication_HandleSetRequest(t *testing.T) {
 	})
 }
 
+func TestApplication_SetRequestWithList(t *testing.T) {
+	app, _, clientAddr, dataObj := setupTestApp(t)
+
+	deniedObis := obisOf(t, "1.1.1.1.1.1")
+	deniedObj, err := NewData(deniedObis, uint32(999))
+	require.NoError(t, err)
+	app.RegisterObject(deniedObj)
+
+	req := &SetRequest{
+		Type:                SET_REQUEST_WITH_LIST,
+		InvokeIDAndPriority: 0xC1,
+		AttributeList: []CosemAttributeDescriptor{
+			{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+			{ClassID: DataClassID, InstanceID: deniedObis, AttributeID: 2},
+		},
+		ValueList: []interface{}{uint32(777), uint32(888)},
+	}
+
+	encodedReq, err := req.Encode()
+	require.NoError(t, err)
+	encodedResp, err := app.HandleAPDU(encodedReq, clientAddr)
+	require.NoError(t, err)
+
+	resp := &SetResponse{}
+	require.NoError(t, resp.Decode(encodedResp))
+	assert.Equal(t, SET_RESPONSE_WITH_LIST, resp.Type)
+	assert.Equal(t, []DataAccessResultEnum{SUCCESS, READ_WRITE_DENIED}, resp.ResultList)
+
+	val, _ := dataObj.GetAttribute(2)
+	assert.Equal(t, uint32(777), val)
+	val, _ = deniedObj.GetAttribute(2)
+	assert.Equal(t, uint32(999), val)
+}
+
+func TestApplication_LongSet(t *testing.T) {
+	app, assoc, clientAddr, _ := setupTestApp(t)
+
+	obis := obisOf(t, "0.0.96.1.0.255")
+	dataObj, err := NewData(obis, []byte{})
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	assoc.AddObject(dataObj)
+	desc := CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: obis, AttributeID: 2}
+
+	payload := make([]byte, 200)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	blocks, err := SetRequestBlocks(0xC1, desc, payload, 64)
+	require.NoError(t, err)
+	require.Len(t, blocks, 4)
+
+	exchange := func(t *testing.T, req *SetRequest) *SetResponse {
+		t.Helper()
+		encodedReq, err := req.Encode()
+		require.NoError(t, err)
+		encodedResp, err := app.HandleAPDU(encodedReq, clientAddr)
+		require.NoError(t, err)
+		resp := &SetResponse{}
+		require.NoError(t, resp.Decode(encodedResp))
+		return resp
+	}
+
+	t.Run("Writes Reassembled Value", func(t *testing.T) {
+		for i, req := range blocks[:len(blocks)-1] {
+			resp := exchange(t, req)
+			assert.Equal(t, SET_RESPONSE_DATABLOCK, resp.Type)
+			assert.Equal(t, uint32(i+1), resp.BlockNumber)
+		}
+		resp := exchange(t, blocks[len(blocks)-1])
+		assert.Equal(t, SET_RESPONSE_LAST_DATABLOCK, resp.Type)
+		assert.Equal(t, SUCCESS, resp.Result)
+		assert.Equal(t, uint32(len(blocks)), resp.BlockNumber)
+
+		val, _ := dataObj.GetAttribute(2)
+		assert.Equal(t, payload, val)
+	})
+
+	t.Run("No Long Set In Progress", func(t *testing.T) {
+		resp := exchange(t, blocks[1])
+		assert.Equal(t, SET_RESPONSE_LAST_DATABLOCK, resp.Type)
+		assert.Equal(t, NO_LONG_SET_IN_PROGRESS, resp.Result)
+	})
+
+	t.Run("Invalid Block Number Aborts", func(t *testing.T) {
+		exchange(t, blocks[0])
+		resp := exchange(t, blocks[2])
+		assert.Equal(t, DATA_BLOCK_NUMBER_INVALID, resp.Result)
+		resp = exchange(t, blocks[1])
+		assert.Equal(t, NO_LONG_SET_IN_PROGRESS, resp.Result)
+	})
+
+	t.Run("Different Invoke ID Aborts", func(t *testing.T) {
+		exchange(t, blocks[0])
+		next := *blocks[1]
+		next.InvokeIDAndPriority = 0xC2
+		resp := exchange(t, &next)
+		assert.Equal(t, LONG_SET_ABORTED, resp.Result)
+		_, inProgress := app.longSets[assoc]
+		assert.False(t, inProgress)
+	})
+
+	t.Run("With List And First Datablock", func(t *testing.T) {
+		var raw bytes.Buffer
+		require.NoError(t, encodeDataList(&raw, []interface{}{[]byte{0x01}, uint32(2)}))
+		encoded := raw.Bytes()
+
+		otherObis := obisOf(t, "0.0.96.1.1.255")
+		otherObj, err := NewData(otherObis, uint32(0))
+		require.NoError(t, err)
+		app.RegisterObject(otherObj)
+		assoc.AddObject(otherObj)
+
+		resp := exchange(t, &SetRequest{
+			Type:                SET_REQUEST_WITH_LIST_AND_FIRST_DATABLOCK,
+			InvokeIDAndPriority: 0xC1,
+			AttributeList: []CosemAttributeDescriptor{
+				desc,
+				{ClassID: DataClassID, InstanceID: otherObis, AttributeID: 2},
+			},
+			DataBlock: DataBlockSA{BlockNumber: 1, RawData: encoded[:4]},
+		})
+		assert.Equal(t, SET_RESPONSE_DATABLOCK, resp.Type)
+
+		resp = exchange(t, &SetRequest{
+			Type:                SET_REQUEST_WITH_DATABLOCK,
+			InvokeIDAndPriority: 0xC1,
+			DataBlock:           DataBlockSA{LastBlock: true, BlockNumber: 2, RawData: encoded[4:]},
+		})
+		assert.Equal(t, SET_RESPONSE_LAST_DATABLOCK_WITH_LIST, resp.Type)
+		assert.Equal(t, []DataAccessResultEnum{SUCCESS, SUCCESS}, resp.ResultList)
+
+		val, _ := otherObj.GetAttribute(2)
+		assert.Equal(t, uint32(2), val)
+	})
+}
+
 func TestApplication_HandleActionRequest(t *testing.T) {
 	app, assoc, clientAddr, _ := setupTestApp(t)
 
@@ -342,7 +641,7 @@ func TestApplication_HandleActionRequest(t *testing.T) {
 	t.Run("Successful Action", func(t *testing.T) {
 		req := &ActionRequest{
 			Type:                ACTION_REQUEST_NORMAL,
-			InvokeIDAndPriority: 0x81,
+			InvokeIDAndPriority: 0xC1,
 			MethodDescriptor: CosemMethodDescriptor{
 				ClassID:    RegisterClassID,
 				InstanceID: *obis,
@@ -382,8 +681,10 @@ func TestApplication_SecurityPolicy(t *testing.T) {
 	}
 	encodedReq, _ := req.Encode()
 
-	_, err = app.HandleAPDU(encodedReq, clientAddr)
-	assert.Error(t, err)
+	policyViolation := []byte{byte(APDU_EXCEPTION_RESPONSE), byte(STATE_ERROR_SERVICE_NOT_ALLOWED), byte(SERVICE_ERROR_OPERATION_NOT_POSSIBLE)}
+	encodedResp, err := app.HandleAPDU(encodedReq, clientAddr)
+	assert.NoError(t, err)
+	assert.Equal(t, policyViolation, encodedResp)
 
 	header := &SecurityHeader{
 		SecurityControl: SecurityControlEncryptionOnly, // Policy requires authentication
@@ -394,12 +695,732 @@ func TestApplication_SecurityPolicy(t *testing.T) {
 	// We expect an error because the security control level is not sufficient.
 	// This test doesn't actually try to decrypt the request.
 	guek := []byte("0123456789ABCDEF")
-	serverSystemTitle := []byte("SERVER01")
-	ciphertext, err := EncryptAndTag(guek, encodedReq, serverSystemTitle, header, SecuritySuite0)
+	clientSystemTitle := []byte("CLIENT01")
+	securedReq := cipherAPDU(t, APDU_GLO_GET_REQUEST, guek, encodedReq, clientSystemTitle, header, SecuritySuite0)
+
+	encodedResp, err = app.HandleAPDU(securedReq, clientAddr)
 	assert.NoError(t, err)
-	encodedHeader, _ := header.Encode()
-	securedReq := append([]byte{byte(APDU_GLO_GET_REQUEST)}, append(encodedHeader, ciphertext...)...)
+	assert.Equal(t, policyViolation, encodedResp)
+}
 
-	_, err = app.HandleAPDU(securedReq, clientAddr)
+func newEchoObject(t *testing.T, obis string) *BaseImpl {
+	t.Helper()
+	return &BaseImpl{
+		ClassID:    DataClassID,
+		InstanceID: obisOf(t, obis),
+		Attributes: map[byte]AttributeDescriptor{},
+		Methods: map[byte]MethodDescriptor{
+			1: { // echo
+				Access:     MethodAccessAllowed,
+				ParamTypes: []reflect.Type{reflect.TypeOf([]byte{})},
+				Handler: func(params []interface{}) (interface{}, error) {
+					return params[0], nil
+				},
+			},
+			2: { // no-op
+				Access: MethodAccessAllowed,
+				Handler: func(_ []interface{}) (interface{}, error) {
+					return nil, nil
+				},
+			},
+		},
+	}
+}
+
+func TestApplication_ActionVariants(t *testing.T) {
+	app, assoc, clientAddr, _ := setupTestApp(t)
+	echo := newEchoObject(t, "0.0.96.2.0.255")
+	app.RegisterObject(echo)
+	assoc.AddObject(echo)
+	echoMethod := CosemMethodDescriptor{ClassID: DataClassID, InstanceID: echo.InstanceID, MethodID: 1}
+	noopMethod := CosemMethodDescriptor{ClassID: DataClassID, InstanceID: echo.InstanceID, MethodID: 2}
+
+	payload := make([]byte, 300)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	exchange := func(t *testing.T, req *ActionRequest) *ActionResponse {
+		t.Helper()
+		encodedReq, err := req.Encode()
+		require.NoError(t, err)
+		encodedResp, err := app.HandleAPDU(encodedReq, clientAddr)
+		require.NoError(t, err)
+		resp := &ActionResponse{}
+		require.NoError(t, resp.Decode(encodedResp))
+		return resp
+	}
+
+	t.Run("Single Parameter Without Array", func(t *testing.T) {
+		resp := exchange(t, &ActionRequest{
+			Type:                ACTION_REQUEST_NORMAL,
+			InvokeIDAndPriority: 0xC1,
+			MethodDescriptor:    echoMethod,
+			Parameters:          []byte{0x01, 0x02},
+		})
+		assert.Equal(t, ACTION_RESPONSE_NORMAL, resp.Type)
+		assert.False(t, resp.Result.IsDataAccessResult)
+		assert.Equal(t, []byte{0x01, 0x02}, resp.Result.Value)
+	})
+
+	t.Run("Parameters In Pblocks", func(t *testing.T) {
+		blocks, err := ActionRequestBlocks(0xC1, echoMethod, payload, 100)
+		require.NoError(t, err)
+		require.Len(t, blocks, 4)
+
+		for i, req := range blocks[:len(blocks)-1] {
+			resp := exchange(t, req)
+			assert.Equal(t, ACTION_RESPONSE_NEXT_PBLOCK, resp.Type)
+			assert.Equal(t, uint32(i+1), resp.BlockNumber)
+		}
+		resp := exchange(t, blocks[len(blocks)-1])
+		assert.Equal(t, ACTION_RESPONSE_NORMAL, resp.Type)
+		assert.Equal(t, payload, resp.Result.Value)
+	})
+
+	t.Run("Return Value In Pblocks", func(t *testing.T) {
+		app.SetMaxPDUSize(96)
+		defer app.SetMaxPDUSize(DefaultMaxPDUSize)
+
+		blocks, err := ActionRequestBlocks(0xC1, echoMethod, payload, 32)
+		require.NoError(t, err)
+		var resp *ActionResponse
+		for _, req := range blocks {
+			resp = exchange(t, req)
+		}
+
+		var raw bytes.Buffer
+		for {
+			require.Equal(t, ACTION_RESPONSE_WITH_PBLOCK, resp.Type)
+			raw.Write(resp.DataBlock.RawData)
+			if resp.DataBlock.LastBlock {
+				break
+			}
+			resp = exchange(t, &ActionRequest{
+				Type:                ACTION_REQUEST_NEXT_PBLOCK,
+				InvokeIDAndPriority: 0xC1,
+				BlockNumber:         resp.DataBlock.BlockNumber,
+			})
+		}
+
+		result, err := decodeActionResult(bytes.NewReader(raw.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, payload, result.Value)
+	})
+
+	t.Run("No Long Action In Progress", func(t *testing.T) {
+		resp := exchange(t, &ActionRequest{
+			Type:                ACTION_REQUEST_WITH_PBLOCK,
+			InvokeIDAndPriority: 0xC1,
+			DataBlock:           DataBlockSA{BlockNumber: 2, RawData: []byte{0x00}},
+		})
+		assert.Equal(t, ACTION_RESPONSE_NORMAL, resp.Type)
+		assert.Equal(t, NO_LONG_ACTION_IN_PROGRESS, resp.Result.Value)
+
+		resp = exchange(t, &ActionRequest{Type: ACTION_REQUEST_NEXT_PBLOCK, InvokeIDAndPriority: 0xC1, BlockNumber: 1})
+		assert.Equal(t, NO_LONG_ACTION_IN_PROGRESS, resp.Result.Value)
+	})
+
+	t.Run("Out Of Sequence Pblock Aborts", func(t *testing.T) {
+		blocks, err := ActionRequestBlocks(0xC1, echoMethod, payload, 100)
+		require.NoError(t, err)
+		exchange(t, blocks[0])
+		resp := exchange(t, blocks[2])
+		assert.Equal(t, LONG_ACTION_ABORTED, resp.Result.Value)
+	})
+
+	t.Run("With List", func(t *testing.T) {
+		resp := exchange(t, &ActionRequest{
+			Type:                ACTION_REQUEST_WITH_LIST,
+			InvokeIDAndPriority: 0xC1,
+			MethodList:          []CosemMethodDescriptor{echoMethod, noopMethod, {ClassID: DataClassID, InstanceID: obisOf(t, "1.1.1.1.1.1"), MethodID: 1}},
+			ParameterList:       []interface{}{[]byte{0x07}, nil, nil},
+		})
+		assert.Equal(t, ACTION_RESPONSE_WITH_LIST, resp.Type)
+		require.Len(t, resp.ResultList, 3)
+		assert.Equal(t, []byte{0x07}, resp.ResultList[0].Value)
+		assert.False(t, resp.ResultList[1].IsDataAccessResult)
+		assert.True(t, resp.ResultList[2].IsDataAccessResult)
+		assert.Equal(t, READ_WRITE_DENIED, resp.ResultList[2].Value)
+	})
+
+	t.Run("With List And First Pblock", func(t *testing.T) {
+		var raw bytes.Buffer
+		require.NoError(t, encodeDataList(&raw, []interface{}{payload, nil}))
+		encoded := raw.Bytes()
+
+		resp := exchange(t, &ActionRequest{
+			Type:                ACTION_REQUEST_WITH_LIST_AND_FIRST_PBLOCK,
+			InvokeIDAndPriority: 0xC1,
+			MethodList:          []CosemMethodDescriptor{echoMethod, noopMethod},
+			DataBlock:           DataBlockSA{BlockNumber: 1, RawData: encoded[:200]},
+		})
+		assert.Equal(t, ACTION_RESPONSE_NEXT_PBLOCK, resp.Type)
+
+		resp = exchange(t, &ActionRequest{
+			Type:                ACTION_REQUEST_WITH_PBLOCK,
+			InvokeIDAndPriority: 0xC1,
+			DataBlock:           DataBlockSA{LastBlock: true, BlockNumber: 2, RawData: encoded[200:]},
+		})
+		assert.Equal(t, ACTION_RESPONSE_WITH_LIST, resp.Type)
+		require.Len(t, resp.ResultList, 2)
+		assert.Equal(t, payload, resp.ResultList[0].Value)
+		assert.False(t, resp.ResultList[1].IsDataAccessResult)
+	})
+}
+
+func TestApplication_SelectiveAccess(t *testing.T) {
+	app, assoc, clientAddr, dataObj := setupTestApp(t)
+
+	pg, _, _ := newTestLoadProfile(t)
+	app.RegisterObject(pg)
+	assoc.AddObject(pg)
+
+	get := func(t *testing.T, desc CosemAttributeDescriptor) GetDataResult {
+		t.Helper()
+		req := &GetRequest{Type: GET_REQUEST_NORMAL, InvokeIDAndPriority: 0x81, AttributeDescriptor: desc}
+		encodedReq, err := req.Encode()
+		require.NoError(t, err)
+		encodedResp, err := app.HandleAPDU(encodedReq, clientAddr)
+		require.NoError(t, err)
+		resp := &GetResponse{}
+		require.NoError(t, resp.Decode(encodedResp))
+		return resp.Result
+	}
+
+	bufferDesc := func(selector uint8, params interface{}) CosemAttributeDescriptor {
+		return CosemAttributeDescriptor{
+			ClassID:         ProfileGenericClassID,
+			InstanceID:      pg.InstanceID,
+			AttributeID:     2,
+			AccessSelection: &SelectiveAccessDescriptor{AccessSelector: selector, AccessParameters: params},
+		}
+	}
+
+	result := get(t, bufferDesc(ProfileGenericEntryDescriptor, EntryDescriptor{FromEntry: 3, FromSelectedValue: 1, ToSelectedValue: 1}.AccessParameters()))
+	assert.False(t, result.IsDataAccessResult)
+	assert.Equal(t, axdr.Array{axdr.Structure{uint32(300)}}, result.Value)
+
+	result = get(t, bufferDesc(ProfileGenericEntryDescriptor, uint32(1)))
+	assert.True(t, result.IsDataAccessResult)
+	assert.Equal(t, TYPE_UNMATCHED, result.Value)
+
+	result = get(t, bufferDesc(9, nil))
+	assert.True(t, result.IsDataAccessResult)
+	assert.Equal(t, OBJECT_UNAVAILABLE, result.Value)
+
+	dataDesc := CosemAttributeDescriptor{
+		ClassID:         DataClassID,
+		InstanceID:      dataObj.InstanceID,
+		AttributeID:     2,
+		AccessSelection: &SelectiveAccessDescriptor{AccessSelector: 1, AccessParameters: uint8(0)},
+	}
+	result = get(t, dataDesc)
+	assert.True(t, result.IsDataAccessResult)
+	assert.Equal(t, OBJECT_UNAVAILABLE, result.Value)
+	assert.Equal(t, OBJECT_UNAVAILABLE, app.HandleSetRequest(&SetRequest{
+		Type:                SET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0x81,
+		AttributeDescriptor: dataDesc,
+		Value:               uint32(1),
+	}, assoc).Result)
+}
+
+// arrayData is a Data object holding an array that supports index range reads
+// through SelectiveAccessor.
+type arrayData struct {
+	*Data
+}
+
+func (d *arrayData) GetAttributeWithSelector(attributeID byte, selector uint8, parameters interface{}) (interface{}, error) {
+	if attributeID != 2 || selector != 1 {
+		return nil, ErrAccessSelectorNotSupported
+	}
+	bounds, ok := parameters.(axdr.Structure)
+	if !ok || len(bounds) != 2 {
+		return nil, ErrInvalidParameter
+	}
+	from, ok1 := bounds[0].(uint16)
+	to, ok2 := bounds[1].(uint16)
+	value, err := d.GetAttribute(2)
+	if err != nil {
+		return nil, err
+	}
+	values := value.([]uint32)
+	if !ok1 || !ok2 || from == 0 || from > to || int(to) > len(values) {
+		return nil, ErrInvalidParameter
+	}
+	return values[from-1 : to], nil
+}
+
+func TestApplication_SelectiveAccessorRouting(t *testing.T) {
+	app, _, clientAddr, _ := setupTestApp(t)
+
+	obis := obisOf(t, "0.0.96.2.0.255")
+	data, err := NewData(obis, []uint32{10, 20, 30, 40})
+	require.NoError(t, err)
+	obj := &arrayData{Data: data}
+	app.RegisterObject(obj)
+	app.associations[clientAddr.String()].AddObject(obj)
+
+	get := func(t *testing.T, selector uint8, params interface{}) GetDataResult {
+		t.Helper()
+		req := &GetRequest{
+			Type:                GET_REQUEST_NORMAL,
+			InvokeIDAndPriority: 0x81,
+			AttributeDescriptor: CosemAttributeDescriptor{
+				ClassID:         DataClassID,
+				InstanceID:      obis,
+				AttributeID:     2,
+				AccessSelection: &SelectiveAccessDescriptor{AccessSelector: selector, AccessParameters: params},
+			},
+		}
+		encodedReq, err := req.Encode()
+		require.NoError(t, err)
+		encodedResp, err := app.HandleAPDU(encodedReq, clientAddr)
+		require.NoError(t, err)
+		resp := &GetResponse{}
+		require.NoError(t, resp.Decode(encodedResp))
+		return resp.Result
+	}
+
+	result := get(t, 1, axdr.Structure{uint16(2), uint16(3)})
+	assert.False(t, result.IsDataAccessResult)
+	assert.Equal(t, axdr.Array{uint32(20), uint32(30)}, result.Value)
+
+	result = get(t, 1, axdr.Structure{uint16(3), uint16(9)})
+	assert.Equal(t, GetDataResult{IsDataAccessResult: true, Value: TYPE_UNMATCHED}, result)
+
+	result = get(t, 2, nil)
+	assert.Equal(t, GetDataResult{IsDataAccessResult: true, Value: OBJECT_UNAVAILABLE}, result)
+}
+
+// recordingTransport is a transport.Transport that records the PDUs passed to Send.
+type recordingTransport struct {
+	sent [][]byte
+}
+
+func (r *recordingTransport) Connect() ([]byte, error)         { return nil, nil }
+func (r *recordingTransport) Disconnect() ([]byte, error)      { return nil, nil }
+func (r *recordingTransport) IsConnected() bool                { return true }
+func (r *recordingTransport) Receive([]byte) ([][]byte, error) { return nil, nil }
+func (r *recordingTransport) Read() ([]byte, net.Addr, error)  { return nil, nil, nil }
+func (r *recordingTransport) Send(pdu []byte) ([][]byte, error) {
+	r.sent = append(r.sent, pdu)
+	return [][]byte{pdu}, nil
+}
+
+func TestApplication_SendEventNotification(t *testing.T) {
+	obisSecurity := obisOf(t, "0.0.43.0.0.255")
+	serverSystemTitle := []byte("SERVER01")
+	guek := []byte("0123456789ABCDEF")
+	gak := []byte("FEDCBA9876543210")
+	securitySetup, err := NewSecuritySetup(obisSecurity, nil, serverSystemTitle, nil, guek, gak)
+	require.NoError(t, err)
+
+	tr := &recordingTransport{}
+	app := NewApplication(tr, securitySetup)
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	clientAddr := mockAddr("client1")
+	app.AddAssociation(clientAddr.String(), assoc)
+
+	alarmObis := obisOf(t, "0.0.97.98.0.255")
+	alarm, err := NewData(alarmObis, uint32(0x00000100))
+	require.NoError(t, err)
+	app.RegisterObject(alarm)
+
+	desc := CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: alarmObis, AttributeID: 2}
+	frames, err := app.SendEventNotification(clientAddr, desc, nil)
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	require.Len(t, tr.sent, 1)
+
+	var received *EventNotificationRequest
+	listener := &NotificationListener{OnEventNotification: func(n *EventNotificationRequest) { received = n }}
+	handled, err := listener.HandleAPDU(tr.sent[0])
+	require.NoError(t, err)
+	assert.True(t, handled)
+	require.NotNil(t, received)
+	assert.Equal(t, desc, received.AttributeDescriptor)
+	assert.Equal(t, uint32(0x00000100), received.AttributeValue)
+	assert.Nil(t, received.Time)
+
+	_, err = app.SendEventNotification(clientAddr, CosemAttributeDescriptor{ClassID: RegisterClassID, InstanceID: alarmObis, AttributeID: 2}, nil)
+	assert.Error(t, err)
+	_, err = app.SendEventNotification(clientAddr, CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: obisOf(t, "0.0.97.98.1.255"), AttributeID: 2}, nil)
+	assert.Error(t, err)
+	_, err = app.SendEventNotification(mockAddr("client2"), desc, nil)
 	assert.Error(t, err)
+
+	noTransport := NewApplication(nil, securitySetup)
+	noTransport.RegisterObject(alarm)
+	_, err = noTransport.SendEventNotification(clientAddr, desc, nil)
+	assert.Error(t, err)
+
+	t.Run("ProtectedByResponsePolicy", func(t *testing.T) {
+		require.NoError(t, securitySetup.SetAttribute(2, PolicyAuthenticatedResponse|PolicyEncryptedResponse))
+		tr.sent = nil
+
+		_, err := app.SendEventNotification(clientAddr, desc, nil)
+		require.NoError(t, err)
+		require.Len(t, tr.sent, 1)
+		assert.Equal(t, byte(APDU_GENERAL_GLO_CIPHERING), tr.sent[0][0])
+
+		glo := &GeneralGloCiphering{}
+		require.NoError(t, glo.Decode(tr.sent[0]))
+		assert.Equal(t, serverSystemTitle, glo.SystemTitle)
+		assert.Equal(t, SecurityControlAuthenticatedAndEncrypted, glo.SecurityHeader.SecurityControl)
+		assert.Equal(t, uint32(1), glo.SecurityHeader.FrameCounter)
+		plaintext, err := glo.Open(guek, SecuritySuite0, 0)
+		require.NoError(t, err)
+
+		received = nil
+		listener := &NotificationListener{OnEventNotification: func(n *EventNotificationRequest) { received = n }}
+		handled, err := listener.HandleAPDU(plaintext)
+		require.NoError(t, err)
+		assert.True(t, handled)
+		require.NotNil(t, received)
+		assert.Equal(t, uint32(0x00000100), received.AttributeValue)
+
+		// Every notification takes a new server frame counter.
+		_, err = app.SendEventNotification(clientAddr, desc, nil)
+		require.NoError(t, err)
+		require.NoError(t, glo.Decode(tr.sent[1]))
+		assert.Equal(t, uint32(2), glo.SecurityHeader.FrameCounter)
+	})
+
+	t.Run("SignedByResponsePolicy", func(t *testing.T) {
+		require.NoError(t, securitySetup.SetAttribute(2, PolicyAuthenticatedResponse|PolicyEncryptedResponse|PolicyDigitallySignedResponse))
+		_, err := app.SendEventNotification(clientAddr, desc, nil)
+		assert.Error(t, err, "no server signing key")
+
+		serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		securitySetup.ServerSigningKey = serverKey
+		tr.sent = nil
+		_, err = app.SendEventNotification(clientAddr, desc, nil)
+		require.NoError(t, err)
+		require.Len(t, tr.sent, 1)
+
+		signed := &GeneralSigning{}
+		require.NoError(t, signed.Decode(tr.sent[0]))
+		require.NoError(t, signed.Verify(&serverKey.PublicKey))
+		assert.Equal(t, byte(APDU_GENERAL_GLO_CIPHERING), signed.Content[0])
+	})
+}
+
+func TestApplication_DedicatedCiphering(t *testing.T) {
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+	guek := []byte("0123456789ABCDEF")
+	dedicatedKey := []byte("DEDICATEDKEY0123")
+
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), clientSystemTitle, serverSystemTitle, nil, guek, nil)
+	require.NoError(t, err)
+	require.NoError(t, securitySetup.SetAttribute(2, SecurityPolicy(PolicyEncryptedRequest)))
+	app := NewApplication(nil, securitySetup)
+
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	clientAddr := mockAddr("ded-client")
+	app.AddAssociation(clientAddr.String(), assoc)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
+
+	dataObj, err := NewData(obisOf(t, "1.0.0.3.0.255"), uint32(777))
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+
+	req, err := (&GetRequest{
+		Type:                GET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0x81,
+		AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+	}).Encode()
+	require.NoError(t, err)
+
+	buildDed := func(key []byte, frameCounter uint32) []byte {
+		header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: frameCounter}
+		return cipherAPDU(t, APDU_DED_GET_REQUEST, key, req, clientSystemTitle, header, SecuritySuite0)
+	}
+
+	// Without a dedicated key the request cannot be deciphered.
+	resp, err := app.HandleAPDU(buildDed(dedicatedKey, 1), clientAddr)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp)
+
+	assert.Error(t, app.SetDedicatedKey("unknown", dedicatedKey))
+	require.NoError(t, app.SetDedicatedKey(clientAddr.String(), dedicatedKey))
+
+	resp, err = app.HandleAPDU(buildDed(dedicatedKey, 2), clientAddr)
+	require.NoError(t, err)
+	require.Equal(t, byte(APDU_DED_GET_RESPONSE), resp[0])
+	_, plaintext := decipherAPDU(t, resp, dedicatedKey, serverSystemTitle, SecuritySuite0, 0)
+	getResp := &GetResponse{}
+	require.NoError(t, getResp.Decode(plaintext))
+	assert.Equal(t, uint32(777), getResp.Result.Value)
+
+	// The global key does not decipher ded- requests.
+	resp, err = app.HandleAPDU(buildDed(guek, 3), clientAddr)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp)
+
+	// Releasing the association discards the key.
+	require.NoError(t, app.SetDedicatedKey(clientAddr.String(), nil))
+	resp, err = app.HandleAPDU(buildDed(dedicatedKey, 4), clientAddr)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp)
+}
+
+func TestApplication_RegisterShortName(t *testing.T) {
+	app, assoc, _, dataObj := setupTestApp(t)
+
+	register, err := NewRegister(obisOf(t, "1.0.1.8.0.255"), uint32(0), ScalerUnit{Scaler: 0, Unit: 30})
+	require.NoError(t, err)
+
+	assert.Error(t, app.RegisterShortName(dataObj, 0x0104))
+	require.NoError(t, app.RegisterShortName(dataObj, 0x0100))
+	// A Register occupies its attributes and, from 0x28 on, its reset method.
+	require.NoError(t, app.RegisterShortName(register, 0x0200))
+	assert.Error(t, app.RegisterShortName(dataObj, 0x0228))
+	assert.Error(t, app.RegisterShortName(dataObj, 0x01F8))
+	assert.Error(t, app.RegisterShortName(dataObj, 0xFFF8))
+	require.NoError(t, app.RegisterShortName(app.securitySetup, 0x0230))
+
+	for name, want := range map[uint16]shortNameReference{
+		0x0100: {obj: dataObj, attributeID: 1},
+		0x0108: {obj: dataObj, attributeID: 2},
+		0x0210: {obj: register, attributeID: 3},
+		0x0228: {obj: register, methodID: 1},
+		0x0238: {obj: app.securitySetup, attributeID: 2},
+		0x0260: {obj: app.securitySetup, methodID: 1},
+		0x0278: {obj: app.securitySetup, methodID: 4},
+	} {
+		ref, ok := app.resolveShortName(name)
+		assert.True(t, ok, "%04X", name)
+		assert.Equal(t, want, ref, "%04X", name)
+	}
+	for _, name := range []uint16{0x00F8, 0x0104, 0x0110, 0x0218, 0x0280} {
+		_, ok := app.resolveShortName(name)
+		assert.False(t, ok, "%04X", name)
+	}
+
+	associationSN, err := NewAssociationSN(obisOf(t, "0.0.40.0.0.255"), assoc)
+	require.NoError(t, err)
+	require.NoError(t, app.PopulateObjectListSN(associationSN, []ObisCode{dataObj.InstanceID, register.InstanceID}))
+	baseName, ok := associationSN.BaseName(register.InstanceID)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(0x0200), baseName)
+	assert.Error(t, app.PopulateObjectListSN(associationSN, []ObisCode{obisOf(t, "0.0.1.0.0.255")}))
+}
+
+func TestApplication_ShortNameServices(t *testing.T) {
+	app, assoc, clientAddr, dataObj := setupTestApp(t)
+
+	register, err := NewRegister(obisOf(t, "1.0.1.8.0.255"), uint32(500), ScalerUnit{Scaler: 0, Unit: 30})
+	require.NoError(t, err)
+	associationSN, err := NewAssociationSN(obisOf(t, "0.0.40.0.1.255"), assoc)
+	require.NoError(t, err)
+	require.NoError(t, app.RegisterShortName(dataObj, 0x0100))
+	require.NoError(t, app.RegisterShortName(register, 0x0200))
+	require.NoError(t, app.RegisterShortName(associationSN, 0xFA00))
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{register.InstanceID, associationSN.InstanceID}))
+	require.NoError(t, app.PopulateObjectListSN(associationSN, []ObisCode{dataObj.InstanceID, register.InstanceID, associationSN.InstanceID}))
+
+	read := func(t *testing.T, variables ...VariableAccessSpecification) []byte {
+		src, err := (&ReadRequest{Variables: variables}).Encode()
+		require.NoError(t, err)
+		resp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		return resp
+	}
+	readResults := func(t *testing.T, variables ...VariableAccessSpecification) []ReadResult {
+		resp := &ReadResponse{}
+		require.NoError(t, resp.Decode(read(t, variables...)))
+		return resp.Results
+	}
+	write := func(t *testing.T, apduType APDUType, variable VariableAccessSpecification, value interface{}) []byte {
+		var src []byte
+		var err error
+		if apduType == APDU_UNCONFIRMED_WRITE_REQUEST {
+			src, err = (&UnconfirmedWriteRequest{Variables: []VariableAccessSpecification{variable}, Values: []interface{}{value}}).Encode()
+		} else {
+			src, err = (&WriteRequest{Variables: []VariableAccessSpecification{variable}, Values: []interface{}{value}}).Encode()
+		}
+		require.NoError(t, err)
+		resp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		return resp
+	}
+	name := func(shortName uint16) VariableAccessSpecification {
+		return VariableAccessSpecification{Type: VARIABLE_ACCESS_NAME, VariableName: shortName}
+	}
+
+	t.Run("Read", func(t *testing.T) {
+		results := readResults(t, name(0x0108), name(0x0208), name(0x0110), name(0xFA08))
+		require.Len(t, results, 4)
+		assert.Equal(t, ReadResult{Type: READ_RESULT_DATA, Value: uint32(12345)}, results[0])
+		assert.Equal(t, ReadResult{Type: READ_RESULT_DATA, Value: uint32(500)}, results[1])
+		assert.Equal(t, readAccessError(OBJECT_UNDEFINED), results[2])
+		objList := results[3].Value.(axdr.Array)
+		assert.Len(t, objList, 3)
+		assert.Equal(t, int16(0x0200), objList[1].(axdr.Structure)[0])
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		resp := write(t, APDU_WRITE_REQUEST, name(0x0108), uint32(54321))
+		assert.Equal(t, []byte{0x0D, 0x01, 0x00}, resp)
+		assert.Equal(t, ReadResult{Type: READ_RESULT_DATA, Value: uint32(54321)}, readResults(t, name(0x0108))[0])
+
+		wr := &WriteResponse{}
+		require.NoError(t, wr.Decode(write(t, APDU_WRITE_REQUEST, name(0xFA08), axdr.Array{})))
+		assert.Equal(t, []WriteResult{{Result: READ_WRITE_DENIED}}, wr.Results)
+
+		resp = write(t, APDU_UNCONFIRMED_WRITE_REQUEST, name(0x0208), uint32(42))
+		assert.Nil(t, resp)
+		assert.Equal(t, ReadResult{Type: READ_RESULT_DATA, Value: uint32(42)}, readResults(t, name(0x0208))[0])
+	})
+
+	t.Run("Method", func(t *testing.T) {
+		// reset is invoked by writing its short name.
+		resp := write(t, APDU_WRITE_REQUEST, name(0x0228), nil)
+		assert.Equal(t, []byte{0x0D, 0x01, 0x00}, resp)
+		assert.Equal(t, ReadResult{Type: READ_RESULT_DATA, Value: uint32(0)}, readResults(t, name(0x0208))[0])
+
+		results := readResults(t, VariableAccessSpecification{Type: VARIABLE_ACCESS_PARAMETERIZED, VariableName: 0x0228, Parameter: nil})
+		assert.Equal(t, ReadResult{Type: READ_RESULT_DATA, Value: nil}, results[0])
+
+		// change_HLS_secret of the Association SN replaces the secret of the association.
+		resp = write(t, APDU_WRITE_REQUEST, name(0xFA48), []byte("new secret"))
+		assert.Equal(t, []byte{0x0D, 0x01, 0x00}, resp)
+		assert.Equal(t, []byte("new secret"), assoc.Attributes[7].Value)
+	})
+
+	t.Run("UnsupportedAccess", func(t *testing.T) {
+		resp := read(t, VariableAccessSpecification{Type: VARIABLE_ACCESS_READ_DATA_BLOCK, BlockNumber: 1, RawData: []byte{}})
+		assert.Equal(t, []byte{0x0E, 0x05, 0x03, 0x02}, resp)
+
+		resp = write(t, APDU_WRITE_REQUEST, VariableAccessSpecification{Type: VARIABLE_ACCESS_WRITE_DATA_BLOCK, BlockNumber: 1}, []byte{})
+		assert.Equal(t, []byte{0x0E, 0x06, 0x03, 0x02}, resp)
+
+		resp = write(t, APDU_UNCONFIRMED_WRITE_REQUEST, VariableAccessSpecification{Type: VARIABLE_ACCESS_WRITE_DATA_BLOCK, BlockNumber: 1}, []byte{})
+		assert.Nil(t, resp)
+
+		resp, err := app.HandleAPDU([]byte{byte(APDU_READ_REQUEST), 0x01, 0x02}, clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x0E, 0x05, 0x03, 0x00}, resp)
+	})
+
+	t.Run("LongRead", func(t *testing.T) {
+		long, err := NewData(obisOf(t, "0.0.96.1.0.255"), bytes.Repeat([]byte{0x5A}, 100))
+		require.NoError(t, err)
+		require.NoError(t, app.RegisterShortName(long, 0x0300))
+		require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{long.InstanceID}))
+		app.SetMaxPDUSize(64)
+		defer app.SetMaxPDUSize(DefaultMaxPDUSize)
+
+		results := readResults(t, VariableAccessSpecification{Type: VARIABLE_ACCESS_BLOCK_NUMBER, BlockNumber: 1})
+		assert.Equal(t, readAccessError(NO_LONG_GET_IN_PROGRESS), results[0])
+
+		var data bytes.Buffer
+		results = readResults(t, name(0x0308))
+		for blockNumber := uint16(1); ; blockNumber++ {
+			require.Len(t, results, 1)
+			require.Equal(t, READ_RESULT_DATA_BLOCK, results[0].Type)
+			block := results[0].DataBlock
+			assert.Equal(t, blockNumber, block.BlockNumber)
+			data.Write(block.RawData)
+			if block.LastBlock {
+				break
+			}
+			results = readResults(t, VariableAccessSpecification{Type: VARIABLE_ACCESS_BLOCK_NUMBER, BlockNumber: blockNumber})
+		}
+
+		list, err := decodeReadResultList(bytes.NewReader(data.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, []ReadResult{{Type: READ_RESULT_DATA, Value: bytes.Repeat([]byte{0x5A}, 100)}}, list)
+
+		results = readResults(t, name(0x0308))
+		require.Equal(t, READ_RESULT_DATA_BLOCK, results[0].Type)
+		results = readResults(t, VariableAccessSpecification{Type: VARIABLE_ACCESS_BLOCK_NUMBER, BlockNumber: 5})
+		assert.Equal(t, readAccessError(DATA_BLOCK_NUMBER_INVALID), results[0])
+	})
+}
+
+func TestApplication_NegotiatedContext(t *testing.T) {
+	app, assoc, clientAddr, dataObj := setupTestApp(t)
+	require.NoError(t, app.SetXDLMSContext(clientAddr.String(), XDLMSContextInfo{
+		Conformance:       conformanceValue(NewConformance(ConformanceGet, ConformanceBlockTransferWithGetOrRead)),
+		MaxReceivePDUSize: 32,
+		MaxSendPDUSize:    64,
+		DLMSVersionNumber: DLMSVersion,
+	}))
+	assert.Error(t, app.SetXDLMSContext("unknown", XDLMSContextInfo{}))
+
+	info, err := assoc.GetAttribute(5)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(32), info.(XDLMSContextInfo).MaxReceivePDUSize)
+
+	dataValue := CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2}
+	handle := func(t *testing.T, apdu APDU) []byte {
+		src, err := apdu.Encode()
+		require.NoError(t, err)
+		resp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		return resp
+	}
+	exception := func(t *testing.T, resp []byte) *ExceptionResponse {
+		decoded := &ExceptionResponse{}
+		require.NoError(t, decoded.Decode(resp))
+		return decoded
+	}
+
+	t.Run("NegotiatedService", func(t *testing.T) {
+		resp := &GetResponse{}
+		require.NoError(t, resp.Decode(handle(t, &GetRequest{Type: GET_REQUEST_NORMAL, InvokeIDAndPriority: 0xC1, AttributeDescriptor: dataValue})))
+		assert.Equal(t, uint32(12345), resp.Result.Value)
+	})
+
+	t.Run("ServicesNotNegotiated", func(t *testing.T) {
+		for _, req := range []APDU{
+			&GetRequest{Type: GET_REQUEST_WITH_LIST, InvokeIDAndPriority: 0xC1, AttributeList: []CosemAttributeDescriptor{dataValue}},
+			&SetRequest{Type: SET_REQUEST_NORMAL, InvokeIDAndPriority: 0xC1, AttributeDescriptor: dataValue, Value: uint32(1)},
+			&ReadRequest{Variables: []VariableAccessSpecification{{Type: VARIABLE_ACCESS_NAME, VariableName: 0x0008}}},
+		} {
+			resp := handle(t, req)
+			if _, ok := req.(*ReadRequest); ok {
+				assert.Equal(t, []byte{byte(APDU_CONFIRMED_SERVICE_ERROR), byte(CONFIRMED_SERVICE_ERROR_READ), byte(SERVICE_ERROR_TYPE_SERVICE), SERVICE_SERVICE_UNSUPPORTED}, resp)
+				continue
+			}
+			assert.Equal(t, SERVICE_ERROR_SERVICE_NOT_SUPPORTED, exception(t, resp).ServiceError)
+		}
+		value, err := dataObj.GetAttribute(2)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(12345), value)
+	})
+
+	t.Run("RequestTooLong", func(t *testing.T) {
+		desc := dataValue
+		desc.AccessSelection = &SelectiveAccessDescriptor{AccessSelector: 1, AccessParameters: make([]byte, 32)}
+		resp := exception(t, handle(t, &GetRequest{Type: GET_REQUEST_NORMAL, InvokeIDAndPriority: 0xC1, AttributeDescriptor: desc}))
+		assert.Equal(t, SERVICE_ERROR_PDU_TOO_LONG, resp.ServiceError)
+	})
+
+	t.Run("ResponseInBlocks", func(t *testing.T) {
+		obis := obisOf(t, "0.0.96.1.0.255")
+		bigObj, err := NewData(obis, make([]byte, 100))
+		require.NoError(t, err)
+		app.RegisterObject(bigObj)
+		assoc.AddObject(bigObj)
+
+		encodedResp := handle(t, &GetRequest{
+			Type:                GET_REQUEST_NORMAL,
+			InvokeIDAndPriority: 0xC1,
+			AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: obis, AttributeID: 2},
+		})
+		assert.LessOrEqual(t, len(encodedResp), 64)
+		resp := &GetResponse{}
+		require.NoError(t, resp.Decode(encodedResp))
+		assert.Equal(t, GET_RESPONSE_WITH_DATABLOCK, resp.Type)
+	})
 }
@@ -0,0 +1,202 @@
+package cosem
+
+import (
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"fmt"
+	"net"
+
+	"github.com/gvtret/spodes-go/pkg/common"
+)
+
+// PreEstablishAssociation marks the association of the client at address as
+// established without an AARQ, as for a pre-established application association.
+// It stays established until released by an RLRQ or a transport disconnection.
+func (app *Application) PreEstablishAssociation(address string) error {
+	assoc, ok := app.associations[address]
+	if !ok {
+		return fmt.Errorf("no association found for client address: %s", address)
+	}
+	assoc.setStatus(AssociationStatusAssociated)
+	return nil
+}
+
+// HandleDisconnect releases the association of the client at clientAddr when its
+// supporting layer connection is closed, as an RLRQ would but without a response.
+// General-Block-Transfers in progress with the client are abandoned.
+func (app *Application) HandleDisconnect(clientAddr net.Addr) error {
+	assoc, ok := app.associations[clientAddr.String()]
+	if !ok {
+		return fmt.Errorf("no association found for client address: %s", clientAddr.String())
+	}
+	app.releaseAssociation(assoc)
+	if app.gbt != nil {
+		app.gbt.abandon(clientAddr)
+	}
+	return nil
+}
+
+// currentAssociationLN is the logical name of the current association object.
+var currentAssociationLN = *NewObisCodeFromBytes([6]byte{0, 0, 40, 0, 0, 255})
+
+// handleAARQ opens the application association of the client of assoc and
+// returns the AARE. The AARQ must propose the authentication mechanism held in
+// the authentication_mechanism_name of assoc; an LLS password is checked against
+// its secret. Under HLS the association stays pending until the client
+// authenticates with reply_to_HLS_authentication, and is released if its first
+// attempt fails. A client that is already associated must release its
+// association before opening a new one.
+func (app *Application) handleAARQ(src []byte, assoc *AssociationLN) ([]byte, error) {
+	req := &AARQ{}
+	if err := req.Decode(src); err != nil {
+		return rejectAARQ(OidApplicationContextLN, ResultSourceDiagnostic{ACSEServiceProvider: ACSEServiceProviderNoReasonGiven})
+	}
+	if assoc.Status() != AssociationStatusNonAssociated {
+		return rejectAARQ(req.ApplicationContextName, ResultSourceDiagnostic{ACSEServiceUser: ACSEUserNoReasonGiven})
+	}
+
+	mechanism := assoc.Attributes[6].Value.(AuthenticationMechanismName)
+	if id, ok := mechanismID(req.MechanismName); !ok || id != mechanism.MechanismID {
+		diagnostic := ACSEUserAuthenticationMechanismNotSupported
+		if len(req.MechanismName) == 0 {
+			diagnostic = ACSEUserAuthenticationMechanismRequired
+		}
+		return rejectAARQ(req.ApplicationContextName, ResultSourceDiagnostic{ACSEServiceUser: diagnostic})
+	}
+
+	secret := assoc.Attributes[7].Value.([]byte)
+	privateKey, _ := app.securitySetup.ServerSigningKey.(*ecdsa.PrivateKey)
+	serverSystemTitle, _ := app.serverSystemTitle()
+	acse := NewACSE(string(secret), privateKey, serverSystemTitle)
+	acse.SetMaxReceivePDUSize(app.maxPDUSize)
+	acse.SetConformance(app.conformance)
+	acse.SetLastFrameCounter(app.lastFrameCounters[assoc])
+
+	resp, err := acse.HandleAARQ(req, app.securitySetup)
+	if err != nil {
+		return rejectAARQ(req.ApplicationContextName, ResultSourceDiagnostic{ACSEServiceProvider: ACSEServiceProviderNoReasonGiven})
+	}
+	app.lastFrameCounters[assoc] = acse.LastFrameCounter()
+	if resp.Result == ResultAccepted {
+		app.acses[assoc] = acse
+		if ctx := acse.XDLMSContext(); ctx.DLMSVersionNumber != 0 {
+			assoc.SetXDLMSContextInfo(ctx)
+		}
+		if key := acse.DedicatedKey(); key != nil {
+			app.dedicatedKeys[assoc] = key
+		}
+		if resp.RespondingAuthenticationValue != nil {
+			assoc.awaitHLS(func(fStoC []byte) ([]byte, error) {
+				fCtoS, err := acse.ReplyToHLSAuthentication(fStoC, app.securitySetup, func() uint32 {
+					return app.nextServerFrameCounter(assoc)
+				})
+				if err != nil {
+					// The challenge is answered once: the client opens a new
+					// association to be given a fresh one.
+					app.releaseAssociation(assoc)
+					return nil, err
+				}
+				app.lastFrameCounters[assoc] = acse.LastFrameCounter()
+				return fCtoS, nil
+			})
+		} else {
+			assoc.setStatus(AssociationStatusAssociated)
+		}
+	}
+	return resp.Encode()
+}
+
+// isReplyToHLS reports whether req invokes reply_to_HLS_authentication, the only
+// service an LN client may use while its association is pending. An SN client
+// reads or writes the method's short name instead (see namesReplyToHLS).
+func isReplyToHLS(req *ActionRequest) bool {
+	desc := req.MethodDescriptor
+	return req.Type == ACTION_REQUEST_NORMAL && desc.ClassID == AssociationLNClassID && byte(desc.MethodID) == associationLNMethodReplyToHLS
+}
+
+// replyToHLSObject returns the object whose reply_to_HLS_authentication method
+// desc invokes for the client of assoc: assoc itself, by either of its logical
+// names, or an Association SN presenting it. The client may invoke it whatever
+// the access rights, as it is how the client authenticates.
+func (app *Application) replyToHLSObject(desc CosemMethodDescriptor, assoc *AssociationLN) (BaseInterface, bool) {
+	switch desc.ClassID {
+	case AssociationLNClassID:
+		if byte(desc.MethodID) == associationLNMethodReplyToHLS && currentAssociation(desc.InstanceID, assoc) {
+			return assoc, true
+		}
+	case AssociationSNClassID:
+		if byte(desc.MethodID) != associationSNMethodReplyToHLS {
+			return nil, false
+		}
+		obj, found := app.FindObject(desc.InstanceID)
+		if sn, ok := obj.(*AssociationSN); found && ok && sn.Association() == assoc {
+			return sn, true
+		}
+	}
+	return nil, false
+}
+
+// namesReplyToHLS reports whether variables, those of a ReadRequest or a
+// WriteRequest, name only the reply_to_HLS_authentication method of an
+// Association SN presenting assoc. That is how an SN client authenticates while
+// its association is pending.
+func (app *Application) namesReplyToHLS(variables []VariableAccessSpecification, assoc *AssociationLN) bool {
+	if len(variables) != 1 || variables[0].Type != VARIABLE_ACCESS_NAME && variables[0].Type != VARIABLE_ACCESS_PARAMETERIZED {
+		return false
+	}
+	ref, found := app.resolveShortName(variables[0].VariableName)
+	if !found || ref.methodID == 0 {
+		return false
+	}
+	desc := CosemMethodDescriptor{ClassID: ref.obj.GetClassID(), InstanceID: ref.obj.GetInstanceID(), MethodID: int8(ref.methodID)}
+	_, ok := app.replyToHLSObject(desc, assoc)
+	return ok
+}
+
+// currentAssociation reports whether the Association LN object named instanceID
+// is assoc, either by its own logical name or by 0.0.40.0.0.255, which always
+// names the association of the client.
+func currentAssociation(instanceID ObisCode, assoc *AssociationLN) bool {
+	return instanceID.String() == currentAssociationLN.String() || instanceID.String() == assoc.InstanceID.String()
+}
+
+// rejectAARQ encodes the AARE refusing an AARQ for the given diagnostic.
+func rejectAARQ(applicationContextName asn1.ObjectIdentifier, diagnostic ResultSourceDiagnostic) ([]byte, error) {
+	resp := &AARE{
+		ApplicationContextName: applicationContextName,
+		Result:                 ResultRejectedPermanent,
+		ResultSourceDiagnostic: diagnostic,
+	}
+	return resp.Encode()
+}
+
+// handleRLRQ releases the application association of the client of assoc and
+// returns the RLRE. Releasing a client that is not associated is not an error.
+func (app *Application) handleRLRQ(src []byte, assoc *AssociationLN) ([]byte, error) {
+	req := &RLRQ{}
+	if err := req.Decode(src); err != nil {
+		return nil, common.WrapError(common.ErrCosemAPDUUngarsable, "failed to decode RLRQ", err)
+	}
+	resp := &RLRE{Reason: req.Reason}
+	if acse, ok := app.acses[assoc]; ok {
+		resp = acse.HandleRLRQ(req)
+	}
+	app.releaseAssociation(assoc)
+	return resp.Encode()
+}
+
+// releaseAssociation closes the association of assoc and drops the state kept
+// for it: the block transfers in progress, the dedicated key and the negotiated
+// xDLMS context. The frame counters are kept, since the global keys they protect
+// outlive the association and must never see a counter value twice.
+func (app *Application) releaseAssociation(assoc *AssociationLN) {
+	delete(app.acses, assoc)
+	delete(app.longGets, assoc)
+	delete(app.longSets, assoc)
+	delete(app.longActions, assoc)
+	delete(app.longActionResponses, assoc)
+	delete(app.longReads, assoc)
+	delete(app.dedicatedKeys, assoc)
+	assoc.SetXDLMSContextInfo(XDLMSContextInfo{DLMSVersionNumber: DLMSVersion, CypheringInfo: []byte{}})
+	assoc.setStatus(AssociationStatusNonAssociated)
+}
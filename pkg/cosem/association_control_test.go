@@ -0,0 +1,547 @@
+package cosem
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplication_AssociationLifecycle(t *testing.T) {
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.2.255"))
+	require.NoError(t, err)
+	require.NoError(t, assoc.SetAttribute(6, AuthenticationMechanismName{MechanismID: 1, MechanismName: OidMechanismLLS}))
+	require.NoError(t, assoc.SetAttribute(7, []byte("12345678")))
+
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	app := NewApplication(nil, securitySetup)
+	clientAddr := mockAddr("client-lls")
+	app.AddAssociation(clientAddr.String(), assoc)
+
+	dataObj, err := NewData(obisOf(t, "0.0.96.1.0.255"), make([]byte, 100))
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+
+	initiate, err := (&InitiateRequest{
+		ProposedConformance:       DefaultConformance(),
+		ProposedMaxPduSize:        64,
+		ProposedDlmsVersionNumber: DLMSVersion,
+	}).Encode()
+	require.NoError(t, err)
+	aarq := func(mechanism []int, password string) *AARQ {
+		return &AARQ{
+			ApplicationContextName:     OidApplicationContextLN,
+			SenderACSERequirements:     true,
+			MechanismName:              mechanism,
+			CallingAuthenticationValue: &AuthenticationValue{Charstring: []byte(password)},
+			UserInformation:            initiate,
+		}
+	}
+	handle := func(t *testing.T, apdu APDU) []byte {
+		src, err := apdu.Encode()
+		require.NoError(t, err)
+		resp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		return resp
+	}
+	associate := func(t *testing.T, req *AARQ) *AARE {
+		resp := &AARE{}
+		require.NoError(t, resp.Decode(handle(t, req)))
+		return resp
+	}
+	get := &GetRequest{
+		Type:                GET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0xC1,
+		AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+	}
+
+	t.Run("NotAssociated", func(t *testing.T) {
+		resp := &ExceptionResponse{}
+		require.NoError(t, resp.Decode(handle(t, get)))
+		assert.Equal(t, STATE_ERROR_SERVICE_NOT_ALLOWED, resp.StateError)
+		assert.Equal(t, SERVICE_ERROR_OPERATION_NOT_POSSIBLE, resp.ServiceError)
+		assert.Equal(t,
+			[]byte{byte(APDU_CONFIRMED_SERVICE_ERROR), byte(CONFIRMED_SERVICE_ERROR_READ), byte(SERVICE_ERROR_TYPE_APPLICATION_REFERENCE), APPLICATION_REFERENCE_INVALID},
+			handle(t, &ReadRequest{Variables: []VariableAccessSpecification{{Type: VARIABLE_ACCESS_NAME, VariableName: 0x0008}}}))
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		oldVersion := aarq(OidMechanismLLS, "12345678")
+		oldVersion.UserInformation = []byte{0x01, 0x00, 0x00, 0x00, 0x05, 0x5F, 0x1F, 0x04, 0x00, 0x00, 0x18, 0x1F, 0xFF, 0xFF}
+		for name, tc := range map[string]struct {
+			req        *AARQ
+			diagnostic ResultSourceDiagnostic
+		}{
+			"WrongPassword":      {aarq(OidMechanismLLS, "00000000"), ResultSourceDiagnostic{ACSEServiceUser: ACSEUserAuthenticationFailed}},
+			"MechanismRequired":  {aarq(nil, ""), ResultSourceDiagnostic{ACSEServiceUser: ACSEUserAuthenticationMechanismRequired}},
+			"OtherMechanism":     {aarq(OidMechanismHLS, ""), ResultSourceDiagnostic{ACSEServiceUser: ACSEUserAuthenticationMechanismNotSupported}},
+			"NotACOSEMMechanism": {aarq([]int{1, 2, 3}, ""), ResultSourceDiagnostic{ACSEServiceUser: ACSEUserAuthenticationMechanismNotSupported}},
+			"UndecodableRequest": {nil, ResultSourceDiagnostic{ACSEServiceProvider: ACSEServiceProviderNoReasonGiven}},
+			"DLMSVersionTooLow":  {oldVersion, ResultSourceDiagnostic{ACSEServiceUser: ACSEUserNoReasonGiven}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				var resp *AARE
+				if tc.req == nil {
+					encoded, err := app.HandleAPDU([]byte{byte(APDU_AARQ), 0x02, 0xA1}, clientAddr)
+					require.NoError(t, err)
+					resp = &AARE{}
+					require.NoError(t, resp.Decode(encoded))
+				} else {
+					resp = associate(t, tc.req)
+				}
+				assert.Equal(t, ResultRejectedPermanent, resp.Result)
+				assert.Equal(t, tc.diagnostic, resp.ResultSourceDiagnostic)
+				assert.Equal(t, AssociationStatusNonAssociated, assoc.Status())
+			})
+		}
+	})
+
+	t.Run("AssociateAndRelease", func(t *testing.T) {
+		resp := associate(t, aarq(OidMechanismLLS, "12345678"))
+		require.Equal(t, ResultAccepted, resp.Result)
+		initiateResp := &InitiateResponse{}
+		require.NoError(t, initiateResp.Decode(resp.UserInformation))
+		assert.Equal(t, AssociationStatusAssociated, assoc.Status())
+		assert.Equal(t, uint16(64), xdlmsContext(assoc).MaxSendPDUSize)
+
+		// An association must be released before it is opened again.
+		assert.Equal(t, ResultRejectedPermanent, associate(t, aarq(OidMechanismLLS, "12345678")).Result)
+		assert.Equal(t, AssociationStatusAssociated, assoc.Status())
+
+		getResp := &GetResponse{}
+		require.NoError(t, getResp.Decode(handle(t, get)))
+		assert.Equal(t, GET_RESPONSE_WITH_DATABLOCK, getResp.Type)
+		assert.Contains(t, app.longGets, assoc)
+
+		assert.Equal(t, []byte{byte(APDU_RLRE), 0x03, 0x80, 0x01, 0x00}, handle(t, &RLRQ{Reason: ReasonNormal}))
+		assert.Equal(t, AssociationStatusNonAssociated, assoc.Status())
+		assert.NotContains(t, app.longGets, assoc)
+		assert.NotContains(t, app.acses, assoc)
+		assert.Equal(t, XDLMSContextInfo{DLMSVersionNumber: DLMSVersion, CypheringInfo: []byte{}}, xdlmsContext(assoc))
+
+		exception := &ExceptionResponse{}
+		require.NoError(t, exception.Decode(handle(t, get)))
+		assert.Equal(t, SERVICE_ERROR_OPERATION_NOT_POSSIBLE, exception.ServiceError)
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		require.Equal(t, ResultAccepted, associate(t, aarq(OidMechanismLLS, "12345678")).Result)
+		require.NoError(t, app.HandleDisconnect(clientAddr))
+		assert.Equal(t, AssociationStatusNonAssociated, assoc.Status())
+		assert.Error(t, app.HandleDisconnect(mockAddr("unknown")))
+	})
+
+	t.Run("UnknownClient", func(t *testing.T) {
+		src, err := aarq(OidMechanismLLS, "12345678").Encode()
+		require.NoError(t, err)
+		encodedAARE, err := app.HandleAPDU(src, mockAddr("unknown"))
+		require.NoError(t, err)
+		aare := &AARE{}
+		require.NoError(t, aare.Decode(encodedAARE))
+		assert.Equal(t, ResultRejectedPermanent, aare.Result)
+		assert.Equal(t, ACSEUserNoReasonGiven, aare.ResultSourceDiagnostic.ACSEServiceUser)
+
+		src, err = get.Encode()
+		require.NoError(t, err)
+		resp, err := app.HandleAPDU(src, mockAddr("unknown"))
+		require.NoError(t, err)
+		exception := &ExceptionResponse{}
+		require.NoError(t, exception.Decode(resp))
+		assert.Equal(t, STATE_ERROR_SERVICE_NOT_ALLOWED, exception.StateError)
+	})
+
+	t.Run("PreEstablished", func(t *testing.T) {
+		require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
+		assert.Equal(t, AssociationStatusAssociated, assoc.Status())
+		assert.Error(t, app.PreEstablishAssociation("unknown"))
+	})
+}
+
+func TestApplication_HLSGMACAuthentication(t *testing.T) {
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.3.255"))
+	require.NoError(t, err)
+	require.NoError(t, assoc.SetAttribute(6, AuthenticationMechanismName{MechanismID: 5, MechanismName: OidMechanismHLS}))
+
+	guek := []byte("0123456789ABCDEF")
+	gak := []byte("FEDCBA9876543210")
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), []byte("CLIENT01"), []byte("SERVER01"), nil, guek, gak)
+	require.NoError(t, err)
+	app := NewApplication(nil, securitySetup)
+	clientAddr := mockAddr("client-hls")
+	app.AddAssociation(clientAddr.String(), assoc)
+
+	dataObj, err := NewData(obisOf(t, "0.0.96.1.0.255"), uint32(7))
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+
+	handle := func(t *testing.T, apdu APDU) []byte {
+		src, err := apdu.Encode()
+		require.NoError(t, err)
+		resp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		return resp
+	}
+	get := &GetRequest{
+		Type:                GET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0xC1,
+		AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+	}
+	replyToHLS := func(t *testing.T, fStoC []byte) ActionResult {
+		resp := &ActionResponse{}
+		require.NoError(t, resp.Decode(handle(t, &ActionRequest{
+			Type:                ACTION_REQUEST_NORMAL,
+			InvokeIDAndPriority: 0xC2,
+			MethodDescriptor:    CosemMethodDescriptor{ClassID: AssociationLNClassID, InstanceID: currentAssociationLN, MethodID: 1},
+			Parameters:          fStoC,
+		})))
+		return resp.Result
+	}
+
+	ctos := []byte("CtoS0123")
+	open := func(t *testing.T) []byte {
+		aare := &AARE{}
+		require.NoError(t, aare.Decode(handle(t, &AARQ{
+			ApplicationContextName:     OidApplicationContextLN,
+			SenderACSERequirements:     true,
+			MechanismName:              OidMechanismHLS,
+			CallingAuthenticationValue: &AuthenticationValue{Charstring: ctos},
+		})))
+		require.Equal(t, ResultAccepted, aare.Result)
+		assert.Equal(t, ACSEUserAuthenticationRequired, aare.ResultSourceDiagnostic.ACSEServiceUser)
+		require.NotNil(t, aare.RespondingAuthenticationValue)
+		assert.Equal(t, AssociationStatusAssociationPending, assoc.Status())
+		return aare.RespondingAuthenticationValue.Charstring
+	}
+	stoc := open(t)
+
+	// Only reply_to_HLS_authentication is served while the association is pending.
+	exception := &ExceptionResponse{}
+	require.NoError(t, exception.Decode(handle(t, get)))
+	assert.Equal(t, SERVICE_ERROR_OPERATION_NOT_POSSIBLE, exception.ServiceError)
+
+	// A wrong answer takes no server frame counter and releases the association,
+	// so the challenge cannot be guessed at.
+	wrong, err := HLSGMAC(guek, gak, []byte("CtoS0123"), []byte("CLIENT01"), 1)
+	require.NoError(t, err)
+	assert.Equal(t, ActionResult{IsDataAccessResult: true, Value: READ_WRITE_DENIED}, replyToHLS(t, wrong))
+	assert.Equal(t, AssociationStatusNonAssociated, assoc.Status())
+	assert.Equal(t, uint32(0), assoc.ServerInvocationCounter())
+	require.NoError(t, exception.Decode(handle(t, get)))
+
+	stoc = open(t)
+	fStoC, err := HLSGMAC(guek, gak, stoc, []byte("CLIENT01"), 2)
+	require.NoError(t, err)
+	result := replyToHLS(t, fStoC)
+	require.False(t, result.IsDataAccessResult)
+	fCtoS, ok := result.Value.([]byte)
+	require.True(t, ok)
+	assert.Equal(t, []byte{0x10, 0x00, 0x00, 0x00, 0x01}, fCtoS[:5])
+	assert.NoError(t, VerifyHLSGMAC(fCtoS, guek, gak, ctos, []byte("SERVER01")))
+	assert.Equal(t, AssociationStatusAssociated, assoc.Status())
+
+	getResp := &GetResponse{}
+	require.NoError(t, getResp.Decode(handle(t, get)))
+	assert.Equal(t, uint32(7), getResp.Result.Value)
+
+	// Once associated, the challenge cannot be answered again.
+	assert.Equal(t, ActionResult{IsDataAccessResult: true, Value: READ_WRITE_DENIED}, replyToHLS(t, fStoC))
+
+	// The frame counter of f(StoC) is that of the client: a new association
+	// answered under a counter already used is refused.
+	rlre := &RLRE{}
+	require.NoError(t, rlre.Decode(handle(t, &RLRQ{})))
+	stoc = open(t)
+	replayed, err := HLSGMAC(guek, gak, stoc, []byte("CLIENT01"), 2)
+	require.NoError(t, err)
+	assert.Equal(t, ActionResult{IsDataAccessResult: true, Value: READ_WRITE_DENIED}, replyToHLS(t, replayed))
+	assert.Equal(t, AssociationStatusNonAssociated, assoc.Status())
+}
+
+func TestApplication_HLSAuthenticationSN(t *testing.T) {
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.3.255"))
+	require.NoError(t, err)
+	require.NoError(t, assoc.SetAttribute(6, AuthenticationMechanismName{MechanismID: 5, MechanismName: OidMechanismHLS}))
+	assocSN, err := NewAssociationSN(obisOf(t, "0.0.40.0.1.255"), assoc)
+	require.NoError(t, err)
+
+	guek := []byte("0123456789ABCDEF")
+	gak := []byte("FEDCBA9876543210")
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), []byte("CLIENT01"), []byte("SERVER01"), nil, guek, gak)
+	require.NoError(t, err)
+	app := NewApplication(nil, securitySetup)
+	clientAddr := mockAddr("client-hls-sn")
+	app.AddAssociation(clientAddr.String(), assoc)
+
+	dataObj, err := NewData(obisOf(t, "0.0.96.1.0.255"), uint32(7))
+	require.NoError(t, err)
+	require.NoError(t, app.RegisterShortName(assocSN, 0xFA00))
+	require.NoError(t, app.RegisterShortName(dataObj, 0x0100))
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+	require.NoError(t, app.PopulateObjectListSN(assocSN, []ObisCode{assocSN.InstanceID, dataObj.InstanceID}))
+
+	handle := func(t *testing.T, apdu APDU) []byte {
+		src, err := apdu.Encode()
+		require.NoError(t, err)
+		resp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		return resp
+	}
+	readData := &ReadRequest{Variables: []VariableAccessSpecification{{Type: VARIABLE_ACCESS_NAME, VariableName: 0x0108}}}
+	// reply_to_HLS_authentication is method 8 of the Association SN, whose
+	// methods start at offset 0x20.
+	replyToHLS := func(t *testing.T, fStoC []byte) ReadResult {
+		resp := &ReadResponse{}
+		require.NoError(t, resp.Decode(handle(t, &ReadRequest{Variables: []VariableAccessSpecification{
+			{Type: VARIABLE_ACCESS_PARAMETERIZED, VariableName: 0xFA58, Parameter: fStoC},
+		}})))
+		require.Len(t, resp.Results, 1)
+		return resp.Results[0]
+	}
+
+	// The SN context negotiates no Action service.
+	initiate, err := (&InitiateRequest{
+		ProposedConformance:       NewConformance(ConformanceRead, ConformanceWrite, ConformanceParameterizedAccess),
+		ProposedDlmsVersionNumber: DLMSVersion,
+	}).Encode()
+	require.NoError(t, err)
+	ctos := []byte("CtoS0123")
+	open := func(t *testing.T) []byte {
+		aare := &AARE{}
+		require.NoError(t, aare.Decode(handle(t, &AARQ{
+			ApplicationContextName:     OidApplicationContextSN,
+			SenderACSERequirements:     true,
+			MechanismName:              OidMechanismHLS,
+			CallingAuthenticationValue: &AuthenticationValue{Charstring: ctos},
+			UserInformation:            initiate,
+		})))
+		require.Equal(t, ResultAccepted, aare.Result)
+		require.NotNil(t, aare.RespondingAuthenticationValue)
+		assert.Equal(t, AssociationStatusAssociationPending, assoc.Status())
+		return aare.RespondingAuthenticationValue.Charstring
+	}
+	stoc := open(t)
+
+	// Only reply_to_HLS_authentication is served while the association is pending.
+	assert.Equal(t, byte(APDU_CONFIRMED_SERVICE_ERROR), handle(t, readData)[0])
+	assert.Equal(t, byte(APDU_CONFIRMED_SERVICE_ERROR), handle(t, &WriteRequest{
+		Variables: []VariableAccessSpecification{{Type: VARIABLE_ACCESS_NAME, VariableName: 0xFA48}},
+		Values:    []interface{}{[]byte("secret")},
+	})[0])
+
+	wrong, err := HLSGMAC(guek, gak, []byte("CtoS0123"), []byte("CLIENT01"), 1)
+	require.NoError(t, err)
+	assert.Equal(t, readAccessError(READ_WRITE_DENIED), replyToHLS(t, wrong))
+	assert.Equal(t, AssociationStatusNonAssociated, assoc.Status())
+
+	stoc = open(t)
+	fStoC, err := HLSGMAC(guek, gak, stoc, []byte("CLIENT01"), 2)
+	require.NoError(t, err)
+	result := replyToHLS(t, fStoC)
+	require.Equal(t, READ_RESULT_DATA, result.Type)
+	fCtoS, ok := result.Value.([]byte)
+	require.True(t, ok)
+	assert.NoError(t, VerifyHLSGMAC(fCtoS, guek, gak, ctos, []byte("SERVER01")))
+	assert.Equal(t, AssociationStatusAssociated, assoc.Status())
+
+	readResp := &ReadResponse{}
+	require.NoError(t, readResp.Decode(handle(t, readData)))
+	require.Len(t, readResp.Results, 1)
+	assert.Equal(t, uint32(7), readResp.Results[0].Value)
+}
+
+func TestApplication_HLSAuthenticationMechanisms(t *testing.T) {
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+	secret := []byte("HLSsecret")
+
+	digest := func(mechanism byte) func(ctos, stoc []byte) ([]byte, error) {
+		return func(ctos, stoc []byte) ([]byte, error) {
+			return HLSDigest(mechanism, secret, stoc, ctos, clientSystemTitle, serverSystemTitle)
+		}
+	}
+	verifyDigest := func(mechanism byte) func(fCtoS, ctos, stoc []byte) error {
+		return func(fCtoS, ctos, stoc []byte) error {
+			expected, err := HLSDigest(mechanism, secret, ctos, stoc, serverSystemTitle, clientSystemTitle)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(fCtoS, expected) {
+				return ErrAuthenticationFailed
+			}
+			return nil
+		}
+	}
+
+	tests := map[string]struct {
+		mechanism    AuthenticationMechanismName
+		computeFStoC func(ctos, stoc []byte) ([]byte, error)
+		verifyFCtoS  func(fCtoS, ctos, stoc []byte) error
+	}{
+		"MD5": {
+			mechanism:    AuthenticationMechanismName{MechanismID: MechanismHLSMD5, MechanismName: OidMechanismHLSMD5},
+			computeFStoC: digest(MechanismHLSMD5),
+			verifyFCtoS:  verifyDigest(MechanismHLSMD5),
+		},
+		"SHA1": {
+			mechanism:    AuthenticationMechanismName{MechanismID: MechanismHLSSHA1, MechanismName: OidMechanismHLSSHA1},
+			computeFStoC: digest(MechanismHLSSHA1),
+			verifyFCtoS:  verifyDigest(MechanismHLSSHA1),
+		},
+		"SHA256": {
+			mechanism:    AuthenticationMechanismName{MechanismID: MechanismHLSSHA256, MechanismName: OidMechanismHLSSHA256},
+			computeFStoC: digest(MechanismHLSSHA256),
+			verifyFCtoS:  verifyDigest(MechanismHLSSHA256),
+		},
+		"ECDSA": {
+			mechanism: AuthenticationMechanismName{MechanismID: MechanismHLSECDSA, MechanismName: OidMechanismHLSECDSA},
+			computeFStoC: func(ctos, stoc []byte) ([]byte, error) {
+				return HLSSign(clientKey, stoc, ctos, clientSystemTitle, serverSystemTitle)
+			},
+			verifyFCtoS: func(fCtoS, ctos, stoc []byte) error {
+				return VerifyHLSSignature(fCtoS, &serverKey.PublicKey, ctos, stoc, serverSystemTitle, clientSystemTitle)
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.3.255"))
+			require.NoError(t, err)
+			require.NoError(t, assoc.SetAttribute(6, tt.mechanism))
+			require.NoError(t, assoc.SetAttribute(7, secret))
+
+			securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), clientSystemTitle, serverSystemTitle, nil, nil, nil)
+			require.NoError(t, err)
+			securitySetup.ServerSigningKey = serverKey
+			securitySetup.ClientSigningKey = &clientKey.PublicKey
+			app := NewApplication(nil, securitySetup)
+			clientAddr := mockAddr("client-" + name)
+			app.AddAssociation(clientAddr.String(), assoc)
+
+			handle := func(t *testing.T, apdu APDU) []byte {
+				src, err := apdu.Encode()
+				require.NoError(t, err)
+				resp, err := app.HandleAPDU(src, clientAddr)
+				require.NoError(t, err)
+				return resp
+			}
+			replyToHLS := func(t *testing.T, fStoC []byte) ActionResult {
+				resp := &ActionResponse{}
+				require.NoError(t, resp.Decode(handle(t, &ActionRequest{
+					Type:                ACTION_REQUEST_NORMAL,
+					InvokeIDAndPriority: 0xC2,
+					MethodDescriptor:    CosemMethodDescriptor{ClassID: AssociationLNClassID, InstanceID: currentAssociationLN, MethodID: 1},
+					Parameters:          fStoC,
+				})))
+				return resp.Result
+			}
+
+			ctos := []byte("CtoS0123")
+			open := func(t *testing.T) []byte {
+				aare := &AARE{}
+				require.NoError(t, aare.Decode(handle(t, &AARQ{
+					ApplicationContextName:     OidApplicationContextLN,
+					SenderACSERequirements:     true,
+					MechanismName:              tt.mechanism.MechanismName,
+					CallingAuthenticationValue: &AuthenticationValue{Charstring: ctos},
+				})))
+				require.Equal(t, ResultAccepted, aare.Result)
+				require.NotNil(t, aare.RespondingAuthenticationValue)
+				assert.Equal(t, AssociationStatusAssociationPending, assoc.Status())
+				return aare.RespondingAuthenticationValue.Charstring
+			}
+			stoc := open(t)
+
+			// An answer to the wrong challenge is refused and ends the association.
+			wrong, err := tt.computeFStoC(ctos, ctos)
+			require.NoError(t, err)
+			assert.Equal(t, ActionResult{IsDataAccessResult: true, Value: READ_WRITE_DENIED}, replyToHLS(t, wrong))
+			assert.Equal(t, AssociationStatusNonAssociated, assoc.Status())
+
+			stoc = open(t)
+			fStoC, err := tt.computeFStoC(ctos, stoc)
+			require.NoError(t, err)
+			result := replyToHLS(t, fStoC)
+			require.False(t, result.IsDataAccessResult)
+			fCtoS, ok := result.Value.([]byte)
+			require.True(t, ok)
+			assert.NoError(t, tt.verifyFCtoS(fCtoS, ctos, stoc))
+			assert.Equal(t, AssociationStatusAssociated, assoc.Status())
+		})
+	}
+}
+
+func TestApplication_ClientSystemTitle(t *testing.T) {
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+	guek := []byte("0123456789ABCDEF")
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, serverSystemTitle, nil, guek, nil)
+	require.NoError(t, err)
+	app := NewApplication(nil, securitySetup)
+
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	clientAddr := mockAddr("client-title")
+	app.AddAssociation(clientAddr.String(), assoc)
+
+	dataObj, err := NewData(obisOf(t, "1.0.0.3.0.255"), uint32(31))
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+
+	src, err := (&AARQ{ApplicationContextName: OidApplicationContextLN, CallingAPTitle: clientSystemTitle}).Encode()
+	require.NoError(t, err)
+	encodedAARE, err := app.HandleAPDU(src, clientAddr)
+	require.NoError(t, err)
+	aare := &AARE{}
+	require.NoError(t, aare.Decode(encodedAARE))
+	require.Equal(t, ResultAccepted, aare.Result)
+	assert.Equal(t, serverSystemTitle, aare.RespondingAPTitle)
+
+	req, err := (&GetRequest{
+		Type:                GET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0x81,
+		AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+	}).Encode()
+	require.NoError(t, err)
+	header := func(frameCounter uint32) *SecurityHeader {
+		return &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: frameCounter}
+	}
+
+	// Requests are ciphered under the client title, responses under the server title.
+	resp, err := app.HandleAPDU(cipherAPDU(t, APDU_GLO_GET_REQUEST, guek, req, clientSystemTitle, header(1), SecuritySuite0), clientAddr)
+	require.NoError(t, err)
+	require.Equal(t, byte(APDU_GLO_GET_RESPONSE), resp[0])
+	_, plaintext := decipherAPDU(t, resp, guek, serverSystemTitle, SecuritySuite0, 0)
+	getResp := &GetResponse{}
+	require.NoError(t, getResp.Decode(plaintext))
+	assert.Equal(t, uint32(31), getResp.Result.Value)
+
+	// Ciphered traffic sent under another system title is refused.
+	glo, err := NewGeneralGloCiphering(guek, req, []byte("CLIENT02"), header(2), SecuritySuite0)
+	require.NoError(t, err)
+	src, err = glo.Encode()
+	require.NoError(t, err)
+	resp, err = app.HandleAPDU(src, clientAddr)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp)
+
+	glo, err = NewGeneralGloCiphering(guek, req, clientSystemTitle, header(3), SecuritySuite0)
+	require.NoError(t, err)
+	src, err = glo.Encode()
+	require.NoError(t, err)
+	resp, err = app.HandleAPDU(src, clientAddr)
+	require.NoError(t, err)
+	assert.Equal(t, byte(APDU_GENERAL_GLO_CIPHERING), resp[0])
+}
@@ -2,7 +2,10 @@ package cosem
 
 import (
 	"encoding/asn1"
+	"fmt"
 	"reflect"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
 )
 
 // AssociationLNClassID is the class ID for the "Association LN" interface class.
@@ -15,6 +18,9 @@ const AssociationLNVersion byte = 0
 type AssociationLN struct {
 	BaseImpl
 	serverInvocationCounter uint32
+	// verifyHLS checks the f(StoC) passed to reply_to_HLS_authentication while
+	// the association is pending and returns f(CtoS).
+	verifyHLS func(fStoC []byte) ([]byte, error)
 }
 
 // ObjectListElement represents an element in the object_list attribute of the Association LN class.
@@ -83,11 +89,14 @@ type AssociationInformation struct {
 	UserList                []UserListEntry
 }
 
+// Methods of the Association LN class. associate, get_association_information
+// and get_application_context_name_list are manufacturer specific, numbered from
+// 128 so that they never stand in for a method of the standard class.
 const (
-	associationLNMethodAssociate byte = iota + 1
-	associationLNMethodReplyToHLS
-	associationLNMethodGetAssociationInformation
-	associationLNMethodGetApplicationContextNameList
+	associationLNMethodReplyToHLS                    byte = 1
+	associationLNMethodAssociate                     byte = 128
+	associationLNMethodGetAssociationInformation     byte = 129
+	associationLNMethodGetApplicationContextNameList byte = 130
 )
 
 // AccessRights represents the access_rights attribute of an ObjectListElement.
@@ -226,7 +235,7 @@ func NewAssociationLN(obis ObisCode) (*AssociationLN, error) {
 	assoc.Methods[associationLNMethodReplyToHLS] = MethodDescriptor{
 		Access:     MethodAccessAllowed,
 		ParamTypes: []reflect.Type{reflect.TypeOf([]byte{})},
-		ReturnType: reflect.TypeOf(true),
+		ReturnType: reflect.TypeOf([]byte{}),
 		Handler:    assoc.handleReplyToHLSAuthentication,
 	}
 
@@ -266,15 +275,25 @@ func (a *AssociationLN) handleReplyToHLSAuthentication(params []interface{}) (in
 		return nil, ErrAccessDenied
 	}
 
-	challenge := params[0].([]byte)
-	if len(challenge) == 0 {
+	fStoC := params[0].([]byte)
+	if len(fStoC) == 0 {
 		return nil, ErrInvalidParameter
 	}
+	if a.verifyHLS == nil {
+		return nil, ErrAccessDenied
+	}
+	// The challenge can only be answered once.
+	verify := a.verifyHLS
+	a.verifyHLS = nil
+	fCtoS, err := verify(fStoC)
+	if err != nil {
+		return nil, ErrAccessDenied
+	}
 
 	statusAttr.Value = AssociationStatusAssociated
 	a.Attributes[8] = statusAttr
 
-	return true, nil
+	return fCtoS, nil
 }
 
 func (a *AssociationLN) handleGetAssociationInformation(_ []interface{}) (interface{}, error) {
@@ -315,6 +334,36 @@ func (a *AssociationLN) SetServerInvocationCounter(counter uint32) {
 	a.serverInvocationCounter = counter
 }
 
+// SetXDLMSContextInfo records the xDLMS context negotiated for the association
+// in the xDLMS_context_info attribute, which clients can only read.
+func (a *AssociationLN) SetXDLMSContextInfo(info XDLMSContextInfo) {
+	attr := a.Attributes[5]
+	attr.Value = info
+	a.Attributes[5] = attr
+}
+
+// Status returns the association_status of the association.
+func (a *AssociationLN) Status() AssociationStatus {
+	return a.Attributes[8].Value.(AssociationStatus)
+}
+
+// setStatus updates the association_status attribute, which clients can only read.
+func (a *AssociationLN) setStatus(status AssociationStatus) {
+	attr := a.Attributes[8]
+	attr.Value = status
+	a.Attributes[8] = attr
+	if status != AssociationStatusAssociationPending {
+		a.verifyHLS = nil
+	}
+}
+
+// awaitHLS leaves the association pending until reply_to_HLS_authentication is
+// invoked with an f(StoC) accepted by verify.
+func (a *AssociationLN) awaitHLS(verify func(fStoC []byte) ([]byte, error)) {
+	a.setStatus(AssociationStatusAssociationPending)
+	a.verifyHLS = verify
+}
+
 // ServerInvocationCounter returns the last server-side invocation counter value.
 func (a *AssociationLN) ServerInvocationCounter() uint32 {
 	return a.serverInvocationCounter
@@ -372,6 +421,83 @@ func (a *AssociationLN) AddObject(obj BaseInterface) {
 	}
 }
 
+// Access selectors supported by the object_list attribute of the "Association LN" interface class.
+const (
+	AssociationLNClassListSelector    uint8 = 1
+	AssociationLNObjectIDListSelector uint8 = 2
+)
+
+// GetAttributeWithSelector reads object_list filtered by a class_list (selector 1,
+// an array of class IDs) or an object_id_list (selector 2, an array of
+// {class_id, logical_name} structures).
+func (a *AssociationLN) GetAttributeWithSelector(attributeID byte, selector uint8, parameters interface{}) (interface{}, error) {
+	if attributeID != 2 {
+		return nil, fmt.Errorf("%w: attribute %d has no selective access", ErrAccessSelectorNotSupported, attributeID)
+	}
+
+	if selector != AssociationLNClassListSelector && selector != AssociationLNObjectIDListSelector {
+		return nil, fmt.Errorf("%w: %d", ErrAccessSelectorNotSupported, selector)
+	}
+
+	value, err := a.GetAttribute(2)
+	if err != nil {
+		return nil, err
+	}
+	objList, _ := value.([]ObjectListElement)
+
+	list, ok := parameters.(axdr.Array)
+	if !ok {
+		return nil, fmt.Errorf("%w: access parameters must be an array", ErrInvalidParameter)
+	}
+
+	var match func(elem ObjectListElement) bool
+	switch selector {
+	case AssociationLNClassListSelector:
+		classes := make(map[uint16]bool, len(list))
+		for _, item := range list {
+			classID, ok := item.(uint16)
+			if !ok {
+				return nil, fmt.Errorf("%w: class_list entries must be long-unsigned", ErrInvalidParameter)
+			}
+			classes[classID] = true
+		}
+		match = func(elem ObjectListElement) bool { return classes[elem.ClassID] }
+	case AssociationLNObjectIDListSelector:
+		ids := make(map[[8]byte]bool, len(list))
+		for _, item := range list {
+			id, ok := item.(axdr.Structure)
+			if !ok || len(id) != 2 {
+				return nil, fmt.Errorf("%w: object_id must be a structure of 2 elements", ErrInvalidParameter)
+			}
+			classID, ok1 := id[0].(uint16)
+			ln, ok2 := id[1].([]byte)
+			if !ok1 || !ok2 || len(ln) != 6 {
+				return nil, fmt.Errorf("%w: malformed object_id", ErrInvalidParameter)
+			}
+			ids[objectIDKey(classID, ln)] = true
+		}
+		match = func(elem ObjectListElement) bool {
+			ln := elem.InstanceID.Bytes()
+			return ids[objectIDKey(elem.ClassID, ln[:])]
+		}
+	}
+
+	selected := []ObjectListElement{}
+	for _, elem := range objList {
+		if match(elem) {
+			selected = append(selected, elem)
+		}
+	}
+	return selected, nil
+}
+
+func objectIDKey(classID uint16, logicalName []byte) [8]byte {
+	var key [8]byte
+	key[0], key[1] = byte(classID>>8), byte(classID)
+	copy(key[2:], logicalName)
+	return key
+}
+
 // CheckAttributeAccess verifies if a specific attribute has the required access rights.
 func (a *AssociationLN) CheckAttributeAccess(obis ObisCode, attributeID byte, requiredAccess AttributeAccessRight) bool {
 	objListAttr, ok := a.Attributes[2]
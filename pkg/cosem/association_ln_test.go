@@ -4,6 +4,7 @@ import (
 	"encoding/asn1"
 	"testing"
 
+	"github.com/gvtret/spodes-go/pkg/axdr"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -77,8 +78,35 @@ func TestAssociationLN_AssociationFlow(t *testing.T) {
 	_, err = associationLN.Invoke(associationLNMethodAssociate, nil)
 	assert.ErrorIs(t, err, ErrAccessDenied)
 
+	// Method 2 is change_HLS_secret of the standard class, not associate.
+	_, err = associationLN.Invoke(2, nil)
+	assert.ErrorIs(t, err, ErrMethodNotSupported)
+
+	// Without an HLS authentication to complete, no reply is accepted.
+	_, err = associationLN.Invoke(associationLNMethodReplyToHLS, []interface{}{[]byte{0x01}})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	awaitHLS := func() {
+		associationLN.awaitHLS(func(fStoC []byte) ([]byte, error) {
+			if fStoC[0] != 0x01 {
+				return nil, ErrAuthenticationFailed
+			}
+			return []byte{0x0F}, nil
+		})
+	}
+	awaitHLS()
+	_, err = associationLN.Invoke(associationLNMethodReplyToHLS, []interface{}{[]byte{0x02}})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	assert.Equal(t, AssociationStatusAssociationPending, associationLN.Status())
+
+	// The challenge can only be answered once.
 	_, err = associationLN.Invoke(associationLNMethodReplyToHLS, []interface{}{[]byte{0x01}})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	awaitHLS()
+	res, err = associationLN.Invoke(associationLNMethodReplyToHLS, []interface{}{[]byte{0x01}})
 	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x0F}, res)
 
 	attr8, err := associationLN.GetAttribute(8)
 	assert.NoError(t, err)
@@ -95,10 +123,7 @@ func TestAssociationLN_GetAssociationInformation(t *testing.T) {
 	_, err := associationLN.Invoke(associationLNMethodGetAssociationInformation, nil)
 	assert.ErrorIs(t, err, ErrAccessDenied)
 
-	_, err = associationLN.Invoke(associationLNMethodAssociate, nil)
-	assert.NoError(t, err)
-	_, err = associationLN.Invoke(associationLNMethodReplyToHLS, []interface{}{[]byte{0x01}})
-	assert.NoError(t, err)
+	associationLN.setStatus(AssociationStatusAssociated)
 
 	res, err := associationLN.Invoke(associationLNMethodGetAssociationInformation, nil)
 	assert.NoError(t, err)
@@ -143,3 +168,40 @@ func TestAssociationLN_ReplyToHLSAuthentication_Unauthorized(t *testing.T) {
 	_, err := associationLN.Invoke(associationLNMethodReplyToHLS, []interface{}{[]byte{0x01}})
 	assert.ErrorIs(t, err, ErrAccessDenied)
 }
+
+func TestAssociationLN_ObjectListSelectiveAccess(t *testing.T) {
+	obis, _ := NewObisCodeFromString("0.0.40.0.0.255")
+	associationLN, _ := NewAssociationLN(*obis)
+
+	obisData, _ := NewObisCodeFromString("1.0.0.3.0.255")
+	dataObj, _ := NewData(*obisData, uint32(12345))
+	obisClock, _ := NewObisCodeFromString("0.0.1.0.0.255")
+	clockObj, _ := NewClock(*obisClock)
+	associationLN.AddObject(dataObj)
+	associationLN.AddObject(clockObj)
+
+	res, err := associationLN.GetAttributeWithSelector(2, AssociationLNClassListSelector, axdr.Array{ClockClassID})
+	assert.NoError(t, err)
+	list := res.([]ObjectListElement)
+	assert.Len(t, list, 1)
+	assert.Equal(t, *obisClock, list[0].InstanceID)
+
+	dataLN := obisData.Bytes()
+	res, err = associationLN.GetAttributeWithSelector(2, AssociationLNObjectIDListSelector, axdr.Array{
+		axdr.Structure{DataClassID, dataLN[:]},
+		axdr.Structure{ClockClassID, dataLN[:]},
+	})
+	assert.NoError(t, err)
+	list = res.([]ObjectListElement)
+	assert.Len(t, list, 1)
+	assert.Equal(t, *obisData, list[0].InstanceID)
+
+	_, err = associationLN.GetAttributeWithSelector(2, AssociationLNClassListSelector, axdr.Array{uint8(1)})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = associationLN.GetAttributeWithSelector(2, AssociationLNObjectIDListSelector, uint16(1))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = associationLN.GetAttributeWithSelector(2, 3, axdr.Array{})
+	assert.ErrorIs(t, err, ErrAccessSelectorNotSupported)
+	_, err = associationLN.GetAttributeWithSelector(12, AssociationLNClassListSelector, axdr.Array{})
+	assert.ErrorIs(t, err, ErrAccessSelectorNotSupported)
+}
@@ -0,0 +1,181 @@
+package cosem
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
+)
+
+// AssociationSNClassID is the class ID for the "Association SN" interface class.
+const AssociationSNClassID uint16 = 12
+
+// AssociationSNVersion is the version of the "Association SN" interface class.
+const AssociationSNVersion byte = 0
+
+// AssociationSN represents the COSEM "Association SN" interface class. It presents
+// the objects of an association to a client using short name referencing: every
+// entry of object_list carries the base_name the object is read and written by.
+//
+// The attribute values are kept in their A-XDR form so that they can be read by
+// short name clients as they are:
+//
+//	object_list_element ::= structure {base_name: long, class_id: long-unsigned,
+//	                                   version: unsigned, logical_name: octet-string}
+//	access_right        ::= structure {base_name: long,
+//	                                   attribute_access: array of structure {attribute_id: integer, access_mode: unsigned, access_selectors: null-data},
+//	                                   method_access: array of structure {method_id: integer, access_mode: boolean}}
+//
+// An AssociationSN presents an AssociationLN to short name clients. The
+// Application checks short name requests against the access rights of that
+// association, so access_rights_list is built from them, and the methods of the
+// AssociationSN change its secret and authenticate its client.
+type AssociationSN struct {
+	BaseImpl
+	association *AssociationLN
+}
+
+// Methods of the "Association SN" interface class.
+const (
+	associationSNMethodChangeLLSSecret byte = 5
+	associationSNMethodChangeHLSSecret byte = 6
+	associationSNMethodReplyToHLS      byte = 8
+)
+
+// NewAssociationSN creates a new instance of the "Association SN" interface class
+// presenting association.
+func NewAssociationSN(obis ObisCode, association *AssociationLN) (*AssociationSN, error) {
+	if association == nil {
+		return nil, fmt.Errorf("%w: Association SN needs an association", ErrInvalidParameter)
+	}
+	attributes := map[byte]AttributeDescriptor{
+		1: { // logical_name
+			Type:   reflect.TypeOf(ObisCode{}),
+			Access: AttributeRead,
+			Value:  obis,
+		},
+		2: { // object_list
+			Type:   reflect.TypeOf(axdr.Array{}),
+			Access: AttributeRead,
+			Value:  axdr.Array{},
+		},
+		3: { // access_rights_list
+			Type:   reflect.TypeOf(axdr.Array{}),
+			Access: AttributeRead,
+			Value:  axdr.Array{},
+		},
+		4: { // security_setup_reference
+			Type:   reflect.TypeOf(ObisCode{}),
+			Access: AttributeRead,
+			Value:  ObisCode{},
+		},
+	}
+
+	assoc := &AssociationSN{
+		BaseImpl: BaseImpl{
+			ClassID:    AssociationSNClassID,
+			InstanceID: obis,
+			Attributes: attributes,
+			Methods:    map[byte]MethodDescriptor{},
+		},
+		association: association,
+	}
+
+	assoc.Methods[associationSNMethodChangeLLSSecret] = MethodDescriptor{
+		Access:     MethodAccessAllowed,
+		ParamTypes: []reflect.Type{reflect.TypeOf([]byte{})},
+		Handler:    assoc.handleChangeSecret,
+	}
+
+	assoc.Methods[associationSNMethodChangeHLSSecret] = MethodDescriptor{
+		Access:     MethodAccessAllowed,
+		ParamTypes: []reflect.Type{reflect.TypeOf([]byte{})},
+		Handler:    assoc.handleChangeSecret,
+	}
+
+	assoc.Methods[associationSNMethodReplyToHLS] = MethodDescriptor{
+		Access:     MethodAccessAllowed,
+		ParamTypes: []reflect.Type{reflect.TypeOf([]byte{})},
+		ReturnType: reflect.TypeOf([]byte{}),
+		Handler:    association.handleReplyToHLSAuthentication,
+	}
+
+	return assoc, nil
+}
+
+// Association returns the association the AssociationSN presents.
+func (a *AssociationSN) Association() *AssociationLN {
+	return a.association
+}
+
+// handleChangeSecret replaces the secret of the association, which the client
+// authenticates with when it next opens it.
+func (a *AssociationSN) handleChangeSecret(params []interface{}) (interface{}, error) {
+	secret := params[0].([]byte)
+	if len(secret) == 0 {
+		return nil, ErrInvalidParameter
+	}
+	attr := a.association.Attributes[7]
+	attr.Value = append([]byte(nil), secret...)
+	a.association.Attributes[7] = attr
+	return nil, nil
+}
+
+// AddObject adds obj, known by baseName, to the object_list and access_rights_list
+// attributes. The access rights listed are those obj has in the association.
+func (a *AssociationSN) AddObject(obj BaseInterface, baseName uint16) {
+	obis := obj.GetInstanceID()
+	ln := obis.Bytes()
+	objList := a.Attributes[2].Value.(axdr.Array)
+	objList = append(objList, axdr.Structure{
+		int16(baseName),
+		obj.GetClassID(),
+		uint8(0), // Version is not available in BaseInterface, default to 0
+		ln[:],
+	})
+	a.setAttributeValue(2, objList)
+
+	attrAccessItems := axdr.Array{}
+	for i := byte(1); i <= 20; i++ { // Assuming max 20 attributes
+		var accessMode AttributeAccessRight
+		if a.association.CheckAttributeAccess(obis, i, Read) {
+			accessMode |= Read
+		}
+		if a.association.CheckAttributeAccess(obis, i, Write) {
+			accessMode |= Write
+		}
+		if accessMode == NoAccess {
+			continue
+		}
+		attrAccessItems = append(attrAccessItems, axdr.Structure{int8(i), uint8(accessMode), nil})
+	}
+
+	methodAccessItems := axdr.Array{}
+	for i := byte(1); i <= 20; i++ { // Assuming max 20 methods
+		if a.association.CheckMethodAccess(obis, i) {
+			methodAccessItems = append(methodAccessItems, axdr.Structure{int8(i), true})
+		}
+	}
+
+	accessList := a.Attributes[3].Value.(axdr.Array)
+	accessList = append(accessList, axdr.Structure{int16(baseName), attrAccessItems, methodAccessItems})
+	a.setAttributeValue(3, accessList)
+}
+
+// BaseName returns the base_name of the object identified by obis in object_list.
+func (a *AssociationSN) BaseName(obis ObisCode) (uint16, bool) {
+	ln := obis.Bytes()
+	for _, item := range a.Attributes[2].Value.(axdr.Array) {
+		elem := item.(axdr.Structure)
+		if string(elem[3].([]byte)) == string(ln[:]) {
+			return uint16(elem[0].(int16)), true
+		}
+	}
+	return 0, false
+}
+
+func (a *AssociationSN) setAttributeValue(attributeID byte, value axdr.Array) {
+	attr := a.Attributes[attributeID]
+	attr.Value = value
+	a.Attributes[attributeID] = attr
+}
@@ -0,0 +1,87 @@
+package cosem
+
+import (
+	"testing"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssociationSN_AddObject(t *testing.T) {
+	obis, _ := NewObisCodeFromString("0.0.40.0.0.255")
+	associationLN, err := NewAssociationLN(*obis)
+	require.NoError(t, err)
+	associationSN, err := NewAssociationSN(*obis, associationLN)
+	require.NoError(t, err)
+	assert.Equal(t, AssociationSNClassID, associationSN.GetClassID())
+	assert.True(t, associationSN.Association() == associationLN)
+
+	obisData, _ := NewObisCodeFromString("1.0.0.3.0.255")
+	dataObj, _ := NewData(*obisData, uint32(12345))
+	associationLN.AddObject(dataObj)
+	associationSN.AddObject(dataObj, 0x0100)
+
+	objList, err := associationSN.GetAttribute(2)
+	require.NoError(t, err)
+	ln := obisData.Bytes()
+	assert.Equal(t, axdr.Array{axdr.Structure{int16(0x0100), DataClassID, uint8(0), ln[:]}}, objList)
+
+	accessList, err := associationSN.GetAttribute(3)
+	require.NoError(t, err)
+	assert.Equal(t, axdr.Array{axdr.Structure{
+		int16(0x0100),
+		axdr.Array{axdr.Structure{int8(1), uint8(Read), nil}, axdr.Structure{int8(2), uint8(ReadWrite), nil}},
+		axdr.Array{},
+	}}, accessList)
+
+	// The lists are sent to short name clients as they are.
+	_, err = axdr.Encode(objList)
+	assert.NoError(t, err)
+	_, err = axdr.Encode(accessList)
+	assert.NoError(t, err)
+
+	baseName, ok := associationSN.BaseName(*obisData)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(0x0100), baseName)
+	_, ok = associationSN.BaseName(*obis)
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, associationSN.SetAttribute(2, axdr.Array{}), ErrAccessDenied)
+
+	// An object the association does not list has no access rights.
+	other, _ := NewData(obisOf(t, "1.0.0.4.0.255"), uint32(1))
+	associationSN.AddObject(other, 0x0200)
+	accessList, err = associationSN.GetAttribute(3)
+	require.NoError(t, err)
+	assert.Equal(t, axdr.Structure{int16(0x0200), axdr.Array{}, axdr.Array{}}, accessList.(axdr.Array)[1])
+}
+
+func TestNewAssociationSN_RequiresAssociation(t *testing.T) {
+	_, err := NewAssociationSN(obisOf(t, "0.0.40.0.0.255"), nil)
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+}
+
+func TestAssociationSN_Methods(t *testing.T) {
+	associationLN, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	associationSN, err := NewAssociationSN(obisOf(t, "0.0.40.0.0.255"), associationLN)
+	require.NoError(t, err)
+
+	for _, methodID := range []byte{associationSNMethodChangeLLSSecret, associationSNMethodChangeHLSSecret} {
+		_, err = associationSN.Invoke(methodID, []interface{}{[]byte("secret")})
+		require.NoError(t, err)
+		assert.Equal(t, []byte("secret"), associationLN.Attributes[7].Value)
+		_, err = associationSN.Invoke(methodID, []interface{}{[]byte{}})
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+	}
+
+	// reply_to_HLS_authentication completes the authentication of the association.
+	_, err = associationSN.Invoke(associationSNMethodReplyToHLS, []interface{}{[]byte("f(StoC)")})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	associationLN.awaitHLS(func(fStoC []byte) ([]byte, error) { return []byte("f(CtoS)"), nil })
+	fCtoS, err := associationSN.Invoke(associationSNMethodReplyToHLS, []interface{}{[]byte("f(StoC)")})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("f(CtoS)"), fCtoS)
+	assert.Equal(t, AssociationStatusAssociated, associationLN.Status())
+}
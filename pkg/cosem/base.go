@@ -63,6 +63,9 @@ const (
 type MethodDescriptor struct {
 	Access     MethodAccess
 	ParamTypes []reflect.Type
+	// Variadic lets the last of ParamTypes repeat any number of times, as for a
+	// method taking an array, whose elements are passed as the parameters.
+	Variadic   bool
 	ReturnType reflect.Type
 	Handler    func(params []interface{}) (interface{}, error)
 }
@@ -74,6 +77,9 @@ var (
 	ErrAccessDenied          = fmt.Errorf("access denied")
 	ErrInvalidParameter      = fmt.Errorf("invalid parameter")
 	ErrInvalidValueType      = fmt.Errorf("invalid value type")
+	// ErrAccessSelectorNotSupported is returned when an attribute has no selective
+	// access or does not support the requested selector.
+	ErrAccessSelectorNotSupported = fmt.Errorf("access selector not supported")
 )
 
 // Callback types
@@ -125,6 +131,15 @@ type BaseInterface interface {
 	SetCallbackContext(ctx interface{})
 }
 
+// SelectiveAccessor is implemented by interface classes that support selective access.
+//
+// GetAttributeWithSelector reads an attribute restricted by an access selector and
+// its access parameters. Unsupported selectors return ErrAccessSelectorNotSupported,
+// malformed parameters return ErrInvalidParameter.
+type SelectiveAccessor interface {
+	GetAttributeWithSelector(attributeID byte, selector uint8, parameters interface{}) (interface{}, error)
+}
+
 // BaseImpl is a base implementation of a COSEM object.
 //
 // It contains the class ID, instance ID, attributes, and methods of the object.
@@ -233,12 +248,16 @@ func (b *BaseImpl) Invoke(methodID byte, parameters []interface{}) (interface{},
 		return nil, ErrAccessDenied
 	}
 
-	if len(parameters) != len(method.ParamTypes) {
+	fixed := len(method.ParamTypes)
+	if method.Variadic {
+		fixed--
+	}
+	if len(parameters) < fixed || (!method.Variadic && len(parameters) != fixed) {
 		return nil, ErrInvalidParameter
 	}
 
 	for i, param := range parameters {
-		if reflect.TypeOf(param) != method.ParamTypes[i] {
+		if reflect.TypeOf(param) != method.ParamTypes[min(i, len(method.ParamTypes)-1)] {
 			return nil, ErrInvalidParameter
 		}
 	}
@@ -0,0 +1,349 @@
+package cosem
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
+)
+
+const (
+	// invokeIDMask selects the invoke-id bits of an Invoke-Id-And-Priority byte.
+	invokeIDMask uint8 = 0x0F
+
+	// apduCipheringOverhead is reserved in every response for a possible ciphering
+	// wrapper: tag, length, security header and authentication tag.
+	apduCipheringOverhead = 24
+
+	// getResponseHeaderSize covers tag, type, invoke-id and the Get-Data-Result CHOICE.
+	getResponseHeaderSize = 4
+
+	// getResponseBlockHeaderSize covers tag, type, invoke-id, last-block, block-number,
+	// the DataBlock-G CHOICE and the longest raw-data length prefix.
+	getResponseBlockHeaderSize = 13
+
+	// actionResponseHeaderSize covers tag, type and invoke-id of an Action-Response.
+	actionResponseHeaderSize = 3
+
+	// actionResponseBlockHeaderSize covers tag, type, invoke-id, last-block, block-number
+	// and the longest raw-data length prefix of an Action-Response-With-Pblock.
+	actionResponseBlockHeaderSize = 12
+
+	// readResponseHeaderSize covers the tag of a ReadResponse; the result list is
+	// measured with its length prefix.
+	readResponseHeaderSize = 1
+
+	// readResponseBlockHeaderSize covers tag, result count, the ReadResponse CHOICE,
+	// last-block, block-number and the longest raw-data length prefix.
+	readResponseBlockHeaderSize = 9
+)
+
+// blockSender splits an encoded response into the numbered blocks of a long get or a
+// long action response.
+type blockSender struct {
+	invokeIDAndPriority uint8
+	remaining           []byte
+	blockSize           int
+	blockNumber         uint32 // number of the last block sent
+}
+
+// newBlockSender prepares data to be sent in blocks that fit into maxPDU once the
+// response header of headerSize bytes and a ciphering wrapper are added.
+func newBlockSender(invokeIDAndPriority uint8, data []byte, maxPDU, headerSize int) *blockSender {
+	blockSize := maxPDU - headerSize - apduCipheringOverhead
+	if blockSize < 1 {
+		blockSize = 1
+	}
+	return &blockSender{
+		invokeIDAndPriority: invokeIDAndPriority,
+		remaining:           data,
+		blockSize:           blockSize,
+	}
+}
+
+// nextBlock cuts the next block from the remaining data.
+func (s *blockSender) nextBlock() DataBlockSA {
+	n := min(s.blockSize, len(s.remaining))
+	s.blockNumber++
+	block := DataBlockSA{
+		BlockNumber: s.blockNumber,
+		RawData:     s.remaining[:n],
+	}
+	s.remaining = s.remaining[n:]
+	block.LastBlock = len(s.remaining) == 0
+	return block
+}
+
+// splitBlocks cuts data into consecutive DataBlock-SA of at most blockSize bytes.
+func splitBlocks(data []byte, blockSize int) []DataBlockSA {
+	sender := &blockSender{remaining: data, blockSize: blockSize}
+	var blocks []DataBlockSA
+	for {
+		block := sender.nextBlock()
+		blocks = append(blocks, block)
+		if block.LastBlock {
+			return blocks
+		}
+	}
+}
+
+// getBlockError builds the Get-Response-With-Datablock that terminates a long get with result.
+func getBlockError(invokeIDAndPriority uint8, blockNumber uint32, result DataAccessResultEnum) *GetResponse {
+	return &GetResponse{
+		Type:                GET_RESPONSE_WITH_DATABLOCK,
+		InvokeIDAndPriority: invokeIDAndPriority,
+		DataBlock: DataBlockG{
+			LastBlock:          true,
+			BlockNumber:        blockNumber,
+			IsDataAccessResult: true,
+			DataAccessResult:   result,
+		},
+	}
+}
+
+// GetBlockReassembler is the client-side counterpart of a long get. It collects the
+// raw-data of consecutive Get-Response-With-Datablock APDUs and decodes the value once
+// the last block has arrived. Use Result for a Get-Request-Normal and ResultList for a
+// Get-Request-With-List.
+type GetBlockReassembler struct {
+	data        bytes.Buffer
+	blockNumber uint32
+	complete    bool
+	result      *GetDataResult
+	resultList  []GetDataResult
+}
+
+// Push consumes a Get-Response. It returns the Get-Request-Next to send for the following
+// block, or nil once the transfer is complete. A Get-Response-Normal completes the transfer
+// immediately, so callers can feed every response through the reassembler.
+func (r *GetBlockReassembler) Push(resp *GetResponse) (*GetRequest, error) {
+	if r.complete {
+		return nil, fmt.Errorf("long get already complete")
+	}
+
+	switch resp.Type {
+	case GET_RESPONSE_NORMAL:
+		if r.blockNumber != 0 {
+			return nil, fmt.Errorf("unexpected Get-Response-Normal after block %d", r.blockNumber)
+		}
+		result := resp.Result
+		r.result = &result
+		r.complete = true
+		return nil, nil
+	case GET_RESPONSE_WITH_LIST:
+		if r.blockNumber != 0 {
+			return nil, fmt.Errorf("unexpected Get-Response-With-List after block %d", r.blockNumber)
+		}
+		r.resultList = resp.ResultList
+		r.complete = true
+		return nil, nil
+	case GET_RESPONSE_WITH_DATABLOCK:
+	default:
+		return nil, fmt.Errorf("unsupported GetResponse type: %d", resp.Type)
+	}
+
+	block := resp.DataBlock
+	if block.IsDataAccessResult {
+		r.result = &GetDataResult{IsDataAccessResult: true, Value: block.DataAccessResult}
+		r.complete = true
+		return nil, nil
+	}
+	if block.BlockNumber != r.blockNumber+1 {
+		return nil, fmt.Errorf("unexpected block number %d, want %d", block.BlockNumber, r.blockNumber+1)
+	}
+	r.blockNumber = block.BlockNumber
+	r.data.Write(block.RawData)
+
+	if block.LastBlock {
+		r.complete = true
+		return nil, nil
+	}
+	return &GetRequest{
+		Type:                GET_REQUEST_NEXT,
+		InvokeIDAndPriority: resp.InvokeIDAndPriority,
+		BlockNumber:         r.blockNumber,
+	}, nil
+}
+
+// Complete reports whether the last block has been received.
+func (r *GetBlockReassembler) Complete() bool {
+	return r.complete
+}
+
+// Result returns the reassembled Get-Data-Result. It fails if the transfer is not complete
+// or the raw data does not decode to a single A-XDR value.
+func (r *GetBlockReassembler) Result() (GetDataResult, error) {
+	if !r.complete {
+		return GetDataResult{}, fmt.Errorf("long get not complete: %d blocks received", r.blockNumber)
+	}
+	if r.result != nil {
+		return *r.result, nil
+	}
+	if r.resultList != nil {
+		return GetDataResult{}, fmt.Errorf("response is a result list")
+	}
+	reader := bytes.NewReader(r.data.Bytes())
+	value, err := axdr.DecodeFrom(reader)
+	if err != nil {
+		return GetDataResult{}, fmt.Errorf("failed to decode reassembled data: %w", err)
+	}
+	if reader.Len() != 0 {
+		return GetDataResult{}, fmt.Errorf("reassembled data has %d trailing bytes", reader.Len())
+	}
+	return GetDataResult{IsDataAccessResult: false, Value: value}, nil
+}
+
+// ResultList returns the reassembled results of a Get-Request-With-List. If the long get
+// was aborted, the list holds the single data-access-result that ended it.
+func (r *GetBlockReassembler) ResultList() ([]GetDataResult, error) {
+	if !r.complete {
+		return nil, fmt.Errorf("long get not complete: %d blocks received", r.blockNumber)
+	}
+	if r.resultList != nil {
+		return r.resultList, nil
+	}
+	if r.result != nil {
+		if !r.result.IsDataAccessResult {
+			return nil, fmt.Errorf("response is a single result")
+		}
+		return []GetDataResult{*r.result}, nil
+	}
+	reader := bytes.NewReader(r.data.Bytes())
+	results, err := decodeGetDataResultList(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode reassembled data: %w", err)
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("reassembled data has %d trailing bytes", reader.Len())
+	}
+	return results, nil
+}
+
+// blockReceiver collects the raw data of a long set or a long action request.
+type blockReceiver struct {
+	invokeIDAndPriority uint8
+	withList            bool
+	data                bytes.Buffer
+	blockNumber         uint32 // number of the last block received
+}
+
+// accept appends the raw data of block. It fails if block does not follow the last
+// block received.
+func (r *blockReceiver) accept(block DataBlockSA) error {
+	if block.BlockNumber != r.blockNumber+1 {
+		return fmt.Errorf("unexpected block number %d, want %d", block.BlockNumber, r.blockNumber+1)
+	}
+	r.blockNumber = block.BlockNumber
+	r.data.Write(block.RawData)
+	return nil
+}
+
+// values decodes the reassembled raw data: a single Data value, or a SEQUENCE OF Data
+// with count elements for the with-list variants.
+func (r *blockReceiver) values(count int) ([]interface{}, error) {
+	reader := bytes.NewReader(r.data.Bytes())
+	var values []interface{}
+	if r.withList {
+		var err error
+		values, err = decodeDataList(reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != count {
+			return nil, fmt.Errorf("reassembled list has %d elements, want %d", len(values), count)
+		}
+	} else {
+		value, err := axdr.DecodeFrom(reader)
+		if err != nil {
+			return nil, err
+		}
+		values = []interface{}{value}
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("reassembled data has %d trailing bytes", reader.Len())
+	}
+	return values, nil
+}
+
+// longSetState tracks a Set-Request-With-Datablock transfer in progress on an association.
+type longSetState struct {
+	blockReceiver
+	attributes []CosemAttributeDescriptor
+}
+
+// longActionState tracks an Action-Request-With-Pblock transfer in progress on an association.
+type longActionState struct {
+	blockReceiver
+	methods []CosemMethodDescriptor
+}
+
+// setBlockError builds the Set-Response-Last-Datablock that terminates a long set with result.
+func setBlockError(invokeIDAndPriority uint8, blockNumber uint32, result DataAccessResultEnum) *SetResponse {
+	return &SetResponse{
+		Type:                SET_RESPONSE_LAST_DATABLOCK,
+		InvokeIDAndPriority: invokeIDAndPriority,
+		Result:              result,
+		BlockNumber:         blockNumber,
+	}
+}
+
+// SetRequestBlocks is the client-side counterpart of a long set. It encodes value and
+// splits it into a Set-Request-With-First-Datablock followed by Set-Request-With-Datablock
+// APDUs, each carrying at most blockSize bytes of raw data.
+func SetRequestBlocks(invokeIDAndPriority uint8, desc CosemAttributeDescriptor, value interface{}, blockSize int) ([]*SetRequest, error) {
+	if blockSize < 1 {
+		return nil, fmt.Errorf("invalid block size: %d", blockSize)
+	}
+	encoded, err := axdr.Encode(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+
+	blocks := splitBlocks(encoded, blockSize)
+	requests := make([]*SetRequest, len(blocks))
+	for i, block := range blocks {
+		requests[i] = &SetRequest{
+			Type:                SET_REQUEST_WITH_DATABLOCK,
+			InvokeIDAndPriority: invokeIDAndPriority,
+			DataBlock:           block,
+		}
+	}
+	requests[0].Type = SET_REQUEST_WITH_FIRST_DATABLOCK
+	requests[0].AttributeDescriptor = desc
+	return requests, nil
+}
+
+// ActionRequestBlocks is the client-side counterpart of a long action request. It encodes
+// parameters and splits them into an Action-Request-With-First-Pblock followed by
+// Action-Request-With-Pblock APDUs, each carrying at most blockSize bytes of raw data.
+func ActionRequestBlocks(invokeIDAndPriority uint8, desc CosemMethodDescriptor, parameters interface{}, blockSize int) ([]*ActionRequest, error) {
+	if blockSize < 1 {
+		return nil, fmt.Errorf("invalid block size: %d", blockSize)
+	}
+	encoded, err := axdr.Encode(parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode parameters: %w", err)
+	}
+
+	blocks := splitBlocks(encoded, blockSize)
+	requests := make([]*ActionRequest, len(blocks))
+	for i, block := range blocks {
+		requests[i] = &ActionRequest{
+			Type:                ACTION_REQUEST_WITH_PBLOCK,
+			InvokeIDAndPriority: invokeIDAndPriority,
+			DataBlock:           block,
+		}
+	}
+	requests[0].Type = ACTION_REQUEST_WITH_FIRST_PBLOCK
+	requests[0].MethodDescriptor = desc
+	return requests, nil
+}
+
+// actionBlockError builds the Action-Response-Normal that terminates a long action with result.
+func actionBlockError(invokeIDAndPriority uint8, result DataAccessResultEnum) *ActionResponse {
+	return &ActionResponse{
+		Type:                ACTION_RESPONSE_NORMAL,
+		InvokeIDAndPriority: invokeIDAndPriority,
+		Result:              ActionResult{IsDataAccessResult: true, Value: result},
+	}
+}
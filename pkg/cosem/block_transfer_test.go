@@ -0,0 +1,63 @@
+package cosem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockSender_SplitsIntoBlocks(t *testing.T) {
+	data := make([]byte, 10)
+	state := newBlockSender(0x81, data, getResponseBlockHeaderSize+apduCipheringOverhead+4, getResponseBlockHeaderSize)
+
+	var sizes []int
+	for {
+		block := state.nextBlock()
+		sizes = append(sizes, len(block.RawData))
+		assert.Equal(t, uint32(len(sizes)), block.BlockNumber)
+		if block.LastBlock {
+			break
+		}
+	}
+	assert.Equal(t, []int{4, 4, 2}, sizes)
+}
+
+func TestGetBlockReassembler(t *testing.T) {
+	t.Run("Normal Response", func(t *testing.T) {
+		r := &GetBlockReassembler{}
+		next, err := r.Push(&GetResponse{Type: GET_RESPONSE_NORMAL, Result: GetDataResult{Value: uint8(7)}})
+		require.NoError(t, err)
+		assert.Nil(t, next)
+		assert.True(t, r.Complete())
+
+		result, err := r.Result()
+		require.NoError(t, err)
+		assert.Equal(t, uint8(7), result.Value)
+	})
+
+	t.Run("Out Of Order Block", func(t *testing.T) {
+		r := &GetBlockReassembler{}
+		_, err := r.Push(&GetResponse{Type: GET_RESPONSE_WITH_DATABLOCK, DataBlock: DataBlockG{BlockNumber: 2}})
+		assert.Error(t, err)
+	})
+
+	t.Run("Aborted Transfer", func(t *testing.T) {
+		r := &GetBlockReassembler{}
+		next, err := r.Push(&GetResponse{Type: GET_RESPONSE_WITH_DATABLOCK, InvokeIDAndPriority: 0x81, DataBlock: DataBlockG{BlockNumber: 1, RawData: []byte{0x11}}})
+		require.NoError(t, err)
+		require.NotNil(t, next)
+		assert.Equal(t, GET_REQUEST_NEXT, next.Type)
+		assert.Equal(t, uint32(1), next.BlockNumber)
+
+		_, err = r.Result()
+		assert.Error(t, err)
+
+		_, err = r.Push(&GetResponse{Type: GET_RESPONSE_WITH_DATABLOCK, DataBlock: DataBlockG{LastBlock: true, BlockNumber: 1, IsDataAccessResult: true, DataAccessResult: LONG_GET_ABORTED}})
+		require.NoError(t, err)
+		result, err := r.Result()
+		require.NoError(t, err)
+		assert.True(t, result.IsDataAccessResult)
+		assert.Equal(t, LONG_GET_ABORTED, result.Value)
+	})
+}
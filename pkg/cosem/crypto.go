@@ -28,19 +28,24 @@ func GenerateECDHKeys() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	return priv, &priv.PublicKey, nil
 }
 
-// ECDH performs a key agreement using the provided private and public keys.
+// ECDH performs a key agreement using the provided private and public keys and
+// returns the shared secret Z, the x-coordinate of the shared point padded to the
+// field size.
 func ECDH(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) ([]byte, error) {
 	if priv == nil {
 		return nil, ErrInvalidPrivateKey
 	}
-	if pub == nil {
+	if pub == nil || pub.Curve == nil || pub.X == nil || pub.Y == nil {
+		return nil, ErrInvalidPublicKey
+	}
+	if pub.Curve != priv.Curve || !pub.Curve.IsOnCurve(pub.X, pub.Y) {
 		return nil, ErrInvalidPublicKey
 	}
 	x, _ := pub.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
-	if x == nil {
+	if x == nil || x.Sign() == 0 {
 		return nil, ErrKeyAgreementFailed
 	}
-	return x.Bytes(), nil
+	return padScalar(x.Bytes(), (pub.Params().BitSize+7)/8)
 }
 
 // SignECDSA signs a message using the provided private key.
@@ -0,0 +1,268 @@
+package cosem
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gvtret/spodes-go/pkg/common"
+)
+
+// APDUType constants for error APDUs.
+const (
+	APDU_CONFIRMED_SERVICE_ERROR APDUType = 0x0E
+	APDU_EXCEPTION_RESPONSE      APDUType = 0xD8
+)
+
+// ErrPDUTooLong is returned for a request longer than the max receive PDU size
+// negotiated for the association.
+var ErrPDUTooLong = fmt.Errorf("APDU exceeds the max receive PDU size")
+
+// ErrNotAssociated is returned for a data service requested by a client that has
+// no established application association.
+var ErrNotAssociated = fmt.Errorf("no application association established")
+
+// ExceptionStateError is the state-error field of an Exception-Response.
+type ExceptionStateError byte
+
+const (
+	STATE_ERROR_SERVICE_NOT_ALLOWED ExceptionStateError = 1
+	STATE_ERROR_SERVICE_UNKNOWN     ExceptionStateError = 2
+)
+
+// ExceptionServiceError is the service-error choice of an Exception-Response.
+type ExceptionServiceError byte
+
+const (
+	SERVICE_ERROR_OPERATION_NOT_POSSIBLE   ExceptionServiceError = 1
+	SERVICE_ERROR_SERVICE_NOT_SUPPORTED    ExceptionServiceError = 2
+	SERVICE_ERROR_OTHER_REASON             ExceptionServiceError = 3
+	SERVICE_ERROR_PDU_TOO_LONG             ExceptionServiceError = 4
+	SERVICE_ERROR_DECIPHERING_ERROR        ExceptionServiceError = 5
+	SERVICE_ERROR_INVOCATION_COUNTER_ERROR ExceptionServiceError = 6
+)
+
+// ExceptionResponse represents the Exception-Response APDU a server sends when
+// it cannot process a request at all.
+type ExceptionResponse struct {
+	StateError   ExceptionStateError
+	ServiceError ExceptionServiceError
+	// InvocationCounter is the expected invocation counter. It is only sent
+	// with SERVICE_ERROR_INVOCATION_COUNTER_ERROR.
+	InvocationCounter uint32
+}
+
+// ConfirmedServiceErrorService identifies the service a Confirmed-Service-Error reports on.
+type ConfirmedServiceErrorService byte
+
+const (
+	CONFIRMED_SERVICE_ERROR_INITIATE ConfirmedServiceErrorService = 1
+	CONFIRMED_SERVICE_ERROR_READ     ConfirmedServiceErrorService = 5
+	CONFIRMED_SERVICE_ERROR_WRITE    ConfirmedServiceErrorService = 6
+)
+
+// ServiceErrorType is the ServiceError choice of a Confirmed-Service-Error.
+type ServiceErrorType byte
+
+const (
+	SERVICE_ERROR_TYPE_APPLICATION_REFERENCE ServiceErrorType = 0
+	SERVICE_ERROR_TYPE_HARDWARE_RESOURCE     ServiceErrorType = 1
+	SERVICE_ERROR_TYPE_VDE_STATE_ERROR       ServiceErrorType = 2
+	SERVICE_ERROR_TYPE_SERVICE               ServiceErrorType = 3
+	SERVICE_ERROR_TYPE_DEFINITION            ServiceErrorType = 4
+	SERVICE_ERROR_TYPE_ACCESS                ServiceErrorType = 5
+	SERVICE_ERROR_TYPE_INITIATE              ServiceErrorType = 6
+	SERVICE_ERROR_TYPE_LOAD_DATA_SET         ServiceErrorType = 7
+	SERVICE_ERROR_TYPE_TASK                  ServiceErrorType = 9
+)
+
+// Values of the ServiceError enumerations used by this package. Each value is
+// only meaningful with the ServiceErrorType named in its prefix.
+const (
+	APPLICATION_REFERENCE_OTHER             byte = 0
+	APPLICATION_REFERENCE_INVALID           byte = 3
+	APPLICATION_REFERENCE_DECIPHERING_ERROR byte = 6
+
+	SERVICE_OTHER               byte = 0
+	SERVICE_PDU_SIZE            byte = 1
+	SERVICE_SERVICE_UNSUPPORTED byte = 2
+
+	DEFINITION_OTHER                         byte = 0
+	DEFINITION_OBJECT_UNDEFINED              byte = 1
+	DEFINITION_OBJECT_CLASS_INCONSISTENT     byte = 2
+	DEFINITION_OBJECT_ATTRIBUTE_INCONSISTENT byte = 3
+
+	ACCESS_OTHER                    byte = 0
+	ACCESS_SCOPE_OF_ACCESS_VIOLATED byte = 1
+	ACCESS_OBJECT_ACCESS_VIOLATED   byte = 2
+	ACCESS_HARDWARE_FAULT           byte = 3
+	ACCESS_OBJECT_UNAVAILABLE       byte = 4
+
+	INITIATE_OTHER                    byte = 0
+	INITIATE_DLMS_VERSION_TOO_LOW     byte = 1
+	INITIATE_INCOMPATIBLE_CONFORMANCE byte = 2
+	INITIATE_PDU_SIZE_TOO_SHORT       byte = 3
+	INITIATE_REFUSED_BY_THE_VDE       byte = 4
+)
+
+// ConfirmedServiceError represents the Confirmed-Service-Error APDU.
+type ConfirmedServiceError struct {
+	Service   ConfirmedServiceErrorService
+	ErrorType ServiceErrorType
+	Value     byte
+}
+
+// Encode encodes the ExceptionResponse APDU into a byte slice.
+func (er *ExceptionResponse) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_EXCEPTION_RESPONSE))
+	buf.WriteByte(byte(er.StateError))
+	buf.WriteByte(byte(er.ServiceError))
+	if er.ServiceError == SERVICE_ERROR_INVOCATION_COUNTER_ERROR {
+		writeUint32(&buf, er.InvocationCounter)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into an ExceptionResponse APDU.
+func (er *ExceptionResponse) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_EXCEPTION_RESPONSE, "ExceptionResponse")
+	if err != nil {
+		return err
+	}
+
+	stateError, err := readByte(reader, "StateError")
+	if err != nil {
+		return err
+	}
+	er.StateError = ExceptionStateError(stateError)
+
+	serviceError, err := readByte(reader, "ServiceError")
+	if err != nil {
+		return err
+	}
+	er.ServiceError = ExceptionServiceError(serviceError)
+
+	er.InvocationCounter = 0
+	if er.ServiceError == SERVICE_ERROR_INVOCATION_COUNTER_ERROR {
+		er.InvocationCounter, err = readUint32(reader, "InvocationCounter")
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectEnd(reader, "ExceptionResponse")
+}
+
+// Encode encodes the ConfirmedServiceError APDU into a byte slice.
+func (ce *ConfirmedServiceError) Encode() ([]byte, error) {
+	return []byte{byte(APDU_CONFIRMED_SERVICE_ERROR), byte(ce.Service), byte(ce.ErrorType), ce.Value}, nil
+}
+
+// Decode decodes a byte slice into a ConfirmedServiceError APDU.
+func (ce *ConfirmedServiceError) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_CONFIRMED_SERVICE_ERROR, "ConfirmedServiceError")
+	if err != nil {
+		return err
+	}
+
+	service, err := readByte(reader, "Service")
+	if err != nil {
+		return err
+	}
+	ce.Service = ConfirmedServiceErrorService(service)
+
+	errorType, err := readByte(reader, "ServiceErrorType")
+	if err != nil {
+		return err
+	}
+	ce.ErrorType = ServiceErrorType(errorType)
+
+	ce.Value, err = readByte(reader, "ServiceError")
+	if err != nil {
+		return err
+	}
+
+	return expectEnd(reader, "ConfirmedServiceError")
+}
+
+// NewExceptionResponse maps an error raised while handling a request to the
+// Exception-Response sent to the client in its place.
+func NewExceptionResponse(err error) *ExceptionResponse {
+	switch {
+	case errors.Is(err, ErrReplayAttack):
+		return &ExceptionResponse{StateError: STATE_ERROR_SERVICE_NOT_ALLOWED, ServiceError: SERVICE_ERROR_INVOCATION_COUNTER_ERROR}
+	case errors.Is(err, ErrAuthenticationFailed), errors.Is(err, ErrInvalidPadding), errors.Is(err, ErrInvalidSignature):
+		return &ExceptionResponse{StateError: STATE_ERROR_SERVICE_NOT_ALLOWED, ServiceError: SERVICE_ERROR_DECIPHERING_ERROR}
+	case errors.Is(err, ErrPDUTooLong):
+		return &ExceptionResponse{StateError: STATE_ERROR_SERVICE_NOT_ALLOWED, ServiceError: SERVICE_ERROR_PDU_TOO_LONG}
+	case errors.Is(err, ErrNotAssociated):
+		return &ExceptionResponse{StateError: STATE_ERROR_SERVICE_NOT_ALLOWED, ServiceError: SERVICE_ERROR_OPERATION_NOT_POSSIBLE}
+	}
+
+	switch spodesErrorCode(err) {
+	case common.ErrCosemServiceNotSupported:
+		return &ExceptionResponse{StateError: STATE_ERROR_SERVICE_UNKNOWN, ServiceError: SERVICE_ERROR_SERVICE_NOT_SUPPORTED}
+	case common.ErrCosemAPDUUngarsable:
+		return &ExceptionResponse{StateError: STATE_ERROR_SERVICE_UNKNOWN, ServiceError: SERVICE_ERROR_OTHER_REASON}
+	case common.ErrCosemSecurityPolicyViolation, common.ErrCosemObjectAccessDenied,
+		common.ErrCosemObjectUnavailable, common.ErrCosemTypeMismatch:
+		return &ExceptionResponse{StateError: STATE_ERROR_SERVICE_NOT_ALLOWED, ServiceError: SERVICE_ERROR_OPERATION_NOT_POSSIBLE}
+	default:
+		return &ExceptionResponse{StateError: STATE_ERROR_SERVICE_NOT_ALLOWED, ServiceError: SERVICE_ERROR_OTHER_REASON}
+	}
+}
+
+// NewConfirmedServiceError maps an error raised while handling service to the
+// Confirmed-Service-Error sent to the client in its place.
+func NewConfirmedServiceError(service ConfirmedServiceErrorService, err error) *ConfirmedServiceError {
+	ce := &ConfirmedServiceError{Service: service}
+
+	switch {
+	case errors.Is(err, ErrReplayAttack), errors.Is(err, ErrAuthenticationFailed), errors.Is(err, ErrInvalidPadding),
+		errors.Is(err, ErrInvalidSignature):
+		ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_APPLICATION_REFERENCE, APPLICATION_REFERENCE_DECIPHERING_ERROR
+		return ce
+	case errors.Is(err, ErrPDUTooLong):
+		ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_SERVICE, SERVICE_PDU_SIZE
+		return ce
+	case errors.Is(err, ErrNotAssociated):
+		ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_APPLICATION_REFERENCE, APPLICATION_REFERENCE_INVALID
+		return ce
+	}
+
+	switch spodesErrorCode(err) {
+	case common.ErrCosemServiceNotSupported:
+		ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_SERVICE, SERVICE_SERVICE_UNSUPPORTED
+	case common.ErrCosemAPDUUngarsable:
+		ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_SERVICE, SERVICE_OTHER
+	case common.ErrCosemObjectUnavailable:
+		ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_ACCESS, ACCESS_OBJECT_UNAVAILABLE
+	case common.ErrCosemObjectAccessDenied, common.ErrCosemSecurityPolicyViolation:
+		ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_ACCESS, ACCESS_SCOPE_OF_ACCESS_VIOLATED
+	case common.ErrCosemTypeMismatch:
+		ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_DEFINITION, DEFINITION_OBJECT_ATTRIBUTE_INCONSISTENT
+	default:
+		if service == CONFIRMED_SERVICE_ERROR_INITIATE {
+			ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_INITIATE, INITIATE_OTHER
+		} else {
+			ce.ErrorType, ce.Value = SERVICE_ERROR_TYPE_SERVICE, SERVICE_OTHER
+		}
+	}
+	return ce
+}
+
+// spodesErrorCode returns the code of the first SpodesError in err's chain, or
+// common.ErrUnknown if there is none.
+func spodesErrorCode(err error) common.ErrorCode {
+	var spodesErr *common.SpodesError
+	if errors.As(err, &spodesErr) {
+		return spodesErr.Code
+	}
+	return common.ErrUnknown
+}
+
+// errUnsupportedAPDU reports an APDU tag the server does not implement.
+func errUnsupportedAPDU(apduType APDUType) error {
+	return common.NewError(common.ErrCosemServiceNotSupported, fmt.Sprintf("unsupported APDU type: %X", apduType))
+}
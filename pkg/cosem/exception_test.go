@@ -0,0 +1,149 @@
+package cosem
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gvtret/spodes-go/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExceptionResponse_WireFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		apdu *ExceptionResponse
+		want []byte
+	}{
+		{
+			name: "ServiceNotSupported",
+			apdu: &ExceptionResponse{StateError: STATE_ERROR_SERVICE_UNKNOWN, ServiceError: SERVICE_ERROR_SERVICE_NOT_SUPPORTED},
+			want: []byte{0xD8, 0x02, 0x02},
+		},
+		{
+			name: "InvocationCounterError",
+			apdu: &ExceptionResponse{
+				StateError:        STATE_ERROR_SERVICE_NOT_ALLOWED,
+				ServiceError:      SERVICE_ERROR_INVOCATION_COUNTER_ERROR,
+				InvocationCounter: 0x0102,
+			},
+			want: []byte{0xD8, 0x01, 0x06, 0x00, 0x00, 0x01, 0x02},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.apdu.Encode()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, encoded)
+
+			decoded := &ExceptionResponse{}
+			require.NoError(t, decoded.Decode(encoded))
+			assert.Equal(t, tt.apdu, decoded)
+		})
+	}
+
+	assert.Error(t, (&ExceptionResponse{}).Decode([]byte{0xD8, 0x01, 0x06, 0x00}))
+	assert.Error(t, (&ExceptionResponse{}).Decode([]byte{0xD8, 0x01, 0x02, 0x00}))
+}
+
+func TestConfirmedServiceError_WireFormat(t *testing.T) {
+	apdu := &ConfirmedServiceError{
+		Service:   CONFIRMED_SERVICE_ERROR_INITIATE,
+		ErrorType: SERVICE_ERROR_TYPE_INITIATE,
+		Value:     INITIATE_DLMS_VERSION_TOO_LOW,
+	}
+	encoded, err := apdu.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0E, 0x01, 0x06, 0x01}, encoded)
+
+	decoded := &ConfirmedServiceError{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, apdu, decoded)
+
+	assert.Error(t, decoded.Decode([]byte{0x0E, 0x01, 0x06}))
+}
+
+func TestNewExceptionResponse_Mapping(t *testing.T) {
+	tests := []struct {
+		err          error
+		stateError   ExceptionStateError
+		serviceError ExceptionServiceError
+	}{
+		{ErrReplayAttack, STATE_ERROR_SERVICE_NOT_ALLOWED, SERVICE_ERROR_INVOCATION_COUNTER_ERROR},
+		{fmt.Errorf("wrapped: %w", ErrAuthenticationFailed), STATE_ERROR_SERVICE_NOT_ALLOWED, SERVICE_ERROR_DECIPHERING_ERROR},
+		{common.NewError(common.ErrCosemServiceNotSupported, "x"), STATE_ERROR_SERVICE_UNKNOWN, SERVICE_ERROR_SERVICE_NOT_SUPPORTED},
+		{common.WrapError(common.ErrCosemAPDUUngarsable, "x", fmt.Errorf("eof")), STATE_ERROR_SERVICE_UNKNOWN, SERVICE_ERROR_OTHER_REASON},
+		{common.NewError(common.ErrCosemSecurityPolicyViolation, "x"), STATE_ERROR_SERVICE_NOT_ALLOWED, SERVICE_ERROR_OPERATION_NOT_POSSIBLE},
+		{fmt.Errorf("anything else"), STATE_ERROR_SERVICE_NOT_ALLOWED, SERVICE_ERROR_OTHER_REASON},
+	}
+
+	for _, tt := range tests {
+		exception := NewExceptionResponse(tt.err)
+		assert.Equal(t, tt.stateError, exception.StateError, tt.err.Error())
+		assert.Equal(t, tt.serviceError, exception.ServiceError, tt.err.Error())
+	}
+}
+
+func TestNewConfirmedServiceError_Mapping(t *testing.T) {
+	tests := []struct {
+		service   ConfirmedServiceErrorService
+		err       error
+		errorType ServiceErrorType
+		value     byte
+	}{
+		{CONFIRMED_SERVICE_ERROR_READ, ErrAuthenticationFailed, SERVICE_ERROR_TYPE_APPLICATION_REFERENCE, APPLICATION_REFERENCE_DECIPHERING_ERROR},
+		{CONFIRMED_SERVICE_ERROR_READ, common.WrapError(common.ErrCosemAPDUUngarsable, "x", ErrReplayAttack), SERVICE_ERROR_TYPE_APPLICATION_REFERENCE, APPLICATION_REFERENCE_DECIPHERING_ERROR},
+		{CONFIRMED_SERVICE_ERROR_READ, common.NewError(common.ErrCosemObjectUnavailable, "x"), SERVICE_ERROR_TYPE_ACCESS, ACCESS_OBJECT_UNAVAILABLE},
+		{CONFIRMED_SERVICE_ERROR_WRITE, common.NewError(common.ErrCosemObjectAccessDenied, "x"), SERVICE_ERROR_TYPE_ACCESS, ACCESS_SCOPE_OF_ACCESS_VIOLATED},
+		{CONFIRMED_SERVICE_ERROR_WRITE, common.NewError(common.ErrCosemTypeMismatch, "x"), SERVICE_ERROR_TYPE_DEFINITION, DEFINITION_OBJECT_ATTRIBUTE_INCONSISTENT},
+		{CONFIRMED_SERVICE_ERROR_READ, common.NewError(common.ErrCosemServiceNotSupported, "x"), SERVICE_ERROR_TYPE_SERVICE, SERVICE_SERVICE_UNSUPPORTED},
+		{CONFIRMED_SERVICE_ERROR_INITIATE, fmt.Errorf("refused"), SERVICE_ERROR_TYPE_INITIATE, INITIATE_OTHER},
+	}
+
+	for _, tt := range tests {
+		ce := NewConfirmedServiceError(tt.service, tt.err)
+		assert.Equal(t, tt.service, ce.Service, tt.err.Error())
+		assert.Equal(t, tt.errorType, ce.ErrorType, tt.err.Error())
+		assert.Equal(t, tt.value, ce.Value, tt.err.Error())
+	}
+}
+
+func TestApplication_ExceptionResponses(t *testing.T) {
+	app, _, clientAddr, _ := setupTestApp(t)
+
+	tests := []struct {
+		name string
+		src  []byte
+		want []byte
+	}{
+		{"UnsupportedAPDU", []byte{0xC6, 0x01}, []byte{0xD8, 0x02, 0x02}},
+		{"MalformedGetRequest", []byte{0xC0, 0x01, 0x81, 0x00}, []byte{0xD8, 0x02, 0x03}},
+		{"TruncatedSecurityHeader", []byte{0xC8, 0x30, 0x00}, []byte{0xD8, 0x02, 0x03}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.HandleAPDU(tt.src, clientAddr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, resp)
+		})
+	}
+
+	t.Run("DecipheringError", func(t *testing.T) {
+		serverSystemTitle := []byte("SERVER01")
+		securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), []byte("CLIENT01"), serverSystemTitle, nil, []byte("0123456789ABCDEF"), nil)
+		require.NoError(t, err)
+		secured := NewApplication(nil, securitySetup)
+		assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+		require.NoError(t, err)
+		secured.AddAssociation(clientAddr.String(), assoc)
+		require.NoError(t, secured.PreEstablishAssociation(clientAddr.String()))
+
+		header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: 1}
+		src := cipherAPDU(t, APDU_GLO_GET_REQUEST, []byte("FEDCBA9876543210"), []byte{0xC0}, []byte("CLIENT01"), header, SecuritySuite0)
+
+		resp, err := secured.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp)
+	})
+}
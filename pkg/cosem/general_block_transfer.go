@@ -0,0 +1,532 @@
+package cosem
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/gvtret/spodes-go/pkg/transport"
+)
+
+// APDU_GENERAL_BLOCK_TRANSFER is the tag of the General-Block-Transfer APDU.
+const APDU_GENERAL_BLOCK_TRANSFER APDUType = 0xE0
+
+// Bits of the Block-Control field of a General-Block-Transfer APDU.
+const (
+	gbtWindowMask    uint8 = 0x3F
+	gbtStreamingBit  uint8 = 0x40
+	gbtLastBlockBit  uint8 = 0x80
+	gbtMaxWindowSize uint8 = gbtWindowMask
+
+	// gbtHeaderSize covers tag, block-control, block-number, block-number-ack and the
+	// longest block-data length prefix.
+	gbtHeaderSize = 9
+)
+
+// GeneralBlockTransfer represents the General-Block-Transfer APDU. It carries one
+// block of a longer APDU, or no data at all when it only acknowledges blocks.
+type GeneralBlockTransfer struct {
+	LastBlock bool
+	// Streaming is set on every block of a window except the last one, telling the
+	// receiver that more blocks follow without waiting for an acknowledgement.
+	Streaming bool
+	// Window is the number of blocks the sender of this APDU is able to receive
+	// before it acknowledges them.
+	Window         uint8
+	BlockNumber    uint16
+	BlockNumberAck uint16
+	BlockData      []byte
+}
+
+// Encode encodes the GeneralBlockTransfer APDU into a byte slice.
+func (g *GeneralBlockTransfer) Encode() ([]byte, error) {
+	if g.Window > gbtMaxWindowSize {
+		return nil, fmt.Errorf("invalid General-Block-Transfer window size: %d", g.Window)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_GENERAL_BLOCK_TRANSFER))
+	control := g.Window
+	if g.Streaming {
+		control |= gbtStreamingBit
+	}
+	if g.LastBlock {
+		control |= gbtLastBlockBit
+	}
+	buf.WriteByte(control)
+	writeUint16(&buf, g.BlockNumber)
+	writeUint16(&buf, g.BlockNumberAck)
+	if err := writeOctetString(&buf, g.BlockData); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into a GeneralBlockTransfer APDU.
+func (g *GeneralBlockTransfer) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_GENERAL_BLOCK_TRANSFER, "GeneralBlockTransfer")
+	if err != nil {
+		return err
+	}
+
+	control, err := readByte(reader, "BlockControl")
+	if err != nil {
+		return err
+	}
+	g.Window = control & gbtWindowMask
+	g.Streaming = control&gbtStreamingBit != 0
+	g.LastBlock = control&gbtLastBlockBit != 0
+
+	g.BlockNumber, err = readUint16(reader, "BlockNumber")
+	if err != nil {
+		return err
+	}
+	g.BlockNumberAck, err = readUint16(reader, "BlockNumberAck")
+	if err != nil {
+		return err
+	}
+	g.BlockData, err = readOctetString(reader, "BlockData")
+	if err != nil {
+		return err
+	}
+
+	return expectEnd(reader, "GeneralBlockTransfer")
+}
+
+// gbtSender splits an encoded APDU into General-Block-Transfer blocks and sends them
+// a window at a time.
+type gbtSender struct {
+	blocks [][]byte
+	acked  uint16 // number of the last block the peer confirmed in sequence
+}
+
+// newGBTSender splits apdu into blocks of at most blockSize bytes.
+func newGBTSender(apdu []byte, blockSize int) (*gbtSender, error) {
+	if blockSize < 1 {
+		blockSize = 1
+	}
+	count := (len(apdu) + blockSize - 1) / blockSize
+	if count > 0xFFFF {
+		return nil, fmt.Errorf("APDU of %d bytes needs %d blocks, more than General-Block-Transfer can number", len(apdu), count)
+	}
+	sender := &gbtSender{}
+	for len(apdu) > 0 {
+		n := min(blockSize, len(apdu))
+		sender.blocks = append(sender.blocks, apdu[:n])
+		apdu = apdu[n:]
+	}
+	return sender, nil
+}
+
+// done reports whether the peer has confirmed every block.
+func (s *gbtSender) done() bool {
+	return int(s.acked) >= len(s.blocks)
+}
+
+// nextWindow returns the blocks following the last acknowledged one, at most window of
+// them. Blocks the peer has not confirmed are sent again, so a lost block is recovered
+// by acknowledging the block before it.
+func (s *gbtSender) nextWindow(window, ownWindow uint8, ackNumber uint16) []*GeneralBlockTransfer {
+	if window == 0 {
+		window = 1
+	}
+	first := int(s.acked) + 1
+	last := min(int(s.acked)+int(window), len(s.blocks))
+
+	var apdus []*GeneralBlockTransfer
+	for number := first; number <= last; number++ {
+		apdus = append(apdus, &GeneralBlockTransfer{
+			LastBlock:      number == len(s.blocks),
+			Streaming:      number < last,
+			Window:         ownWindow,
+			BlockNumber:    uint16(number),
+			BlockNumberAck: ackNumber,
+			BlockData:      s.blocks[number-1],
+		})
+	}
+	return apdus
+}
+
+// acknowledge records that the peer received every block up to ack in sequence.
+func (s *gbtSender) acknowledge(ack uint16) error {
+	if int(ack) > len(s.blocks) {
+		return fmt.Errorf("acknowledged block %d, only %d blocks sent", ack, len(s.blocks))
+	}
+	if ack > s.acked {
+		s.acked = ack
+	}
+	return nil
+}
+
+// gbtReceiver reassembles the blocks of an incoming General-Block-Transfer. Blocks
+// arriving out of order are kept until the missing blocks before them are resent.
+// Only the blocks of the window following the last block received in sequence are
+// accepted, and the data kept may not exceed the size of the largest APDU the
+// receiver accepts.
+type gbtReceiver struct {
+	blocks    map[uint16][]byte
+	received  uint16 // number of the last block received in sequence
+	lastBlock uint16 // number of the block flagged as last, 0 while unknown
+	window    uint8
+	size      int // bytes of block data kept
+	maxSize   int
+}
+
+func newGBTReceiver(window uint8, maxSize int) *gbtReceiver {
+	return &gbtReceiver{blocks: make(map[uint16][]byte), window: window, maxSize: maxSize}
+}
+
+// accept stores block. It reports whether the peer is waiting for an acknowledgement,
+// which is the case at the end of every window and whenever the last block has
+// arrived but earlier blocks are missing.
+func (r *gbtReceiver) accept(block *GeneralBlockTransfer) (bool, error) {
+	if block.BlockNumber == 0 {
+		return false, fmt.Errorf("invalid General-Block-Transfer block number 0")
+	}
+	if r.lastBlock != 0 && block.BlockNumber > r.lastBlock {
+		return false, fmt.Errorf("block %d follows last block %d", block.BlockNumber, r.lastBlock)
+	}
+	if int(block.BlockNumber) > int(r.received)+int(r.window) {
+		return false, fmt.Errorf("block %d is outside the window of %d blocks following block %d", block.BlockNumber, r.window, r.received)
+	}
+	if block.BlockNumber > r.received {
+		size := r.size + len(block.BlockData) - len(r.blocks[block.BlockNumber])
+		if size > r.maxSize {
+			return false, fmt.Errorf("General-Block-Transfer exceeds the APDU size limit of %d bytes", r.maxSize)
+		}
+		r.blocks[block.BlockNumber] = block.BlockData
+		r.size = size
+	}
+	if block.LastBlock {
+		r.lastBlock = block.BlockNumber
+	}
+	for {
+		if _, ok := r.blocks[r.received+1]; !ok {
+			break
+		}
+		r.received++
+	}
+	return !r.complete() && (!block.Streaming || block.LastBlock), nil
+}
+
+// complete reports whether every block up to the last one has been received.
+func (r *gbtReceiver) complete() bool {
+	return r.lastBlock != 0 && r.received == r.lastBlock
+}
+
+// data returns the reassembled APDU.
+func (r *gbtReceiver) data() []byte {
+	var buf bytes.Buffer
+	for number := uint16(1); number <= r.received; number++ {
+		buf.Write(r.blocks[number])
+	}
+	return buf.Bytes()
+}
+
+var _ transport.Transport = (*GBTTransport)(nil)
+
+// GBTConfig holds the configuration of a General-Block-Transfer layer.
+type GBTConfig struct {
+	// MaxPDUSize is the largest APDU passed to the underlying transport. Longer
+	// APDUs are sent as General-Block-Transfer blocks of at most this size.
+	MaxPDUSize int
+	// Window is the number of blocks this side accepts before acknowledging them,
+	// advertised to the peer in every General-Block-Transfer APDU (1 to 63).
+	Window uint8
+	// MaxAPDUSize is the largest APDU Read reassembles from the blocks of a peer,
+	// 0xFFFF when 0. The Application limits the APDUs of each client to the max
+	// receive PDU size negotiated with it instead.
+	MaxAPDUSize int
+}
+
+// gbtMaxAPDUSize is the largest APDU reassembled when no limit is configured, the
+// largest max receive PDU size a client can be offered.
+const gbtMaxAPDUSize = 0xFFFF
+
+// DefaultGBTConfig returns a default General-Block-Transfer configuration.
+func DefaultGBTConfig() *GBTConfig {
+	return &GBTConfig{
+		MaxPDUSize: int(DefaultMaxPDUSize),
+		Window:     1,
+	}
+}
+
+// GBTTransport is a General-Block-Transfer layer between the application and a
+// transport.Transport. It segments APDUs that exceed the configured max PDU size,
+// ciphered or not, into General-Block-Transfer blocks and reassembles incoming
+// blocks, so the application only ever sees complete APDUs.
+//
+// Blocks are sent a window at a time; the window is the one the peer advertised
+// in its last General-Block-Transfer APDU, or 1 until it has advertised one.
+// Acknowledgements and the remaining windows are produced while Read, or the
+// Application the layer is the transport of, consumes the peer's APDUs, and their
+// frames are written to out. The transfers of each peer, told apart by the
+// address its APDUs are read from, are kept apart.
+type GBTTransport struct {
+	inner     transport.Transport
+	out       io.Writer
+	config    *GBTConfig
+	mutex     sync.Mutex
+	transfers map[string]*gbtTransfer
+	lastPeer  net.Addr // peer of the last APDU read
+}
+
+// gbtTransfer holds the General-Block-Transfer state of one peer.
+type gbtTransfer struct {
+	sender     *gbtSender
+	receiver   *gbtReceiver
+	peerWindow uint8
+	lastSent   uint16 // number of the last data block sent
+}
+
+// NewGBTTransport creates a General-Block-Transfer layer on top of inner. Frames
+// the layer sends on its own, acknowledgements and further windows of a transfer,
+// are written to out, typically the connection inner frames are sent on.
+func NewGBTTransport(inner transport.Transport, out io.Writer, config *GBTConfig) *GBTTransport {
+	if config == nil {
+		config = DefaultGBTConfig()
+	}
+	return &GBTTransport{
+		inner:     inner,
+		out:       out,
+		config:    config,
+		transfers: make(map[string]*gbtTransfer),
+	}
+}
+
+// transfer returns the transfer state of peer, creating it if needed.
+func (t *GBTTransport) transfer(peer net.Addr) *gbtTransfer {
+	key := ""
+	if peer != nil {
+		key = peer.String()
+	}
+	transfer, ok := t.transfers[key]
+	if !ok {
+		transfer = &gbtTransfer{peerWindow: 1}
+		t.transfers[key] = transfer
+	}
+	return transfer
+}
+
+// heardFrom records peer as the peer of the last APDU read. A transfer started
+// before any peer was heard from, as the first request of a client, is the one
+// with the first peer heard from.
+func (t *GBTTransport) heardFrom(peer net.Addr) {
+	if t.lastPeer == nil && peer != nil {
+		if transfer, ok := t.transfers[""]; ok {
+			delete(t.transfers, "")
+			t.transfers[peer.String()] = transfer
+		}
+	}
+	t.lastPeer = peer
+}
+
+// abandon drops the transfers in progress with peer, as when its association is
+// released.
+func (t *GBTTransport) abandon(peer net.Addr) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.transfers, peer.String())
+}
+
+// Connect implements the transport.Transport interface.
+func (t *GBTTransport) Connect() ([]byte, error) {
+	return t.inner.Connect()
+}
+
+// Disconnect implements the transport.Transport interface. Transfers in progress
+// are abandoned.
+func (t *GBTTransport) Disconnect() ([]byte, error) {
+	t.mutex.Lock()
+	t.transfers = make(map[string]*gbtTransfer)
+	t.mutex.Unlock()
+	return t.inner.Disconnect()
+}
+
+// IsConnected implements the transport.Transport interface.
+func (t *GBTTransport) IsConnected() bool {
+	return t.inner.IsConnected()
+}
+
+// Send implements the transport.Transport interface. It sends pdu to the peer of
+// the last APDU read (see SendTo).
+func (t *GBTTransport) Send(pdu []byte) ([][]byte, error) {
+	t.mutex.Lock()
+	peer := t.lastPeer
+	t.mutex.Unlock()
+	return t.SendTo(pdu, peer)
+}
+
+// SendTo sends pdu to peer. An APDU that fits into the max PDU size is passed to
+// the underlying transport unchanged. A longer one starts a General-Block-Transfer
+// to peer, ending the one it replaces; the frames of its first window are
+// returned.
+func (t *GBTTransport) SendTo(pdu []byte, peer net.Addr) ([][]byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(pdu) <= t.config.MaxPDUSize {
+		return t.inner.Send(pdu)
+	}
+
+	if t.config.Window == 0 || t.config.Window > gbtMaxWindowSize {
+		return nil, fmt.Errorf("invalid General-Block-Transfer window size: %d", t.config.Window)
+	}
+	sender, err := newGBTSender(pdu, t.config.MaxPDUSize-gbtHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	transfer := t.transfer(peer)
+	transfer.sender = sender
+	return t.sendWindow(transfer)
+}
+
+// Receive implements the transport.Transport interface. The bytes are passed to
+// the underlying transport, which only yields its own frames; the blocks of the
+// PDUs it reassembles are consumed by Read, or by Application.HandleAPDU when the
+// layer is the transport of the application.
+func (t *GBTTransport) Receive(src []byte) ([][]byte, error) {
+	return t.inner.Receive(src)
+}
+
+// Read implements the transport.Transport interface. It consumes the
+// General-Block-Transfer APDUs received from the peer and returns once a complete
+// APDU, sent in blocks or not, is available.
+func (t *GBTTransport) Read() ([]byte, net.Addr, error) {
+	for {
+		pdu, addr, err := t.inner.Read()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(pdu) == 0 || APDUType(pdu[0]) != APDU_GENERAL_BLOCK_TRANSFER {
+			t.mutex.Lock()
+			t.heardFrom(addr)
+			t.mutex.Unlock()
+			return pdu, addr, nil
+		}
+
+		block := &GeneralBlockTransfer{}
+		if err := block.Decode(pdu); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode General-Block-Transfer: %w", err)
+		}
+		maxSize := t.config.MaxAPDUSize
+		if maxSize == 0 {
+			maxSize = gbtMaxAPDUSize
+		}
+		apdu, err := t.handleBlock(block, addr, maxSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		if apdu != nil {
+			return apdu, addr, nil
+		}
+	}
+}
+
+// handleBlock processes a General-Block-Transfer APDU from peer. It returns the
+// reassembled APDU once the last block of an incoming transfer has arrived; the
+// APDU may be at most maxSize bytes long.
+func (t *GBTTransport) handleBlock(block *GeneralBlockTransfer, peer net.Addr, maxSize int) ([]byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.heardFrom(peer)
+	transfer := t.transfer(peer)
+	if block.Window != 0 {
+		transfer.peerWindow = block.Window
+	}
+
+	// A block without data only acknowledges the blocks of our own transfer.
+	if len(block.BlockData) == 0 && !block.LastBlock {
+		if transfer.sender == nil {
+			return nil, nil
+		}
+		if err := transfer.sender.acknowledge(block.BlockNumberAck); err != nil {
+			return nil, err
+		}
+		if transfer.sender.done() {
+			transfer.sender = nil
+			return nil, nil
+		}
+		frames, err := t.sendWindow(transfer)
+		if err != nil {
+			return nil, err
+		}
+		return nil, t.write(frames)
+	}
+
+	// A new incoming transfer ends whatever we were sending the peer before.
+	if transfer.receiver == nil {
+		transfer.receiver = newGBTReceiver(t.config.Window, maxSize)
+		transfer.sender = nil
+	}
+	ackNeeded, err := transfer.receiver.accept(block)
+	if err != nil {
+		transfer.receiver = nil
+		return nil, err
+	}
+	if transfer.receiver.complete() {
+		apdu := transfer.receiver.data()
+		transfer.receiver = nil
+		return apdu, nil
+	}
+	if !ackNeeded {
+		return nil, nil
+	}
+
+	ack, err := (&GeneralBlockTransfer{
+		Window:         t.config.Window,
+		BlockNumber:    transfer.lastSent,
+		BlockNumberAck: transfer.receiver.received,
+	}).Encode()
+	if err != nil {
+		return nil, err
+	}
+	frames, err := t.inner.Send(ack)
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.write(frames)
+}
+
+// sendWindow encodes the next window of the transfer in progress with a peer and
+// passes its blocks to the underlying transport.
+func (t *GBTTransport) sendWindow(transfer *gbtTransfer) ([][]byte, error) {
+	var ackNumber uint16
+	if transfer.receiver != nil {
+		ackNumber = transfer.receiver.received
+	}
+
+	var frames [][]byte
+	for _, block := range transfer.sender.nextWindow(transfer.peerWindow, t.config.Window, ackNumber) {
+		encoded, err := block.Encode()
+		if err != nil {
+			return nil, err
+		}
+		blockFrames, err := t.inner.Send(encoded)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, blockFrames...)
+		transfer.lastSent = block.BlockNumber
+	}
+	return frames, nil
+}
+
+// write passes frames produced while reading to the configured writer.
+func (t *GBTTransport) write(frames [][]byte) error {
+	if len(frames) == 0 {
+		return nil
+	}
+	if t.out == nil {
+		return fmt.Errorf("no writer configured for General-Block-Transfer frames")
+	}
+	for _, frame := range frames {
+		if _, err := t.out.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
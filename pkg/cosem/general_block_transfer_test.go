@@ -0,0 +1,425 @@
+package cosem
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneralBlockTransfer_WireFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		apdu *GeneralBlockTransfer
+		want []byte
+	}{
+		{
+			name: "StreamingBlock",
+			apdu: &GeneralBlockTransfer{Streaming: true, Window: 3, BlockNumber: 1, BlockData: []byte{0xAB, 0xCD}},
+			want: []byte{0xE0, 0x43, 0x00, 0x01, 0x00, 0x00, 0x02, 0xAB, 0xCD},
+		},
+		{
+			name: "LastBlock",
+			apdu: &GeneralBlockTransfer{LastBlock: true, Window: 1, BlockNumber: 0x0102, BlockNumberAck: 2, BlockData: []byte{0x01}},
+			want: []byte{0xE0, 0x81, 0x01, 0x02, 0x00, 0x02, 0x01, 0x01},
+		},
+		{
+			name: "Acknowledgement",
+			apdu: &GeneralBlockTransfer{Window: 63, BlockNumberAck: 5, BlockData: []byte{}},
+			want: []byte{0xE0, 0x3F, 0x00, 0x00, 0x00, 0x05, 0x00},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.apdu.Encode()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, encoded)
+
+			decoded := &GeneralBlockTransfer{}
+			require.NoError(t, decoded.Decode(encoded))
+			assert.Equal(t, tt.apdu, decoded)
+		})
+	}
+
+	_, err := (&GeneralBlockTransfer{Window: 64}).Encode()
+	assert.Error(t, err)
+	assert.Error(t, (&GeneralBlockTransfer{}).Decode([]byte{0xE0, 0x01, 0x00, 0x01, 0x00}))
+	assert.Error(t, (&GeneralBlockTransfer{}).Decode([]byte{0xE0, 0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x01}))
+}
+
+// pipeTransport is a transport.Transport whose Send returns the PDU as its only frame and
+// whose Read returns the PDUs written to it by its peer.
+type pipeTransport struct {
+	incoming chan []byte
+	closed   chan struct{}
+}
+
+func newPipeTransport() *pipeTransport {
+	return &pipeTransport{incoming: make(chan []byte, 256), closed: make(chan struct{})}
+}
+
+func (p *pipeTransport) Connect() ([]byte, error)         { return nil, nil }
+func (p *pipeTransport) Disconnect() ([]byte, error)      { return nil, nil }
+func (p *pipeTransport) IsConnected() bool                { return true }
+func (p *pipeTransport) Receive([]byte) ([][]byte, error) { return nil, nil }
+func (p *pipeTransport) Send(pdu []byte) ([][]byte, error) {
+	return [][]byte{append([]byte(nil), pdu...)}, nil
+}
+func (p *pipeTransport) Read() ([]byte, net.Addr, error) {
+	select {
+	case pdu := <-p.incoming:
+		return pdu, &net.UDPAddr{}, nil
+	case <-p.closed:
+		return nil, nil, fmt.Errorf("transport closed")
+	case <-time.After(time.Second):
+		return nil, nil, fmt.Errorf("read timeout")
+	}
+}
+
+// pipeWriter delivers frames to a pipeTransport, dropping those drop selects.
+type pipeWriter struct {
+	to   *pipeTransport
+	drop func(frame []byte) bool
+	sent []*GeneralBlockTransfer
+}
+
+func (w *pipeWriter) Write(frame []byte) (int, error) {
+	if len(frame) > 0 && APDUType(frame[0]) == APDU_GENERAL_BLOCK_TRANSFER {
+		block := &GeneralBlockTransfer{}
+		if err := block.Decode(frame); err != nil {
+			return 0, err
+		}
+		w.sent = append(w.sent, block)
+	}
+	if w.drop == nil || !w.drop(frame) {
+		w.to.incoming <- append([]byte(nil), frame...)
+	}
+	return len(frame), nil
+}
+
+func (w *pipeWriter) writeFrames(t *testing.T, frames [][]byte) {
+	for _, frame := range frames {
+		_, err := w.Write(frame)
+		require.NoError(t, err)
+	}
+}
+
+// runGBTExchange sends request from a client layer to a server layer, which answers it
+// with response. It returns what each side received and the blocks the client sent.
+func runGBTExchange(t *testing.T, clientConfig, serverConfig *GBTConfig, request, response []byte, drop func([]byte) bool) ([]byte, []byte, []*GeneralBlockTransfer) {
+	clientInner, serverInner := newPipeTransport(), newPipeTransport()
+	toServer := &pipeWriter{to: serverInner, drop: drop}
+	toClient := &pipeWriter{to: clientInner}
+	client := NewGBTTransport(clientInner, toServer, clientConfig)
+	server := NewGBTTransport(serverInner, toClient, serverConfig)
+
+	serverReceived := make(chan []byte, 1)
+	serverErr := make(chan error, 1)
+	go func() {
+		apdu, _, err := server.Read()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		serverReceived <- apdu
+		frames, err := server.Send(response)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		for _, frame := range frames {
+			if _, err := toClient.Write(frame); err != nil {
+				serverErr <- err
+				return
+			}
+		}
+		// Keep reading so acknowledgements of a long response are served.
+		for {
+			if _, _, err := server.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	frames, err := client.Send(request)
+	require.NoError(t, err)
+	toServer.writeFrames(t, frames)
+
+	clientReceived, _, err := client.Read()
+	close(serverInner.closed)
+	require.NoError(t, err)
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	case received := <-serverReceived:
+		return received, clientReceived, toServer.sent
+	}
+	return nil, nil, nil
+}
+
+func TestGBTTransport_Transfer(t *testing.T) {
+	request := bytes.Repeat([]byte{0xC0, 0x01, 0x81, 0x00, 0x01}, 6)
+	response := []byte{0xC4, 0x01, 0x81, 0x00, 0x09, 0x01, 0x01}
+
+	t.Run("ShortAPDUsPassThrough", func(t *testing.T) {
+		serverReceived, clientReceived, sent := runGBTExchange(t, DefaultGBTConfig(), DefaultGBTConfig(), request, response, nil)
+		assert.Equal(t, request, serverReceived)
+		assert.Equal(t, response, clientReceived)
+		assert.Empty(t, sent)
+	})
+
+	t.Run("WindowNegotiation", func(t *testing.T) {
+		clientConfig := &GBTConfig{MaxPDUSize: gbtHeaderSize + 4, Window: 2}
+		serverConfig := &GBTConfig{MaxPDUSize: gbtHeaderSize + 4, Window: 3}
+		serverReceived, clientReceived, sent := runGBTExchange(t, clientConfig, serverConfig, request, response, nil)
+		assert.Equal(t, request, serverReceived)
+		assert.Equal(t, response, clientReceived)
+
+		// The first block is sent alone; the server then advertises a window of 3.
+		var numbers []uint16
+		var streaming []bool
+		for _, block := range sent {
+			numbers = append(numbers, block.BlockNumber)
+			streaming = append(streaming, block.Streaming)
+			assert.Equal(t, uint8(2), block.Window)
+		}
+		assert.Equal(t, []uint16{1, 2, 3, 4, 5, 6, 7, 8}, numbers)
+		assert.Equal(t, []bool{false, true, true, false, true, true, false, false}, streaming)
+		assert.True(t, sent[len(sent)-1].LastBlock)
+	})
+
+	t.Run("LostBlockIsResent", func(t *testing.T) {
+		config := &GBTConfig{MaxPDUSize: gbtHeaderSize + 4, Window: 3}
+		dropped := false
+		drop := func(frame []byte) bool {
+			block := &GeneralBlockTransfer{}
+			if dropped || block.Decode(frame) != nil || block.BlockNumber != 3 {
+				return false
+			}
+			dropped = true
+			return true
+		}
+		serverReceived, clientReceived, sent := runGBTExchange(t, config, config, request, response, drop)
+		assert.True(t, dropped)
+		assert.Equal(t, request, serverReceived)
+		assert.Equal(t, response, clientReceived)
+
+		var numbers []uint16
+		for _, block := range sent {
+			numbers = append(numbers, block.BlockNumber)
+		}
+		assert.Equal(t, []uint16{1, 2, 3, 4, 3, 4, 5, 6, 7, 8}, numbers)
+	})
+
+	t.Run("LargeResponse", func(t *testing.T) {
+		config := &GBTConfig{MaxPDUSize: gbtHeaderSize + 4, Window: 4}
+		longResponse := bytes.Repeat([]byte{0x5A}, 50)
+		serverReceived, clientReceived, _ := runGBTExchange(t, config, config, response, longResponse, nil)
+		assert.Equal(t, response, serverReceived)
+		assert.Equal(t, longResponse, clientReceived)
+	})
+}
+
+func TestGBTReceiver_RejectsBlocksAfterLast(t *testing.T) {
+	r := newGBTReceiver(gbtMaxWindowSize, gbtMaxAPDUSize)
+	_, err := r.accept(&GeneralBlockTransfer{BlockNumber: 0, BlockData: []byte{1}})
+	assert.Error(t, err)
+
+	ackNeeded, err := r.accept(&GeneralBlockTransfer{LastBlock: true, BlockNumber: 2, BlockData: []byte{2}})
+	require.NoError(t, err)
+	assert.True(t, ackNeeded)
+	assert.False(t, r.complete())
+
+	_, err = r.accept(&GeneralBlockTransfer{BlockNumber: 3, BlockData: []byte{3}})
+	assert.Error(t, err)
+
+	ackNeeded, err = r.accept(&GeneralBlockTransfer{Streaming: true, BlockNumber: 1, BlockData: []byte{1}})
+	require.NoError(t, err)
+	assert.False(t, ackNeeded)
+	assert.True(t, r.complete())
+	assert.Equal(t, []byte{1, 2}, r.data())
+}
+
+func TestGBTReceiver_Limits(t *testing.T) {
+	t.Run("Window", func(t *testing.T) {
+		r := newGBTReceiver(2, gbtMaxAPDUSize)
+		_, err := r.accept(&GeneralBlockTransfer{Streaming: true, BlockNumber: 3, BlockData: []byte{3}})
+		assert.Error(t, err)
+
+		_, err = r.accept(&GeneralBlockTransfer{Streaming: true, BlockNumber: 1, BlockData: []byte{1}})
+		require.NoError(t, err)
+		_, err = r.accept(&GeneralBlockTransfer{BlockNumber: 3, BlockData: []byte{3}})
+		require.NoError(t, err)
+		_, err = r.accept(&GeneralBlockTransfer{BlockNumber: 4, BlockData: []byte{4}})
+		assert.Error(t, err)
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		r := newGBTReceiver(gbtMaxWindowSize, 4)
+		// A block kept out of order and sent again replaces its data rather than
+		// adding to it.
+		_, err := r.accept(&GeneralBlockTransfer{Streaming: true, BlockNumber: 2, BlockData: []byte{2}})
+		require.NoError(t, err)
+		_, err = r.accept(&GeneralBlockTransfer{Streaming: true, BlockNumber: 2, BlockData: []byte{2, 2}})
+		require.NoError(t, err)
+		_, err = r.accept(&GeneralBlockTransfer{Streaming: true, BlockNumber: 1, BlockData: []byte{1, 1}})
+		require.NoError(t, err)
+		_, err = r.accept(&GeneralBlockTransfer{LastBlock: true, BlockNumber: 3, BlockData: []byte{3}})
+		assert.Error(t, err)
+	})
+}
+
+func TestApplication_GeneralBlockTransfer(t *testing.T) {
+	clientInner, serverInner := newPipeTransport(), newPipeTransport()
+	toServer := &pipeWriter{to: serverInner}
+	toClient := &pipeWriter{to: clientInner}
+	// The request is short enough to go in blocks of 3 bytes, the response in blocks of 23.
+	client := NewGBTTransport(clientInner, toServer, &GBTConfig{MaxPDUSize: gbtHeaderSize + 3, Window: 2})
+	server := NewGBTTransport(serverInner, toClient, &GBTConfig{MaxPDUSize: 32, Window: 3})
+
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	app := NewApplication(server, securitySetup)
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	clientAddr := mockAddr("client1")
+	app.AddAssociation(clientAddr.String(), assoc)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
+	value := bytes.Repeat([]byte{0x5A}, 100)
+	dataObj, err := NewData(obisOf(t, "1.0.0.3.0.255"), value)
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+
+	// The server passes every APDU it receives to the application, as a server
+	// loop reading the underlying transport would.
+	serverErr := make(chan error, 1)
+	go func() {
+		for {
+			pdu, _, err := serverInner.Read()
+			if err != nil {
+				return
+			}
+			resp, err := app.HandleAPDU(pdu, clientAddr)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			if resp == nil {
+				continue
+			}
+			frames, err := server.Send(resp)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			for _, frame := range frames {
+				if _, err := toClient.Write(frame); err != nil {
+					serverErr <- err
+					return
+				}
+			}
+		}
+	}()
+
+	req := &GetRequest{
+		Type:                GET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0xC1,
+		AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+	}
+	encodedReq, err := req.Encode()
+	require.NoError(t, err)
+	frames, err := client.Send(encodedReq)
+	require.NoError(t, err)
+	toServer.writeFrames(t, frames)
+
+	received, _, err := client.Read()
+	close(serverInner.closed)
+	require.NoError(t, err)
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	default:
+	}
+
+	resp := &GetResponse{}
+	require.NoError(t, resp.Decode(received))
+	assert.Equal(t, GET_RESPONSE_NORMAL, resp.Type)
+	assert.False(t, resp.Result.IsDataAccessResult)
+	assert.Equal(t, value, resp.Result.Value)
+
+	assert.Greater(t, len(toServer.sent), 1, "request sent in blocks")
+	assert.Greater(t, len(toClient.sent), 1, "response sent in blocks")
+	for _, block := range toClient.sent {
+		encoded, err := block.Encode()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(encoded), 32)
+	}
+}
+
+func TestApplication_GeneralBlockTransferWithoutLayer(t *testing.T) {
+	app, _, clientAddr, _ := setupTestApp(t)
+	block, err := (&GeneralBlockTransfer{LastBlock: true, Window: 1, BlockNumber: 1, BlockData: []byte{0xC0}}).Encode()
+	require.NoError(t, err)
+
+	resp, err := app.HandleAPDU(block, clientAddr)
+	require.NoError(t, err)
+	assert.Equal(t, byte(APDU_EXCEPTION_RESPONSE), resp[0])
+}
+
+func TestApplication_GeneralBlockTransferPerClient(t *testing.T) {
+	inner := newPipeTransport()
+	server := NewGBTTransport(inner, &pipeWriter{to: newPipeTransport()}, &GBTConfig{MaxPDUSize: 32, Window: 3})
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	app := NewApplication(server, securitySetup)
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	clientAddr := mockAddr("client1")
+	app.AddAssociation(clientAddr.String(), assoc)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
+	other, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	app.AddAssociation("client2", other)
+	dataObj, err := NewData(obisOf(t, "1.0.0.3.0.255"), uint32(7))
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+
+	encodedReq, err := (&GetRequest{
+		Type:                GET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0xC1,
+		AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+	}).Encode()
+	require.NoError(t, err)
+	handleBlock := func(t *testing.T, block *GeneralBlockTransfer, clientAddr net.Addr) []byte {
+		src, err := block.Encode()
+		require.NoError(t, err)
+		resp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		return resp
+	}
+
+	half := len(encodedReq) / 2
+	assert.Nil(t, handleBlock(t, &GeneralBlockTransfer{Streaming: true, Window: 3, BlockNumber: 1, BlockData: encodedReq[:half]}, clientAddr))
+
+	// Clients that are unknown or not associated are refused without touching
+	// the transfer of client1.
+	intruding := &GeneralBlockTransfer{LastBlock: true, Window: 3, BlockNumber: 2, BlockData: []byte{0xFF}}
+	assert.Equal(t, byte(APDU_EXCEPTION_RESPONSE), handleBlock(t, intruding, mockAddr("unknown"))[0])
+	assert.Equal(t, byte(APDU_EXCEPTION_RESPONSE), handleBlock(t, intruding, mockAddr("client2"))[0])
+
+	resp := &GetResponse{}
+	require.NoError(t, resp.Decode(handleBlock(t, &GeneralBlockTransfer{LastBlock: true, Window: 3, BlockNumber: 2, BlockData: encodedReq[half:]}, clientAddr)))
+	assert.Equal(t, uint32(7), resp.Result.Value)
+
+	// A transfer may not exceed the max receive PDU size of the client.
+	app.SetMaxPDUSize(16)
+	assert.Nil(t, handleBlock(t, &GeneralBlockTransfer{Streaming: true, Window: 3, BlockNumber: 1, BlockData: make([]byte, 10)}, clientAddr))
+	assert.Equal(t, byte(APDU_EXCEPTION_RESPONSE), handleBlock(t, &GeneralBlockTransfer{LastBlock: true, Window: 3, BlockNumber: 2, BlockData: make([]byte, 10)}, clientAddr)[0])
+}
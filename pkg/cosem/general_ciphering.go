@@ -0,0 +1,208 @@
+package cosem
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// KeyInfoType is the choice of a Key-Info: how the key protecting a
+// general-ciphering APDU is obtained.
+type KeyInfoType byte
+
+const (
+	KeyInfoIdentifiedKey KeyInfoType = 0
+	KeyInfoWrappedKey    KeyInfoType = 1
+	KeyInfoAgreedKey     KeyInfoType = 2
+)
+
+// KeyID identifies a global key in an identified-key Key-Info and in the
+// methods of the security setup.
+type KeyID byte
+
+const (
+	KeyIDGlobalUnicastEncryption   KeyID = 0
+	KeyIDGlobalBroadcastEncryption KeyID = 1
+	KeyIDGlobalAuthentication      KeyID = 2
+	KeyIDMasterKey                 KeyID = 3
+)
+
+// KekIDMasterKey is the only key encrypting key of a wrapped-key Key-Info.
+const KekIDMasterKey byte = 0
+
+// KeyInfo represents the Key-Info of a general-ciphering APDU. Only the fields
+// of the chosen Type are encoded.
+type KeyInfo struct {
+	Type KeyInfoType
+	// KeyID is the global key of an identified-key.
+	KeyID KeyID
+	// KekID is the key encrypting key of a wrapped-key.
+	KekID byte
+	// KeyParameters are the key agreement parameters of an agreed-key.
+	KeyParameters []byte
+	// KeyCipheredData is the wrapped key or the ephemeral public key of a
+	// wrapped-key or an agreed-key.
+	KeyCipheredData []byte
+}
+
+// GeneralCiphering represents the general-ciphering APDU, which carries the
+// originator and recipient system titles and the key information alongside
+// the protected APDU.
+type GeneralCiphering struct {
+	TransactionID         []byte
+	OriginatorSystemTitle []byte
+	RecipientSystemTitle  []byte
+	// DateTime is the COSEM date-time of the APDU as an octet string; it may be
+	// empty.
+	DateTime         []byte
+	OtherInformation []byte
+	// KeyInfo is optional; nil means the key is known from the context.
+	KeyInfo        *KeyInfo
+	SecurityHeader SecurityHeader
+	Ciphertext     []byte
+}
+
+// Seal protects plaintext with key and stores the result in the APDU. The
+// originator system title is used for the nonce.
+func (g *GeneralCiphering) Seal(key, plaintext []byte, header *SecurityHeader, suite SecuritySuite) error {
+	ciphertext, err := EncryptAndTag(key, plaintext, g.OriginatorSystemTitle, header, suite)
+	if err != nil {
+		return err
+	}
+	g.SecurityHeader = *header
+	g.Ciphertext = ciphertext
+	return nil
+}
+
+// Open verifies and decrypts the protected APDU using the originator system
+// title carried in the general-ciphering APDU.
+func (g *GeneralCiphering) Open(key []byte, suite SecuritySuite, lastFrameCounter uint32) ([]byte, error) {
+	return DecryptAndVerify(key, g.Ciphertext, g.OriginatorSystemTitle, &g.SecurityHeader, suite, lastFrameCounter)
+}
+
+// Encode encodes the GeneralCiphering APDU into a byte slice.
+func (g *GeneralCiphering) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_GENERAL_CIPHERING))
+	for _, field := range [][]byte{g.TransactionID, g.OriginatorSystemTitle, g.RecipientSystemTitle, g.DateTime, g.OtherInformation} {
+		if err := writeOctetString(&buf, field); err != nil {
+			return nil, err
+		}
+	}
+	if g.KeyInfo == nil {
+		buf.WriteByte(0x00)
+	} else {
+		buf.WriteByte(0x01)
+		if err := g.KeyInfo.encode(&buf); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeCipheredContent(&buf, &g.SecurityHeader, g.Ciphertext); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into a GeneralCiphering APDU.
+func (g *GeneralCiphering) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_GENERAL_CIPHERING, "GeneralCiphering")
+	if err != nil {
+		return err
+	}
+
+	g.TransactionID, err = readOctetString(reader, "TransactionID")
+	if err != nil {
+		return err
+	}
+	g.OriginatorSystemTitle, err = readSystemTitle(reader, "OriginatorSystemTitle")
+	if err != nil {
+		return err
+	}
+	g.RecipientSystemTitle, err = readOctetString(reader, "RecipientSystemTitle")
+	if err != nil {
+		return err
+	}
+	g.DateTime, err = readOctetString(reader, "DateTime")
+	if err != nil {
+		return err
+	}
+	g.OtherInformation, err = readOctetString(reader, "OtherInformation")
+	if err != nil {
+		return err
+	}
+
+	present, err := readOptionalFlag(reader, "KeyInfo")
+	if err != nil {
+		return err
+	}
+	g.KeyInfo = nil
+	if present {
+		g.KeyInfo = &KeyInfo{}
+		if err := g.KeyInfo.decode(reader); err != nil {
+			return err
+		}
+	}
+
+	g.Ciphertext, err = readCipheredContent(reader, &g.SecurityHeader)
+	if err != nil {
+		return err
+	}
+
+	return expectEnd(reader, "GeneralCiphering")
+}
+
+func (k *KeyInfo) encode(buf *bytes.Buffer) error {
+	buf.WriteByte(byte(k.Type))
+	switch k.Type {
+	case KeyInfoIdentifiedKey:
+		buf.WriteByte(byte(k.KeyID))
+		return nil
+	case KeyInfoWrappedKey:
+		buf.WriteByte(k.KekID)
+		return writeOctetString(buf, k.KeyCipheredData)
+	case KeyInfoAgreedKey:
+		if err := writeOctetString(buf, k.KeyParameters); err != nil {
+			return err
+		}
+		return writeOctetString(buf, k.KeyCipheredData)
+	default:
+		return fmt.Errorf("unsupported Key-Info choice: %d", k.Type)
+	}
+}
+
+func (k *KeyInfo) decode(reader *bytes.Reader) error {
+	choice, err := readByte(reader, "KeyInfo")
+	if err != nil {
+		return err
+	}
+	*k = KeyInfo{Type: KeyInfoType(choice)}
+
+	switch k.Type {
+	case KeyInfoIdentifiedKey:
+		keyID, err := readByte(reader, "KeyID")
+		if err != nil {
+			return err
+		}
+		k.KeyID = KeyID(keyID)
+	case KeyInfoWrappedKey:
+		k.KekID, err = readByte(reader, "KekID")
+		if err != nil {
+			return err
+		}
+		k.KeyCipheredData, err = readOctetString(reader, "KeyCipheredData")
+		if err != nil {
+			return err
+		}
+	case KeyInfoAgreedKey:
+		k.KeyParameters, err = readOctetString(reader, "KeyParameters")
+		if err != nil {
+			return err
+		}
+		k.KeyCipheredData, err = readOctetString(reader, "KeyCipheredData")
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported Key-Info choice: %d", choice)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package cosem
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneralCiphering_WireFormat(t *testing.T) {
+	g := &GeneralCiphering{
+		TransactionID:         []byte{0x01},
+		OriginatorSystemTitle: []byte("CLIENT01"),
+		RecipientSystemTitle:  []byte("SERVER01"),
+		DateTime:              []byte{},
+		OtherInformation:      []byte{},
+		KeyInfo:               &KeyInfo{Type: KeyInfoIdentifiedKey, KeyID: KeyIDGlobalUnicastEncryption},
+		SecurityHeader:        SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: 1},
+		Ciphertext:            []byte{0xAA, 0xBB},
+	}
+	encoded, err := g.Encode()
+	require.NoError(t, err)
+
+	want := []byte{0xDD, 0x01, 0x01, 0x08}
+	want = append(want, "CLIENT01"...)
+	want = append(want, 0x08)
+	want = append(want, "SERVER01"...)
+	want = append(want, 0x00, 0x00, 0x01, 0x00, 0x00, 0x07, 0x30, 0x00, 0x00, 0x00, 0x01, 0xAA, 0xBB)
+	assert.Equal(t, want, encoded)
+
+	decoded := &GeneralCiphering{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, g, decoded)
+
+	for _, keyInfo := range []*KeyInfo{
+		nil,
+		{Type: KeyInfoWrappedKey, KekID: KekIDMasterKey, KeyCipheredData: []byte{1, 2, 3}},
+		{Type: KeyInfoAgreedKey, KeyParameters: []byte{0x01}, KeyCipheredData: []byte{4, 5}},
+	} {
+		g.KeyInfo = keyInfo
+		encoded, err := g.Encode()
+		require.NoError(t, err)
+		decoded := &GeneralCiphering{}
+		require.NoError(t, decoded.Decode(encoded))
+		assert.Equal(t, keyInfo, decoded.KeyInfo)
+	}
+
+	assert.Error(t, decoded.Decode(encoded[:len(encoded)-8]))
+	g.OriginatorSystemTitle = []byte("SHORT")
+	encoded, err = g.Encode()
+	require.NoError(t, err)
+	assert.Error(t, decoded.Decode(encoded))
+}
+
+func TestGeneralDedCiphering_SealAndOpen(t *testing.T) {
+	key := []byte("DEDICATEDKEY0123")
+	header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: 3}
+	ded, err := NewGeneralDedCiphering(key, []byte{0xC0, 0x01}, []byte("CLIENT01"), header, SecuritySuite0)
+	require.NoError(t, err)
+
+	encoded, err := ded.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, byte(APDU_GENERAL_DED_CIPHERING), encoded[0])
+
+	decoded := &GeneralDedCiphering{}
+	require.NoError(t, decoded.Decode(encoded))
+	plaintext, err := decoded.Open(key, SecuritySuite0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xC0, 0x01}, plaintext)
+
+	assert.Error(t, (&GeneralGloCiphering{}).Decode(encoded))
+}
+
+func TestApplication_GeneralCiphering(t *testing.T) {
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+	guek := []byte("0123456789ABCDEF")
+	dedicatedKey := []byte("FEDCBA9876543210")
+
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), clientSystemTitle, serverSystemTitle, nil, guek, nil)
+	require.NoError(t, err)
+	require.NoError(t, securitySetup.SetAttribute(2, SecurityPolicy(PolicyAuthenticatedRequest|PolicyEncryptedRequest)))
+	app := NewApplication(nil, securitySetup)
+
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	clientAddr := mockAddr("general-client")
+	app.AddAssociation(clientAddr.String(), assoc)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
+
+	dataObj, err := NewData(obisOf(t, "1.0.0.3.0.255"), uint32(4242))
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+
+	req, err := (&GetRequest{
+		Type:                GET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0x81,
+		AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+	}).Encode()
+	require.NoError(t, err)
+
+	header := func(frameCounter uint32) *SecurityHeader {
+		return &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: frameCounter}
+	}
+	checkGetResponse := func(t *testing.T, plaintext []byte) {
+		resp := &GetResponse{}
+		require.NoError(t, resp.Decode(plaintext))
+		assert.Equal(t, uint32(4242), resp.Result.Value)
+	}
+
+	t.Run("GeneralGloCiphering", func(t *testing.T) {
+		glo, err := NewGeneralGloCiphering(guek, req, clientSystemTitle, header(1), SecuritySuite0)
+		require.NoError(t, err)
+		src, err := glo.Encode()
+		require.NoError(t, err)
+
+		encodedResp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		resp := &GeneralGloCiphering{}
+		require.NoError(t, resp.Decode(encodedResp))
+		assert.Equal(t, serverSystemTitle, resp.SystemTitle)
+		plaintext, err := resp.Open(guek, SecuritySuite0, 0)
+		require.NoError(t, err)
+		checkGetResponse(t, plaintext)
+	})
+
+	t.Run("GeneralDedCipheringWithoutKey", func(t *testing.T) {
+		ded, err := NewGeneralDedCiphering(dedicatedKey, req, clientSystemTitle, header(2), SecuritySuite0)
+		require.NoError(t, err)
+		src, err := ded.Encode()
+		require.NoError(t, err)
+
+		encodedResp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x01, 0x05}, encodedResp)
+	})
+
+	t.Run("GeneralDedCiphering", func(t *testing.T) {
+		require.NoError(t, app.SetDedicatedKey(clientAddr.String(), dedicatedKey))
+		defer func() { require.NoError(t, app.SetDedicatedKey(clientAddr.String(), nil)) }()
+
+		ded, err := NewGeneralDedCiphering(dedicatedKey, req, clientSystemTitle, header(3), SecuritySuite0)
+		require.NoError(t, err)
+		src, err := ded.Encode()
+		require.NoError(t, err)
+
+		encodedResp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		resp := &GeneralDedCiphering{}
+		require.NoError(t, resp.Decode(encodedResp))
+		plaintext, err := resp.Open(dedicatedKey, SecuritySuite0, 0)
+		require.NoError(t, err)
+		checkGetResponse(t, plaintext)
+	})
+
+	t.Run("GeneralCiphering", func(t *testing.T) {
+		general := &GeneralCiphering{
+			TransactionID:         []byte{0x2A},
+			OriginatorSystemTitle: clientSystemTitle,
+			RecipientSystemTitle:  serverSystemTitle,
+			DateTime:              []byte{},
+			OtherInformation:      []byte{},
+			KeyInfo:               &KeyInfo{Type: KeyInfoIdentifiedKey, KeyID: KeyIDGlobalUnicastEncryption},
+		}
+		require.NoError(t, general.Seal(guek, req, header(4), SecuritySuite0))
+		src, err := general.Encode()
+		require.NoError(t, err)
+
+		encodedResp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		resp := &GeneralCiphering{}
+		require.NoError(t, resp.Decode(encodedResp))
+		assert.Equal(t, []byte{0x2A}, resp.TransactionID)
+		assert.Equal(t, serverSystemTitle, resp.OriginatorSystemTitle)
+		assert.Equal(t, clientSystemTitle, resp.RecipientSystemTitle)
+		plaintext, err := resp.Open(guek, SecuritySuite0, 0)
+		require.NoError(t, err)
+		checkGetResponse(t, plaintext)
+	})
+
+	t.Run("GeneralCipheringWithWrappedKey", func(t *testing.T) {
+		general := &GeneralCiphering{
+			TransactionID:         []byte{0x2B},
+			OriginatorSystemTitle: clientSystemTitle,
+			KeyInfo:               &KeyInfo{Type: KeyInfoWrappedKey, KekID: KekIDMasterKey, KeyCipheredData: make([]byte, 24)},
+		}
+		require.NoError(t, general.Seal(guek, req, header(5), SecuritySuite0))
+		src, err := general.Encode()
+		require.NoError(t, err)
+
+		encodedResp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x02, 0x02}, encodedResp)
+	})
+
+	t.Run("GeneralCipheringWithAgreedKey", func(t *testing.T) {
+		staticKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		securitySetup.ServerKeyAgreementKey = staticKey
+		require.NoError(t, securitySetup.SetAttribute(3, SecuritySuite1))
+		defer func() { require.NoError(t, securitySetup.SetAttribute(3, SecuritySuite0)) }()
+
+		ephemeralKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		encodedKey, err := MarshalPublicKey(&ephemeralKey.PublicKey)
+		require.NoError(t, err)
+		key, err := AgreeKey(SecuritySuite1, ephemeralKey, &staticKey.PublicKey, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle)
+		require.NoError(t, err)
+
+		general := &GeneralCiphering{
+			TransactionID:         []byte{0x2C},
+			OriginatorSystemTitle: clientSystemTitle,
+			RecipientSystemTitle:  serverSystemTitle,
+			KeyInfo: &KeyInfo{
+				Type:            KeyInfoAgreedKey,
+				KeyParameters:   []byte{KeyAgreementOnePassDiffieHellman},
+				KeyCipheredData: encodedKey[1:],
+			},
+		}
+		require.NoError(t, general.Seal(key, req, header(6), SecuritySuite1))
+		src, err := general.Encode()
+		require.NoError(t, err)
+
+		encodedResp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		resp := &GeneralCiphering{}
+		require.NoError(t, resp.Decode(encodedResp))
+		plaintext, err := resp.Open(key, SecuritySuite1, 0)
+		require.NoError(t, err)
+		checkGetResponse(t, plaintext)
+
+		// Only One-Pass Diffie-Hellman is supported for agreed keys.
+		general.KeyInfo.KeyParameters = []byte{KeyAgreementStaticUnifiedModel}
+		require.NoError(t, general.Seal(key, req, header(7), SecuritySuite1))
+		src, err = general.Encode()
+		require.NoError(t, err)
+		encodedResp, err = app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x02, 0x02}, encodedResp)
+	})
+}
@@ -0,0 +1,153 @@
+package cosem
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+
+	"github.com/ddulesov/gogost/gost34112012256"
+)
+
+// APDU_GENERAL_SIGNING is the tag of the general-signing APDU.
+const APDU_GENERAL_SIGNING APDUType = 0xDF
+
+// GeneralSigning represents the general-signing APDU. Content is the signed APDU,
+// which may itself be ciphered. The signature covers the encoding of every
+// component before the signature.
+type GeneralSigning struct {
+	TransactionID         []byte
+	OriginatorSystemTitle []byte
+	RecipientSystemTitle  []byte
+	// DateTime is the COSEM date-time of the APDU as an octet string; it may be
+	// empty.
+	DateTime         []byte
+	OtherInformation []byte
+	Content          []byte
+	Signature        []byte
+}
+
+// Sign computes the signature of the APDU with key, which is either an
+// *ecdsa.PrivateKey on P-256 or P-384, hashed with SHA-256 or SHA-384, or a
+// *GOSTPrivateKey, hashed with GOST R 34.11-2012.
+func (g *GeneralSigning) Sign(key crypto.PrivateKey) error {
+	signed, err := g.signedData()
+	if err != nil {
+		return err
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		digest, err := ecdsaDigest(k.Curve, signed)
+		if err != nil {
+			return err
+		}
+		g.Signature, err = SignECDSA(k, digest)
+		return err
+	case *GOSTPrivateKey:
+		g.Signature, err = SignGOST(k, gostDigest(signed))
+		return err
+	default:
+		return ErrInvalidPrivateKey
+	}
+}
+
+// Verify checks the signature of the APDU with key, an *ecdsa.PublicKey or a
+// *GOSTPublicKey. It returns ErrInvalidSignature if the signature does not match.
+func (g *GeneralSigning) Verify(key crypto.PublicKey) error {
+	signed, err := g.signedData()
+	if err != nil {
+		return err
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		digest, err := ecdsaDigest(k.Curve, signed)
+		if err != nil {
+			return err
+		}
+		return VerifyECDSA(k, digest, g.Signature)
+	case *GOSTPublicKey:
+		return VerifyGOST(k, gostDigest(signed), g.Signature)
+	default:
+		return ErrInvalidPublicKey
+	}
+}
+
+// Encode encodes the GeneralSigning APDU into a byte slice.
+func (g *GeneralSigning) Encode() ([]byte, error) {
+	signed, err := g.signedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := writeOctetString(buf, g.Signature); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into a GeneralSigning APDU.
+func (g *GeneralSigning) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_GENERAL_SIGNING, "GeneralSigning")
+	if err != nil {
+		return err
+	}
+
+	fields := []struct {
+		name  string
+		value *[]byte
+	}{
+		{"TransactionID", &g.TransactionID},
+		{"OriginatorSystemTitle", &g.OriginatorSystemTitle},
+		{"RecipientSystemTitle", &g.RecipientSystemTitle},
+		{"DateTime", &g.DateTime},
+		{"OtherInformation", &g.OtherInformation},
+		{"Content", &g.Content},
+		{"Signature", &g.Signature},
+	}
+	for _, field := range fields {
+		*field.value, err = readOctetString(reader, field.name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectEnd(reader, "GeneralSigning")
+}
+
+// signedData encodes the tag and every component covered by the signature.
+func (g *GeneralSigning) signedData() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_GENERAL_SIGNING))
+	for _, field := range [][]byte{g.TransactionID, g.OriginatorSystemTitle, g.RecipientSystemTitle, g.DateTime, g.OtherInformation, g.Content} {
+		if err := writeOctetString(&buf, field); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// ecdsaDigest hashes data with the hash function paired with curve.
+func ecdsaDigest(curve elliptic.Curve, data []byte) ([]byte, error) {
+	switch curve {
+	case elliptic.P256():
+		digest := sha256.Sum256(data)
+		return digest[:], nil
+	case elliptic.P384():
+		digest := sha512.Sum384(data)
+		return digest[:], nil
+	default:
+		return nil, fmt.Errorf("unsupported ECDSA curve")
+	}
+}
+
+// gostDigest hashes data with GOST R 34.11-2012 (256 bit).
+func gostDigest(data []byte) []byte {
+	h := gost34112012256.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,221 @@
+package cosem
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneralSigning_WireFormat(t *testing.T) {
+	g := &GeneralSigning{
+		TransactionID:         []byte{0x01},
+		OriginatorSystemTitle: []byte("CLIENT01"),
+		RecipientSystemTitle:  []byte{},
+		DateTime:              []byte{},
+		OtherInformation:      []byte{},
+		Content:               []byte{0xC0, 0x01},
+		Signature:             []byte{0xAA},
+	}
+	encoded, err := g.Encode()
+	require.NoError(t, err)
+
+	want := []byte{0xDF, 0x01, 0x01, 0x08}
+	want = append(want, "CLIENT01"...)
+	want = append(want, 0x00, 0x00, 0x00, 0x02, 0xC0, 0x01, 0x01, 0xAA)
+	assert.Equal(t, want, encoded)
+
+	decoded := &GeneralSigning{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, g, decoded)
+
+	assert.Error(t, decoded.Decode(encoded[:len(encoded)-1]))
+	assert.Error(t, decoded.Decode(append(encoded, 0x00)))
+}
+
+func TestGeneralSigning_SignAndVerify(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	gost, err := GenerateGOSTKey()
+	require.NoError(t, err)
+
+	keys := map[string]struct {
+		priv interface{}
+		pub  interface{}
+		size int
+	}{
+		"ECDSA-P256": {p256, &p256.PublicKey, 64},
+		"ECDSA-P384": {p384, &p384.PublicKey, 96},
+		"GOST":       {gost, &gost.PublicKey, 64},
+	}
+	for name, key := range keys {
+		t.Run(name, func(t *testing.T) {
+			g := &GeneralSigning{TransactionID: []byte{0x07}, OriginatorSystemTitle: []byte("CLIENT01"), Content: []byte{0xC0, 0x01}}
+			require.NoError(t, g.Sign(key.priv))
+			assert.Len(t, g.Signature, key.size)
+
+			encoded, err := g.Encode()
+			require.NoError(t, err)
+			decoded := &GeneralSigning{}
+			require.NoError(t, decoded.Decode(encoded))
+			assert.NoError(t, decoded.Verify(key.pub))
+
+			decoded.Content[1] = 0x02
+			assert.ErrorIs(t, decoded.Verify(key.pub), ErrInvalidSignature)
+		})
+	}
+
+	g := &GeneralSigning{}
+	assert.ErrorIs(t, g.Sign([]byte("not a key")), ErrInvalidPrivateKey)
+	assert.ErrorIs(t, g.Verify(&gost.PublicKey), ErrInvalidSignature)
+	assert.ErrorIs(t, g.Verify("not a key"), ErrInvalidPublicKey)
+}
+
+func TestApplication_GeneralSigning(t *testing.T) {
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serverSystemTitle := []byte("SERVER01")
+
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), []byte("CLIENT01"), serverSystemTitle, nil, nil, nil)
+	require.NoError(t, err)
+	securitySetup.ClientSigningKey = &clientKey.PublicKey
+	securitySetup.ServerSigningKey = serverKey
+	require.NoError(t, securitySetup.SetAttribute(2, SecurityPolicy(PolicyDigitallySignedRequest|PolicyDigitallySignedResponse)))
+	app := NewApplication(nil, securitySetup)
+
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	clientAddr := mockAddr("signing-client")
+	app.AddAssociation(clientAddr.String(), assoc)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
+
+	dataObj, err := NewData(obisOf(t, "1.0.0.3.0.255"), uint32(31337))
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+
+	req, err := (&GetRequest{
+		Type:                GET_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0x81,
+		AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+	}).Encode()
+	require.NoError(t, err)
+
+	signAs := func(key *ecdsa.PrivateKey, g *GeneralSigning) []byte {
+		require.NoError(t, g.Sign(key))
+		encoded, err := g.Encode()
+		require.NoError(t, err)
+		return encoded
+	}
+	transactionID := uint16(0x1122)
+	sign := func(key *ecdsa.PrivateKey, content []byte) []byte {
+		transactionID++
+		return signAs(key, &GeneralSigning{
+			TransactionID:         []byte{byte(transactionID >> 8), byte(transactionID)},
+			OriginatorSystemTitle: []byte("CLIENT01"),
+			RecipientSystemTitle:  serverSystemTitle,
+			Content:               content,
+		})
+	}
+
+	t.Run("SignedRequest", func(t *testing.T) {
+		encodedResp, err := app.HandleAPDU(sign(clientKey, req), clientAddr)
+		require.NoError(t, err)
+
+		resp := &GeneralSigning{}
+		require.NoError(t, resp.Decode(encodedResp))
+		require.NoError(t, resp.Verify(&serverKey.PublicKey))
+		assert.Equal(t, []byte{0x11, 0x23}, resp.TransactionID)
+		assert.Equal(t, serverSystemTitle, resp.OriginatorSystemTitle)
+		assert.Equal(t, []byte("CLIENT01"), resp.RecipientSystemTitle)
+
+		getResp := &GetResponse{}
+		require.NoError(t, getResp.Decode(resp.Content))
+		assert.Equal(t, uint32(31337), getResp.Result.Value)
+	})
+
+	t.Run("UnsignedRequestRejected", func(t *testing.T) {
+		resp, err := app.HandleAPDU(req, clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x01, 0x01}, resp)
+	})
+
+	t.Run("WrongSignerRejected", func(t *testing.T) {
+		resp, err := app.HandleAPDU(sign(serverKey, req), clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp)
+	})
+
+	t.Run("NestedSigningRejected", func(t *testing.T) {
+		resp, err := app.HandleAPDU(sign(clientKey, sign(clientKey, req)), clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x02, 0x03}, resp)
+	})
+
+	t.Run("ReplayedTransactionRejected", func(t *testing.T) {
+		signed := sign(clientKey, req)
+		resp, err := app.HandleAPDU(signed, clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, byte(APDU_GENERAL_SIGNING), resp[0])
+
+		resp, err = app.HandleAPDU(signed, clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp)
+
+		// An older transaction-id is refused as well.
+		resp, err = app.HandleAPDU(signAs(clientKey, &GeneralSigning{
+			TransactionID:         []byte{0x00, 0x01},
+			OriginatorSystemTitle: []byte("CLIENT01"),
+			RecipientSystemTitle:  serverSystemTitle,
+			Content:               req,
+		}), clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp)
+	})
+
+	t.Run("WrongSystemTitlesRejected", func(t *testing.T) {
+		for name, g := range map[string]*GeneralSigning{
+			"Recipient":  {OriginatorSystemTitle: []byte("CLIENT01"), RecipientSystemTitle: []byte("OTHERSRV")},
+			"Originator": {OriginatorSystemTitle: []byte("CLIENT02"), RecipientSystemTitle: serverSystemTitle},
+		} {
+			transactionID++
+			g.TransactionID = []byte{byte(transactionID >> 8), byte(transactionID)}
+			g.Content = req
+			resp, err := app.HandleAPDU(signAs(clientKey, g), clientAddr)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp, name)
+		}
+	})
+
+	t.Run("DateTime", func(t *testing.T) {
+		signAt := func(at time.Time) []byte {
+			dateTime, err := axdr.Encode(axdr.FromTime(at, false))
+			require.NoError(t, err)
+			transactionID++
+			return signAs(clientKey, &GeneralSigning{
+				TransactionID:         []byte{byte(transactionID >> 8), byte(transactionID)},
+				OriginatorSystemTitle: []byte("CLIENT01"),
+				RecipientSystemTitle:  serverSystemTitle,
+				DateTime:              dateTime[1:],
+				Content:               req,
+			})
+		}
+
+		resp, err := app.HandleAPDU(signAt(time.Now().UTC()), clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, byte(APDU_GENERAL_SIGNING), resp[0])
+
+		resp, err = app.HandleAPDU(signAt(time.Now().UTC().Add(-time.Hour)), clientAddr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xD8, 0x01, 0x05}, resp, "a stale date-time must be refused")
+	})
+}
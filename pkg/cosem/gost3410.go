@@ -0,0 +1,146 @@
+package cosem
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+)
+
+// gostCurve is the 256-bit id-GostR3410-2001-CryptoPro-A-ParamSet curve (RFC 4357),
+// which is also a parameter set of GOST R 34.10-2012. Its coefficient a is p-3, so
+// the generic short Weierstrass arithmetic of elliptic.CurveParams applies.
+var gostCurve = &elliptic.CurveParams{
+	Name:    "id-GostR3410-2001-CryptoPro-A-ParamSet",
+	P:       gostHexInt("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFD97"),
+	N:       gostHexInt("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF6C611070995AD10045841B09B761B893"),
+	B:       gostHexInt("A6"),
+	Gx:      gostHexInt("01"),
+	Gy:      gostHexInt("8D91E471E0989CDA27DF505A453F2B7635294F2DDF23E3B122ACC99C9E9F1E14"),
+	BitSize: 256,
+}
+
+// gostCoordinateSize is the length of a scalar or coordinate of gostCurve in bytes.
+const gostCoordinateSize = 32
+
+func gostHexInt(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid GOST curve parameter: " + s)
+	}
+	return v
+}
+
+// GOSTPublicKey is a GOST R 34.10-2012 256-bit public key.
+type GOSTPublicKey struct {
+	X, Y *big.Int
+}
+
+// GOSTPrivateKey is a GOST R 34.10-2012 256-bit private key.
+type GOSTPrivateKey struct {
+	PublicKey GOSTPublicKey
+	D         *big.Int
+}
+
+// GenerateGOSTKey generates a new GOST R 34.10-2012 256-bit key pair.
+func GenerateGOSTKey() (*GOSTPrivateKey, error) {
+	d, err := randomGOSTScalar()
+	if err != nil {
+		return nil, err
+	}
+	x, y := gostCurve.ScalarBaseMult(d.Bytes())
+	return &GOSTPrivateKey{PublicKey: GOSTPublicKey{X: x, Y: y}, D: d}, nil
+}
+
+// SignGOST signs a GOST R 34.11-2012 digest with the provided private key. The
+// signature is s followed by r, each 32 bytes long.
+func SignGOST(priv *GOSTPrivateKey, digest []byte) ([]byte, error) {
+	if priv == nil || priv.D == nil || priv.D.Sign() <= 0 || priv.D.Cmp(gostCurve.N) >= 0 {
+		return nil, ErrInvalidPrivateKey
+	}
+	e := gostDigestScalar(digest)
+
+	for {
+		k, err := randomGOSTScalar()
+		if err != nil {
+			return nil, err
+		}
+		x, _ := gostCurve.ScalarBaseMult(k.Bytes())
+		r := new(big.Int).Mod(x, gostCurve.N)
+		if r.Sign() == 0 {
+			continue
+		}
+		s := new(big.Int).Mul(r, priv.D)
+		s.Add(s, new(big.Int).Mul(k, e))
+		s.Mod(s, gostCurve.N)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		sBytes, err := padScalar(s.Bytes(), gostCoordinateSize)
+		if err != nil {
+			return nil, err
+		}
+		rBytes, err := padScalar(r.Bytes(), gostCoordinateSize)
+		if err != nil {
+			return nil, err
+		}
+		return append(sBytes, rBytes...), nil
+	}
+}
+
+// VerifyGOST verifies a signature produced by SignGOST.
+func VerifyGOST(pub *GOSTPublicKey, digest, sig []byte) error {
+	if pub == nil || pub.X == nil || pub.Y == nil || !gostCurve.IsOnCurve(pub.X, pub.Y) {
+		return ErrInvalidPublicKey
+	}
+	if len(sig) != 2*gostCoordinateSize {
+		return ErrInvalidSignature
+	}
+	s := new(big.Int).SetBytes(sig[:gostCoordinateSize])
+	r := new(big.Int).SetBytes(sig[gostCoordinateSize:])
+	if r.Sign() <= 0 || r.Cmp(gostCurve.N) >= 0 || s.Sign() <= 0 || s.Cmp(gostCurve.N) >= 0 {
+		return ErrInvalidSignature
+	}
+
+	v := new(big.Int).ModInverse(gostDigestScalar(digest), gostCurve.N)
+	z1 := new(big.Int).Mul(s, v)
+	z1.Mod(z1, gostCurve.N)
+	z2 := new(big.Int).Mul(r, v)
+	z2.Mod(z2.Neg(z2), gostCurve.N)
+
+	x1, y1 := gostCurve.ScalarBaseMult(z1.Bytes())
+	x2, y2 := gostCurve.ScalarMult(pub.X, pub.Y, z2.Bytes())
+	x, _ := gostCurve.Add(x1, y1, x2, y2)
+	if new(big.Int).Mod(x, gostCurve.N).Cmp(r) != 0 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// gostDigestScalar converts a digest into the integer e of GOST R 34.10-2012. The
+// digest is read as a little-endian number, and e = 0 is replaced by 1.
+func gostDigestScalar(digest []byte) *big.Int {
+	reversed := make([]byte, len(digest))
+	for i, b := range digest {
+		reversed[len(digest)-1-i] = b
+	}
+	e := new(big.Int).SetBytes(reversed)
+	e.Mod(e, gostCurve.N)
+	if e.Sign() == 0 {
+		e.SetInt64(1)
+	}
+	return e
+}
+
+// randomGOSTScalar returns a random integer in [1, N-1].
+func randomGOSTScalar() (*big.Int, error) {
+	for {
+		k, err := rand.Int(rand.Reader, gostCurve.N)
+		if err != nil {
+			return nil, err
+		}
+		if k.Sign() != 0 {
+			return k, nil
+		}
+	}
+}
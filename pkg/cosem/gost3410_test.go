@@ -0,0 +1,41 @@
+package cosem
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGOSTCurveParameters(t *testing.T) {
+	assert.True(t, gostCurve.IsOnCurve(gostCurve.Gx, gostCurve.Gy))
+	x, y := gostCurve.ScalarBaseMult(gostCurve.N.Bytes())
+	assert.Equal(t, 0, x.Sign())
+	assert.Equal(t, 0, y.Sign())
+}
+
+func TestSignGOST(t *testing.T) {
+	priv, err := GenerateGOSTKey()
+	require.NoError(t, err)
+	digest := gostDigest([]byte("general-signing"))
+
+	sig, err := SignGOST(priv, digest)
+	require.NoError(t, err)
+	assert.Len(t, sig, 64)
+	assert.NoError(t, VerifyGOST(&priv.PublicKey, digest, sig))
+
+	tampered := append([]byte(nil), sig...)
+	tampered[10] ^= 0x01
+	assert.ErrorIs(t, VerifyGOST(&priv.PublicKey, digest, tampered), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyGOST(&priv.PublicKey, gostDigest([]byte("other")), sig), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyGOST(&priv.PublicKey, digest, sig[:63]), ErrInvalidSignature)
+
+	other, err := GenerateGOSTKey()
+	require.NoError(t, err)
+	assert.ErrorIs(t, VerifyGOST(&other.PublicKey, digest, sig), ErrInvalidSignature)
+	assert.ErrorIs(t, VerifyGOST(&GOSTPublicKey{X: big.NewInt(1), Y: big.NewInt(1)}, digest, sig), ErrInvalidPublicKey)
+
+	_, err = SignGOST(&GOSTPrivateKey{D: big.NewInt(0)}, digest)
+	assert.ErrorIs(t, err, ErrInvalidPrivateKey)
+}
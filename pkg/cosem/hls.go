@@ -4,6 +4,11 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
 	"fmt"
 )
 
@@ -15,6 +20,17 @@ const (
 	APDU_GLO_GET_RESPONSE    APDUType = 0xCC
 	APDU_GLO_SET_RESPONSE    APDUType = 0xCD
 	APDU_GLO_ACTION_RESPONSE APDUType = 0xCF
+
+	APDU_DED_GET_REQUEST     APDUType = 0xD0
+	APDU_DED_SET_REQUEST     APDUType = 0xD1
+	APDU_DED_ACTION_REQUEST  APDUType = 0xD3
+	APDU_DED_GET_RESPONSE    APDUType = 0xD4
+	APDU_DED_SET_RESPONSE    APDUType = 0xD5
+	APDU_DED_ACTION_RESPONSE APDUType = 0xD7
+
+	APDU_GENERAL_GLO_CIPHERING APDUType = 0xDB
+	APDU_GENERAL_DED_CIPHERING APDUType = 0xDC
+	APDU_GENERAL_CIPHERING     APDUType = 0xDD
 )
 
 const (
@@ -69,53 +85,256 @@ func (h *SecurityHeader) Decode(src []byte) error {
 	return nil
 }
 
-// EncryptAndTag encrypts and authenticates a plaintext APDU.
-func EncryptAndTag(key, plaintext, serverSystemTitle []byte, header *SecurityHeader, suite SecuritySuite) ([]byte, error) {
+// CipheredAPDU represents a service-specific glo- or ded-ciphered APDU, such as
+// glo-get-request or ded-action-response. Tag is the tag of the ciphered service;
+// its content is an OCTET STRING holding the security header followed by the
+// ciphertext. The system title of the originator is not carried in the APDU.
+type CipheredAPDU struct {
+	Tag            APDUType
+	SecurityHeader SecurityHeader
+	Ciphertext     []byte
+}
+
+// NewCipheredAPDU protects plaintext with key under the system title of its
+// originator and wraps it in the ciphered APDU tag.
+func NewCipheredAPDU(tag APDUType, key, plaintext, systemTitle []byte, header *SecurityHeader, suite SecuritySuite) (*CipheredAPDU, error) {
+	ciphertext, err := EncryptAndTag(key, plaintext, systemTitle, header, suite)
+	if err != nil {
+		return nil, err
+	}
+	return &CipheredAPDU{Tag: tag, SecurityHeader: *header, Ciphertext: ciphertext}, nil
+}
+
+// Open verifies and decrypts the protected APDU, sent by the originator with
+// systemTitle.
+func (c *CipheredAPDU) Open(key, systemTitle []byte, suite SecuritySuite, lastFrameCounter uint32) ([]byte, error) {
+	return DecryptAndVerify(key, c.Ciphertext, systemTitle, &c.SecurityHeader, suite, lastFrameCounter)
+}
+
+// Encode encodes the ciphered APDU: its tag and the ciphered content.
+func (c *CipheredAPDU) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(c.Tag))
+	if err := writeCipheredContent(&buf, &c.SecurityHeader, c.Ciphertext); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into a ciphered APDU of any tag.
+func (c *CipheredAPDU) Decode(src []byte) error {
+	if len(src) == 0 {
+		return fmt.Errorf("empty source byte slice")
+	}
+	reader := bytes.NewReader(src[1:])
+	ciphertext, err := readCipheredContent(reader, &c.SecurityHeader)
+	if err != nil {
+		return err
+	}
+	c.Tag, c.Ciphertext = APDUType(src[0]), ciphertext
+	return expectEnd(reader, "CipheredAPDU")
+}
+
+// GeneralGloCiphering represents the general-glo-ciphering APDU. Its ciphered
+// content is the security header followed by the protected APDU.
+type GeneralGloCiphering struct {
+	SystemTitle    []byte
+	SecurityHeader SecurityHeader
+	Ciphertext     []byte
+}
+
+// NewGeneralGloCiphering protects plaintext with the global key and wraps it in
+// a general-glo-ciphering APDU sent under systemTitle.
+func NewGeneralGloCiphering(key, plaintext, systemTitle []byte, header *SecurityHeader, suite SecuritySuite) (*GeneralGloCiphering, error) {
+	ciphertext, err := EncryptAndTag(key, plaintext, systemTitle, header, suite)
+	if err != nil {
+		return nil, err
+	}
+	return &GeneralGloCiphering{
+		SystemTitle:    append([]byte(nil), systemTitle...),
+		SecurityHeader: *header,
+		Ciphertext:     ciphertext,
+	}, nil
+}
+
+// Open verifies and decrypts the protected APDU using the system title carried
+// in the general-glo-ciphering APDU.
+func (g *GeneralGloCiphering) Open(key []byte, suite SecuritySuite, lastFrameCounter uint32) ([]byte, error) {
+	return DecryptAndVerify(key, g.Ciphertext, g.SystemTitle, &g.SecurityHeader, suite, lastFrameCounter)
+}
+
+// Encode encodes the general-glo-ciphering APDU: system-title and
+// ciphered-content, both as OCTET STRING.
+func (g *GeneralGloCiphering) Encode() ([]byte, error) {
+	return encodeGeneralCipheredAPDU(APDU_GENERAL_GLO_CIPHERING, g.SystemTitle, &g.SecurityHeader, g.Ciphertext)
+}
+
+// Decode decodes a byte slice into a general-glo-ciphering APDU.
+func (g *GeneralGloCiphering) Decode(src []byte) error {
+	var err error
+	g.SystemTitle, g.Ciphertext, err = decodeGeneralCipheredAPDU(src, APDU_GENERAL_GLO_CIPHERING, "GeneralGloCiphering", &g.SecurityHeader)
+	return err
+}
+
+// GeneralDedCiphering represents the general-ded-ciphering APDU. It has the
+// layout of general-glo-ciphering but is protected with the dedicated key of
+// the association.
+type GeneralDedCiphering struct {
+	SystemTitle    []byte
+	SecurityHeader SecurityHeader
+	Ciphertext     []byte
+}
+
+// NewGeneralDedCiphering protects plaintext with the dedicated key and wraps it
+// in a general-ded-ciphering APDU sent under systemTitle.
+func NewGeneralDedCiphering(key, plaintext, systemTitle []byte, header *SecurityHeader, suite SecuritySuite) (*GeneralDedCiphering, error) {
+	ciphertext, err := EncryptAndTag(key, plaintext, systemTitle, header, suite)
+	if err != nil {
+		return nil, err
+	}
+	return &GeneralDedCiphering{
+		SystemTitle:    append([]byte(nil), systemTitle...),
+		SecurityHeader: *header,
+		Ciphertext:     ciphertext,
+	}, nil
+}
+
+// Open verifies and decrypts the protected APDU using the system title carried
+// in the general-ded-ciphering APDU.
+func (g *GeneralDedCiphering) Open(key []byte, suite SecuritySuite, lastFrameCounter uint32) ([]byte, error) {
+	return DecryptAndVerify(key, g.Ciphertext, g.SystemTitle, &g.SecurityHeader, suite, lastFrameCounter)
+}
+
+// Encode encodes the general-ded-ciphering APDU into a byte slice.
+func (g *GeneralDedCiphering) Encode() ([]byte, error) {
+	return encodeGeneralCipheredAPDU(APDU_GENERAL_DED_CIPHERING, g.SystemTitle, &g.SecurityHeader, g.Ciphertext)
+}
+
+// Decode decodes a byte slice into a general-ded-ciphering APDU.
+func (g *GeneralDedCiphering) Decode(src []byte) error {
+	var err error
+	g.SystemTitle, g.Ciphertext, err = decodeGeneralCipheredAPDU(src, APDU_GENERAL_DED_CIPHERING, "GeneralDedCiphering", &g.SecurityHeader)
+	return err
+}
+
+// encodeGeneralCipheredAPDU encodes the system-title and ciphered-content shared by
+// general-glo-ciphering and general-ded-ciphering.
+func encodeGeneralCipheredAPDU(tag APDUType, systemTitle []byte, header *SecurityHeader, ciphertext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(tag))
+	if err := writeOctetString(&buf, systemTitle); err != nil {
+		return nil, err
+	}
+	if err := writeCipheredContent(&buf, header, ciphertext); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeGeneralCipheredAPDU decodes the system-title and ciphered-content shared by
+// general-glo-ciphering and general-ded-ciphering.
+func decodeGeneralCipheredAPDU(src []byte, tag APDUType, name string, header *SecurityHeader) ([]byte, []byte, error) {
+	reader, err := newAPDUReader(src, tag, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	systemTitle, err := readSystemTitle(reader, "SystemTitle")
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := readCipheredContent(reader, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return systemTitle, ciphertext, expectEnd(reader, name)
+}
+
+// writeCipheredContent writes the security header followed by the ciphertext as a
+// single OCTET STRING.
+func writeCipheredContent(buf *bytes.Buffer, header *SecurityHeader, ciphertext []byte) error {
+	encodedHeader, err := header.Encode()
+	if err != nil {
+		return err
+	}
+	return writeOctetString(buf, append(encodedHeader, ciphertext...))
+}
+
+// readCipheredContent reads a ciphered-content OCTET STRING into header and returns
+// the ciphertext following it.
+func readCipheredContent(reader *bytes.Reader, header *SecurityHeader) ([]byte, error) {
+	content, err := readOctetString(reader, "CipheredContent")
+	if err != nil {
+		return nil, err
+	}
+	if err := header.Decode(content); err != nil {
+		return nil, err
+	}
+	return content[5:], nil
+}
+
+// readSystemTitle reads a system title OCTET STRING, which must be 8 bytes long
+// as it is part of the nonce.
+func readSystemTitle(reader *bytes.Reader, field string) ([]byte, error) {
+	systemTitle, err := readOctetString(reader, field)
+	if err != nil {
+		return nil, err
+	}
+	if len(systemTitle) != gcmSystemTitleSize {
+		return nil, fmt.Errorf("invalid %s length: got %d, want %d", field, len(systemTitle), gcmSystemTitleSize)
+	}
+	return systemTitle, nil
+}
+
+// EncryptAndTag encrypts and authenticates a plaintext APDU. systemTitle is the
+// system title of the originator of the APDU, which starts the nonce: the
+// client title for a request and the server title for a response.
+func EncryptAndTag(key, plaintext, systemTitle []byte, header *SecurityHeader, suite SecuritySuite) ([]byte, error) {
 	switch suite {
 	case SecuritySuite0:
 		if err := validateKeyLength(key, suite); err != nil {
 			return nil, err
 		}
-		return encryptGCM(key, plaintext, serverSystemTitle, header)
+		return encryptGCM(key, plaintext, systemTitle, header)
 	case SecuritySuite1, SecuritySuite2:
 		if err := validateKeyLength(key, suite); err != nil {
 			return nil, err
 		}
-		return encryptCBCandGMAC(key, plaintext, serverSystemTitle, header)
+		return encryptCBCandGMAC(key, plaintext, systemTitle, header)
 	case SecuritySuite3, SecuritySuite4:
 		if err := validateKeyLength(key, suite); err != nil {
 			return nil, err
 		}
-		return encryptKuznCmac(key, plaintext, serverSystemTitle, header, suite)
+		return encryptKuznCmac(key, plaintext, systemTitle, header, suite)
 	default:
 		return nil, fmt.Errorf("unsupported security suite: %d", suite)
 	}
 }
 
-// DecryptAndVerify decrypts and authenticates a ciphertext APDU.
-func DecryptAndVerify(key, ciphertext, serverSystemTitle []byte, header *SecurityHeader, suite SecuritySuite, lastFrameCounter uint32) ([]byte, error) {
+// DecryptAndVerify decrypts and authenticates a ciphertext APDU protected by the
+// originator with systemTitle (see EncryptAndTag).
+func DecryptAndVerify(key, ciphertext, systemTitle []byte, header *SecurityHeader, suite SecuritySuite, lastFrameCounter uint32) ([]byte, error) {
 	switch suite {
 	case SecuritySuite0:
 		if err := validateKeyLength(key, suite); err != nil {
 			return nil, err
 		}
-		return decryptGCM(key, ciphertext, serverSystemTitle, header, lastFrameCounter)
+		return decryptGCM(key, ciphertext, systemTitle, header, lastFrameCounter)
 	case SecuritySuite1, SecuritySuite2:
 		if err := validateKeyLength(key, suite); err != nil {
 			return nil, err
 		}
-		return decryptCBCandGMAC(key, ciphertext, serverSystemTitle, header, lastFrameCounter)
+		return decryptCBCandGMAC(key, ciphertext, systemTitle, header, lastFrameCounter)
 	case SecuritySuite3, SecuritySuite4:
 		if err := validateKeyLength(key, suite); err != nil {
 			return nil, err
 		}
-		return decryptKuznCmac(key, ciphertext, serverSystemTitle, header, suite, lastFrameCounter)
+		return decryptKuznCmac(key, ciphertext, systemTitle, header, suite, lastFrameCounter)
 	default:
 		return nil, fmt.Errorf("unsupported security suite: %d", suite)
 	}
 }
 
-func encryptGCM(key, plaintext, serverSystemTitle []byte, header *SecurityHeader) ([]byte, error) {
+func encryptGCM(key, plaintext, systemTitle []byte, header *SecurityHeader) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -126,7 +345,7 @@ func encryptGCM(key, plaintext, serverSystemTitle []byte, header *SecurityHeader
 		return nil, err
 	}
 
-	nonce, err := makeGCMNonce(serverSystemTitle, header.FrameCounter)
+	nonce, err := makeGCMNonce(systemTitle, header.FrameCounter)
 	if err != nil {
 		return nil, err
 	}
@@ -139,7 +358,7 @@ func encryptGCM(key, plaintext, serverSystemTitle []byte, header *SecurityHeader
 	return ciphertext, nil
 }
 
-func decryptGCM(key, ciphertext, serverSystemTitle []byte, header *SecurityHeader, lastFrameCounter uint32) ([]byte, error) {
+func decryptGCM(key, ciphertext, systemTitle []byte, header *SecurityHeader, lastFrameCounter uint32) ([]byte, error) {
 	if header.FrameCounter <= lastFrameCounter {
 		return nil, ErrReplayAttack
 	}
@@ -154,7 +373,7 @@ func decryptGCM(key, ciphertext, serverSystemTitle []byte, header *SecurityHeade
 		return nil, err
 	}
 
-	nonce, err := makeGCMNonce(serverSystemTitle, header.FrameCounter)
+	nonce, err := makeGCMNonce(systemTitle, header.FrameCounter)
 	if err != nil {
 		return nil, err
 	}
@@ -170,13 +389,13 @@ func decryptGCM(key, ciphertext, serverSystemTitle []byte, header *SecurityHeade
 	return plaintext, nil
 }
 
-func encryptCBCandGMAC(key, plaintext, serverSystemTitle []byte, header *SecurityHeader) ([]byte, error) {
+func encryptCBCandGMAC(key, plaintext, systemTitle []byte, header *SecurityHeader) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
-	iv := makeCBCIV(block, serverSystemTitle, header.FrameCounter)
+	iv := makeCBCIV(block, systemTitle, header.FrameCounter)
 
 	// Encrypt
 	paddedPlaintext, err := pkcs7Pad(plaintext, aes.BlockSize)
@@ -196,7 +415,7 @@ func encryptCBCandGMAC(key, plaintext, serverSystemTitle []byte, header *Securit
 	copy(authenticatedData, headerBytes)
 	copy(authenticatedData[len(headerBytes):], ciphertext)
 
-	nonce, err := makeGCMNonce(serverSystemTitle, header.FrameCounter)
+	nonce, err := makeGCMNonce(systemTitle, header.FrameCounter)
 	if err != nil {
 		return nil, err
 	}
@@ -208,7 +427,7 @@ func encryptCBCandGMAC(key, plaintext, serverSystemTitle []byte, header *Securit
 	return append(ciphertext, tag...), nil
 }
 
-func decryptCBCandGMAC(key, ciphertext, serverSystemTitle []byte, header *SecurityHeader, lastFrameCounter uint32) ([]byte, error) {
+func decryptCBCandGMAC(key, ciphertext, systemTitle []byte, header *SecurityHeader, lastFrameCounter uint32) ([]byte, error) {
 	if header.FrameCounter <= lastFrameCounter {
 		return nil, ErrReplayAttack
 	}
@@ -218,7 +437,7 @@ func decryptCBCandGMAC(key, ciphertext, serverSystemTitle []byte, header *Securi
 		return nil, err
 	}
 
-	iv := makeCBCIV(block, serverSystemTitle, header.FrameCounter)
+	iv := makeCBCIV(block, systemTitle, header.FrameCounter)
 
 	// Verify tag
 	if len(ciphertext) < 12 {
@@ -234,7 +453,7 @@ func decryptCBCandGMAC(key, ciphertext, serverSystemTitle []byte, header *Securi
 	copy(authenticatedData, headerBytes)
 	copy(authenticatedData[len(headerBytes):], ciphertext)
 
-	nonce, err := makeGCMNonce(serverSystemTitle, header.FrameCounter)
+	nonce, err := makeGCMNonce(systemTitle, header.FrameCounter)
 	if err != nil {
 		return nil, err
 	}
@@ -259,6 +478,114 @@ func decryptCBCandGMAC(key, ciphertext, serverSystemTitle []byte, header *Securi
 	return unpaddedPlaintext, nil
 }
 
+// hlsGMACSize is the length of f(challenge) under HLS mechanism 5: the security
+// control byte, the frame counter and the 12-byte GMAC tag.
+const hlsGMACSize = 1 + 4 + 12
+
+// HLSGMAC computes f(challenge) for HLS mechanism 5 (GMAC) as
+// SC || FC || GMAC(SC || AK || challenge), where SC is the authentication only
+// security control byte, AK the authentication key and the GMAC is computed with
+// key under the nonce systemTitle || FC. systemTitle is the title of the party
+// computing the value and frameCounter its invocation counter.
+func HLSGMAC(key, authenticationKey, challenge, systemTitle []byte, frameCounter uint32) ([]byte, error) {
+	header := &SecurityHeader{SecurityControl: SecurityControlAuthenticationOnly, FrameCounter: frameCounter}
+	encodedHeader, err := header.Encode()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := makeGCMNonce(systemTitle, frameCounter)
+	if err != nil {
+		return nil, err
+	}
+	authenticatedData := append([]byte{byte(header.SecurityControl)}, authenticationKey...)
+	tag, err := gmac(key, nonce, append(authenticatedData, challenge...))
+	if err != nil {
+		return nil, err
+	}
+	return append(encodedHeader, tag...), nil
+}
+
+// VerifyHLSGMAC checks that value is f(challenge) computed by HLSGMAC by the party
+// with systemTitle, under the frame counter carried in value.
+func VerifyHLSGMAC(value, key, authenticationKey, challenge, systemTitle []byte) error {
+	if len(value) != hlsGMACSize || SecurityControl(value[0]) != SecurityControlAuthenticationOnly {
+		return ErrAuthenticationFailed
+	}
+	header := &SecurityHeader{}
+	if err := header.Decode(value); err != nil {
+		return err
+	}
+	expected, err := HLSGMAC(key, authenticationKey, challenge, systemTitle, header.FrameCounter)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(value, expected) != 1 {
+		return ErrAuthenticationFailed
+	}
+	return nil
+}
+
+// HLSDigest computes f(challenge) for the HLS mechanisms 3 (MD5), 4 (SHA-1) and
+// 6 (SHA-256). challenge is the challenge being answered and ownChallenge the one
+// the answering party sent; systemTitle is the title of the answering party and
+// peerSystemTitle that of the other. Mechanisms 3 and 4 hash challenge || secret;
+// mechanism 6 hashes secret || systemTitle || peerSystemTitle || challenge ||
+// ownChallenge.
+func HLSDigest(mechanism byte, secret, challenge, ownChallenge, systemTitle, peerSystemTitle []byte) ([]byte, error) {
+	switch mechanism {
+	case MechanismHLSMD5:
+		digest := md5.Sum(concatBytes(challenge, secret))
+		return digest[:], nil
+	case MechanismHLSSHA1:
+		digest := sha1.Sum(concatBytes(challenge, secret))
+		return digest[:], nil
+	case MechanismHLSSHA256:
+		digest := sha256.Sum256(concatBytes(secret, systemTitle, peerSystemTitle, challenge, ownChallenge))
+		return digest[:], nil
+	default:
+		return nil, fmt.Errorf("no HLS digest for mechanism %d", mechanism)
+	}
+}
+
+// HLSSign computes f(challenge) for HLS mechanism 7 (ECDSA), the raw r || s
+// signature of systemTitle || peerSystemTitle || challenge || ownChallenge, with
+// the parameters named as for HLSDigest.
+func HLSSign(key *ecdsa.PrivateKey, challenge, ownChallenge, systemTitle, peerSystemTitle []byte) ([]byte, error) {
+	if key == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+	digest, err := ecdsaDigest(key.Curve, concatBytes(systemTitle, peerSystemTitle, challenge, ownChallenge))
+	if err != nil {
+		return nil, err
+	}
+	return SignECDSA(key, digest)
+}
+
+// VerifyHLSSignature checks that value is f(challenge) computed by HLSSign by
+// the party holding the private key of key.
+func VerifyHLSSignature(value []byte, key *ecdsa.PublicKey, challenge, ownChallenge, systemTitle, peerSystemTitle []byte) error {
+	if key == nil {
+		return ErrInvalidPublicKey
+	}
+	digest, err := ecdsaDigest(key.Curve, concatBytes(systemTitle, peerSystemTitle, challenge, ownChallenge))
+	if err != nil {
+		return err
+	}
+	if err := VerifyECDSA(key, digest, value); err != nil {
+		return ErrAuthenticationFailed
+	}
+	return nil
+}
+
+// concatBytes returns the concatenation of parts in a new slice.
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
 func makeGCMNonce(systemTitle []byte, frameCounter uint32) ([]byte, error) {
 	if len(systemTitle) < gcmSystemTitleSize {
 		return nil, fmt.Errorf("system title must be at least %d bytes: got %d", gcmSystemTitleSize, len(systemTitle))
@@ -1,6 +1,9 @@
 package cosem
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/hex"
 	"testing"
 
@@ -246,12 +249,74 @@ func TestDecryptCBCandGMAC_DetectsTampering(t *testing.T) {
 	})
 }
 
+func TestHLSGMAC(t *testing.T) {
+	key := []byte("0123456789ABCDEF")
+	authenticationKey := []byte("FEDCBA9876543210")
+	challenge := []byte("P6wRJ21F")
+	clientSystemTitle := []byte("CLIENT01")
+
+	value, err := HLSGMAC(key, authenticationKey, challenge, clientSystemTitle, 1)
+	require.NoError(t, err)
+	expected, err := hex.DecodeString("100000000113470ee50fc1ad6970efa7f2")
+	require.NoError(t, err)
+	assert.Equal(t, expected, value)
+
+	assert.NoError(t, VerifyHLSGMAC(value, key, authenticationKey, challenge, clientSystemTitle))
+	assert.ErrorIs(t, VerifyHLSGMAC(value, key, authenticationKey, challenge, []byte("SERVER01")), ErrAuthenticationFailed)
+	assert.ErrorIs(t, VerifyHLSGMAC(value, key, authenticationKey, []byte("P6wRJ21G"), clientSystemTitle), ErrAuthenticationFailed)
+	assert.ErrorIs(t, VerifyHLSGMAC(value[:16], key, authenticationKey, challenge, clientSystemTitle), ErrAuthenticationFailed)
+	value[0] = byte(SecurityControlAuthenticatedAndEncrypted)
+	assert.ErrorIs(t, VerifyHLSGMAC(value, key, authenticationKey, challenge, clientSystemTitle), ErrAuthenticationFailed)
+}
+
+func TestHLSDigest(t *testing.T) {
+	secret := []byte("HLSsecret")
+	challenge := []byte("P6wRJ21F")
+	ownChallenge := []byte("K56iVagY")
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+
+	for mechanism, want := range map[byte]string{
+		MechanismHLSMD5:    "0f59562212b8d2d27b26554f8b4b61bf",
+		MechanismHLSSHA1:   "1544f2c7ecd815182e9d8a3283c23e48925edcaf",
+		MechanismHLSSHA256: "8df753d92b6297ca218b008796faa15cc8a787a4a10e069516c9e95862e9c4f0",
+	} {
+		value, err := HLSDigest(mechanism, secret, challenge, ownChallenge, clientSystemTitle, serverSystemTitle)
+		require.NoError(t, err)
+		assert.Equal(t, want, hex.EncodeToString(value), "mechanism %d", mechanism)
+	}
+
+	_, err := HLSDigest(MechanismHLSGMAC, secret, challenge, ownChallenge, clientSystemTitle, serverSystemTitle)
+	assert.Error(t, err)
+}
+
+func TestHLSSign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	challenge := []byte("P6wRJ21F")
+	ownChallenge := []byte("K56iVagY")
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+
+	value, err := HLSSign(key, challenge, ownChallenge, clientSystemTitle, serverSystemTitle)
+	require.NoError(t, err)
+	assert.Len(t, value, 64)
+	assert.NoError(t, VerifyHLSSignature(value, &key.PublicKey, challenge, ownChallenge, clientSystemTitle, serverSystemTitle))
+	assert.ErrorIs(t, VerifyHLSSignature(value, &key.PublicKey, ownChallenge, challenge, clientSystemTitle, serverSystemTitle), ErrAuthenticationFailed)
+	assert.ErrorIs(t, VerifyHLSSignature(value, &key.PublicKey, challenge, ownChallenge, serverSystemTitle, clientSystemTitle), ErrAuthenticationFailed)
+	assert.ErrorIs(t, VerifyHLSSignature(value[:63], &key.PublicKey, challenge, ownChallenge, clientSystemTitle, serverSystemTitle), ErrAuthenticationFailed)
+	assert.ErrorIs(t, VerifyHLSSignature(value, nil, challenge, ownChallenge, clientSystemTitle, serverSystemTitle), ErrInvalidPublicKey)
+
+	_, err = HLSSign(nil, challenge, ownChallenge, clientSystemTitle, serverSystemTitle)
+	assert.ErrorIs(t, err, ErrInvalidPrivateKey)
+}
+
 func TestApplication_HandleAPDU_Secured(t *testing.T) {
 	obisAssociationLN, _ := NewObisCodeFromString("0.0.40.0.0.255")
 	associationLN, _ := NewAssociationLN(*obisAssociationLN)
 	obisSecurity, err := NewObisCodeFromString("0.0.43.0.0.255")
 	require.NoError(t, err)
-	clientSystemTitle := []byte("CLIENT")
+	clientSystemTitle := []byte("CLIENT01")
 	serverSystemTitle := []byte("SERVER01")
 	masterKey := []byte("master_key")
 	guek := []byte("0123456789ABCDEF")
@@ -264,6 +329,7 @@ func TestApplication_HandleAPDU_Secured(t *testing.T) {
 
 	clientAddr := mockAddr("client1")
 	app.AddAssociation(clientAddr.String(), associationLN)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
 
 	obis, err := NewObisCodeFromString("1.0.0.3.0.255")
 	require.NoError(t, err)
@@ -289,11 +355,7 @@ func TestApplication_HandleAPDU_Secured(t *testing.T) {
 			SecurityControl: SecurityControlAuthenticatedAndEncrypted,
 			FrameCounter:    counter,
 		}
-
-		ciphertext, err := EncryptAndTag(guek, encodedReq, serverSystemTitle, header, SecuritySuite1)
-		assert.NoError(t, err)
-		encodedHeader, _ := header.Encode()
-		return append([]byte{byte(APDU_GLO_GET_REQUEST)}, append(encodedHeader, ciphertext...)...)
+		return cipherAPDU(t, APDU_GLO_GET_REQUEST, guek, encodedReq, clientSystemTitle, header, SecuritySuite1)
 	}
 
 	lastServerCounter := associationLN.ServerInvocationCounter()
@@ -304,14 +366,9 @@ func TestApplication_HandleAPDU_Secured(t *testing.T) {
 		encodedResp, err := app.HandleAPDU(securedReq, clientAddr)
 		assert.NoError(t, err)
 
-		respHeader := &SecurityHeader{}
-		err = respHeader.Decode(encodedResp[1:])
-		assert.NoError(t, err)
+		respHeader, plaintext := decipherAPDU(t, encodedResp, guek, serverSystemTitle, SecuritySuite1, lastServerCounter)
 		assert.Equal(t, lastServerCounter+1, respHeader.FrameCounter)
 
-		plaintext, err := DecryptAndVerify(guek, encodedResp[6:], serverSystemTitle, respHeader, SecuritySuite1, lastServerCounter)
-		assert.NoError(t, err)
-
 		lastServerCounter = respHeader.FrameCounter
 
 		resp := &GetResponse{}
@@ -322,3 +379,53 @@ func TestApplication_HandleAPDU_Secured(t *testing.T) {
 		assert.Equal(t, uint32(12345), resp.Result.Value.(uint32))
 	}
 }
+
+func TestCipheredAPDU_EncodeDecode(t *testing.T) {
+	key := []byte("0123456789ABCDEF")
+	systemTitle := []byte("CLIENT01")
+	header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: 7}
+
+	ciphered, err := NewCipheredAPDU(APDU_GLO_GET_REQUEST, key, []byte{0xC0, 0x01}, systemTitle, header, SecuritySuite0)
+	require.NoError(t, err)
+	encoded, err := ciphered.Encode()
+	require.NoError(t, err)
+
+	// The security header and ciphertext are an A-XDR OCTET STRING.
+	assert.Equal(t, byte(APDU_GLO_GET_REQUEST), encoded[0])
+	assert.Equal(t, byte(len(encoded)-2), encoded[1])
+	assert.Equal(t, byte(SecurityControlAuthenticatedAndEncrypted), encoded[2])
+
+	decoded := &CipheredAPDU{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, *header, decoded.SecurityHeader)
+	plaintext, err := decoded.Open(key, systemTitle, SecuritySuite0, 6)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xC0, 0x01}, plaintext)
+
+	_, err = decoded.Open(key, systemTitle, SecuritySuite0, 7)
+	assert.Error(t, err, "a replayed frame counter must be refused")
+
+	// The octet-string length must cover the rest of the APDU.
+	assert.Error(t, (&CipheredAPDU{}).Decode(encoded[:len(encoded)-1]))
+}
+
+// cipherAPDU protects plaintext in a ciphered APDU with the given tag.
+func cipherAPDU(t *testing.T, tag APDUType, key, plaintext, systemTitle []byte, header *SecurityHeader, suite SecuritySuite) []byte {
+	t.Helper()
+	ciphered, err := NewCipheredAPDU(tag, key, plaintext, systemTitle, header, suite)
+	require.NoError(t, err)
+	encoded, err := ciphered.Encode()
+	require.NoError(t, err)
+	return encoded
+}
+
+// decipherAPDU unprotects a ciphered APDU, returning its security header and
+// plaintext.
+func decipherAPDU(t *testing.T, src, key, systemTitle []byte, suite SecuritySuite, lastFrameCounter uint32) (*SecurityHeader, []byte) {
+	t.Helper()
+	ciphered := &CipheredAPDU{}
+	require.NoError(t, ciphered.Decode(src))
+	plaintext, err := ciphered.Open(key, systemTitle, suite, lastFrameCounter)
+	require.NoError(t, err)
+	return &ciphered.SecurityHeader, plaintext
+}
@@ -0,0 +1,394 @@
+package cosem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Bits of an Invoke-Id-And-Priority. The invoke-id itself is selected by
+// invokeIDMask.
+const (
+	// InvokeIDConfirmed marks a confirmed service; a server does not answer an
+	// unconfirmed Set-Request or Action-Request.
+	InvokeIDConfirmed uint8 = 0x40
+	// InvokeIDHighPriority marks a high priority service, served ahead of the
+	// normal priority ones waiting in a RequestQueue.
+	InvokeIDHighPriority uint8 = 0x80
+)
+
+// requestHighPriority reports whether the unprotected request APDU src carries
+// the high priority bit. Ciphered requests cannot be inspected and count as
+// normal priority (see Application.RequestHighPriority).
+func requestHighPriority(src []byte) bool {
+	switch APDUType(src[0]) {
+	case APDU_GET_REQUEST, APDU_SET_REQUEST, APDU_ACTION_REQUEST:
+		return len(src) > 2 && src[2]&InvokeIDHighPriority != 0
+	case APDU_ACCESS_REQUEST:
+		return len(src) > 4 && binary.BigEndian.Uint32(src[1:5])&LongInvokeIDHighPriority != 0
+	default:
+		return false
+	}
+}
+
+// requestUnconfirmed reports whether the unprotected request APDU src is an
+// unconfirmed service, which takes no response. The Get service is confirmed
+// only, so a Get-Request is answered whatever its service-class bit.
+func requestUnconfirmed(src []byte) bool {
+	switch APDUType(src[0]) {
+	case APDU_SET_REQUEST, APDU_ACTION_REQUEST:
+		return len(src) > 2 && src[2]&InvokeIDConfirmed == 0
+	case APDU_ACCESS_REQUEST:
+		return len(src) > 4 && binary.BigEndian.Uint32(src[1:5])&LongInvokeIDConfirmed == 0
+	case APDU_UNCONFIRMED_WRITE_REQUEST:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrUncorrelatedResponse is returned by Correlator.Match for an
+// Exception-Response or Confirmed-Service-Error, which carry no invoke-id, when
+// several requests are pending.
+var ErrUncorrelatedResponse = fmt.Errorf("response carries no invoke-id")
+
+// responseInvokeID returns the invoke-id of a Get-, Set- or Action-Response APDU,
+// or the long-invoke-id of an Access-Response APDU, without the priority and
+// service-class bits.
+func responseInvokeID(src []byte) (uint32, error) {
+	if len(src) == 0 {
+		return 0, fmt.Errorf("empty APDU")
+	}
+	switch APDUType(src[0]) {
+	case APDU_GET_RESPONSE, APDU_SET_RESPONSE, APDU_ACTION_RESPONSE:
+		if len(src) < 3 {
+			return 0, fmt.Errorf("truncated response APDU")
+		}
+		return uint32(src[2] & invokeIDMask), nil
+	case APDU_ACCESS_RESPONSE:
+		if len(src) < 5 {
+			return 0, fmt.Errorf("truncated response APDU")
+		}
+		return binary.BigEndian.Uint32(src[1:5]) & LongInvokeIDMask, nil
+	default:
+		return 0, fmt.Errorf("APDU 0x%02X carries no invoke-id", src[0])
+	}
+}
+
+// LongInvokeIDAndPriority returns the Long-Invoke-Id-And-Priority carrying the
+// invoke-id, priority and service-class of invokeIDAndPriority, so that an
+// Access-Request can be sent under an invoke-id allocated by a Correlator.
+func LongInvokeIDAndPriority(invokeIDAndPriority uint8) uint32 {
+	longInvokeID := uint32(invokeIDAndPriority & invokeIDMask)
+	if invokeIDAndPriority&InvokeIDConfirmed != 0 {
+		longInvokeID |= LongInvokeIDConfirmed
+	}
+	if invokeIDAndPriority&InvokeIDHighPriority != 0 {
+		longInvokeID |= LongInvokeIDHighPriority
+	}
+	return longInvokeID
+}
+
+// requestProtected reports whether the request APDU src is ciphered or signed.
+// The server refuses a frame counter or a transaction-id lower than one it has
+// already received, so such requests are served in the order they were sent.
+func requestProtected(src []byte) bool {
+	if len(src) == 0 {
+		return false
+	}
+	switch APDUType(src[0]) {
+	case APDU_GLO_GET_REQUEST, APDU_GLO_SET_REQUEST, APDU_GLO_ACTION_REQUEST,
+		APDU_DED_GET_REQUEST, APDU_DED_SET_REQUEST, APDU_DED_ACTION_REQUEST,
+		APDU_GLO_READ_REQUEST, APDU_GLO_WRITE_REQUEST, APDU_GLO_UNCONFIRMED_WRITE_REQUEST,
+		APDU_DED_READ_REQUEST, APDU_DED_WRITE_REQUEST, APDU_DED_UNCONFIRMED_WRITE_REQUEST,
+		APDU_GENERAL_GLO_CIPHERING, APDU_GENERAL_DED_CIPHERING, APDU_GENERAL_CIPHERING,
+		APDU_GENERAL_SIGNING:
+		return true
+	default:
+		return false
+	}
+}
+
+type queuedRequest struct {
+	src        []byte
+	clientAddr net.Addr
+	seq        uint64
+	protected  bool
+}
+
+// RequestQueue holds the request APDUs received but not yet served. Pop returns
+// high priority requests ahead of normal priority ones, each in the order they
+// were received. A protected request is never served ahead of an earlier
+// protected request of the same client, whose frame counter or transaction-id
+// it would make stale. It is safe for concurrent use, HighPriority aside.
+type RequestQueue struct {
+	// HighPriority tells the high priority requests pushed. When nil, only the
+	// priority bit of unprotected requests is read.
+	HighPriority func(src []byte, clientAddr net.Addr) bool
+
+	mutex  sync.Mutex
+	seq    uint64
+	high   []queuedRequest
+	normal []queuedRequest
+}
+
+// NewRequestQueue creates a RequestQueue telling the priority of requests with
+// RequestHighPriority, so that protected requests are classified as well. Like
+// HandleAPDU, its Push must not run concurrently with other calls to the
+// application.
+func (app *Application) NewRequestQueue() *RequestQueue {
+	return &RequestQueue{HighPriority: app.RequestHighPriority}
+}
+
+// RequestHighPriority reports whether the request APDU src received from
+// clientAddr carries the high priority bit. A protected request is deciphered
+// first, without recording its frame counter; one that cannot be deciphered
+// counts as normal priority and is refused when served.
+func (app *Application) RequestHighPriority(src []byte, clientAddr net.Addr) bool {
+	assoc, ok := app.associations[clientAddr.String()]
+	if !ok {
+		return false
+	}
+	return app.requestHighPriority(src, assoc)
+}
+
+func (app *Application) requestHighPriority(src []byte, assoc *AssociationLN) bool {
+	if len(src) == 0 {
+		return false
+	}
+	switch apduType := APDUType(src[0]); apduType {
+	case APDU_GENERAL_SIGNING:
+		signed := &GeneralSigning{}
+		return signed.Decode(src) == nil && app.requestHighPriority(signed.Content, assoc)
+	case APDU_GLO_GET_REQUEST, APDU_GLO_SET_REQUEST, APDU_GLO_ACTION_REQUEST,
+		APDU_DED_GET_REQUEST, APDU_DED_SET_REQUEST, APDU_DED_ACTION_REQUEST:
+		_, _, plaintext, err := app.openSecuredAPDU(apduType, src, assoc)
+		return err == nil && len(plaintext) > 0 && requestHighPriority(plaintext)
+	case APDU_GENERAL_GLO_CIPHERING, APDU_GENERAL_DED_CIPHERING, APDU_GENERAL_CIPHERING:
+		_, _, _, plaintext, err := app.openGeneralCipheredAPDU(apduType, src, assoc)
+		return err == nil && len(plaintext) > 0 && requestHighPriority(plaintext)
+	default:
+		return requestHighPriority(src)
+	}
+}
+
+// Push queues the request APDU src received from clientAddr.
+func (q *RequestQueue) Push(src []byte, clientAddr net.Addr) {
+	var high bool
+	if q.HighPriority != nil {
+		high = q.HighPriority(src, clientAddr)
+	} else {
+		high = len(src) > 0 && requestHighPriority(src)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.seq++
+	req := queuedRequest{src: src, clientAddr: clientAddr, seq: q.seq, protected: requestProtected(src)}
+	if high {
+		q.high = append(q.high, req)
+	} else {
+		q.normal = append(q.normal, req)
+	}
+}
+
+// Pop removes the next request to serve from the queue. ok is false when the
+// queue is empty.
+func (q *RequestQueue) Pop() (src []byte, clientAddr net.Addr, ok bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var req queuedRequest
+	switch {
+	case len(q.high) > 0:
+		if i := q.protectedBefore(q.high[0]); i >= 0 {
+			req = q.normal[i]
+			q.normal = append(q.normal[:i], q.normal[i+1:]...)
+			break
+		}
+		req, q.high = q.high[0], q.high[1:]
+	case len(q.normal) > 0:
+		req, q.normal = q.normal[0], q.normal[1:]
+	default:
+		return nil, nil, false
+	}
+	return req.src, req.clientAddr, true
+}
+
+// protectedBefore returns the index of the normal priority request to serve
+// before req, the earliest protected request its client sent before it, or -1.
+func (q *RequestQueue) protectedBefore(req queuedRequest) int {
+	if !req.protected {
+		return -1
+	}
+	for i, other := range q.normal {
+		if other.seq > req.seq {
+			break
+		}
+		if other.protected && other.clientAddr.String() == req.clientAddr.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+// Len returns the number of queued requests.
+func (q *RequestQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.high) + len(q.normal)
+}
+
+// ServeNext serves the next request of queue and returns its response together
+// with the address of the client to send it to. ok is false when the queue is
+// empty; a nil response means none is to be sent.
+func (app *Application) ServeNext(queue *RequestQueue) (resp []byte, clientAddr net.Addr, ok bool, err error) {
+	src, clientAddr, ok := queue.Pop()
+	if !ok {
+		return nil, nil, false, nil
+	}
+	resp, err = app.HandleAPDU(src, clientAddr)
+	return resp, clientAddr, true, err
+}
+
+// Correlator matches the responses of one association to the confirmed requests
+// pending on it by invoke-id, so that several requests can be pipelined. Each
+// pending request holds one of the 16 invoke-ids until its response arrives; an
+// Access-Request is sent with the invoke-id as its long-invoke-id (see
+// LongInvokeIDAndPriority). It is safe for concurrent use.
+type Correlator struct {
+	mutex   sync.Mutex
+	next    uint8
+	pending map[uint8]interface{}
+}
+
+// NewCorrelator creates a Correlator with no pending requests.
+func NewCorrelator() *Correlator {
+	return &Correlator{pending: make(map[uint8]interface{})}
+}
+
+// Allocate reserves a free invoke-id for a confirmed request and returns the
+// Invoke-Id-And-Priority to send it with. context is returned by Match with the
+// response. It fails when all invoke-ids are in use.
+func (c *Correlator) Allocate(highPriority bool, context interface{}) (uint8, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i := uint8(0); i <= invokeIDMask; i++ {
+		id := (c.next + i) & invokeIDMask
+		if _, busy := c.pending[id]; busy {
+			continue
+		}
+		c.pending[id] = context
+		c.next = (id + 1) & invokeIDMask
+
+		invokeIDAndPriority := id | InvokeIDConfirmed
+		if highPriority {
+			invokeIDAndPriority |= InvokeIDHighPriority
+		}
+		return invokeIDAndPriority, nil
+	}
+	return 0, fmt.Errorf("no free invoke-id: %d requests pending", len(c.pending))
+}
+
+// Match finds the pending request answered by the Get-, Set-, Action- or
+// Access-Response APDU resp, releases its invoke-id and returns the context it
+// was allocated with. A request served by block transfer keeps its invoke-id
+// until the response that completes it arrives.
+//
+// An Exception-Response or Confirmed-Service-Error carries no invoke-id. It
+// answers the only pending request, if there is one; otherwise Match returns
+// ErrUncorrelatedResponse and the caller cannot tell which request failed, so it
+// should give up on all of them with FailAll.
+func (c *Correlator) Match(resp []byte) (interface{}, error) {
+	if len(resp) > 0 && (APDUType(resp[0]) == APDU_EXCEPTION_RESPONSE || APDUType(resp[0]) == APDU_CONFIRMED_SERVICE_ERROR) {
+		return c.matchUncorrelated()
+	}
+	invokeID, err := responseInvokeID(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	id := uint8(invokeID)
+	context, ok := c.pending[id]
+	if invokeID > uint32(invokeIDMask) || !ok {
+		return nil, fmt.Errorf("no pending request with invoke-id %d", invokeID)
+	}
+	if !responseContinues(resp) {
+		delete(c.pending, id)
+	}
+	return context, nil
+}
+
+// matchUncorrelated answers the only pending request with a response carrying
+// no invoke-id.
+func (c *Correlator) matchUncorrelated() (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.pending) != 1 {
+		return nil, fmt.Errorf("%w: %d requests pending", ErrUncorrelatedResponse, len(c.pending))
+	}
+	for id, context := range c.pending {
+		delete(c.pending, id)
+		return context, nil
+	}
+	return nil, nil
+}
+
+// FailAll releases the invoke-ids of all pending requests and returns the
+// contexts they were allocated with, for the caller to fail them.
+func (c *Correlator) FailAll() []interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	contexts := make([]interface{}, 0, len(c.pending))
+	for id, context := range c.pending {
+		contexts = append(contexts, context)
+		delete(c.pending, id)
+	}
+	return contexts
+}
+
+// Release frees the invoke-id of invokeIDAndPriority, for a request that was
+// abandoned or whose block transfer has completed.
+func (c *Correlator) Release(invokeIDAndPriority uint8) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.pending, invokeIDAndPriority&invokeIDMask)
+}
+
+// Pending returns the number of requests waiting for their response.
+func (c *Correlator) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.pending)
+}
+
+// responseContinues reports whether resp is part of a block transfer that goes on
+// under the same invoke-id: a block of a long response other than the last one,
+// or the acknowledgement of a block of a long set or long action request.
+func responseContinues(resp []byte) bool {
+	if len(resp) < 2 {
+		return false
+	}
+	switch APDUType(resp[0]) {
+	case APDU_GET_RESPONSE:
+		return GetResponseType(resp[1]) == GET_RESPONSE_WITH_DATABLOCK && len(resp) > 3 && resp[3] == 0x00 // last-block
+	case APDU_SET_RESPONSE:
+		return SetResponseType(resp[1]) == SET_RESPONSE_DATABLOCK
+	case APDU_ACTION_RESPONSE:
+		switch ActionResponseType(resp[1]) {
+		case ACTION_RESPONSE_WITH_PBLOCK:
+			return len(resp) > 3 && resp[3] == 0x00 // last-block
+		case ACTION_RESPONSE_NEXT_PBLOCK:
+			return true
+		}
+	}
+	return false
+}
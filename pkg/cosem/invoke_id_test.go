@@ -0,0 +1,278 @@
+package cosem
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestQueue_HighPriorityFirst(t *testing.T) {
+	queue := &RequestQueue{}
+	requests := [][]byte{
+		{byte(APDU_GET_REQUEST), byte(GET_REQUEST_NORMAL), 0x41},
+		{byte(APDU_SET_REQUEST), byte(SET_REQUEST_NORMAL), 0xC2},
+		{byte(APDU_GLO_GET_REQUEST), 0x10},
+		{byte(APDU_ACCESS_REQUEST), 0xC0, 0x00, 0x00, 0x03},
+		{byte(APDU_ACTION_REQUEST), byte(ACTION_REQUEST_NORMAL), 0x44},
+	}
+	for i, src := range requests {
+		queue.Push(src, mockAddr(string(rune('a'+i))))
+	}
+	assert.Equal(t, 5, queue.Len())
+
+	var order []net.Addr
+	for {
+		_, clientAddr, ok := queue.Pop()
+		if !ok {
+			break
+		}
+		order = append(order, clientAddr)
+	}
+	assert.Equal(t, []net.Addr{mockAddr("b"), mockAddr("d"), mockAddr("a"), mockAddr("c"), mockAddr("e")}, order)
+	assert.Equal(t, 0, queue.Len())
+}
+
+func TestCorrelator(t *testing.T) {
+	c := NewCorrelator()
+
+	first, err := c.Allocate(false, "first")
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x40), first)
+	second, err := c.Allocate(true, "second")
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0xC1), second)
+
+	// Responses may arrive in any order.
+	context, err := c.Match([]byte{byte(APDU_SET_RESPONSE), byte(SET_RESPONSE_NORMAL), second, 0x00})
+	require.NoError(t, err)
+	assert.Equal(t, "second", context)
+	_, err = c.Match([]byte{byte(APDU_SET_RESPONSE), byte(SET_RESPONSE_NORMAL), second, 0x00})
+	assert.Error(t, err)
+
+	// A long get keeps its invoke-id until the last block.
+	block := []byte{byte(APDU_GET_RESPONSE), byte(GET_RESPONSE_WITH_DATABLOCK), first, 0x00, 0x00, 0x00, 0x00, 0x01}
+	context, err = c.Match(block)
+	require.NoError(t, err)
+	assert.Equal(t, "first", context)
+	block[3] = 0x01
+	_, err = c.Match(block)
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Pending())
+
+	for i := 0; i < 16; i++ {
+		_, err := c.Allocate(false, i)
+		require.NoError(t, err)
+	}
+	_, err = c.Allocate(false, nil)
+	assert.Error(t, err)
+	c.Release(0x45)
+	id, err := c.Allocate(false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x45), id)
+
+	// An error response carrying no invoke-id cannot be told apart among several
+	// pending requests, which are all failed.
+	exception := []byte{byte(APDU_EXCEPTION_RESPONSE), 0x01, 0x02}
+	_, err = c.Match(exception)
+	assert.ErrorIs(t, err, ErrUncorrelatedResponse)
+	assert.Len(t, c.FailAll(), 16)
+	assert.Equal(t, 0, c.Pending())
+
+	// It answers the only pending request.
+	_, err = c.Allocate(false, "only")
+	require.NoError(t, err)
+	context, err = c.Match([]byte{byte(APDU_CONFIRMED_SERVICE_ERROR), 0x05, 0x03, 0x02})
+	require.NoError(t, err)
+	assert.Equal(t, "only", context)
+	assert.Equal(t, 0, c.Pending())
+}
+
+func TestCorrelator_AccessResponse(t *testing.T) {
+	c := NewCorrelator()
+	invokeIDAndPriority, err := c.Allocate(true, "access")
+	require.NoError(t, err)
+	longInvokeID := LongInvokeIDAndPriority(invokeIDAndPriority)
+	assert.Equal(t, LongInvokeIDHighPriority|LongInvokeIDConfirmed, longInvokeID)
+
+	resp, err := (&AccessResponse{
+		LongInvokeIDAndPriority: longInvokeID,
+		DateTime:                []byte{},
+		DataList:                []interface{}{uint32(1)},
+		Specifications:          []AccessResponseSpecification{{Type: ACCESS_RESPONSE_GET, Result: SUCCESS}},
+	}).Encode()
+	require.NoError(t, err)
+	context, err := c.Match(resp)
+	require.NoError(t, err)
+	assert.Equal(t, "access", context)
+	assert.Equal(t, 0, c.Pending())
+
+	// A long-invoke-id beyond the invoke-ids of the correlator matches nothing.
+	_, err = c.Allocate(false, nil)
+	require.NoError(t, err)
+	_, err = c.Match([]byte{byte(APDU_ACCESS_RESPONSE), 0x40, 0x00, 0x01, 0x01})
+	assert.Error(t, err)
+	assert.Equal(t, 1, c.Pending())
+}
+
+func TestApplication_ServiceClass(t *testing.T) {
+	app, assoc, clientAddr, dataObj := setupTestApp(t)
+
+	register, err := NewRegister(obisOf(t, "1.0.1.8.0.255"), uint32(500), ScalerUnit{Scaler: 0, Unit: 30})
+	require.NoError(t, err)
+	app.RegisterObject(register)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{register.InstanceID}))
+	dataValue := CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2}
+
+	handle := func(t *testing.T, apdu APDU) []byte {
+		src, err := apdu.Encode()
+		require.NoError(t, err)
+		resp, err := app.HandleAPDU(src, clientAddr)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("UnconfirmedSet", func(t *testing.T) {
+		resp := handle(t, &SetRequest{Type: SET_REQUEST_NORMAL, InvokeIDAndPriority: 0x01, AttributeDescriptor: dataValue, Value: uint32(1)})
+		assert.Nil(t, resp)
+		value, err := dataObj.GetAttribute(2)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1), value)
+
+		// A failing unconfirmed request is not answered either.
+		resp = handle(t, &SetRequest{Type: SET_REQUEST_WITH_DATABLOCK, InvokeIDAndPriority: 0x01, DataBlock: DataBlockSA{BlockNumber: 2}})
+		assert.Nil(t, resp)
+		resp, err = app.HandleAPDU([]byte{byte(APDU_SET_REQUEST), 0x09, 0x01}, clientAddr)
+		require.NoError(t, err)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("UnconfirmedAction", func(t *testing.T) {
+		resp := handle(t, &ActionRequest{
+			Type:                ACTION_REQUEST_NORMAL,
+			InvokeIDAndPriority: 0x82,
+			MethodDescriptor:    CosemMethodDescriptor{ClassID: RegisterClassID, InstanceID: register.InstanceID, MethodID: 1},
+		})
+		assert.Nil(t, resp)
+		value, err := register.GetAttribute(2)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(0), value)
+	})
+
+	t.Run("UnconfirmedAccess", func(t *testing.T) {
+		resp := handle(t, &AccessRequest{
+			LongInvokeIDAndPriority: 0x000010,
+			Specifications:          []AccessRequestSpecification{{Type: ACCESS_REQUEST_SET, AttributeDescriptor: dataValue}},
+			DataList:                []interface{}{uint32(2)},
+		})
+		assert.Nil(t, resp)
+		value, err := dataObj.GetAttribute(2)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2), value)
+	})
+
+	t.Run("GetIsAlwaysConfirmed", func(t *testing.T) {
+		encodedResp := handle(t, &GetRequest{Type: GET_REQUEST_NORMAL, InvokeIDAndPriority: 0x03, AttributeDescriptor: dataValue})
+		resp := &GetResponse{}
+		require.NoError(t, resp.Decode(encodedResp))
+		assert.Equal(t, uint8(0x03), resp.InvokeIDAndPriority)
+		assert.Equal(t, uint32(2), resp.Result.Value)
+	})
+
+	t.Run("ServeNext", func(t *testing.T) {
+		queue := &RequestQueue{}
+		for _, invokeID := range []uint8{0x41, 0xC2, 0x43} {
+			src, err := (&GetRequest{Type: GET_REQUEST_NORMAL, InvokeIDAndPriority: invokeID, AttributeDescriptor: dataValue}).Encode()
+			require.NoError(t, err)
+			queue.Push(src, clientAddr)
+		}
+
+		var served []uint32
+		for {
+			encodedResp, addr, ok, err := app.ServeNext(queue)
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			assert.Equal(t, clientAddr, addr)
+			invokeID, err := responseInvokeID(encodedResp)
+			require.NoError(t, err)
+			served = append(served, invokeID)
+		}
+		assert.Equal(t, []uint32{2, 1, 3}, served)
+	})
+}
+
+func TestApplication_RequestHighPriority(t *testing.T) {
+	clientSystemTitle := []byte("CLIENT01")
+	guek := []byte("0123456789ABCDEF")
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), clientSystemTitle, []byte("SERVER01"), nil, guek, guek)
+	require.NoError(t, err)
+	app := NewApplication(nil, securitySetup)
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	clientAddr := mockAddr("client1")
+	app.AddAssociation(clientAddr.String(), assoc)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
+	dataObj, err := NewData(obisOf(t, "1.0.0.3.0.255"), uint32(7))
+	require.NoError(t, err)
+	app.RegisterObject(dataObj)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{dataObj.InstanceID}))
+
+	get := func(invokeIDAndPriority uint8) []byte {
+		src, err := (&GetRequest{
+			Type:                GET_REQUEST_NORMAL,
+			InvokeIDAndPriority: invokeIDAndPriority,
+			AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: dataObj.InstanceID, AttributeID: 2},
+		}).Encode()
+		require.NoError(t, err)
+		return src
+	}
+	cipher := func(frameCounter uint32, plaintext []byte) []byte {
+		header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: frameCounter}
+		return cipherAPDU(t, APDU_GLO_GET_REQUEST, guek, plaintext, clientSystemTitle, header, SecuritySuite0)
+	}
+
+	normal, high := cipher(1, get(0x41)), cipher(2, get(0xC2))
+	assert.False(t, app.RequestHighPriority(normal, clientAddr))
+	assert.True(t, app.RequestHighPriority(high, clientAddr))
+	assert.True(t, app.RequestHighPriority(get(0xC3), clientAddr))
+	assert.False(t, app.RequestHighPriority(high, mockAddr("unknown")))
+
+	// Telling the priority did not use up the frame counters. The ciphered high
+	// priority request is served ahead of an unprotected request, but not ahead
+	// of the earlier ciphered one, whose frame counter it would make stale.
+	other, err := NewAssociationLN(obisOf(t, "0.0.40.0.1.255"))
+	require.NoError(t, err)
+	app.AddAssociation("other", other)
+	require.NoError(t, app.PreEstablishAssociation("other"))
+	require.NoError(t, app.PopulateObjectList(other, []ObisCode{dataObj.InstanceID}))
+
+	queue := app.NewRequestQueue()
+	queue.Push(normal, clientAddr)
+	queue.Push(get(0x45), mockAddr("other"))
+	queue.Push(high, clientAddr)
+	queue.Push(get(0xC6), clientAddr)
+	var served []uint32
+	for {
+		resp, _, ok, err := app.ServeNext(queue)
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		if APDUType(resp[0]) == APDU_GLO_GET_RESPONSE {
+			_, resp = decipherAPDU(t, resp, guek, []byte("SERVER01"), SecuritySuite0, 0)
+		}
+		invokeID, err := responseInvokeID(resp)
+		require.NoError(t, err)
+		served = append(served, invokeID)
+	}
+	assert.Equal(t, []uint32{1, 2, 6, 5}, served)
+	assert.Equal(t, uint32(2), app.lastFrameCounters[assoc])
+
+	// A request that cannot be deciphered counts as normal priority.
+	tampered := cipher(3, get(0xC4))
+	tampered[len(tampered)-1] ^= 0xFF
+	assert.False(t, app.RequestHighPriority(tampered, clientAddr))
+}
@@ -0,0 +1,162 @@
+package cosem
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+)
+
+// Key agreement schemes. The key_agreement method of the security setup uses
+// the Ephemeral Unified Model; the others are the key-parameters of an
+// agreed-key Key-Info.
+const (
+	KeyAgreementEphemeralUnifiedModel byte = 0 // C(2e, 0s, ECC CDH)
+	KeyAgreementOnePassDiffieHellman  byte = 1 // C(1e, 1s, ECC CDH)
+	KeyAgreementStaticUnifiedModel    byte = 2 // C(0e, 2s, ECC CDH)
+)
+
+// OIDs of the algorithms a key is derived for, the AlgorithmID of the key
+// derivation function.
+var (
+	OidAlgorithmAESGCM128  = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 3, 0}
+	OidAlgorithmAESGCM256  = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 3, 1}
+	OidAlgorithmAESWrap128 = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 3, 2}
+	OidAlgorithmAESWrap256 = asn1.ObjectIdentifier{2, 16, 756, 5, 8, 3, 3}
+)
+
+// keyAgreementSuite holds the primitives of a security suite supporting key
+// agreement: suite 1 uses P-256, SHA-256 and AES-128, suite 2 P-384, SHA-384
+// and AES-256.
+type keyAgreementSuite struct {
+	curve       elliptic.Curve
+	hash        func() hash.Hash
+	algorithmID asn1.ObjectIdentifier
+	keySize     int
+}
+
+func keyAgreementSuiteOf(suite SecuritySuite) (keyAgreementSuite, error) {
+	switch suite {
+	case SecuritySuite1:
+		return keyAgreementSuite{elliptic.P256(), sha256.New, OidAlgorithmAESGCM128, 16}, nil
+	case SecuritySuite2:
+		return keyAgreementSuite{elliptic.P384(), sha512.New384, OidAlgorithmAESGCM256, 32}, nil
+	default:
+		return keyAgreementSuite{}, fmt.Errorf("%w: security suite %d has no key agreement", ErrKeyAgreementFailed, suite)
+	}
+}
+
+// ConcatKDF derives a key of keySize bytes from the shared secret z with the
+// concatenation key derivation function of NIST SP 800-56A:
+// K(i) = H(counter || z || OtherInfo) for a 32 bit counter from 1. OtherInfo is
+// AlgorithmID || PartyUInfo || PartyVInfo, where AlgorithmID is the content of
+// the BER encoding of algorithmID and the party infos are the system titles of
+// the parties U (the client or originator) and V; SuppPubInfo and SuppPrivInfo
+// are not used.
+func ConcatKDF(newHash func() hash.Hash, z []byte, algorithmID asn1.ObjectIdentifier, partyUInfo, partyVInfo []byte, keySize int) ([]byte, error) {
+	encodedID, err := asn1.Marshal(algorithmID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AlgorithmID: %w", err)
+	}
+	otherInfo := concatBytes(encodedID[2:], partyUInfo, partyVInfo)
+
+	h := newHash()
+	var key []byte
+	counter := make([]byte, 4)
+	for i := uint32(1); len(key) < keySize; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+		h.Reset()
+		h.Write(counter)
+		h.Write(z)
+		h.Write(otherInfo)
+		key = h.Sum(key)
+	}
+	return key[:keySize], nil
+}
+
+// AgreeKey computes the key U and V agree under suite, from the private key of
+// one party and the public key of the other. In the Ephemeral Unified Model
+// both keys are ephemeral; in One-Pass Diffie-Hellman the key of U is ephemeral
+// and the key of V static. The key is derived by ConcatKDF for algorithmID from
+// the system titles of U and V.
+func AgreeKey(suite SecuritySuite, privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey, algorithmID asn1.ObjectIdentifier, systemTitleU, systemTitleV []byte) ([]byte, error) {
+	params, err := keyAgreementSuiteOf(suite)
+	if err != nil {
+		return nil, err
+	}
+	if privateKey == nil || privateKey.Curve != params.curve {
+		return nil, ErrInvalidPrivateKey
+	}
+	z, err := ECDH(privateKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return ConcatKDF(params.hash, z, algorithmID, systemTitleU, systemTitleV, params.keySize)
+}
+
+// SignEphemeralKey returns the key_data of a key_agreement_data for the global
+// key keyID: the ephemeral public key as x || y followed by the raw r || s
+// signature of keyID || x || y under signingKey.
+func SignEphemeralKey(keyID KeyID, ephemeralKey *ecdsa.PublicKey, signingKey *ecdsa.PrivateKey) ([]byte, error) {
+	encoded, err := MarshalPublicKey(ephemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	if signingKey == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+	encoded[0] = byte(keyID)
+	digest, err := ecdsaDigest(signingKey.Curve, encoded)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := SignECDSA(signingKey, digest)
+	if err != nil {
+		return nil, err
+	}
+	return append(encoded[1:], signature...), nil
+}
+
+// VerifyEphemeralKey checks the signature of key_data built by SignEphemeralKey
+// with signingKey and returns the ephemeral public key, a point of curve.
+func VerifyEphemeralKey(keyID KeyID, keyData []byte, curve elliptic.Curve, signingKey *ecdsa.PublicKey) (*ecdsa.PublicKey, error) {
+	if signingKey == nil {
+		return nil, ErrInvalidPublicKey
+	}
+	pointSize := 2 * ((curve.Params().BitSize + 7) / 8)
+	if len(keyData) < pointSize {
+		return nil, ErrInvalidPublicKey
+	}
+	ephemeralKey, err := unmarshalRawPublicKey(curve, keyData[:pointSize])
+	if err != nil {
+		return nil, err
+	}
+	digest, err := ecdsaDigest(signingKey.Curve, append([]byte{byte(keyID)}, keyData[:pointSize]...))
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyECDSA(signingKey, digest, keyData[pointSize:]); err != nil {
+		return nil, err
+	}
+	return ephemeralKey, nil
+}
+
+// unmarshalRawPublicKey parses a public key of curve sent as x || y, without
+// the uncompressed point prefix.
+func unmarshalRawPublicKey(curve elliptic.Curve, data []byte) (*ecdsa.PublicKey, error) {
+	coordinateSize := (curve.Params().BitSize + 7) / 8
+	if len(data) != 2*coordinateSize {
+		return nil, ErrInvalidPublicKey
+	}
+	x := new(big.Int).SetBytes(data[:coordinateSize])
+	y := new(big.Int).SetBytes(data[coordinateSize:])
+	if !curve.IsOnCurve(x, y) {
+		return nil, ErrInvalidPublicKey
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
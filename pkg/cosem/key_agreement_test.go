@@ -0,0 +1,95 @@
+package cosem
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcatKDF(t *testing.T) {
+	z := make([]byte, 32)
+	for i := range z {
+		z[i] = byte(i)
+	}
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+
+	key, err := ConcatKDF(sha256.New, z, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle, 16)
+	require.NoError(t, err)
+	assert.Equal(t, "d388069b229608a6d83f8a7060b1aa58", hex.EncodeToString(key))
+
+	// Longer keys take further rounds of the counter.
+	key, err = ConcatKDF(sha256.New, z, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle, 48)
+	require.NoError(t, err)
+	assert.Equal(t, "d388069b229608a6d83f8a7060b1aa588b652703580374f8ffc89c1e5a478b9b"+
+		"6a0d8dc3d3936feaba6c82f480cd94e2", hex.EncodeToString(key))
+
+	key, err = ConcatKDF(sha512.New384, z, OidAlgorithmAESGCM256, clientSystemTitle, serverSystemTitle, 32)
+	require.NoError(t, err)
+	assert.Equal(t, "e591b3ec7a8bb7cbf2aff80089343c4b147b7b20241101d27e4c3c8471f244c0", hex.EncodeToString(key))
+}
+
+func TestAgreeKey(t *testing.T) {
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+
+	for suite, curve := range map[SecuritySuite]elliptic.Curve{SecuritySuite1: elliptic.P256(), SecuritySuite2: elliptic.P384()} {
+		clientKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		require.NoError(t, err)
+		serverKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		require.NoError(t, err)
+
+		clientSide, err := AgreeKey(suite, clientKey, &serverKey.PublicKey, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle)
+		require.NoError(t, err)
+		serverSide, err := AgreeKey(suite, serverKey, &clientKey.PublicKey, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle)
+		require.NoError(t, err)
+		assert.Equal(t, clientSide, serverSide)
+
+		swapped, err := AgreeKey(suite, serverKey, &clientKey.PublicKey, OidAlgorithmAESGCM128, serverSystemTitle, clientSystemTitle)
+		require.NoError(t, err)
+		assert.NotEqual(t, clientSide, swapped)
+	}
+
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, err = AgreeKey(SecuritySuite0, p256, &p256.PublicKey, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle)
+	assert.ErrorIs(t, err, ErrKeyAgreementFailed)
+	_, err = AgreeKey(SecuritySuite1, p384, &p384.PublicKey, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle)
+	assert.ErrorIs(t, err, ErrInvalidPrivateKey)
+	_, err = AgreeKey(SecuritySuite1, p256, &p384.PublicKey, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle)
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+}
+
+func TestSignEphemeralKey(t *testing.T) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ephemeralKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	keyData, err := SignEphemeralKey(KeyIDGlobalAuthentication, &ephemeralKey.PublicKey, signingKey)
+	require.NoError(t, err)
+	assert.Len(t, keyData, 128)
+
+	key, err := VerifyEphemeralKey(KeyIDGlobalAuthentication, keyData, elliptic.P256(), &signingKey.PublicKey)
+	require.NoError(t, err)
+	assert.True(t, key.Equal(&ephemeralKey.PublicKey))
+
+	// The signature covers the key id.
+	_, err = VerifyEphemeralKey(KeyIDGlobalUnicastEncryption, keyData, elliptic.P256(), &signingKey.PublicKey)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = VerifyEphemeralKey(KeyIDGlobalAuthentication, keyData, elliptic.P256(), &ephemeralKey.PublicKey)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = VerifyEphemeralKey(KeyIDGlobalAuthentication, keyData[:60], elliptic.P256(), &signingKey.PublicKey)
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+	_, err = VerifyEphemeralKey(KeyIDGlobalAuthentication, keyData, elliptic.P256(), nil)
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+}
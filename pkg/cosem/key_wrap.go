@@ -0,0 +1,73 @@
+package cosem
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// ErrKeyUnwrapFailed is returned when a wrapped key fails its integrity check.
+var ErrKeyUnwrapFailed = fmt.Errorf("key unwrap failed")
+
+// keyWrapIV is the default initial value of the AES key wrap of RFC 3394.
+var keyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// KeyWrap wraps key under kek with the AES key wrap algorithm of RFC 3394, as
+// global keys are transferred under the master key. kek is an AES-128 or
+// AES-256 key; key must be a multiple of 8 bytes, at least 16.
+func KeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, fmt.Errorf("invalid key length for key wrap: %d", len(key))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, keyWrapIV)
+	copy(out[8:], key)
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, out[:8])
+			copy(b[8:], out[8*i:8*i+8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[8*i:8*i+8], b[8:])
+		}
+	}
+	return out, nil
+}
+
+// KeyUnwrap unwraps a key wrapped by KeyWrap under kek and checks its integrity.
+func KeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("invalid wrapped key length: %d", len(wrapped))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	out := append([]byte(nil), wrapped...)
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(b[8:], out[8*i:8*i+8])
+			block.Decrypt(b, b)
+			copy(out[:8], b[:8])
+			copy(out[8*i:8*i+8], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(out[:8], keyWrapIV) != 1 {
+		return nil, ErrKeyUnwrapFailed
+	}
+	return out[8:], nil
+}
@@ -0,0 +1,49 @@
+package cosem
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyWrap(t *testing.T) {
+	// Test vectors of RFC 3394, section 4.
+	kek128, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	kek256, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key128, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	key256, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+
+	for _, tc := range []struct {
+		name     string
+		kek, key []byte
+		wrapped  string
+	}{
+		{"128 bit key with 128 bit KEK", kek128, key128, "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"},
+		{"128 bit key with 256 bit KEK", kek256, key128, "64e8c3f9ce0f5ba263e9777905818a2a93c8191e7d6e8ae7"},
+		{"256 bit key with 256 bit KEK", kek256, key256, "28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326cbc7f0e71a99f43bfb988b9b7a02dd21"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			wrapped, err := KeyWrap(tc.kek, tc.key)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wrapped, hex.EncodeToString(wrapped))
+
+			key, err := KeyUnwrap(tc.kek, wrapped)
+			require.NoError(t, err)
+			assert.Equal(t, tc.key, key)
+		})
+	}
+
+	wrapped, err := KeyWrap(kek128, key128)
+	require.NoError(t, err)
+	wrapped[len(wrapped)-1] ^= 0x01
+	_, err = KeyUnwrap(kek128, wrapped)
+	assert.ErrorIs(t, err, ErrKeyUnwrapFailed)
+	_, err = KeyUnwrap(kek256, wrapped[:16])
+	assert.Error(t, err)
+	_, err = KeyWrap(kek128, key128[:12])
+	assert.Error(t, err)
+	_, err = KeyWrap(kek128[:10], key128)
+	assert.Error(t, err)
+}
@@ -54,21 +54,21 @@ func ctrEncrypt(key, iv, plaintext []byte) ([]byte, error) {
 	return ciphertext, nil
 }
 
-func encryptKuznCmac(key, plaintext, serverSystemTitle []byte, header *SecurityHeader, suite SecuritySuite) ([]byte, error) {
+func encryptKuznCmac(key, plaintext, systemTitle []byte, header *SecurityHeader, suite SecuritySuite) ([]byte, error) {
 	additionalData, err := header.Encode()
 	if err != nil {
 		return nil, err
 	}
 
 	iv := make([]byte, kuznyechikBlockSize)
-	copy(iv, serverSystemTitle)
+	copy(iv, systemTitle)
 	iv[8] = byte(header.FrameCounter >> 24)
 	iv[9] = byte(header.FrameCounter >> 16)
 	iv[10] = byte(header.FrameCounter >> 8)
 	iv[11] = byte(header.FrameCounter)
 
-	context := make([]byte, 0, len(serverSystemTitle)+1)
-	context = append(context, serverSystemTitle...)
+	context := make([]byte, 0, len(systemTitle)+1)
+	context = append(context, systemTitle...)
 	context = append(context, byte(suite))
 	ke, ka, err := deriveKuznyechikKeys(key, context, suite)
 	if err != nil {
@@ -89,7 +89,7 @@ func encryptKuznCmac(key, plaintext, serverSystemTitle []byte, header *SecurityH
 	return append(ciphertext, tag...), nil
 }
 
-func decryptKuznCmac(key, ciphertext, serverSystemTitle []byte, header *SecurityHeader, suite SecuritySuite, lastFrameCounter uint32) ([]byte, error) {
+func decryptKuznCmac(key, ciphertext, systemTitle []byte, header *SecurityHeader, suite SecuritySuite, lastFrameCounter uint32) ([]byte, error) {
 	if header.FrameCounter <= lastFrameCounter {
 		return nil, ErrReplayAttack
 	}
@@ -102,8 +102,8 @@ func decryptKuznCmac(key, ciphertext, serverSystemTitle []byte, header *Security
 		return nil, err
 	}
 
-	context := make([]byte, 0, len(serverSystemTitle)+1)
-	context = append(context, serverSystemTitle...)
+	context := make([]byte, 0, len(systemTitle)+1)
+	context = append(context, systemTitle...)
 	context = append(context, byte(suite))
 	ke, ka, err := deriveKuznyechikKeys(key, context, suite)
 	if err != nil {
@@ -120,7 +120,7 @@ func decryptKuznCmac(key, ciphertext, serverSystemTitle []byte, header *Security
 	}
 
 	iv := make([]byte, kuznyechikBlockSize)
-	copy(iv, serverSystemTitle)
+	copy(iv, systemTitle)
 	iv[8] = byte(header.FrameCounter >> 24)
 	iv[9] = byte(header.FrameCounter >> 16)
 	iv[10] = byte(header.FrameCounter >> 8)
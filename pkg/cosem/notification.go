@@ -0,0 +1,193 @@
+package cosem
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
+)
+
+// DataNotification represents the Data-Notification APDU used to push data
+// without a preceding request.
+type DataNotification struct {
+	LongInvokeIDAndPriority uint32
+	// DateTime is the COSEM date-time of the notification as a 12-byte octet
+	// string. An empty value means no date-time is sent.
+	DateTime []byte
+	// NotificationBody is the pushed Data value.
+	NotificationBody interface{}
+}
+
+// Encode encodes the DataNotification APDU into a byte slice.
+//
+// The body is long-invoke-id-and-priority (Unsigned32), date-time
+// (OCTET STRING) and the notification body Data.
+func (dn *DataNotification) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_DATA_NOTIFICATION))
+	writeUint32(&buf, dn.LongInvokeIDAndPriority)
+	if err := writeOctetString(&buf, dn.DateTime); err != nil {
+		return nil, err
+	}
+	if err := encodeData(&buf, dn.NotificationBody); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into a DataNotification APDU.
+func (dn *DataNotification) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_DATA_NOTIFICATION, "DataNotification")
+	if err != nil {
+		return err
+	}
+
+	dn.LongInvokeIDAndPriority, err = readUint32(reader, "LongInvokeIDAndPriority")
+	if err != nil {
+		return err
+	}
+	dn.DateTime, err = readOctetString(reader, "DateTime")
+	if err != nil {
+		return err
+	}
+	if len(dn.DateTime) != 0 && len(dn.DateTime) != 12 {
+		return fmt.Errorf("invalid DataNotification date-time length: %d", len(dn.DateTime))
+	}
+	dn.NotificationBody, err = axdr.DecodeFrom(reader)
+	if err != nil {
+		return err
+	}
+
+	return expectEnd(reader, "DataNotification")
+}
+
+// EncodeGeneralGloCiphered encodes the notification and protects it in a
+// general-glo-ciphering APDU. The systemTitle is the sender's system title; it
+// is carried in the APDU and used for the nonce.
+func (dn *DataNotification) EncodeGeneralGloCiphered(key, systemTitle []byte, header *SecurityHeader, suite SecuritySuite) ([]byte, error) {
+	plaintext, err := dn.Encode()
+	if err != nil {
+		return nil, err
+	}
+	ciphered, err := NewGeneralGloCiphering(key, plaintext, systemTitle, header, suite)
+	if err != nil {
+		return nil, err
+	}
+	return ciphered.Encode()
+}
+
+// DecodeGeneralGloCiphered unprotects a general-glo-ciphering APDU and decodes
+// the Data-Notification it carries. It returns the decoded ciphering APDU so
+// the caller can check the sender's system title and frame counter.
+func (dn *DataNotification) DecodeGeneralGloCiphered(src, key []byte, suite SecuritySuite, lastFrameCounter uint32) (*GeneralGloCiphering, error) {
+	ciphered := &GeneralGloCiphering{}
+	if err := ciphered.Decode(src); err != nil {
+		return nil, err
+	}
+	plaintext, err := ciphered.Open(key, suite, lastFrameCounter)
+	if err != nil {
+		return nil, err
+	}
+	if err := dn.Decode(plaintext); err != nil {
+		return nil, err
+	}
+	return ciphered, nil
+}
+
+// EventNotificationRequest represents the Event-Notification-Request APDU sent
+// by a server to report the value of an attribute without being polled.
+type EventNotificationRequest struct {
+	// Time is the COSEM date-time of the event as a 12-byte octet string. It is
+	// optional; nil means no time is sent.
+	Time                []byte
+	AttributeDescriptor CosemAttributeDescriptor
+	AttributeValue      interface{}
+}
+
+// Encode encodes the EventNotificationRequest APDU into a byte slice.
+func (en *EventNotificationRequest) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_EVENT_NOTIFICATION_REQUEST))
+	if en.Time == nil {
+		buf.WriteByte(0x00)
+	} else {
+		buf.WriteByte(0x01)
+		if err := writeOctetString(&buf, en.Time); err != nil {
+			return nil, err
+		}
+	}
+	encodeAttributeDescriptor(&buf, en.AttributeDescriptor)
+	if err := encodeData(&buf, en.AttributeValue); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into an EventNotificationRequest APDU.
+func (en *EventNotificationRequest) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_EVENT_NOTIFICATION_REQUEST, "EventNotificationRequest")
+	if err != nil {
+		return err
+	}
+
+	present, err := readOptionalFlag(reader, "Time")
+	if err != nil {
+		return err
+	}
+	en.Time = nil
+	if present {
+		en.Time, err = readOctetString(reader, "Time")
+		if err != nil {
+			return err
+		}
+	}
+	en.AttributeDescriptor, err = decodeAttributeDescriptor(reader)
+	if err != nil {
+		return err
+	}
+	en.AttributeValue, err = axdr.DecodeFrom(reader)
+	if err != nil {
+		return err
+	}
+
+	return expectEnd(reader, "EventNotificationRequest")
+}
+
+// NotificationListener dispatches unsolicited APDUs received by a client to
+// the registered hooks. Hooks left nil ignore the corresponding notification.
+type NotificationListener struct {
+	OnEventNotification func(notification *EventNotificationRequest)
+	OnDataNotification  func(notification *DataNotification)
+}
+
+// HandleAPDU decodes src and passes it to the matching hook. It reports false
+// when src is not a notification, so the caller can treat it as a response to
+// one of its own requests.
+func (l *NotificationListener) HandleAPDU(src []byte) (bool, error) {
+	if len(src) == 0 {
+		return false, fmt.Errorf("empty APDU")
+	}
+
+	switch APDUType(src[0]) {
+	case APDU_EVENT_NOTIFICATION_REQUEST:
+		notification := &EventNotificationRequest{}
+		if err := notification.Decode(src); err != nil {
+			return true, err
+		}
+		if l.OnEventNotification != nil {
+			l.OnEventNotification(notification)
+		}
+		return true, nil
+	case APDU_DATA_NOTIFICATION:
+		notification := &DataNotification{}
+		if err := notification.Decode(src); err != nil {
+			return true, err
+		}
+		if l.OnDataNotification != nil {
+			l.OnDataNotification(notification)
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
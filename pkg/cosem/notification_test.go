@@ -0,0 +1,170 @@
+package cosem
+
+import (
+	"testing"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataNotification_WireFormat(t *testing.T) {
+	dateTime := []byte{0x07, 0xEA, 0x0A, 0x10, 0x05, 0x00, 0x00, 0x00, 0xFF, 0x80, 0x00, 0x00}
+
+	tests := []struct {
+		name string
+		apdu *DataNotification
+		want []byte
+	}{
+		{
+			name: "WithoutDateTime",
+			apdu: &DataNotification{LongInvokeIDAndPriority: 0x80000001, NotificationBody: []byte{0x12, 0x34}},
+			want: []byte{0x0F, 0x80, 0x00, 0x00, 0x01, 0x00, 0x09, 0x02, 0x12, 0x34},
+		},
+		{
+			name: "WithDateTime",
+			apdu: &DataNotification{
+				LongInvokeIDAndPriority: 0x00000002,
+				DateTime:                dateTime,
+				NotificationBody:        axdr.Structure{[]byte{0x01}, []byte{0x02}},
+			},
+			want: append(append([]byte{0x0F, 0x00, 0x00, 0x00, 0x02, 0x0C}, dateTime...),
+				0x02, 0x02, 0x09, 0x01, 0x01, 0x09, 0x01, 0x02),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.apdu.Encode()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, encoded)
+
+			decoded := &DataNotification{}
+			require.NoError(t, decoded.Decode(encoded))
+			assert.Equal(t, tt.apdu.LongInvokeIDAndPriority, decoded.LongInvokeIDAndPriority)
+			assert.Equal(t, len(tt.apdu.DateTime), len(decoded.DateTime))
+			assert.Equal(t, tt.apdu.NotificationBody, decoded.NotificationBody)
+		})
+	}
+}
+
+func TestDataNotification_DecodeRejectsMalformedInput(t *testing.T) {
+	inputs := map[string][]byte{
+		"WrongTag":          {0xC0, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00},
+		"TruncatedInvokeID": {0x0F, 0x00, 0x00},
+		"BadDateTimeLength": {0x0F, 0x00, 0x00, 0x00, 0x01, 0x02, 0x07, 0xEA, 0x00},
+		"MissingBody":       {0x0F, 0x00, 0x00, 0x00, 0x01, 0x00},
+		"TrailingBytes":     {0x0F, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0xFF},
+	}
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, (&DataNotification{}).Decode(input))
+		})
+	}
+}
+
+func TestDataNotification_GeneralGloCiphered(t *testing.T) {
+	key := []byte("0123456789ABCDEF")
+	systemTitle := []byte("METER001")
+	header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: 7}
+	dn := &DataNotification{LongInvokeIDAndPriority: 1, NotificationBody: uint32(4242)}
+
+	encoded, err := dn.EncodeGeneralGloCiphered(key, systemTitle, header, SecuritySuite0)
+	require.NoError(t, err)
+	assert.Equal(t, byte(APDU_GENERAL_GLO_CIPHERING), encoded[0])
+	assert.Equal(t, []byte{0x08}, encoded[1:2])
+	assert.Equal(t, systemTitle, encoded[2:10])
+	assert.Equal(t, []byte{0x30, 0x00, 0x00, 0x00, 0x07}, encoded[11:16])
+
+	decoded := &DataNotification{}
+	ciphered, err := decoded.DecodeGeneralGloCiphered(encoded, key, SecuritySuite0, 6)
+	require.NoError(t, err)
+	assert.Equal(t, systemTitle, ciphered.SystemTitle)
+	assert.Equal(t, uint32(7), ciphered.SecurityHeader.FrameCounter)
+	assert.Equal(t, uint32(4242), decoded.NotificationBody)
+
+	_, err = (&DataNotification{}).DecodeGeneralGloCiphered(encoded, key, SecuritySuite0, 7)
+	assert.ErrorIs(t, err, ErrReplayAttack)
+
+	tampered := append([]byte(nil), encoded...)
+	tampered[len(tampered)-1] ^= 0xFF
+	_, err = (&DataNotification{}).DecodeGeneralGloCiphered(tampered, key, SecuritySuite0, 0)
+	assert.ErrorIs(t, err, ErrAuthenticationFailed)
+}
+
+func TestEventNotificationRequest_WireFormat(t *testing.T) {
+	obis, _ := NewObisCodeFromString("0.0.97.98.0.255")
+	desc := CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: *obis, AttributeID: 2}
+	eventTime := []byte{0x07, 0xEA, 0x0A, 0x10, 0x05, 0x0C, 0x00, 0x00, 0x00, 0x00, 0xB4, 0x00}
+
+	tests := []struct {
+		name string
+		apdu *EventNotificationRequest
+		want []byte
+	}{
+		{
+			name: "WithoutTime",
+			apdu: &EventNotificationRequest{AttributeDescriptor: desc, AttributeValue: []byte{0x01}},
+			want: []byte{0xC2, 0x00, 0x00, 0x01, 0x00, 0x00, 0x61, 0x62, 0x00, 0xFF, 0x02, 0x09, 0x01, 0x01},
+		},
+		{
+			name: "WithTime",
+			apdu: &EventNotificationRequest{Time: eventTime, AttributeDescriptor: desc, AttributeValue: []byte{0x01}},
+			want: append(append([]byte{0xC2, 0x01, 0x0C}, eventTime...),
+				0x00, 0x01, 0x00, 0x00, 0x61, 0x62, 0x00, 0xFF, 0x02, 0x09, 0x01, 0x01),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.apdu.Encode()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, encoded)
+
+			decoded := &EventNotificationRequest{}
+			require.NoError(t, decoded.Decode(encoded))
+			assert.Equal(t, tt.apdu, decoded)
+		})
+	}
+
+	assert.Error(t, (&EventNotificationRequest{}).Decode([]byte{0xC2, 0x02}))
+	assert.Error(t, (&EventNotificationRequest{}).Decode([]byte{0xC2, 0x00, 0x00, 0x01}))
+}
+
+func TestNotificationListener_HandleAPDU(t *testing.T) {
+	obis, _ := NewObisCodeFromString("0.0.97.98.0.255")
+	var events []*EventNotificationRequest
+	var data []*DataNotification
+	listener := &NotificationListener{
+		OnEventNotification: func(n *EventNotificationRequest) { events = append(events, n) },
+		OnDataNotification:  func(n *DataNotification) { data = append(data, n) },
+	}
+
+	event, err := (&EventNotificationRequest{
+		AttributeDescriptor: CosemAttributeDescriptor{ClassID: DataClassID, InstanceID: *obis, AttributeID: 2},
+		AttributeValue:      uint32(1),
+	}).Encode()
+	require.NoError(t, err)
+	handled, err := listener.HandleAPDU(event)
+	require.NoError(t, err)
+	assert.True(t, handled)
+
+	push, err := (&DataNotification{LongInvokeIDAndPriority: 1, NotificationBody: uint32(2)}).Encode()
+	require.NoError(t, err)
+	handled, err = listener.HandleAPDU(push)
+	require.NoError(t, err)
+	assert.True(t, handled)
+
+	handled, err = listener.HandleAPDU([]byte{0xC4, 0x01, 0x81, 0x01, 0x0B})
+	require.NoError(t, err)
+	assert.False(t, handled)
+
+	handled, err = listener.HandleAPDU([]byte{0xC2, 0x00})
+	assert.Error(t, err)
+	assert.True(t, handled)
+
+	require.Len(t, events, 1)
+	assert.Equal(t, uint32(1), events[0].AttributeValue)
+	require.Len(t, data, 1)
+	assert.Equal(t, uint32(2), data[0].NotificationBody)
+}
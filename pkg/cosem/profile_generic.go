@@ -1,8 +1,13 @@
 package cosem
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
 )
 
 func validateCapturePeriod(value interface{}) error {
@@ -195,3 +200,425 @@ func (pg *ProfileGeneric) capture(params []interface{}) (interface{}, error) {
 
 	return nil, nil
 }
+
+// Access selectors supported by the buffer attribute of the "Profile generic" interface class.
+const (
+	ProfileGenericRangeDescriptor uint8 = 1
+	ProfileGenericEntryDescriptor uint8 = 2
+)
+
+// RangeDescriptor selects the buffer entries whose restricting object value lies
+// between FromValue and ToValue inclusive. SelectedValues limits the returned
+// columns; an empty list selects all capture objects.
+type RangeDescriptor struct {
+	RestrictingObject CaptureObjectDefinition
+	FromValue         interface{}
+	ToValue           interface{}
+	SelectedValues    []CaptureObjectDefinition
+}
+
+// EntryDescriptor selects buffer entries and columns by position. Entries and
+// columns are numbered from 1; a zero ToEntry or ToSelectedValue means the last one.
+type EntryDescriptor struct {
+	FromEntry         uint32
+	ToEntry           uint32
+	FromSelectedValue uint16
+	ToSelectedValue   uint16
+}
+
+// AccessParameters returns the range_descriptor structure sent as access_parameters.
+func (rd RangeDescriptor) AccessParameters() axdr.Structure {
+	selected := axdr.Array{}
+	for _, co := range rd.SelectedValues {
+		selected = append(selected, captureObjectStructure(co))
+	}
+	return axdr.Structure{captureObjectStructure(rd.RestrictingObject), rd.FromValue, rd.ToValue, selected}
+}
+
+// AccessParameters returns the entry_descriptor structure sent as access_parameters.
+func (ed EntryDescriptor) AccessParameters() axdr.Structure {
+	return axdr.Structure{ed.FromEntry, ed.ToEntry, ed.FromSelectedValue, ed.ToSelectedValue}
+}
+
+func captureObjectStructure(co CaptureObjectDefinition) axdr.Structure {
+	obis := co.InstanceID.Bytes()
+	return axdr.Structure{co.ClassID, obis[:], int8(co.AttributeID), co.DataIndex}
+}
+
+func parseCaptureObject(v interface{}) (CaptureObjectDefinition, error) {
+	s, ok := v.(axdr.Structure)
+	if !ok || len(s) != 4 {
+		return CaptureObjectDefinition{}, fmt.Errorf("%w: capture_object_definition must be a structure of 4 elements", ErrInvalidParameter)
+	}
+	classID, ok1 := unsignedValue(s[0])
+	raw, ok2 := s[1].([]byte)
+	attributeID, ok3 := signedValue(s[2])
+	dataIndex, ok4 := unsignedValue(s[3])
+	if !ok1 || !ok2 || !ok3 || !ok4 || len(raw) != 6 || classID > 0xFFFF || attributeID < 0 || attributeID > 0x7F || dataIndex > 0xFFFF {
+		return CaptureObjectDefinition{}, fmt.Errorf("%w: malformed capture_object_definition", ErrInvalidParameter)
+	}
+	var obis [6]byte
+	copy(obis[:], raw)
+	return CaptureObjectDefinition{
+		ClassID:     uint16(classID),
+		InstanceID:  *NewObisCodeFromBytes(obis),
+		AttributeID: uint8(attributeID),
+		DataIndex:   uint16(dataIndex),
+	}, nil
+}
+
+func parseRangeDescriptor(v interface{}) (RangeDescriptor, error) {
+	s, ok := v.(axdr.Structure)
+	if !ok || len(s) != 4 {
+		return RangeDescriptor{}, fmt.Errorf("%w: range_descriptor must be a structure of 4 elements", ErrInvalidParameter)
+	}
+	restricting, err := parseCaptureObject(s[0])
+	if err != nil {
+		return RangeDescriptor{}, err
+	}
+	list, ok := s[3].(axdr.Array)
+	if !ok {
+		return RangeDescriptor{}, fmt.Errorf("%w: selected_values must be an array", ErrInvalidParameter)
+	}
+	rd := RangeDescriptor{RestrictingObject: restricting, FromValue: s[1], ToValue: s[2]}
+	for _, item := range list {
+		co, err := parseCaptureObject(item)
+		if err != nil {
+			return RangeDescriptor{}, err
+		}
+		rd.SelectedValues = append(rd.SelectedValues, co)
+	}
+	return rd, nil
+}
+
+func parseEntryDescriptor(v interface{}) (EntryDescriptor, error) {
+	s, ok := v.(axdr.Structure)
+	if !ok || len(s) != 4 {
+		return EntryDescriptor{}, fmt.Errorf("%w: entry_descriptor must be a structure of 4 elements", ErrInvalidParameter)
+	}
+	fromEntry, ok1 := unsignedValue(s[0])
+	toEntry, ok2 := unsignedValue(s[1])
+	fromValue, ok3 := unsignedValue(s[2])
+	toValue, ok4 := unsignedValue(s[3])
+	if !ok1 || !ok2 || !ok3 || !ok4 || fromEntry > 0xFFFFFFFF || toEntry > 0xFFFFFFFF || fromValue > 0xFFFF || toValue > 0xFFFF {
+		return EntryDescriptor{}, fmt.Errorf("%w: malformed entry_descriptor", ErrInvalidParameter)
+	}
+	return EntryDescriptor{
+		FromEntry:         uint32(fromEntry),
+		ToEntry:           uint32(toEntry),
+		FromSelectedValue: uint16(fromValue),
+		ToSelectedValue:   uint16(toValue),
+	}, nil
+}
+
+// GetAttributeWithSelector reads the buffer attribute restricted by a range
+// (selector 1) or entry (selector 2) descriptor.
+//
+// The result keeps the buffer type when all columns are selected; otherwise each
+// entry is returned as a structure of the selected columns.
+func (pg *ProfileGeneric) GetAttributeWithSelector(attributeID byte, selector uint8, parameters interface{}) (interface{}, error) {
+	if attributeID != 2 {
+		return nil, fmt.Errorf("%w: attribute %d has no selective access", ErrAccessSelectorNotSupported, attributeID)
+	}
+
+	buffer, err := pg.GetAttribute(2)
+	if err != nil {
+		return nil, err
+	}
+	bufferVal := reflect.ValueOf(buffer)
+	if !bufferVal.IsValid() || bufferVal.Kind() != reflect.Slice {
+		return nil, ErrInvalidValueType
+	}
+	captureObjects, _ := pg.Attributes[3].Value.([]CaptureObjectDefinition)
+
+	switch selector {
+	case ProfileGenericRangeDescriptor:
+		rd, err := parseRangeDescriptor(parameters)
+		if err != nil {
+			return nil, err
+		}
+		return pg.selectByRange(bufferVal, captureObjects, rd)
+	case ProfileGenericEntryDescriptor:
+		ed, err := parseEntryDescriptor(parameters)
+		if err != nil {
+			return nil, err
+		}
+		return pg.selectByEntry(bufferVal, captureObjects, ed)
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrAccessSelectorNotSupported, selector)
+	}
+}
+
+func (pg *ProfileGeneric) selectByRange(buffer reflect.Value, captureObjects []CaptureObjectDefinition, rd RangeDescriptor) (interface{}, error) {
+	column := captureObjectIndex(captureObjects, rd.RestrictingObject)
+	if column < 0 {
+		return nil, fmt.Errorf("%w: restricting_object is not a capture object", ErrInvalidParameter)
+	}
+
+	var columns []int
+	for _, co := range rd.SelectedValues {
+		idx := captureObjectIndex(captureObjects, co)
+		if idx < 0 {
+			return nil, fmt.Errorf("%w: selected value is not a capture object", ErrInvalidParameter)
+		}
+		columns = append(columns, idx)
+	}
+
+	var rows []int
+	for i := 0; i < buffer.Len(); i++ {
+		entry := entryColumns(buffer.Index(i).Interface())
+		if column >= len(entry) {
+			return nil, fmt.Errorf("%w: entry %d has no restricting_object value", ErrInvalidParameter, i+1)
+		}
+		lower, err := compareValues(entry[column], rd.FromValue)
+		if err != nil {
+			return nil, err
+		}
+		upper, err := compareValues(entry[column], rd.ToValue)
+		if err != nil {
+			return nil, err
+		}
+		if lower >= 0 && upper <= 0 {
+			rows = append(rows, i)
+		}
+	}
+
+	return buildSelection(buffer, rows, columns), nil
+}
+
+func (pg *ProfileGeneric) selectByEntry(buffer reflect.Value, captureObjects []CaptureObjectDefinition, ed EntryDescriptor) (interface{}, error) {
+	if ed.FromEntry == 0 {
+		return nil, fmt.Errorf("%w: from_entry must be at least 1", ErrInvalidParameter)
+	}
+	count := uint32(buffer.Len())
+	toEntry := ed.ToEntry
+	if toEntry == 0 || toEntry > count {
+		toEntry = count
+	}
+	var rows []int
+	for i := ed.FromEntry; i <= toEntry; i++ {
+		rows = append(rows, int(i-1))
+	}
+
+	width := len(captureObjects)
+	if width == 0 && buffer.Len() > 0 {
+		width = len(entryColumns(buffer.Index(0).Interface()))
+	}
+	fromValue := int(ed.FromSelectedValue)
+	toValue := int(ed.ToSelectedValue)
+	if toValue == 0 {
+		toValue = width
+	}
+	if fromValue == 1 && toValue == width {
+		return buildSelection(buffer, rows, nil), nil
+	}
+	if fromValue == 0 || fromValue > toValue || toValue > width {
+		return nil, fmt.Errorf("%w: selected values %d..%d are out of range", ErrInvalidParameter, ed.FromSelectedValue, ed.ToSelectedValue)
+	}
+
+	columns := make([]int, 0, toValue-fromValue+1)
+	for c := fromValue - 1; c < toValue; c++ {
+		columns = append(columns, c)
+	}
+	return buildSelection(buffer, rows, columns), nil
+}
+
+// buildSelection returns the selected rows of buffer. A nil columns slice keeps
+// whole entries and the buffer type.
+func buildSelection(buffer reflect.Value, rows []int, columns []int) interface{} {
+	if columns == nil {
+		out := reflect.MakeSlice(buffer.Type(), 0, len(rows))
+		for _, r := range rows {
+			out = reflect.Append(out, buffer.Index(r))
+		}
+		return out.Interface()
+	}
+
+	out := axdr.Array{}
+	for _, r := range rows {
+		entry := entryColumns(buffer.Index(r).Interface())
+		row := axdr.Structure{}
+		for _, c := range columns {
+			if c < len(entry) {
+				row = append(row, entry[c])
+			} else {
+				row = append(row, nil)
+			}
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+func captureObjectIndex(captureObjects []CaptureObjectDefinition, target CaptureObjectDefinition) int {
+	for i, co := range captureObjects {
+		if co.ClassID == target.ClassID && co.InstanceID.Bytes() == target.InstanceID.Bytes() &&
+			co.AttributeID == target.AttributeID && co.DataIndex == target.DataIndex {
+			return i
+		}
+	}
+	return -1
+}
+
+// entryColumns splits a buffer entry into its column values.
+func entryColumns(entry interface{}) []interface{} {
+	if _, ok := entry.([]byte); ok {
+		return []interface{}{entry}
+	}
+	v := reflect.ValueOf(entry)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		cols := make([]interface{}, v.Len())
+		for i := range cols {
+			cols[i] = v.Index(i).Interface()
+		}
+		return cols
+	case reflect.Struct:
+		if _, ok := entry.(time.Time); ok {
+			break
+		}
+		if _, ok := entry.(axdr.DateTime); ok {
+			break
+		}
+		cols := make([]interface{}, 0, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				cols = append(cols, v.Field(i).Interface())
+			}
+		}
+		return cols
+	}
+	return []interface{}{entry}
+}
+
+func unsignedValue(v interface{}) (uint64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() >= 0 {
+			return uint64(rv.Int()), true
+		}
+	}
+	return 0, false
+}
+
+func signedValue(v interface{}) (int64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() <= 1<<63-1 {
+			return int64(rv.Uint()), true
+		}
+	}
+	return 0, false
+}
+
+// compareValues orders two restricting object values. Integers of any width
+// compare numerically, date-times chronologically, strings and octet strings
+// lexically.
+func compareValues(a, b interface{}) (int, error) {
+	if ta, ok := timeValue(a); ok {
+		if tb, ok := timeValue(b); ok {
+			return ta.Compare(tb), nil
+		}
+	}
+	if ba, ok := a.([]byte); ok {
+		if bb, ok := b.([]byte); ok {
+			return bytes.Compare(ba, bb), nil
+		}
+	}
+	if sa, ok := a.(string); ok {
+		if sb, ok := b.(string); ok {
+			return strings.Compare(sa, sb), nil
+		}
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if isNumeric(va) && isNumeric(vb) {
+		if isFloat(va) || isFloat(vb) {
+			fa, fb := floatValue(va), floatValue(vb)
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			}
+			return 0, nil
+		}
+		ia, aSigned := signedValue(a)
+		ib, bSigned := signedValue(b)
+		if aSigned && bSigned {
+			switch {
+			case ia < ib:
+				return -1, nil
+			case ia > ib:
+				return 1, nil
+			}
+			return 0, nil
+		}
+		// At least one side does not fit in int64: it is a large unsigned value.
+		if !aSigned && !bSigned {
+			ua, ub := va.Uint(), vb.Uint()
+			switch {
+			case ua < ub:
+				return -1, nil
+			case ua > ub:
+				return 1, nil
+			}
+			return 0, nil
+		}
+		if !aSigned {
+			return 1, nil
+		}
+		return -1, nil
+	}
+
+	return 0, fmt.Errorf("%w: cannot compare %T with %T", ErrInvalidParameter, a, b)
+}
+
+// timeValue converts a date-time value to time.Time. A 12-byte octet string is
+// treated as a COSEM date-time; its day-of-week byte is ignored.
+func timeValue(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case axdr.DateTime:
+		tt, err := t.ToTime()
+		return tt, err == nil
+	case []byte:
+		if len(t) != 12 {
+			return time.Time{}, false
+		}
+		year := int(t[0])<<8 | int(t[1])
+		return time.Date(year, time.Month(t[2]), int(t[3]), int(t[5]), int(t[6]), int(t[7]), 0, time.UTC), true
+	}
+	return time.Time{}, false
+}
+
+func isNumeric(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isFloat(v reflect.Value) bool {
+	return v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+func floatValue(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	}
+	return float64(v.Int())
+}
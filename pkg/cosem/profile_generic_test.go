@@ -4,6 +4,8 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
 )
 
 func newTestProfileGeneric(t *testing.T) *ProfileGeneric {
@@ -213,3 +215,107 @@ func TestProfileGenericCaptureMethod(t *testing.T) {
 		t.Fatalf("expected invalid parameter error for capture with wrong argument type, got %v", err)
 	}
 }
+
+func newTestLoadProfile(t *testing.T) (*ProfileGeneric, CaptureObjectDefinition, CaptureObjectDefinition) {
+	t.Helper()
+
+	obis, _ := NewObisCodeFromString("1.0.99.1.0.255")
+	clockObis, _ := NewObisCodeFromString("0.0.1.0.0.255")
+	energyObis, _ := NewObisCodeFromString("1.0.1.8.0.255")
+	clock := CaptureObjectDefinition{ClassID: ClockClassID, InstanceID: *clockObis, AttributeID: 2}
+	energy := CaptureObjectDefinition{ClassID: 3, InstanceID: *energyObis, AttributeID: 2}
+
+	buffer := [][]interface{}{
+		{uint32(100), uint32(1)},
+		{uint32(200), uint32(2)},
+		{uint32(300), uint32(3)},
+	}
+	pg, err := NewProfileGeneric(*obis, buffer, []CaptureObjectDefinition{clock, energy}, 900, 0, CosemAttributeDescriptor{})
+	if err != nil {
+		t.Fatalf("failed to create profile generic: %v", err)
+	}
+	return pg, clock, energy
+}
+
+func TestProfileGenericRangeDescriptor(t *testing.T) {
+	pg, clock, energy := newTestLoadProfile(t)
+
+	params := RangeDescriptor{RestrictingObject: clock, FromValue: uint32(150), ToValue: uint32(300)}.AccessParameters()
+	got, err := pg.GetAttributeWithSelector(2, ProfileGenericRangeDescriptor, params)
+	if err != nil {
+		t.Fatalf("range read failed: %v", err)
+	}
+	want := [][]interface{}{{uint32(200), uint32(2)}, {uint32(300), uint32(3)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected range selection: got %v want %v", got, want)
+	}
+
+	params = RangeDescriptor{
+		RestrictingObject: clock,
+		FromValue:         uint16(0),
+		ToValue:           uint32(100),
+		SelectedValues:    []CaptureObjectDefinition{energy},
+	}.AccessParameters()
+	got, err = pg.GetAttributeWithSelector(2, ProfileGenericRangeDescriptor, params)
+	if err != nil {
+		t.Fatalf("range read with selected values failed: %v", err)
+	}
+	if want := (axdr.Array{axdr.Structure{uint32(1)}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected column selection: got %v want %v", got, want)
+	}
+
+	unknown := energy
+	unknown.AttributeID = 3
+	params = RangeDescriptor{RestrictingObject: unknown, FromValue: uint32(0), ToValue: uint32(1)}.AccessParameters()
+	if _, err := pg.GetAttributeWithSelector(2, ProfileGenericRangeDescriptor, params); !errors.Is(err, ErrInvalidParameter) {
+		t.Fatalf("expected invalid parameter for unknown restricting object, got %v", err)
+	}
+
+	params = RangeDescriptor{RestrictingObject: clock, FromValue: "a", ToValue: "b"}.AccessParameters()
+	if _, err := pg.GetAttributeWithSelector(2, ProfileGenericRangeDescriptor, params); !errors.Is(err, ErrInvalidParameter) {
+		t.Fatalf("expected invalid parameter for incomparable bounds, got %v", err)
+	}
+}
+
+func TestProfileGenericEntryDescriptor(t *testing.T) {
+	pg, _, _ := newTestLoadProfile(t)
+
+	got, err := pg.GetAttributeWithSelector(2, ProfileGenericEntryDescriptor, EntryDescriptor{FromEntry: 2, FromSelectedValue: 1}.AccessParameters())
+	if err != nil {
+		t.Fatalf("entry read failed: %v", err)
+	}
+	want := [][]interface{}{{uint32(200), uint32(2)}, {uint32(300), uint32(3)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entry selection: got %v want %v", got, want)
+	}
+
+	params := EntryDescriptor{FromEntry: 1, ToEntry: 2, FromSelectedValue: 2, ToSelectedValue: 2}.AccessParameters()
+	got, err = pg.GetAttributeWithSelector(2, ProfileGenericEntryDescriptor, params)
+	if err != nil {
+		t.Fatalf("entry read with columns failed: %v", err)
+	}
+	if want := (axdr.Array{axdr.Structure{uint32(1)}, axdr.Structure{uint32(2)}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected column selection: got %v want %v", got, want)
+	}
+
+	tests := []struct {
+		name     string
+		selector uint8
+		params   interface{}
+		want     error
+	}{
+		{"from_entry zero", ProfileGenericEntryDescriptor, EntryDescriptor{}.AccessParameters(), ErrInvalidParameter},
+		{"columns out of range", ProfileGenericEntryDescriptor, EntryDescriptor{FromEntry: 1, FromSelectedValue: 1, ToSelectedValue: 3}.AccessParameters(), ErrInvalidParameter},
+		{"malformed parameters", ProfileGenericEntryDescriptor, uint32(1), ErrInvalidParameter},
+		{"unknown selector", 3, nil, ErrAccessSelectorNotSupported},
+	}
+	for _, tt := range tests {
+		if _, err := pg.GetAttributeWithSelector(2, tt.selector, tt.params); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	if _, err := pg.GetAttributeWithSelector(3, ProfileGenericEntryDescriptor, nil); !errors.Is(err, ErrAccessSelectorNotSupported) {
+		t.Fatalf("expected access selector not supported for capture_objects, got %v", err)
+	}
+}
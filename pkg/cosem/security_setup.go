@@ -1,14 +1,36 @@
 package cosem
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
 	"reflect"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
 )
 
 // SecuritySetupClassID is the class ID for the "Security setup" interface class.
 const SecuritySetupClassID uint16 = 64
 
 // SecuritySetupVersion is the version of the "Security setup" interface class.
-const SecuritySetupVersion byte = 0
+const SecuritySetupVersion byte = 1
+
+// Method IDs of the "Security setup" interface class.
+const (
+	securitySetupMethodSecurityActivate byte = 1
+	securitySetupMethodKeyTransfer      byte = 2
+	securitySetupMethodKeyAgreement     byte = 3
+	securitySetupMethodGenerateKeyPair  byte = 4
+)
+
+// KeyPairType is the key_pair_type parameter of generate_key_pair.
+type KeyPairType byte
+
+const (
+	KeyPairDigitalSignature KeyPairType = 0
+	KeyPairKeyAgreement     KeyPairType = 1
+	KeyPairTLS              KeyPairType = 2
+)
 
 // SecurityPolicy represents the security_policy attribute of the Security setup class.
 // It's a bitmask defining the minimum security level for requests and responses.
@@ -24,6 +46,10 @@ const (
 	PolicyDigitallySignedResponse SecurityPolicy = 0x80 // bit 7
 )
 
+// policyMask holds the defined bits of the security policy.
+const policyMask = PolicyAuthenticatedRequest | PolicyEncryptedRequest | PolicyDigitallySignedRequest |
+	PolicyAuthenticatedResponse | PolicyEncryptedResponse | PolicyDigitallySignedResponse
+
 // SecuritySuite represents the security_suite attribute of the Security setup class.
 type SecuritySuite byte
 
@@ -41,6 +67,21 @@ type SecuritySetup struct {
 	MasterKey               []byte // KEK
 	GlobalUnicastKey        []byte // GUEK
 	GlobalAuthenticationKey []byte // GAK
+	GlobalBroadcastKey      []byte // GBEK
+
+	// ServerSigningKey signs responses under PolicyDigitallySignedResponse and
+	// the ephemeral keys of key_agreement.
+	ServerSigningKey crypto.PrivateKey
+	// ClientSigningKey verifies the signature of general-signing requests and
+	// of the ephemeral keys of key_agreement.
+	ClientSigningKey crypto.PublicKey
+	// ServerKeyAgreementKey is the static key of the server in One-Pass
+	// Diffie-Hellman.
+	ServerKeyAgreementKey crypto.PrivateKey
+
+	// invokingSystemTitle is the system title of the client a method is invoked
+	// for by the Application, set for the duration of the invocation.
+	invokingSystemTitle []byte
 }
 
 // NewSecuritySetup creates a new instance of the "Security setup" interface class.
@@ -73,7 +114,7 @@ func NewSecuritySetup(obis ObisCode, clientSystemTitle []byte, serverSystemTitle
 		},
 	}
 
-	return &SecuritySetup{
+	s := &SecuritySetup{
 		BaseImpl: BaseImpl{
 			ClassID:    SecuritySetupClassID,
 			InstanceID: obis,
@@ -83,5 +124,213 @@ func NewSecuritySetup(obis ObisCode, clientSystemTitle []byte, serverSystemTitle
 		MasterKey:               masterKey,
 		GlobalUnicastKey:        guek,
 		GlobalAuthenticationKey: gak,
-	}, nil
+	}
+	policy := s.Attributes[2]
+	policy.Validator = s.validateSecurityPolicy
+	s.Attributes[2] = policy
+
+	s.Methods[securitySetupMethodSecurityActivate] = MethodDescriptor{
+		Access:     MethodAccessAllowed,
+		ParamTypes: []reflect.Type{reflect.TypeOf(axdr.Enum(0))},
+		Handler:    s.handleSecurityActivate,
+	}
+	s.Methods[securitySetupMethodKeyTransfer] = MethodDescriptor{
+		Access:     MethodAccessAllowed,
+		ParamTypes: []reflect.Type{reflect.TypeOf(axdr.Structure{})},
+		Variadic:   true,
+		Handler:    s.handleKeyTransfer,
+	}
+	s.Methods[securitySetupMethodKeyAgreement] = MethodDescriptor{
+		Access:     MethodAccessAllowed,
+		ParamTypes: []reflect.Type{reflect.TypeOf(axdr.Structure{})},
+		Variadic:   true,
+		ReturnType: reflect.TypeOf(axdr.Array{}),
+		Handler:    s.handleKeyAgreement,
+	}
+	s.Methods[securitySetupMethodGenerateKeyPair] = MethodDescriptor{
+		Access:     MethodAccessAllowed,
+		ParamTypes: []reflect.Type{reflect.TypeOf(axdr.Enum(0))},
+		Handler:    s.handleGenerateKeyPair,
+	}
+
+	return s, nil
+}
+
+// validateSecurityPolicy checks a new security_policy, written or activated. The
+// policy can only be raised: every protection the current policy requires must
+// stay required.
+func (s *SecuritySetup) validateSecurityPolicy(value interface{}) error {
+	policy := value.(SecurityPolicy)
+	if policy&^policyMask != 0 {
+		return ErrInvalidParameter
+	}
+	if current := s.Attributes[2].Value.(SecurityPolicy); policy&current != current {
+		return ErrAccessDenied
+	}
+	return nil
+}
+
+// handleSecurityActivate activates the security policy passed as an enum (see
+// validateSecurityPolicy).
+func (s *SecuritySetup) handleSecurityActivate(params []interface{}) (interface{}, error) {
+	policy := SecurityPolicy(params[0].(axdr.Enum))
+	if err := s.validateSecurityPolicy(policy); err != nil {
+		return nil, err
+	}
+	attr := s.Attributes[2]
+	attr.Value = policy
+	s.Attributes[2] = attr
+	return nil, nil
+}
+
+// handleKeyTransfer replaces the keys named by an array of key_data (key_id,
+// key_wrapped), each wrapped under the master key with KeyWrap. The master key
+// itself can be transferred as key id 3. The keys are replaced only when every
+// one was unwrapped. The response to the request invoking the method is still
+// protected with the previous key, which the application selects before serving
+// the request; the requests that follow a new global key may restart their
+// frame counters.
+func (s *SecuritySetup) handleKeyTransfer(params []interface{}) (interface{}, error) {
+	if len(params) == 0 {
+		return nil, ErrInvalidParameter
+	}
+	suite := s.Attributes[3].Value.(SecuritySuite)
+
+	keys := make(map[KeyID][]byte, len(params))
+	for _, param := range params {
+		data := param.(axdr.Structure)
+		if len(data) != 2 {
+			return nil, ErrInvalidParameter
+		}
+		id, ok1 := data[0].(axdr.Enum)
+		wrapped, ok2 := data[1].([]byte)
+		keyID := KeyID(id)
+		if !ok1 || !ok2 || keyID > KeyIDMasterKey {
+			return nil, ErrInvalidParameter
+		}
+		key, err := KeyUnwrap(s.MasterKey, wrapped)
+		if err != nil {
+			return nil, ErrAccessDenied
+		}
+		if err := validateKeyLength(key, suite); err != nil {
+			return nil, ErrInvalidParameter
+		}
+		keys[keyID] = key
+	}
+
+	for keyID, key := range keys {
+		s.setKey(keyID, key)
+	}
+	return nil, nil
+}
+
+// handleGenerateKeyPair generates a new key pair of the server on the curve of
+// the security suite: the digital signature key pair replaces ServerSigningKey
+// and the key agreement key pair ServerKeyAgreementKey. TLS is not supported.
+func (s *SecuritySetup) handleGenerateKeyPair(params []interface{}) (interface{}, error) {
+	suite, err := keyAgreementSuiteOf(s.Attributes[3].Value.(SecuritySuite))
+	if err != nil {
+		return nil, ErrAccessDenied
+	}
+	keyPairType := KeyPairType(params[0].(axdr.Enum))
+	if keyPairType != KeyPairDigitalSignature && keyPairType != KeyPairKeyAgreement {
+		return nil, ErrInvalidParameter
+	}
+	key, err := ecdsa.GenerateKey(suite.curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if keyPairType == KeyPairDigitalSignature {
+		s.ServerSigningKey = key
+	} else {
+		s.ServerKeyAgreementKey = key
+	}
+	return nil, nil
+}
+
+// setKey replaces the key named by keyID.
+func (s *SecuritySetup) setKey(keyID KeyID, key []byte) {
+	switch keyID {
+	case KeyIDGlobalUnicastEncryption:
+		s.GlobalUnicastKey = key
+	case KeyIDGlobalBroadcastEncryption:
+		s.GlobalBroadcastKey = key
+	case KeyIDGlobalAuthentication:
+		s.GlobalAuthenticationKey = key
+	case KeyIDMasterKey:
+		s.MasterKey = key
+	}
+}
+
+// handleKeyAgreement agrees the global keys named by an array of
+// key_agreement_data (key_id, key_data) with the Ephemeral Unified Model
+// C(2e, 0s). key_data is the ephemeral public key of the client signed with its
+// ClientSigningKey (see SignEphemeralKey); the server answers with its own
+// ephemeral key signed with ServerSigningKey for each key. The keys are derived
+// under the client and server system titles and replaced only when every one
+// was agreed. The client system title is the one the client ciphers under, as
+// the Application passes it, or else client_system_title; key agreement is
+// refused while it is unknown. As for key_transfer, the response is still
+// protected with the previous key.
+func (s *SecuritySetup) handleKeyAgreement(params []interface{}) (interface{}, error) {
+	if len(params) == 0 {
+		return nil, ErrInvalidParameter
+	}
+	securitySuite := s.Attributes[3].Value.(SecuritySuite)
+	suite, err := keyAgreementSuiteOf(securitySuite)
+	if err != nil {
+		return nil, ErrAccessDenied
+	}
+	clientSigningKey, _ := s.ClientSigningKey.(*ecdsa.PublicKey)
+	serverSigningKey, _ := s.ServerSigningKey.(*ecdsa.PrivateKey)
+	if clientSigningKey == nil || serverSigningKey == nil {
+		return nil, ErrAccessDenied
+	}
+	clientSystemTitle := s.invokingSystemTitle
+	if len(clientSystemTitle) == 0 {
+		clientSystemTitle = s.Attributes[4].Value.([]byte)
+	}
+	if len(clientSystemTitle) == 0 {
+		return nil, ErrAccessDenied
+	}
+	serverSystemTitle := s.Attributes[5].Value.([]byte)
+
+	keys := make(map[KeyID][]byte, len(params))
+	response := make(axdr.Array, 0, len(params))
+	for _, param := range params {
+		data := param.(axdr.Structure)
+		if len(data) != 2 {
+			return nil, ErrInvalidParameter
+		}
+		id, ok1 := data[0].(axdr.Enum)
+		keyData, ok2 := data[1].([]byte)
+		keyID := KeyID(id)
+		if !ok1 || !ok2 || keyID > KeyIDGlobalAuthentication {
+			return nil, ErrInvalidParameter
+		}
+
+		clientKey, err := VerifyEphemeralKey(keyID, keyData, suite.curve, clientSigningKey)
+		if err != nil {
+			return nil, ErrAccessDenied
+		}
+		serverKey, err := ecdsa.GenerateKey(suite.curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key, err := AgreeKey(securitySuite, serverKey, clientKey, suite.algorithmID, clientSystemTitle, serverSystemTitle)
+		if err != nil {
+			return nil, err
+		}
+		serverKeyData, err := SignEphemeralKey(keyID, &serverKey.PublicKey, serverSigningKey)
+		if err != nil {
+			return nil, err
+		}
+		keys[keyID] = key
+		response = append(response, axdr.Structure{axdr.Enum(keyID), serverKeyData})
+	}
+
+	for keyID, key := range keys {
+		s.setKey(keyID, key)
+	}
+	return response, nil
 }
@@ -1,9 +1,14 @@
 package cosem
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"testing"
 
+	"github.com/gvtret/spodes-go/pkg/axdr"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewSecuritySetup(t *testing.T) {
@@ -63,3 +68,344 @@ func TestSecurityPolicy(t *testing.T) {
 	sp = PolicyNone
 	assert.False(t, sp&PolicyAuthenticatedRequest != 0)
 }
+
+func TestSecuritySetup_KeyAgreement(t *testing.T) {
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+	guek := []byte("0123456789ABCDEF")
+	gak := []byte("FEDCBA9876543210")
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), clientSystemTitle, serverSystemTitle, nil, guek, gak)
+	require.NoError(t, err)
+
+	clientSigningKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serverSigningKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	securitySetup.ClientSigningKey = &clientSigningKey.PublicKey
+	securitySetup.ServerSigningKey = serverSigningKey
+
+	clientKeys := make(map[KeyID]*ecdsa.PrivateKey)
+	agreementData := func(t *testing.T, keyID KeyID) axdr.Structure {
+		ephemeralKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		clientKeys[keyID] = ephemeralKey
+		keyData, err := SignEphemeralKey(keyID, &ephemeralKey.PublicKey, clientSigningKey)
+		require.NoError(t, err)
+		return axdr.Structure{axdr.Enum(keyID), keyData}
+	}
+
+	// Suite 0 has no key agreement.
+	_, err = securitySetup.Invoke(3, []interface{}{agreementData(t, KeyIDGlobalUnicastEncryption)})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	require.NoError(t, securitySetup.SetAttribute(3, SecuritySuite1))
+	result, err := securitySetup.Invoke(3, []interface{}{
+		agreementData(t, KeyIDGlobalUnicastEncryption),
+		agreementData(t, KeyIDGlobalAuthentication),
+	})
+	require.NoError(t, err)
+	response, ok := result.(axdr.Array)
+	require.True(t, ok)
+	require.Len(t, response, 2)
+
+	for i, keyID := range []KeyID{KeyIDGlobalUnicastEncryption, KeyIDGlobalAuthentication} {
+		data := response[i].(axdr.Structure)
+		assert.Equal(t, axdr.Enum(keyID), data[0])
+		serverKey, err := VerifyEphemeralKey(keyID, data[1].([]byte), elliptic.P256(), &serverSigningKey.PublicKey)
+		require.NoError(t, err)
+		key, err := AgreeKey(SecuritySuite1, clientKeys[keyID], serverKey, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle)
+		require.NoError(t, err)
+		if keyID == KeyIDGlobalUnicastEncryption {
+			assert.Equal(t, key, securitySetup.GlobalUnicastKey)
+		} else {
+			assert.Equal(t, key, securitySetup.GlobalAuthenticationKey)
+		}
+	}
+
+	// A key signed by someone else is refused and no key is changed.
+	agreedGUEK := securitySetup.GlobalUnicastKey
+	forged := agreementData(t, KeyIDGlobalUnicastEncryption)
+	forged[1].([]byte)[0] ^= 0xFF
+	_, err = securitySetup.Invoke(3, []interface{}{agreementData(t, KeyIDGlobalUnicastEncryption), forged})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	assert.Equal(t, agreedGUEK, securitySetup.GlobalUnicastKey)
+
+	_, err = securitySetup.Invoke(3, []interface{}{axdr.Structure{axdr.Enum(3), []byte{}}})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = securitySetup.Invoke(3, []interface{}{})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = securitySetup.Invoke(3, []interface{}{uint8(0)})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+
+	// Keys are not agreed while the client system title is unknown.
+	untitled, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, serverSystemTitle, nil, guek, gak)
+	require.NoError(t, err)
+	require.NoError(t, untitled.SetAttribute(3, SecuritySuite1))
+	untitled.ClientSigningKey = &clientSigningKey.PublicKey
+	untitled.ServerSigningKey = serverSigningKey
+	_, err = untitled.Invoke(3, []interface{}{agreementData(t, KeyIDGlobalUnicastEncryption)})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	assert.Equal(t, guek, untitled.GlobalUnicastKey)
+}
+
+func TestApplication_KeyAgreementClientSystemTitle(t *testing.T) {
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, serverSystemTitle, nil, []byte("0123456789ABCDEF"), nil)
+	require.NoError(t, err)
+	require.NoError(t, securitySetup.SetAttribute(3, SecuritySuite1))
+	clientSigningKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serverSigningKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	securitySetup.ClientSigningKey = &clientSigningKey.PublicKey
+	securitySetup.ServerSigningKey = serverSigningKey
+
+	app := NewApplication(nil, securitySetup)
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	clientAddr := mockAddr("client-title")
+	app.AddAssociation(clientAddr.String(), assoc)
+	app.RegisterObject(securitySetup)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{securitySetup.InstanceID}))
+
+	// The client system title is only known from the calling-AP-title of the AARQ.
+	src, err := (&AARQ{ApplicationContextName: OidApplicationContextLN, CallingAPTitle: clientSystemTitle}).Encode()
+	require.NoError(t, err)
+	encodedAARE, err := app.HandleAPDU(src, clientAddr)
+	require.NoError(t, err)
+	aare := &AARE{}
+	require.NoError(t, aare.Decode(encodedAARE))
+	require.Equal(t, ResultAccepted, aare.Result)
+
+	ephemeralKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyData, err := SignEphemeralKey(KeyIDGlobalUnicastEncryption, &ephemeralKey.PublicKey, clientSigningKey)
+	require.NoError(t, err)
+	src, err = (&ActionRequest{
+		Type:                ACTION_REQUEST_NORMAL,
+		InvokeIDAndPriority: 0xC1,
+		MethodDescriptor:    CosemMethodDescriptor{ClassID: SecuritySetupClassID, InstanceID: securitySetup.InstanceID, MethodID: 3},
+		Parameters:          axdr.Array{axdr.Structure{axdr.Enum(KeyIDGlobalUnicastEncryption), keyData}},
+	}).Encode()
+	require.NoError(t, err)
+	encodedResp, err := app.HandleAPDU(src, clientAddr)
+	require.NoError(t, err)
+	resp := &ActionResponse{}
+	require.NoError(t, resp.Decode(encodedResp))
+	require.False(t, resp.Result.IsDataAccessResult)
+	response := resp.Result.Value.(axdr.Array)
+	require.Len(t, response, 1)
+
+	serverKey, err := VerifyEphemeralKey(KeyIDGlobalUnicastEncryption, response[0].(axdr.Structure)[1].([]byte), elliptic.P256(), &serverSigningKey.PublicKey)
+	require.NoError(t, err)
+	agreed, err := AgreeKey(SecuritySuite1, ephemeralKey, serverKey, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle)
+	require.NoError(t, err)
+	assert.Equal(t, agreed, securitySetup.GlobalUnicastKey)
+}
+
+func TestSecuritySetup_SecurityActivate(t *testing.T) {
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	policy := PolicyAuthenticatedRequest | PolicyEncryptedRequest
+	_, err = securitySetup.Invoke(1, []interface{}{axdr.Enum(policy)})
+	require.NoError(t, err)
+	value, err := securitySetup.GetAttribute(2)
+	require.NoError(t, err)
+	assert.Equal(t, policy, value)
+
+	// The policy can be raised but never lowered.
+	_, err = securitySetup.Invoke(1, []interface{}{axdr.Enum(PolicyAuthenticatedRequest)})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	_, err = securitySetup.Invoke(1, []interface{}{axdr.Enum(PolicyEncryptedResponse)})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	_, err = securitySetup.Invoke(1, []interface{}{axdr.Enum(0x01)})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	value, err = securitySetup.GetAttribute(2)
+	require.NoError(t, err)
+	assert.Equal(t, policy, value)
+
+	_, err = securitySetup.Invoke(1, []interface{}{axdr.Enum(policy | PolicyEncryptedResponse)})
+	require.NoError(t, err)
+	value, err = securitySetup.GetAttribute(2)
+	require.NoError(t, err)
+	assert.Equal(t, policy|PolicyEncryptedResponse, value)
+
+	// Writing the policy is held to the same rule.
+	policy |= PolicyEncryptedResponse
+	assert.ErrorIs(t, securitySetup.SetAttribute(2, PolicyAuthenticatedRequest), ErrAccessDenied)
+	assert.ErrorIs(t, securitySetup.SetAttribute(2, PolicyNone), ErrAccessDenied)
+	assert.ErrorIs(t, securitySetup.SetAttribute(2, policy|0x01), ErrInvalidParameter)
+	value, err = securitySetup.GetAttribute(2)
+	require.NoError(t, err)
+	assert.Equal(t, policy, value)
+
+	require.NoError(t, securitySetup.SetAttribute(2, policy|PolicyAuthenticatedResponse))
+	value, err = securitySetup.GetAttribute(2)
+	require.NoError(t, err)
+	assert.Equal(t, policy|PolicyAuthenticatedResponse, value)
+}
+
+func TestSecuritySetup_KeyTransfer(t *testing.T) {
+	masterKey := []byte("MASTER0123456789")
+	guek := []byte("0123456789ABCDEF")
+	gak := []byte("FEDCBA9876543210")
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, nil, masterKey, guek, gak)
+	require.NoError(t, err)
+
+	keyData := func(t *testing.T, keyID KeyID, kek, key []byte) axdr.Structure {
+		wrapped, err := KeyWrap(kek, key)
+		require.NoError(t, err)
+		return axdr.Structure{axdr.Enum(keyID), wrapped}
+	}
+
+	newGUEK := []byte("NEW-GUEK-0123456")
+	newGBEK := []byte("NEW-GBEK-0123456")
+	newGAK := []byte("NEW-GAK-01234567")
+	_, err = securitySetup.Invoke(2, []interface{}{
+		keyData(t, KeyIDGlobalUnicastEncryption, masterKey, newGUEK),
+		keyData(t, KeyIDGlobalBroadcastEncryption, masterKey, newGBEK),
+		keyData(t, KeyIDGlobalAuthentication, masterKey, newGAK),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, newGUEK, securitySetup.GlobalUnicastKey)
+	assert.Equal(t, newGBEK, securitySetup.GlobalBroadcastKey)
+	assert.Equal(t, newGAK, securitySetup.GlobalAuthenticationKey)
+
+	// A key wrapped under another key is refused and no key is changed.
+	_, err = securitySetup.Invoke(2, []interface{}{
+		keyData(t, KeyIDGlobalUnicastEncryption, masterKey, guek),
+		keyData(t, KeyIDGlobalAuthentication, guek, gak),
+	})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	assert.Equal(t, newGUEK, securitySetup.GlobalUnicastKey)
+
+	// Suite 0 keys are AES-128 keys.
+	_, err = securitySetup.Invoke(2, []interface{}{keyData(t, KeyIDGlobalUnicastEncryption, masterKey, append(guek, guek...))})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = securitySetup.Invoke(2, []interface{}{keyData(t, KeyID(4), masterKey, guek)})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = securitySetup.Invoke(2, []interface{}{})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+
+	// A new master key wraps the keys transferred after it.
+	newMasterKey := []byte("NEW-MASTER-01234")
+	_, err = securitySetup.Invoke(2, []interface{}{keyData(t, KeyIDMasterKey, masterKey, newMasterKey)})
+	require.NoError(t, err)
+	assert.Equal(t, newMasterKey, securitySetup.MasterKey)
+	_, err = securitySetup.Invoke(2, []interface{}{keyData(t, KeyIDGlobalUnicastEncryption, masterKey, guek)})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	_, err = securitySetup.Invoke(2, []interface{}{keyData(t, KeyIDGlobalUnicastEncryption, newMasterKey, guek)})
+	require.NoError(t, err)
+	assert.Equal(t, guek, securitySetup.GlobalUnicastKey)
+}
+
+func TestApplication_KeyChangeResponseProtection(t *testing.T) {
+	clientSystemTitle := []byte("CLIENT01")
+	serverSystemTitle := []byte("SERVER01")
+	masterKey := []byte("MASTER0123456789")
+	guek := []byte("0123456789ABCDEF")
+	gak := []byte("FEDCBA9876543210")
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), clientSystemTitle, serverSystemTitle, masterKey, guek, gak)
+	require.NoError(t, err)
+	require.NoError(t, securitySetup.SetAttribute(3, SecuritySuite1))
+	clientSigningKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serverSigningKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	securitySetup.ClientSigningKey = &clientSigningKey.PublicKey
+	securitySetup.ServerSigningKey = serverSigningKey
+
+	assoc, err := NewAssociationLN(obisOf(t, "0.0.40.0.0.255"))
+	require.NoError(t, err)
+	app := NewApplication(nil, securitySetup)
+	clientAddr := mockAddr("client1")
+	app.AddAssociation(clientAddr.String(), assoc)
+	require.NoError(t, app.PreEstablishAssociation(clientAddr.String()))
+	app.RegisterObject(securitySetup)
+	require.NoError(t, app.PopulateObjectList(assoc, []ObisCode{securitySetup.InstanceID}))
+
+	frameCounter := uint32(0)
+	lastServerCounter := assoc.ServerInvocationCounter()
+	// invoke invokes a method of the security setup with a glo-action-request
+	// ciphered under key and deciphers the response with the same key.
+	invoke := func(t *testing.T, key []byte, methodID int8, parameters axdr.Array) ActionResult {
+		encodedReq, err := (&ActionRequest{
+			Type:                ACTION_REQUEST_NORMAL,
+			InvokeIDAndPriority: 0xC1,
+			MethodDescriptor:    CosemMethodDescriptor{ClassID: SecuritySetupClassID, InstanceID: securitySetup.InstanceID, MethodID: methodID},
+			Parameters:          parameters,
+		}).Encode()
+		require.NoError(t, err)
+		frameCounter++
+		header := &SecurityHeader{SecurityControl: SecurityControlAuthenticatedAndEncrypted, FrameCounter: frameCounter}
+		encodedResp, err := app.HandleAPDU(cipherAPDU(t, APDU_GLO_ACTION_REQUEST, key, encodedReq, clientSystemTitle, header, SecuritySuite1), clientAddr)
+		require.NoError(t, err)
+
+		respHeader, plaintext := decipherAPDU(t, encodedResp, key, serverSystemTitle, SecuritySuite1, lastServerCounter)
+		lastServerCounter = respHeader.FrameCounter
+		resp := &ActionResponse{}
+		require.NoError(t, resp.Decode(plaintext))
+		return resp.Result
+	}
+
+	t.Run("KeyTransfer", func(t *testing.T) {
+		newGUEK := []byte("NEW-GUEK-0123456")
+		wrapped, err := KeyWrap(masterKey, newGUEK)
+		require.NoError(t, err)
+		result := invoke(t, guek, 2, axdr.Array{axdr.Structure{axdr.Enum(KeyIDGlobalUnicastEncryption), wrapped}})
+		assert.Equal(t, ActionResult{}, result)
+		assert.Equal(t, newGUEK, securitySetup.GlobalUnicastKey)
+
+		// The client restarts its frame counter under the new key.
+		frameCounter = 0
+	})
+
+	t.Run("KeyAgreement", func(t *testing.T) {
+		previous := securitySetup.GlobalUnicastKey
+		ephemeralKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		keyData, err := SignEphemeralKey(KeyIDGlobalUnicastEncryption, &ephemeralKey.PublicKey, clientSigningKey)
+		require.NoError(t, err)
+		result := invoke(t, previous, 3, axdr.Array{axdr.Structure{axdr.Enum(KeyIDGlobalUnicastEncryption), keyData}})
+		require.False(t, result.IsDataAccessResult)
+		response, ok := result.Value.(axdr.Array)
+		require.True(t, ok)
+		require.Len(t, response, 1)
+
+		serverKey, err := VerifyEphemeralKey(KeyIDGlobalUnicastEncryption, response[0].(axdr.Structure)[1].([]byte), elliptic.P256(), &serverSigningKey.PublicKey)
+		require.NoError(t, err)
+		agreed, err := AgreeKey(SecuritySuite1, ephemeralKey, serverKey, OidAlgorithmAESGCM128, clientSystemTitle, serverSystemTitle)
+		require.NoError(t, err)
+		assert.Equal(t, agreed, securitySetup.GlobalUnicastKey)
+		assert.NotEqual(t, previous, agreed)
+		assert.Equal(t, uint32(0), app.lastFrameCounters[assoc])
+	})
+}
+
+func TestSecuritySetup_GenerateKeyPair(t *testing.T) {
+	securitySetup, err := NewSecuritySetup(obisOf(t, "0.0.43.0.0.255"), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	// Suite 0 has no public key cryptography.
+	_, err = securitySetup.Invoke(4, []interface{}{axdr.Enum(KeyPairDigitalSignature)})
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	require.NoError(t, securitySetup.SetAttribute(3, SecuritySuite2))
+	_, err = securitySetup.Invoke(4, []interface{}{axdr.Enum(KeyPairDigitalSignature)})
+	require.NoError(t, err)
+	signingKey, ok := securitySetup.ServerSigningKey.(*ecdsa.PrivateKey)
+	require.True(t, ok)
+	assert.Equal(t, elliptic.P384(), signingKey.Curve)
+
+	_, err = securitySetup.Invoke(4, []interface{}{axdr.Enum(KeyPairKeyAgreement)})
+	require.NoError(t, err)
+	agreementKey, ok := securitySetup.ServerKeyAgreementKey.(*ecdsa.PrivateKey)
+	require.True(t, ok)
+	assert.Equal(t, elliptic.P384(), agreementKey.Curve)
+	assert.False(t, agreementKey.Equal(signingKey))
+
+	_, err = securitySetup.Invoke(4, []interface{}{axdr.Enum(KeyPairTLS)})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+}
@@ -0,0 +1,498 @@
+package cosem
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gvtret/spodes-go/pkg/axdr"
+)
+
+// APDUType constants for the xDLMS services used with short name referencing.
+const (
+	APDU_READ_REQUEST              APDUType = 0x05
+	APDU_WRITE_REQUEST             APDUType = 0x06
+	APDU_READ_RESPONSE             APDUType = 0x0C
+	APDU_WRITE_RESPONSE            APDUType = 0x0D
+	APDU_UNCONFIRMED_WRITE_REQUEST APDUType = 0x16
+
+	APDU_GLO_READ_REQUEST              APDUType = 0x25
+	APDU_GLO_WRITE_REQUEST             APDUType = 0x26
+	APDU_GLO_READ_RESPONSE             APDUType = 0x2C
+	APDU_GLO_WRITE_RESPONSE            APDUType = 0x2D
+	APDU_GLO_UNCONFIRMED_WRITE_REQUEST APDUType = 0x36
+
+	APDU_DED_READ_REQUEST              APDUType = 0x45
+	APDU_DED_WRITE_REQUEST             APDUType = 0x46
+	APDU_DED_READ_RESPONSE             APDUType = 0x4C
+	APDU_DED_WRITE_RESPONSE            APDUType = 0x4D
+	APDU_DED_UNCONFIRMED_WRITE_REQUEST APDUType = 0x56
+)
+
+// VariableAccessType is the choice of a Variable-Access-Specification.
+type VariableAccessType byte
+
+const (
+	VARIABLE_ACCESS_NAME             VariableAccessType = 2
+	VARIABLE_ACCESS_PARAMETERIZED    VariableAccessType = 4
+	VARIABLE_ACCESS_BLOCK_NUMBER     VariableAccessType = 5
+	VARIABLE_ACCESS_READ_DATA_BLOCK  VariableAccessType = 6
+	VARIABLE_ACCESS_WRITE_DATA_BLOCK VariableAccessType = 7
+)
+
+// VariableAccessSpecification addresses an attribute or a method by its short name,
+// or continues a transfer in blocks. Only the fields of the chosen Type are used.
+type VariableAccessSpecification struct {
+	Type VariableAccessType
+	// VariableName is the short name of VARIABLE_ACCESS_NAME and VARIABLE_ACCESS_PARAMETERIZED.
+	VariableName uint16
+	// Selector and Parameter are the access selection of VARIABLE_ACCESS_PARAMETERIZED,
+	// or the method invocation parameters when VariableName references a method.
+	Selector  uint8
+	Parameter interface{}
+	// BlockNumber is used by the block variants; LastBlock by the data block variants
+	// and RawData by VARIABLE_ACCESS_READ_DATA_BLOCK.
+	BlockNumber uint16
+	LastBlock   bool
+	RawData     []byte
+}
+
+// ReadResultType is the choice of an element of a ReadResponse.
+type ReadResultType byte
+
+const (
+	READ_RESULT_DATA              ReadResultType = 0
+	READ_RESULT_DATA_ACCESS_ERROR ReadResultType = 1
+	READ_RESULT_DATA_BLOCK        ReadResultType = 2
+	READ_RESULT_BLOCK_NUMBER      ReadResultType = 3
+)
+
+// ReadResult is an element of a ReadResponse.
+// Value holds the data of READ_RESULT_DATA or the DataAccessResultEnum of
+// READ_RESULT_DATA_ACCESS_ERROR. DataBlock is used by READ_RESULT_DATA_BLOCK and
+// BlockNumber by READ_RESULT_BLOCK_NUMBER.
+type ReadResult struct {
+	Type        ReadResultType
+	Value       interface{}
+	DataBlock   DataBlockResult
+	BlockNumber uint16
+}
+
+// DataBlockResult represents the Data-Block-Result of a ReadResponse sent in blocks.
+type DataBlockResult struct {
+	LastBlock   bool
+	BlockNumber uint16
+	RawData     []byte
+}
+
+// WriteResult is an element of a WriteResponse. Result is SUCCESS or the reason the
+// write failed. If IsBlockNumber is true, the element acknowledges the block
+// BlockNumber of a write in blocks instead.
+type WriteResult struct {
+	IsBlockNumber bool
+	Result        DataAccessResultEnum
+	BlockNumber   uint16
+}
+
+// ReadRequest is the structure for a ReadRequest APDU.
+type ReadRequest struct {
+	Variables []VariableAccessSpecification
+}
+
+// ReadResponse is the structure for a ReadResponse APDU. It holds one result per
+// variable of the request.
+type ReadResponse struct {
+	Results []ReadResult
+}
+
+// WriteRequest is the structure for a WriteRequest APDU. Values holds the data to
+// write for each variable, in the same order.
+type WriteRequest struct {
+	Variables []VariableAccessSpecification
+	Values    []interface{}
+}
+
+// WriteResponse is the structure for a WriteResponse APDU.
+type WriteResponse struct {
+	Results []WriteResult
+}
+
+// UnconfirmedWriteRequest is the structure for an UnconfirmedWriteRequest APDU. It
+// is served like a WriteRequest, but no response is sent.
+type UnconfirmedWriteRequest struct {
+	Variables []VariableAccessSpecification
+	Values    []interface{}
+}
+
+// Encode encodes the ReadRequest APDU into a byte slice.
+func (rr *ReadRequest) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_READ_REQUEST))
+	if err := encodeVariableAccessList(&buf, rr.Variables); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into a ReadRequest APDU.
+func (rr *ReadRequest) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_READ_REQUEST, "ReadRequest")
+	if err != nil {
+		return err
+	}
+	rr.Variables, err = decodeVariableAccessList(reader)
+	if err != nil {
+		return err
+	}
+	return expectEnd(reader, "ReadRequest")
+}
+
+// Encode encodes the ReadResponse APDU into a byte slice.
+func (rr *ReadResponse) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_READ_RESPONSE))
+	if err := encodeReadResultList(&buf, rr.Results); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into a ReadResponse APDU.
+func (rr *ReadResponse) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_READ_RESPONSE, "ReadResponse")
+	if err != nil {
+		return err
+	}
+	rr.Results, err = decodeReadResultList(reader)
+	if err != nil {
+		return err
+	}
+	return expectEnd(reader, "ReadResponse")
+}
+
+// Encode encodes the WriteRequest APDU into a byte slice.
+func (wr *WriteRequest) Encode() ([]byte, error) {
+	return encodeWriteRequest(APDU_WRITE_REQUEST, wr.Variables, wr.Values)
+}
+
+// Decode decodes a byte slice into a WriteRequest APDU.
+func (wr *WriteRequest) Decode(src []byte) error {
+	var err error
+	wr.Variables, wr.Values, err = decodeWriteRequest(src, APDU_WRITE_REQUEST, "WriteRequest")
+	return err
+}
+
+// Encode encodes the UnconfirmedWriteRequest APDU into a byte slice.
+func (uw *UnconfirmedWriteRequest) Encode() ([]byte, error) {
+	return encodeWriteRequest(APDU_UNCONFIRMED_WRITE_REQUEST, uw.Variables, uw.Values)
+}
+
+// Decode decodes a byte slice into an UnconfirmedWriteRequest APDU.
+func (uw *UnconfirmedWriteRequest) Decode(src []byte) error {
+	var err error
+	uw.Variables, uw.Values, err = decodeWriteRequest(src, APDU_UNCONFIRMED_WRITE_REQUEST, "UnconfirmedWriteRequest")
+	return err
+}
+
+// Encode encodes the WriteResponse APDU into a byte slice.
+func (wr *WriteResponse) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(APDU_WRITE_RESPONSE))
+	if err := axdr.WriteLength(&buf, len(wr.Results)); err != nil {
+		return nil, err
+	}
+	for _, result := range wr.Results {
+		switch {
+		case result.IsBlockNumber:
+			buf.WriteByte(2) // block-number
+			writeUint16(&buf, result.BlockNumber)
+		case result.Result == SUCCESS:
+			buf.WriteByte(0) // success
+		default:
+			buf.WriteByte(1) // data-access-error
+			buf.WriteByte(byte(result.Result))
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a byte slice into a WriteResponse APDU.
+func (wr *WriteResponse) Decode(src []byte) error {
+	reader, err := newAPDUReader(src, APDU_WRITE_RESPONSE, "WriteResponse")
+	if err != nil {
+		return err
+	}
+
+	count, err := readSequenceLength(reader, "WriteResponse")
+	if err != nil {
+		return err
+	}
+	wr.Results = make([]WriteResult, count)
+	for i := range wr.Results {
+		tag, err := readByte(reader, "WriteResponse CHOICE tag")
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case 0: // success
+			wr.Results[i] = WriteResult{Result: SUCCESS}
+		case 1: // data-access-error
+			result, err := readByte(reader, "DataAccessResult")
+			if err != nil {
+				return err
+			}
+			wr.Results[i] = WriteResult{Result: DataAccessResultEnum(result)}
+		case 2: // block-number
+			blockNumber, err := readUint16(reader, "BlockNumber")
+			if err != nil {
+				return err
+			}
+			wr.Results[i] = WriteResult{IsBlockNumber: true, BlockNumber: blockNumber}
+		default:
+			return fmt.Errorf("invalid tag for WriteResponse CHOICE: %d", tag)
+		}
+	}
+
+	return expectEnd(reader, "WriteResponse")
+}
+
+func encodeWriteRequest(apduType APDUType, variables []VariableAccessSpecification, values []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(apduType))
+	if err := encodeVariableAccessList(&buf, variables); err != nil {
+		return nil, err
+	}
+	if err := encodeDataList(&buf, values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeWriteRequest(src []byte, apduType APDUType, name string) ([]VariableAccessSpecification, []interface{}, error) {
+	reader, err := newAPDUReader(src, apduType, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	variables, err := decodeVariableAccessList(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := decodeDataList(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return variables, values, expectEnd(reader, name)
+}
+
+// encodeVariableAccessList writes a SEQUENCE OF Variable-Access-Specification.
+func encodeVariableAccessList(buf *bytes.Buffer, variables []VariableAccessSpecification) error {
+	if err := axdr.WriteLength(buf, len(variables)); err != nil {
+		return err
+	}
+	for _, v := range variables {
+		buf.WriteByte(byte(v.Type))
+		switch v.Type {
+		case VARIABLE_ACCESS_NAME:
+			writeUint16(buf, v.VariableName)
+		case VARIABLE_ACCESS_PARAMETERIZED:
+			writeUint16(buf, v.VariableName)
+			buf.WriteByte(v.Selector)
+			if err := encodeData(buf, v.Parameter); err != nil {
+				return fmt.Errorf("failed to encode parameter: %w", err)
+			}
+		case VARIABLE_ACCESS_BLOCK_NUMBER:
+			writeUint16(buf, v.BlockNumber)
+		case VARIABLE_ACCESS_READ_DATA_BLOCK:
+			writeBoolean(buf, v.LastBlock)
+			writeUint16(buf, v.BlockNumber)
+			if err := writeOctetString(buf, v.RawData); err != nil {
+				return err
+			}
+		case VARIABLE_ACCESS_WRITE_DATA_BLOCK:
+			writeBoolean(buf, v.LastBlock)
+			writeUint16(buf, v.BlockNumber)
+		default:
+			return fmt.Errorf("unsupported Variable-Access-Specification choice: %d", v.Type)
+		}
+	}
+	return nil
+}
+
+func decodeVariableAccessList(reader *bytes.Reader) ([]VariableAccessSpecification, error) {
+	count, err := readSequenceLength(reader, "variable-access-specification")
+	if err != nil {
+		return nil, err
+	}
+	variables := make([]VariableAccessSpecification, count)
+	for i := range variables {
+		choice, err := readByte(reader, "Variable-Access-Specification CHOICE tag")
+		if err != nil {
+			return nil, err
+		}
+		v := VariableAccessSpecification{Type: VariableAccessType(choice)}
+		switch v.Type {
+		case VARIABLE_ACCESS_NAME:
+			if v.VariableName, err = readUint16(reader, "VariableName"); err != nil {
+				return nil, err
+			}
+		case VARIABLE_ACCESS_PARAMETERIZED:
+			if v.VariableName, err = readUint16(reader, "VariableName"); err != nil {
+				return nil, err
+			}
+			if v.Selector, err = readByte(reader, "Selector"); err != nil {
+				return nil, err
+			}
+			if v.Parameter, err = axdr.DecodeFrom(reader); err != nil {
+				return nil, fmt.Errorf("failed to decode parameter: %w", err)
+			}
+		case VARIABLE_ACCESS_BLOCK_NUMBER:
+			if v.BlockNumber, err = readUint16(reader, "BlockNumber"); err != nil {
+				return nil, err
+			}
+		case VARIABLE_ACCESS_READ_DATA_BLOCK, VARIABLE_ACCESS_WRITE_DATA_BLOCK:
+			if v.LastBlock, err = readBoolean(reader, "LastBlock"); err != nil {
+				return nil, err
+			}
+			if v.BlockNumber, err = readUint16(reader, "BlockNumber"); err != nil {
+				return nil, err
+			}
+			if v.Type == VARIABLE_ACCESS_READ_DATA_BLOCK {
+				if v.RawData, err = readOctetString(reader, "RawData"); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("invalid tag for Variable-Access-Specification CHOICE: %d", choice)
+		}
+		variables[i] = v
+	}
+	return variables, nil
+}
+
+// encodeReadResultList writes the SEQUENCE OF CHOICE of a ReadResponse.
+func encodeReadResultList(buf *bytes.Buffer, results []ReadResult) error {
+	if err := axdr.WriteLength(buf, len(results)); err != nil {
+		return err
+	}
+	for _, result := range results {
+		buf.WriteByte(byte(result.Type))
+		switch result.Type {
+		case READ_RESULT_DATA:
+			if err := encodeData(buf, result.Value); err != nil {
+				return fmt.Errorf("failed to encode ReadResult value: %w", err)
+			}
+		case READ_RESULT_DATA_ACCESS_ERROR:
+			dar, ok := result.Value.(DataAccessResultEnum)
+			if !ok {
+				return fmt.Errorf("invalid type for DataAccessResult: %T", result.Value)
+			}
+			buf.WriteByte(byte(dar))
+		case READ_RESULT_DATA_BLOCK:
+			writeBoolean(buf, result.DataBlock.LastBlock)
+			writeUint16(buf, result.DataBlock.BlockNumber)
+			if err := writeOctetString(buf, result.DataBlock.RawData); err != nil {
+				return err
+			}
+		case READ_RESULT_BLOCK_NUMBER:
+			writeUint16(buf, result.BlockNumber)
+		default:
+			return fmt.Errorf("unsupported ReadResult type: %d", result.Type)
+		}
+	}
+	return nil
+}
+
+func decodeReadResultList(reader *bytes.Reader) ([]ReadResult, error) {
+	count, err := readSequenceLength(reader, "ReadResponse")
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ReadResult, count)
+	for i := range results {
+		tag, err := readByte(reader, "ReadResponse CHOICE tag")
+		if err != nil {
+			return nil, err
+		}
+		result := ReadResult{Type: ReadResultType(tag)}
+		switch result.Type {
+		case READ_RESULT_DATA:
+			if result.Value, err = axdr.DecodeFrom(reader); err != nil {
+				return nil, fmt.Errorf("failed to decode ReadResult value: %w", err)
+			}
+		case READ_RESULT_DATA_ACCESS_ERROR:
+			dar, err := readByte(reader, "DataAccessResult")
+			if err != nil {
+				return nil, err
+			}
+			result.Value = DataAccessResultEnum(dar)
+		case READ_RESULT_DATA_BLOCK:
+			if result.DataBlock.LastBlock, err = readBoolean(reader, "LastBlock"); err != nil {
+				return nil, err
+			}
+			if result.DataBlock.BlockNumber, err = readUint16(reader, "BlockNumber"); err != nil {
+				return nil, err
+			}
+			if result.DataBlock.RawData, err = readOctetString(reader, "RawData"); err != nil {
+				return nil, err
+			}
+		case READ_RESULT_BLOCK_NUMBER:
+			if result.BlockNumber, err = readUint16(reader, "BlockNumber"); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("invalid tag for ReadResponse CHOICE: %d", tag)
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// shortNameMethodOffsets holds, for the interface classes with methods, the offset
+// of the short name of the first method from the base_name of an object. The
+// methods of other classes cannot be invoked by short name.
+var shortNameMethodOffsets = map[uint16]int{
+	RegisterClassID:       0x28,
+	ProfileGenericClassID: 0x58,
+	ClockClassID:          0x60,
+	SecuritySetupClassID:  0x30,
+	AssociationSNClassID:  0x20,
+}
+
+// shortNameEntry is an object registered for short name referencing. It occupies
+// span short names, 8 apart, from baseName on.
+type shortNameEntry struct {
+	baseName uint16
+	obj      BaseInterface
+	span     int
+}
+
+// end returns the first short name after those of the entry.
+func (e shortNameEntry) end() int {
+	return int(e.baseName) + 8*e.span
+}
+
+// shortNameReference is the attribute or method a short name resolves to. Exactly
+// one of attributeID and methodID is set.
+type shortNameReference struct {
+	obj         BaseInterface
+	attributeID byte
+	methodID    byte
+}
+
+// shortNameSpan returns the number of short names obj occupies: one per attribute
+// and, for classes with methods, up to its last method.
+func shortNameSpan(obj BaseInterface) int {
+	span := 1
+	for i := byte(1); i <= 20; i++ { // Assuming max 20 attributes
+		if obj.GetAttributeAccess(i) != AttributeNoAccess {
+			span = int(i)
+		}
+	}
+	if methodOffset, ok := shortNameMethodOffsets[obj.GetClassID()]; ok {
+		for i := byte(1); i <= 20; i++ { // Assuming max 20 methods
+			if obj.GetMethodAccess(i) != MethodNoAccess {
+				span = max(span, methodOffset/8+int(i))
+			}
+		}
+	}
+	return span
+}
@@ -0,0 +1,106 @@
+package cosem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRequest_WireFormat(t *testing.T) {
+	req := &ReadRequest{Variables: []VariableAccessSpecification{
+		{Type: VARIABLE_ACCESS_NAME, VariableName: 0x0108},
+		{Type: VARIABLE_ACCESS_PARAMETERIZED, VariableName: 0x0210, Selector: 2, Parameter: uint8(5)},
+		{Type: VARIABLE_ACCESS_BLOCK_NUMBER, BlockNumber: 3},
+		{Type: VARIABLE_ACCESS_READ_DATA_BLOCK, LastBlock: true, BlockNumber: 1, RawData: []byte{0xAA}},
+	}}
+	encoded, err := req.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x05, 0x04,
+		0x02, 0x01, 0x08,
+		0x04, 0x02, 0x10, 0x02, 0x1F, 0x05,
+		0x05, 0x00, 0x03,
+		0x06, 0x01, 0x00, 0x01, 0x01, 0xAA,
+	}, encoded)
+
+	decoded := &ReadRequest{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, req, decoded)
+
+	assert.Error(t, decoded.Decode([]byte{0x05, 0x01, 0x03, 0x01, 0x08}))
+	assert.Error(t, decoded.Decode([]byte{0x05, 0x01, 0x02, 0x01}))
+}
+
+func TestReadResponse_WireFormat(t *testing.T) {
+	resp := &ReadResponse{Results: []ReadResult{
+		{Type: READ_RESULT_DATA, Value: uint8(7)},
+		{Type: READ_RESULT_DATA_ACCESS_ERROR, Value: OBJECT_UNDEFINED},
+		{Type: READ_RESULT_DATA_BLOCK, DataBlock: DataBlockResult{BlockNumber: 2, RawData: []byte{0x01, 0x02}}},
+		{Type: READ_RESULT_BLOCK_NUMBER, BlockNumber: 4},
+	}}
+	encoded, err := resp.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x0C, 0x04,
+		0x00, 0x1F, 0x07,
+		0x01, 0x04,
+		0x02, 0x00, 0x00, 0x02, 0x02, 0x01, 0x02,
+		0x03, 0x00, 0x04,
+	}, encoded)
+
+	decoded := &ReadResponse{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, resp, decoded)
+
+	_, err = (&ReadResponse{Results: []ReadResult{{Type: READ_RESULT_DATA_ACCESS_ERROR, Value: uint8(4)}}}).Encode()
+	assert.Error(t, err)
+}
+
+func TestWriteRequest_WireFormat(t *testing.T) {
+	req := &WriteRequest{
+		Variables: []VariableAccessSpecification{
+			{Type: VARIABLE_ACCESS_NAME, VariableName: 0x0108},
+			{Type: VARIABLE_ACCESS_WRITE_DATA_BLOCK, LastBlock: false, BlockNumber: 1},
+		},
+		Values: []interface{}{uint8(1), []byte{0x02}},
+	}
+	encoded, err := req.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x06, 0x02,
+		0x02, 0x01, 0x08,
+		0x07, 0x00, 0x00, 0x01,
+		0x02, 0x1F, 0x01, 0x09, 0x01, 0x02,
+	}, encoded)
+
+	decoded := &WriteRequest{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, req, decoded)
+
+	unconfirmed := &UnconfirmedWriteRequest{Variables: req.Variables, Values: req.Values}
+	encoded, err = unconfirmed.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, byte(APDU_UNCONFIRMED_WRITE_REQUEST), encoded[0])
+	decodedUnconfirmed := &UnconfirmedWriteRequest{}
+	require.NoError(t, decodedUnconfirmed.Decode(encoded))
+	assert.Equal(t, unconfirmed, decodedUnconfirmed)
+	assert.Error(t, decoded.Decode(encoded))
+}
+
+func TestWriteResponse_WireFormat(t *testing.T) {
+	resp := &WriteResponse{Results: []WriteResult{
+		{Result: SUCCESS},
+		{Result: READ_WRITE_DENIED},
+		{IsBlockNumber: true, BlockNumber: 2},
+	}}
+	encoded, err := resp.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0D, 0x03, 0x00, 0x01, 0x03, 0x02, 0x00, 0x02}, encoded)
+
+	decoded := &WriteResponse{}
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, resp, decoded)
+
+	assert.Error(t, decoded.Decode([]byte{0x0D, 0x01, 0x04}))
+}